@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+// accessCheck returns the check of the requests to an endpoint action, or nil if
+// the action needs none. A check lets the request through by returning
+// response.EmptySyncResponse.
+type accessCheck func(action rest.EndpointAction) handlerFunc
+
+// applyAccessChecks sets the access handler of the actions allowing untrusted
+// clients to run the checks in order. Microcluster runs the access handler before
+// it forwards a request with ?target= to another member, so the checks also cover
+// the forwarded requests, which never reach the handler of this member. The
+// actions only allowing trusted clients keep the default microcluster access
+// handler.
+func applyAccessChecks(endpoints []rest.Endpoint, checks ...accessCheck) []rest.Endpoint {
+	for i := range endpoints {
+		e := &endpoints[i]
+		for _, action := range []*rest.EndpointAction{&e.Get, &e.Put, &e.Post, &e.Delete, &e.Patch} {
+			if action.Handler == nil || !action.AllowUntrusted || action.AccessHandler != nil {
+				continue
+			}
+
+			var handlers []handlerFunc
+			for _, check := range checks {
+				handler := check(*action)
+				if handler != nil {
+					handlers = append(handlers, handler)
+				}
+			}
+
+			if len(handlers) == 0 {
+				continue
+			}
+
+			action.AccessHandler = func(s *state.State, r *http.Request) response.Response {
+				for _, handler := range handlers {
+					resp := handler(s, r)
+					if resp != response.EmptySyncResponse {
+						return resp
+					}
+				}
+
+				return response.EmptySyncResponse
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// targetAccessCheck refuses the untrusted requests with ?target= naming another
+// member. Microcluster forwards them with the certificate of this member, so the
+// target would handle them as trusted.
+func targetAccessCheck(action rest.EndpointAction) handlerFunc {
+	if !action.ProxyTarget {
+		return nil
+	}
+
+	return func(s *state.State, r *http.Request) response.Response {
+		target := r.URL.Query().Get("target")
+		if target != "" && target != s.Name() && !isTrusted(r) {
+			return response.Forbidden(fmt.Errorf("Untrusted clients may not forward requests to another member"))
+		}
+
+		return response.EmptySyncResponse
+	}
+}
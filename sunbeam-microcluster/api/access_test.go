@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+)
+
+func TestTargetAccessCheck(t *testing.T) {
+	s := newTestState(t)
+
+	endpoints := applyAccessChecks([]rest.Endpoint{{
+		Path: "config/{key}",
+
+		Get: rest.EndpointAction{Handler: cmdConfigGet, ProxyTarget: true, AllowUntrusted: true},
+		Put: rest.EndpointAction{Handler: cmdConfigPut, ProxyTarget: true},
+	}}, targetAccessCheck)
+
+	// Trusted-only actions keep the default microcluster access handler.
+	if endpoints[0].Put.AccessHandler != nil {
+		t.Fatalf("Access handler set on a trusted-only action")
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		trusted bool
+		status  int
+	}{
+		{name: "no target", status: http.StatusOK},
+		{name: "this member", query: "?target=member1", status: http.StatusOK},
+		{name: "other member", query: "?target=member2", status: http.StatusForbidden},
+		{name: "trusted other member", query: "?target=member2", trusted: true, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest("10.0.0.2:1234", tt.trusted)
+			r.URL.RawQuery = httptest.NewRequest(http.MethodGet, "/1.0/config/key1"+tt.query, nil).URL.RawQuery
+
+			resp := endpoints[0].Get.AccessHandler(s, r)
+			if tt.status == http.StatusOK {
+				if resp != response.EmptySyncResponse {
+					t.Errorf("Request%s was refused", tt.query)
+				}
+
+				return
+			}
+
+			w := httptest.NewRecorder()
+			err := resp.Render(w)
+			if err != nil {
+				t.Fatalf("Failed to render the response: %v", err)
+			}
+
+			if w.Code != tt.status {
+				t.Errorf("Request%s returned %d, expected %d", tt.query, w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/capabilities endpoint.
+// Reports the optional features and whether the current settings enable them.
+var capabilitiesCmd = rest.Endpoint{
+	Path: "capabilities",
+
+	Get: rest.EndpointAction{Handler: cmdCapabilitiesGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+func cmdCapabilitiesGet(s *state.State, r *http.Request) response.Response {
+	capabilities, err := sunbeam.GetCapabilities(r.Context(), s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, capabilities)
+}
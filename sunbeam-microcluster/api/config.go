@@ -2,15 +2,19 @@ package api
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
 	"github.com/gorilla/mux"
 
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 )
 
@@ -20,26 +24,218 @@ var configCmd = rest.Endpoint{
 
 	Get:    rest.EndpointAction{Handler: cmdConfigGet, ProxyTarget: true, AllowUntrusted: true},
 	Put:    rest.EndpointAction{Handler: cmdConfigPut, ProxyTarget: true, AllowUntrusted: true},
+	Post:   rest.EndpointAction{Handler: cmdConfigPost, ProxyTarget: true, AllowUntrusted: true},
 	Delete: rest.EndpointAction{Handler: cmdConfigDelete, ProxyTarget: true, AllowUntrusted: true},
 }
 
+// /1.0/config endpoint.
+var configsCmd = rest.Endpoint{
+	Path: "config",
+
+	Get: rest.EndpointAction{Handler: cmdConfigGetAll, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/config/usage endpoint.
+// Registered before /1.0/config/<name> so that it takes precedence over the key named usage.
+var configUsageCmd = rest.Endpoint{
+	Path: "config/usage",
+
+	Get: rest.EndpointAction{Handler: cmdConfigUsageGet, ProxyTarget: true},
+}
+
+// /1.0/config/<name>/touch endpoint.
+// Bumps the update time of the key without rewriting its value.
+var configTouchCmd = rest.Endpoint{
+	Path: "config/{key}/touch",
+
+	Post: rest.EndpointAction{Handler: cmdConfigTouchPost, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/config/<name>/history endpoint.
+// Lists the prior values of the key, most recent first.
+var configHistoryCmd = rest.Endpoint{
+	Path: "config/{key}/history",
+
+	Get: rest.EndpointAction{Handler: cmdConfigHistoryGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/config/<name>/restore endpoint.
+// Sets the key back to the prior value with the given version.
+var configRestoreCmd = rest.Endpoint{
+	Path: "config/{key}/restore",
+
+	Post: rest.EndpointAction{Handler: cmdConfigRestorePost, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/config/<name>/raw endpoint.
+// Returns the stored value as is, without the JSON envelope.
+var configRawCmd = rest.Endpoint{
+	Path: "config/{key}/raw",
+
+	Get: rest.EndpointAction{Handler: cmdConfigRawGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/config/<name>/rename endpoint.
+var configRenameCmd = rest.Endpoint{
+	Path: "config/{key}/rename",
+
+	Post: rest.EndpointAction{Handler: cmdConfigRenamePost, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// defaultConfigCursorLimit is the page size when walking the config by cursor without a limit.
+const defaultConfigCursorLimit = 100
+
+func cmdConfigGetAll(s *state.State, r *http.Request) response.Response {
+	// Clients restricted by the config ACL only see their keys.
+	acl, err := configACL(s, r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	var prefix *string
+	if r.URL.Query().Has("prefix") {
+		value := r.URL.Query().Get("prefix")
+		prefix = &value
+	}
+
+	// The cursor query parameter walks the keys in order, an empty cursor starts
+	// from the first key. It is the key the previous page ended at and is returned
+	// as the next cursor, which keeps pages stable while keys are added or removed.
+	byCursor := r.URL.Query().Has("cursor")
+	cursor := r.URL.Query().Get("cursor")
+	limit := defaultConfigCursorLimit
+	if byCursor && r.URL.Query().Has("limit") {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			return response.BadRequest(fmt.Errorf("Invalid limit value %q, expected a positive integer", r.URL.Query().Get("limit")))
+		}
+	}
+
+	if !shared.IsTrue(r.URL.Query().Get("values")) {
+		if byCursor {
+			keys, next, err := sunbeam.GetConfigKeysPage(r.Context(), s, prefix, cursor, limit)
+			if err != nil {
+				return response.InternalError(err)
+			}
+
+			return response.SyncResponse(true, types.CursorPage[string]{Items: acl.FilterKeys(keys), Limit: limit, Next: next})
+		}
+
+		keys, err := sunbeam.GetConfigItemKeys(r.Context(), s, prefix)
+		if err != nil {
+			return response.InternalError(err)
+		}
+
+		return paginatedResponse(r, acl.FilterKeys(keys))
+	}
+
+	// Large values such as terraform states can be truncated with maxValueBytes.
+	maxValueBytes := 0
+	maxValue := r.URL.Query().Get("maxValueBytes")
+	if maxValue != "" {
+		maxValueBytes, err = strconv.Atoi(maxValue)
+		if err != nil || maxValueBytes < 1 {
+			return response.BadRequest(fmt.Errorf("Invalid maxValueBytes value %q, expected a positive integer", maxValue))
+		}
+	}
+
+	if byCursor {
+		entries, next, err := sunbeam.GetConfigEntriesPage(r.Context(), s, prefix, cursor, limit, maxValueBytes)
+		if err != nil {
+			return response.InternalError(err)
+		}
+
+		return response.SyncResponse(true, types.CursorPage[types.ConfigEntry]{Items: acl.FilterEntries(entries), Limit: limit, Next: next})
+	}
+
+	entries, err := sunbeam.GetConfigEntries(r.Context(), s, prefix, maxValueBytes)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return paginatedResponse(r, acl.FilterEntries(entries))
+}
+
 func cmdConfigGet(s *state.State, r *http.Request) response.Response {
 	var key string
 	key, err := url.PathUnescape(mux.Vars(r)["key"])
 	if err != nil {
 		return response.InternalError(err)
 	}
-	config, err := sunbeam.GetConfig(s, key)
+
+	err = checkConfigAccess(s, r, key)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		return response.SmartError(err)
+	}
+
+	entry, err := sunbeam.GetConfigEntry(r.Context(), s, key)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return notFoundResponse("config", key)
+		}
+		return response.InternalError(err)
+	}
+
+	headers := map[string]string{"ETag": sunbeam.ConfigETag(entry.Value)}
+	if entry.Type != "" {
+		headers["X-Config-Type"] = entry.Type
+	}
+	if entry.UpdatedAt != "" {
+		headers["X-Config-Updated-At"] = entry.UpdatedAt
+	}
+
+	// The pointer query parameter selects a sub-value of a JSON config value.
+	if r.URL.Query().Has("pointer") {
+		subValue, err := sunbeam.ConfigValuePointer(entry.Value, r.URL.Query().Get("pointer"))
+		if err != nil {
+			return response.SmartError(err)
 		}
+
+		return response.SyncResponseHeaders(true, subValue, headers)
+	}
+
+	return response.SyncResponseHeaders(true, entry.Value, headers)
+}
+
+func cmdConfigRawGet(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
 		return response.InternalError(err)
 	}
 
-	return response.SyncResponse(true, config)
+	err = checkConfigAccess(s, r, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	entry, err := sunbeam.GetConfigEntry(r.Context(), s, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	// Like the terraform states, the value is sent verbatim.
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", configContentType(entry.Type))
+		w.Header().Set("ETag", sunbeam.ConfigETag(entry.Value))
+		if entry.Type != "" {
+			w.Header().Set("X-Config-Type", entry.Type)
+		}
+		if entry.UpdatedAt != "" {
+			w.Header().Set("X-Config-Updated-At", entry.UpdatedAt)
+		}
+
+		_, err := w.Write([]byte(entry.Value))
+		return err
+	})
+}
+
+// configContentType returns the content type of the raw values of the given config type
+func configContentType(configType string) string {
+	if configType == sunbeam.ConfigTypeJSON {
+		return "application/json"
+	}
+
+	return "text/plain; charset=utf-8"
 }
 
 func cmdConfigPut(s *state.State, r *http.Request) response.Response {
@@ -48,17 +244,62 @@ func cmdConfigPut(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
+	err = checkConfigWrite(s, r, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	var body bytes.Buffer
 	_, err = body.ReadFrom(r.Body)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.UpdateConfig(s, key, body.String())
+	// The optional type query parameter declares how the value must be parsed.
+	// Without it the declared type is kept, and an empty type clears it.
+	var configType *string
+	if r.URL.Query().Has("type") {
+		declared := r.URL.Query().Get("type")
+		configType = &declared
+	}
+
+	// A client sending If-Match only wants to overwrite the value it last read.
+	created, err := sunbeam.UpdateConfigIfMatch(r.Context(), s, key, body.String(), configType, r.Header.Get("If-Match"), requestCreator(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	// Creating the key is answered with 201 and its location.
+	if created {
+		return response.SyncResponseLocation(true, types.ConfigWrite{Created: true}, api.NewURL().Path("1.0", "config", key).String())
+	}
+
+	return response.SyncResponse(true, types.ConfigWrite{Created: false})
+}
+
+func cmdConfigPost(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	err = checkConfigWrite(s, r, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	var body bytes.Buffer
+	_, err = body.ReadFrom(r.Body)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	// Unlike PUT, creating a key that already exists fails with a conflict.
+	err = sunbeam.CreateConfig(r.Context(), s, key, body.String(), r.URL.Query().Get("type"), requestCreator(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	return response.EmptySyncResponse
 }
 
@@ -68,15 +309,149 @@ func cmdConfigDelete(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.DeleteConfig(s, key)
+	err = checkConfigWrite(s, r, key)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		return response.SmartError(err)
+	}
+
+	// A dry run reports what the delete would do instead.
+	if shared.IsTrue(r.URL.Query().Get("dryRun")) {
+		plan, err := sunbeam.PlanDeleteConfig(r.Context(), s, key)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, plan)
+	}
+
+	err = sunbeam.DeleteConfig(r.Context(), s, key)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return response.NotFound(err)
 		}
 		return response.InternalError(err)
 	}
 
 	return response.EmptySyncResponse
 }
+
+func cmdConfigTouchPost(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = checkConfigWrite(s, r, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	err = sunbeam.TouchConfig(r.Context(), s, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdConfigRenamePost(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	newKey := r.URL.Query().Get("to")
+	if newKey == "" {
+		return response.BadRequest(fmt.Errorf("Missing new key name in \"to\" parameter"))
+	}
+
+	err = checkConfigWrite(s, r, key, newKey)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	err = sunbeam.RenameConfig(r.Context(), s, key, newKey)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdConfigHistoryGet(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = checkConfigAccess(s, r, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	versions, err := sunbeam.GetConfigHistory(r.Context(), s, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, versions)
+}
+
+func cmdConfigRestorePost(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = checkConfigWrite(s, r, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		return response.BadRequest(fmt.Errorf("Invalid version value %q, expected a positive integer", r.URL.Query().Get("version")))
+	}
+
+	err = sunbeam.RestoreConfig(r.Context(), s, key, version)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdConfigUsageGet(s *state.State, r *http.Request) response.Response {
+	usage, err := sunbeam.GetConfigUsage(r.Context(), s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, usage)
+}
+
+// configACL returns the config ACL of the client of the request
+func configACL(s *state.State, r *http.Request) (sunbeam.ConfigACL, error) {
+	return sunbeam.GetConfigACL(r.Context(), s, requestIdentity(r), isTrusted(r))
+}
+
+// checkConfigAccess fails with a forbidden error unless the client of the request may access all the keys
+func checkConfigAccess(s *state.State, r *http.Request, keys ...string) error {
+	acl, err := configACL(s, r)
+	if err != nil {
+		return err
+	}
+
+	return acl.Check(keys...)
+}
+
+// checkConfigWrite fails unless the client of the request may access all the keys
+// and the keys may be written through the config endpoints
+func checkConfigWrite(s *state.State, r *http.Request, keys ...string) error {
+	err := checkConfigAccess(s, r, keys...)
+	if err != nil {
+		return err
+	}
+
+	return sunbeam.CheckConfigWritable(keys...)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+func TestConfigPutCreated(t *testing.T) {
+	s := newTestState(t)
+
+	// Steps run in order against the same database.
+	steps := []struct {
+		name     string
+		value    string
+		status   int
+		created  bool
+		location string
+	}{
+		{name: "create", value: "v1", status: http.StatusCreated, created: true, location: "/1.0/config/key1"},
+		{name: "update", value: "v2", status: http.StatusOK},
+		{name: "unchanged", value: "v2", status: http.StatusOK},
+	}
+
+	for _, step := range steps {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/1.0/config/key1", strings.NewReader(step.value)), map[string]string{"key": "key1"})
+
+		rec := httptest.NewRecorder()
+		err := cmdConfigPut(s, r).Render(rec)
+		if err != nil {
+			t.Fatalf("%s: Failed to render the response: %v", step.name, err)
+		}
+
+		if rec.Code != step.status {
+			t.Fatalf("%s: PUT returned %d, expected %d: %s", step.name, rec.Code, step.status, rec.Body.String())
+		}
+
+		if rec.Header().Get("Location") != step.location {
+			t.Errorf("%s: PUT returned location %q, expected %q", step.name, rec.Header().Get("Location"), step.location)
+		}
+
+		var body struct {
+			Metadata types.ConfigWrite `json:"metadata"`
+		}
+
+		err = json.Unmarshal(rec.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatalf("%s: Failed to decode the response: %v", step.name, err)
+		}
+
+		write := body.Metadata
+		if write.Created != step.created {
+			t.Errorf("%s: PUT reported created=%v, expected %v", step.name, write.Created, step.created)
+		}
+	}
+}
+
+func TestConfigPutType(t *testing.T) {
+	s := newTestState(t)
+
+	// Steps run in order against the same key.
+	steps := []struct {
+		name       string
+		query      string
+		value      string
+		status     int
+		storedType string
+	}{
+		{name: "declared", query: "?type=int", value: "4", status: http.StatusCreated, storedType: "int"},
+		{name: "kept", value: "five", status: http.StatusBadRequest, storedType: "int"},
+		{name: "cleared", query: "?type=", value: "five", status: http.StatusOK, storedType: ""},
+	}
+
+	for _, step := range steps {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/1.0/config/key1"+step.query, strings.NewReader(step.value)), map[string]string{"key": "key1"})
+
+		rec := httptest.NewRecorder()
+		err := cmdConfigPut(s, r).Render(rec)
+		if err != nil {
+			t.Fatalf("%s: Failed to render the response: %v", step.name, err)
+		}
+
+		if rec.Code != step.status {
+			t.Fatalf("%s: PUT returned %d, expected %d: %s", step.name, rec.Code, step.status, rec.Body.String())
+		}
+
+		entry, err := sunbeam.GetConfigEntry(context.Background(), s, "key1")
+		if err != nil {
+			t.Fatalf("%s: Failed to get the key: %v", step.name, err)
+		}
+
+		if entry.Type != step.storedType {
+			t.Errorf("%s: Key has type %q, expected %q", step.name, entry.Type, step.storedType)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/shared"
+)
+
+// confirmDeleteAll checks that a request clearing all the resources of a type
+// sets all=true and repeats the resource type in the confirm query parameter,
+// so that a stray DELETE on a collection never wipes it.
+func confirmDeleteAll(r *http.Request, resource string) error {
+	if !shared.IsTrue(r.URL.Query().Get("all")) {
+		return fmt.Errorf("Deleting all the %s requires all=true", resource)
+	}
+
+	if r.URL.Query().Get("confirm") != resource {
+		return fmt.Errorf("Deleting all the %s must be confirmed with confirm=%s", resource, resource)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+func TestNodesDeleteAll(t *testing.T) {
+	s := newTestState(t)
+	for _, name := range []string{"node1", "node2"} {
+		err := sunbeam.AddNode(context.Background(), s, name, []string{"compute"}, 0, "", nil, types.NodeCapacity{}, "test")
+		if err != nil {
+			t.Fatalf("Failed to add node %q: %v", name, err)
+		}
+	}
+
+	// Steps run in order against the same database.
+	steps := []struct {
+		query  string
+		status int
+		nodes  int
+	}{
+		{query: "", status: http.StatusBadRequest, nodes: 2},
+		{query: "?all=true", status: http.StatusBadRequest, nodes: 2},
+		{query: "?all=true&confirm=jujuusers", status: http.StatusBadRequest, nodes: 2},
+		{query: "?confirm=nodes", status: http.StatusBadRequest, nodes: 2},
+		{query: "?all=true&confirm=nodes", status: http.StatusOK, nodes: 0},
+	}
+
+	for _, step := range steps {
+		r := httptest.NewRequest(http.MethodDelete, "/1.0/nodes"+step.query, nil)
+		w := httptest.NewRecorder()
+		err := cmdNodesDeleteAll(s, r).Render(w)
+		if err != nil {
+			t.Fatalf("Failed to render the response: %v", err)
+		}
+
+		if w.Code != step.status {
+			t.Errorf("DELETE /1.0/nodes%s returned %d, expected %d: %s", step.query, w.Code, step.status, w.Body.String())
+		}
+
+		nodes, err := sunbeam.ListNodes(context.Background(), s, sunbeam.NodeFilter{})
+		if err != nil {
+			t.Fatalf("Failed to list nodes: %v", err)
+		}
+
+		if len(nodes) != step.nodes {
+			t.Errorf("DELETE /1.0/nodes%s left %d nodes, expected %d", step.query, len(nodes), step.nodes)
+		}
+	}
+}
@@ -7,17 +7,55 @@ import (
 
 // Endpoints is a global list of all API endpoints on the /1.0 endpoint of
 // microcluster.
-var Endpoints = []rest.Endpoint{
+var Endpoints = applyAccessChecks(applyMiddleware([]rest.Endpoint{
 	nodesCmd,
+	nodesReconcileCmd,
+	nodesInventoryCmd,
+	nodesByMemberCmd,
+	nodesEnrollTokensCmd,
 	nodeCmd,
+	nodeRolesPlanCmd,
+	nodeConfigCmd,
+	nodeCordonCmd,
+	nodeUncordonCmd,
 	terraformStateListCmd,
 	terraformStateCmd,
+	terraformStateDiffCmd,
+	terraformStateBackendCmd,
+	terraformStateVerifyCmd,
+	terraformStateImportCmd,
+	terraformStateAccessLogCmd,
 	terraformLockListCmd,
+	terraformLockConflictsCmd,
 	terraformLockCmd,
+	terraformLockStealCmd,
 	terraformUnlockCmd,
+	terraformFsckCmd,
 	jujuusersCmd,
+	jujuusersBatchCmd,
 	jujuuserCmd,
+	configsCmd,
+	configUsageCmd,
 	configCmd,
+	configRenameCmd,
+	configTouchCmd,
+	configHistoryCmd,
+	configRestoreCmd,
+	configRawCmd,
 	manifestsCmd,
+	manifestsSearchCmd,
+	manifestsStatsCmd,
+	manifestsExportCmd,
+	manifestBlobsCmd,
+	manifestBlobCmd,
 	manifestCmd,
-}
+	manifestNodesCmd,
+	manifestTagCmd,
+	schemaCmd,
+	schemaVerifyCmd,
+	dbStatsCmd,
+	capabilitiesCmd,
+	metricsCmd,
+	maintenanceCompactCmd,
+	maintenanceDrainCmd,
+}, requestIDMiddleware, gzipMiddleware, leaderMiddleware, timeoutMiddleware), rateLimitAccessCheck, targetAccessCheck)
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+// gzipThreshold is the response body size in bytes above which responses are compressed.
+const gzipThreshold = 1024
+
+// compressedContentTypes are not worth compressing again.
+var compressedContentTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-tar+gzip",
+	"application/zip",
+	"application/zstd",
+}
+
+// gzipMiddleware compresses large responses when the client accepts gzip encoding.
+func gzipMiddleware(_ rest.Endpoint, _ rest.EndpointAction, next handlerFunc) handlerFunc {
+	return func(s *state.State, r *http.Request) response.Response {
+		resp := next(s, r)
+
+		// Requests forwarded to a target member are relayed by a client that
+		// expects a plain JSON body, so they are never compressed.
+		if !acceptsGzip(r) || r.URL.Query().Get("target") != "" {
+			return resp
+		}
+
+		return &gzipResponse{Response: resp}
+	}
+}
+
+// acceptsGzip returns whether the request advertises support for gzip encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+
+	return false
+}
+
+// gzipResponse renders the wrapped response through a gzipResponseWriter.
+type gzipResponse struct {
+	response.Response
+}
+
+// Render implements response.Response.
+func (g *gzipResponse) Render(w http.ResponseWriter) error {
+	gw := &gzipResponseWriter{ResponseWriter: w, code: http.StatusOK}
+
+	err := g.Response.Render(gw)
+	if err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// gzipResponseWriter buffers the start of the body and switches to gzip
+// encoding once the body grows beyond gzipThreshold.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	code        int
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+// WriteHeader records the status code until it is known whether the body is compressed.
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	g.code = code
+}
+
+// Write implements io.Writer.
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.passthrough {
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf.Write(p)
+	if g.buf.Len() <= gzipThreshold {
+		return len(p), nil
+	}
+
+	err := g.start()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// start writes the headers and the buffered body, compressing it if eligible.
+func (g *gzipResponseWriter) start() error {
+	header := g.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" || isCompressedContentType(header.Get("Content-Type")) {
+		g.passthrough = true
+		g.ResponseWriter.WriteHeader(g.code)
+		_, err := g.ResponseWriter.Write(g.buf.Bytes())
+		return err
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.code)
+
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	return err
+}
+
+// Close flushes the response, writing small bodies uncompressed.
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.passthrough {
+		return nil
+	}
+
+	g.ResponseWriter.WriteHeader(g.code)
+	_, err := g.ResponseWriter.Write(g.buf.Bytes())
+	return err
+}
+
+// isCompressedContentType returns whether the content type is already compressed.
+func isCompressedContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	for _, compressed := range compressedContentTypes {
+		if strings.TrimSpace(mediaType) == compressed {
+			return true
+		}
+	}
+
+	return false
+}
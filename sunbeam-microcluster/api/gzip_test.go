@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		accepts        bool
+	}{
+		{acceptEncoding: "", accepts: false},
+		{acceptEncoding: "gzip", accepts: true},
+		{acceptEncoding: "deflate, gzip;q=0.5", accepts: true},
+		{acceptEncoding: "gzip;q=0", accepts: false},
+		{acceptEncoding: "gzip; q=0.000", accepts: false},
+		{acceptEncoding: "br, deflate", accepts: false},
+		{acceptEncoding: "x-gzip", accepts: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/1.0/config", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			if acceptsGzip(r) != tt.accepts {
+				t.Errorf("acceptsGzip(%q) = %v, expected %v", tt.acceptEncoding, !tt.accepts, tt.accepts)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	large := strings.Repeat("x", 2*gzipThreshold)
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		compressed  bool
+	}{
+		{name: "small", body: "small", compressed: false},
+		{name: "large", body: large, compressed: true},
+		{name: "already compressed", contentType: "application/gzip", body: large, compressed: false},
+		{name: "forwarded", query: "?target=member2", body: large, compressed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := func(_ *state.State, _ *http.Request) response.Response {
+				return response.ManualResponse(func(w http.ResponseWriter) error {
+					if tt.contentType != "" {
+						w.Header().Set("Content-Type", tt.contentType)
+					}
+
+					w.WriteHeader(http.StatusAccepted)
+					_, err := io.WriteString(w, tt.body)
+					return err
+				})
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/1.0/config"+tt.query, nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			rec := httptest.NewRecorder()
+			err := gzipMiddleware(rest.Endpoint{}, rest.EndpointAction{}, next)(nil, r).Render(rec)
+			if err != nil {
+				t.Fatalf("Failed to render response: %v", err)
+			}
+
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("Response status is %d, expected %d", rec.Code, http.StatusAccepted)
+			}
+
+			compressed := rec.Header().Get("Content-Encoding") == "gzip"
+			if compressed != tt.compressed {
+				t.Fatalf("Response compressed is %v, expected %v", compressed, tt.compressed)
+			}
+
+			body := rec.Body.Bytes()
+			if compressed {
+				gr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("Failed to read compressed body: %v", err)
+				}
+
+				body, err = io.ReadAll(gr)
+				if err != nil {
+					t.Fatalf("Failed to read compressed body: %v", err)
+				}
+			}
+
+			if string(body) != tt.body {
+				t.Errorf("Response body holds %d bytes, expected %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
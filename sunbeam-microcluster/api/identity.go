@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/canonical/lxd/lxd/request"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// isTrusted returns whether microcluster authenticated the request.
+// The access value microcluster stores in the request context is of an
+// internal type, so its Trusted field is read by reflection.
+func isTrusted(r *http.Request) bool {
+	access := reflect.ValueOf(r.Context().Value(request.CtxAccess))
+	if access.Kind() != reflect.Struct {
+		return false
+	}
+
+	trusted := access.FieldByName("Trusted")
+	return trusted.IsValid() && trusted.Kind() == reflect.Bool && trusted.Bool()
+}
+
+// requestIdentity returns the common name of the client certificate of a
+// trusted request. It is empty for untrusted requests and for requests over
+// the unix socket, which carry no certificate.
+func requestIdentity(r *http.Request) string {
+	if !isTrusted(r) || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// requestCreator returns the identity to record as the creator of the
+// resources added by the request, falling back to sunbeam.UnknownCreator.
+func requestCreator(r *http.Request) string {
+	identity := requestIdentity(r)
+	if identity == "" {
+		return sunbeam.UnknownCreator
+	}
+
+	return identity
+}
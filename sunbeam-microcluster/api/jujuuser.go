@@ -6,6 +6,7 @@ import (
 	"net/url"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
@@ -19,8 +20,17 @@ import (
 var jujuusersCmd = rest.Endpoint{
 	Path: "jujuusers",
 
-	Get:  rest.EndpointAction{Handler: cmdJujuUsersGetAll, ProxyTarget: true},
-	Post: rest.EndpointAction{Handler: cmdJujuUsersPost, ProxyTarget: true},
+	Get:    rest.EndpointAction{Handler: cmdJujuUsersGetAll, ProxyTarget: true},
+	Post:   rest.EndpointAction{Handler: cmdJujuUsersPost, ProxyTarget: true},
+	Delete: rest.EndpointAction{Handler: cmdJujuUsersDeleteAll, ProxyTarget: true},
+}
+
+// /1.0/jujuusers/batch endpoint.
+// Registered before /1.0/jujuusers/<name> so that it takes precedence over the user named batch.
+var jujuusersBatchCmd = rest.Endpoint{
+	Path: "jujuusers/batch",
+
+	Post: rest.EndpointAction{Handler: cmdJujuUsersBatchPost, ProxyTarget: true},
 }
 
 // /1.0/jujuusers/<name> endpoint.
@@ -31,13 +41,13 @@ var jujuuserCmd = rest.Endpoint{
 	Delete: rest.EndpointAction{Handler: cmdJujuUsersDelete, ProxyTarget: true},
 }
 
-func cmdJujuUsersGetAll(s *state.State, _ *http.Request) response.Response {
-	users, err := sunbeam.ListJujuUsers(s)
+func cmdJujuUsersGetAll(s *state.State, r *http.Request) response.Response {
+	users, err := sunbeam.ListJujuUsers(r.Context(), s)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	return response.SyncResponse(true, users)
+	return paginatedResponse(r, users)
 }
 
 func cmdJujuUsersGet(s *state.State, r *http.Request) response.Response {
@@ -46,12 +56,10 @@ func cmdJujuUsersGet(s *state.State, r *http.Request) response.Response {
 	if err != nil {
 		return response.InternalError(err)
 	}
-	jujuUser, err := sunbeam.GetJujuUser(s, name)
+	jujuUser, err := sunbeam.GetJujuUser(r.Context(), s, name)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return response.NotFound(err)
 		}
 		return response.InternalError(err)
 	}
@@ -67,22 +75,67 @@ func cmdJujuUsersPost(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.AddJujuUser(s, req.Username, req.Token)
+	token, err := sunbeam.AddJujuUser(r.Context(), s, req.Username, req.Token, requestCreator(r))
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	// Return the token generated by the daemon to the client.
+	if req.Token == "" {
+		return response.SyncResponse(true, types.JujuUser{Username: req.Username, Token: token, CreatedBy: requestCreator(r)})
+	}
+
 	return response.EmptySyncResponse
 }
 
+func cmdJujuUsersBatchPost(s *state.State, r *http.Request) response.Response {
+	var req types.JujuUsers
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	// With atomic, a single existing user fails the whole batch.
+	atomic := shared.IsTrue(r.URL.Query().Get("atomic"))
+
+	result, err := sunbeam.AddJujuUsers(r.Context(), s, req, atomic, requestCreator(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, result)
+}
+
+func cmdJujuUsersDeleteAll(s *state.State, r *http.Request) response.Response {
+	err := confirmDeleteAll(r, "jujuusers")
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	count, err := sunbeam.DeleteAllJujuUsers(r.Context(), s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, types.DeleteAll{Count: count})
+}
+
 func cmdJujuUsersDelete(s *state.State, r *http.Request) response.Response {
 	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
 		return response.SmartError(err)
 	}
-	err = sunbeam.DeleteJujuUser(s, name)
+	dryRun := shared.IsTrue(r.URL.Query().Get("dryRun"))
+
+	plan, err := sunbeam.DeleteJujuUser(r.Context(), s, name, dryRun)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
+	}
+
+	// A dry run reports what the delete would do instead.
+	if dryRun {
+		return response.SyncResponse(true, plan)
 	}
 
 	return response.EmptySyncResponse
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// leaderHeader holds the address of the dqlite leader on the writes hinted to it.
+const leaderHeader = "X-Sunbeam-Leader"
+
+// getLeader looks up the dqlite leader, replaced by the tests.
+var getLeader = sunbeam.GetLeader
+
+// leaderMiddleware routes the writes to the endpoints configured as leader-only:
+// the leader processes them, the other members redirect them or hint the leader to
+// the client, and all of them answer 503 while no leader can be found. Local
+// requests over the unix socket are always processed by the member they reach.
+func leaderMiddleware(e rest.Endpoint, _ rest.EndpointAction, next handlerFunc) handlerFunc {
+	return func(s *state.State, r *http.Request) response.Response {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.RemoteAddr == "@" {
+			return next(s, r)
+		}
+
+		mode := middlewareSettings.get(s).leaderWrites[e.Path]
+		if mode == "" {
+			return next(s, r)
+		}
+
+		leader, isLeader, err := getLeader(r.Context(), s)
+		if err != nil {
+			return response.Unavailable(err)
+		}
+
+		if isLeader {
+			return next(s, r)
+		}
+
+		return leaderResponse(mode, leader, r)
+	}
+}
+
+// leaderResponse sends the client of a write to the leader at the given address.
+func leaderResponse(mode string, leader string, r *http.Request) response.Response {
+	location := api.NewURL().Scheme("https").Host(leader).String() + r.URL.RequestURI()
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set(leaderHeader, leader)
+
+		if mode == sunbeam.LeaderWritesRedirect {
+			// 307 keeps the method and the body of the request.
+			http.Redirect(w, r, location, http.StatusTemporaryRedirect)
+			return nil
+		}
+
+		return response.ErrorResponse(http.StatusMisdirectedRequest, fmt.Sprintf("Writes to %s are only processed by the leader at %s", r.URL.Path, leader)).Render(w)
+	})
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+func TestLeaderMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		remoteAddr string
+		setting    string
+		isLeader   bool
+		leaderErr  error
+		status     int
+		location   string
+	}{
+		{name: "leader", method: http.MethodPut, setting: "nodes=redirect", isLeader: true, status: http.StatusOK},
+		{name: "redirect", method: http.MethodPut, setting: "nodes=redirect", status: http.StatusTemporaryRedirect, location: "https://10.0.0.1:7000/1.0/nodes?force=true"},
+		{name: "hint", method: http.MethodPut, setting: "nodes=hint", status: http.StatusMisdirectedRequest},
+		{name: "no leader", method: http.MethodPut, setting: "nodes=hint", leaderErr: errors.New("No dqlite leader is elected"), status: http.StatusServiceUnavailable},
+		{name: "read", method: http.MethodGet, setting: "nodes=redirect", status: http.StatusOK},
+		{name: "unix socket", method: http.MethodPut, remoteAddr: "@", setting: "nodes=redirect", status: http.StatusOK},
+		{name: "other endpoint", method: http.MethodPut, setting: "config=redirect", status: http.StatusOK},
+		{name: "not set", method: http.MethodPut, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+
+			if tt.setting != "" {
+				err := sunbeam.CreateConfig(context.Background(), s, "daemon-leader-writes", tt.setting, "", "")
+				if err != nil {
+					t.Fatalf("Failed to set the leader-only writes: %v", err)
+				}
+			}
+
+			reloadEndpointSettings(t)
+
+			lookup := getLeader
+			t.Cleanup(func() { getLeader = lookup })
+
+			getLeader = func(context.Context, *state.State) (string, bool, error) {
+				return "10.0.0.1:7000", tt.isLeader, tt.leaderErr
+			}
+
+			next := func(*state.State, *http.Request) response.Response {
+				return response.EmptySyncResponse
+			}
+
+			r := httptest.NewRequest(tt.method, "/1.0/nodes?force=true", nil)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+
+			w := httptest.NewRecorder()
+			err := leaderMiddleware(rest.Endpoint{Path: "nodes"}, rest.EndpointAction{}, next)(s, r).Render(w)
+			if err != nil {
+				t.Fatalf("Failed to render the response: %v", err)
+			}
+
+			if w.Code != tt.status {
+				t.Fatalf("Response status is %d, expected %d: %s", w.Code, tt.status, w.Body.String())
+			}
+
+			if w.Header().Get("Location") != tt.location {
+				t.Errorf("Location is %q, expected %q", w.Header().Get("Location"), tt.location)
+			}
+
+			if tt.status == http.StatusTemporaryRedirect || tt.status == http.StatusMisdirectedRequest {
+				if w.Header().Get(leaderHeader) != "10.0.0.1:7000" {
+					t.Errorf("Leader header is %q, expected the leader address", w.Header().Get(leaderHeader))
+				}
+			}
+		})
+	}
+}
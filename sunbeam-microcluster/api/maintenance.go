@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/maintenance/compact endpoint.
+var maintenanceCompactCmd = rest.Endpoint{
+	Path: "maintenance/compact",
+
+	Post: rest.EndpointAction{Handler: cmdMaintenanceCompactPost, ProxyTarget: true},
+}
+
+// /1.0/maintenance/drain endpoint.
+// Draining a member before stopping its daemon lets the in-flight terraform
+// writes complete, while new ones are refused and retried on another member.
+// The daemon also drains itself on shutdown, but without waiting for the writes.
+var maintenanceDrainCmd = rest.Endpoint{
+	Path: "maintenance/drain",
+
+	Post:   rest.EndpointAction{Handler: cmdMaintenanceDrainPost, ProxyTarget: true},
+	Delete: rest.EndpointAction{Handler: cmdMaintenanceDrainDelete, ProxyTarget: true},
+}
+
+func cmdMaintenanceCompactPost(s *state.State, r *http.Request) response.Response {
+	result, err := sunbeam.Compact(r.Context(), s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, result)
+}
+
+func cmdMaintenanceDrainPost(s *state.State, r *http.Request) response.Response {
+	var grace time.Duration
+	timeout := r.URL.Query().Get("timeout")
+	if timeout != "" {
+		var err error
+		grace, err = time.ParseDuration(timeout)
+		if err != nil || grace <= 0 {
+			return response.BadRequest(fmt.Errorf("Invalid timeout %q, expected a positive duration", timeout))
+		}
+	}
+
+	inFlight, err := sunbeam.DrainTerraform(r.Context(), s, grace)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, types.Drain{InFlight: inFlight})
+}
+
+func cmdMaintenanceDrainDelete(_ *state.State, _ *http.Request) response.Response {
+	sunbeam.UndrainTerraform()
+
+	return response.EmptySyncResponse
+}
@@ -1,12 +1,20 @@
 package api
 
 import (
+	"archive/tar"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared"
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
 	"github.com/gorilla/mux"
@@ -23,8 +31,53 @@ var manifestsCmd = rest.Endpoint{
 	Post: rest.EndpointAction{Handler: cmdManifestsPost, ProxyTarget: true, AllowUntrusted: true},
 }
 
+// /1.0/manifests/search endpoint.
+// Registered before /1.0/manifests/<manifestid> so that it takes precedence over the manifest id search.
+var manifestsSearchCmd = rest.Endpoint{
+	Path: "manifests/search",
+
+	Get: rest.EndpointAction{Handler: cmdManifestsSearchGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/manifests/stats endpoint.
+// Registered before /1.0/manifests/<manifestid> so that it takes precedence over the manifest id stats.
+var manifestsStatsCmd = rest.Endpoint{
+	Path: "manifests/stats",
+
+	Get: rest.EndpointAction{Handler: cmdManifestsStatsGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/manifests/export endpoint.
+// Streams the manifests as a tar archive with one file per manifest.
+// Registered before /1.0/manifests/<manifestid> so that it takes precedence over the manifest id export.
+var manifestsExportCmd = rest.Endpoint{
+	Path: "manifests/export",
+
+	Get: rest.EndpointAction{Handler: cmdManifestsExportGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/manifests/blobs endpoint.
+// Removes the data blobs of this member no longer referenced by any manifest.
+// It is called on each member by the compaction, which only runs on one member.
+// Registered before /1.0/manifests/<manifestid> so that it takes precedence over the manifest id blobs.
+var manifestBlobsCmd = rest.Endpoint{
+	Path: "manifests/blobs",
+
+	Delete: rest.EndpointAction{Handler: cmdManifestBlobsDelete, ProxyTarget: true},
+}
+
+// /1.0/manifests/blobs/<hash> endpoint.
+// Returns a data blob held by this member, for the other members to read the
+// manifests whose data was stored on this member.
+var manifestBlobCmd = rest.Endpoint{
+	Path: "manifests/blobs/{hash}",
+
+	Get: rest.EndpointAction{Handler: cmdManifestBlobGet, ProxyTarget: true},
+}
+
 // /1.0/manifests/<manifestid> endpoint.
 // /1.0/manifests/latest will give the latest inserted manifest record
+// /1.0/manifests/<tag> will give the manifest record the tag points to
 var manifestCmd = rest.Endpoint{
 	Path: "manifests/{manifestid}",
 
@@ -32,14 +85,155 @@ var manifestCmd = rest.Endpoint{
 	Delete: rest.EndpointAction{Handler: cmdManifestDelete, ProxyTarget: true, AllowUntrusted: true},
 }
 
-func cmdManifestsGetAll(s *state.State, _ *http.Request) response.Response {
+// /1.0/manifests/<manifestid>/nodes endpoint.
+// Lists the nodes last configured by the manifest.
+var manifestNodesCmd = rest.Endpoint{
+	Path: "manifests/{manifestid}/nodes",
+
+	Get: rest.EndpointAction{Handler: cmdManifestNodesGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/manifests/<manifestid>/tags/<tag> endpoint.
+var manifestTagCmd = rest.Endpoint{
+	Path: "manifests/{manifestid}/tags/{tag}",
 
-	manifests, err := sunbeam.ListManifests(s)
+	Post:   rest.EndpointAction{Handler: cmdManifestTagPost, ProxyTarget: true, AllowUntrusted: true},
+	Delete: rest.EndpointAction{Handler: cmdManifestTagDelete, ProxyTarget: true, AllowUntrusted: true},
+}
+
+func cmdManifestsGetAll(s *state.State, r *http.Request) response.Response {
+	n := -1
+	last := r.URL.Query().Get("last")
+	if last != "" {
+		var err error
+		n, err = strconv.Atoi(last)
+		if err != nil || n < 1 {
+			return response.BadRequest(fmt.Errorf("Invalid last value %q, expected a positive integer", last))
+		}
+	}
+
+	// Manifests with the given status, newest first.
+	if r.URL.Query().Has("status") {
+		manifests, err := sunbeam.ListManifestsByStatus(r.Context(), s, r.URL.Query().Get("status"), n)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return paginatedResponse(r, manifests)
+	}
+
+	if last != "" {
+		manifests, err := sunbeam.ListRecentManifests(r.Context(), s, n)
+		if err != nil {
+			return response.InternalError(err)
+		}
+
+		return response.SyncResponse(true, manifests)
+	}
+
+	manifests, err := sunbeam.ListManifests(r.Context(), s)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	return response.SyncResponse(true, manifests)
+	return paginatedResponse(r, manifests)
+}
+
+func cmdManifestsSearchGet(s *state.State, r *http.Request) response.Response {
+	text := r.URL.Query().Get("q")
+	if text == "" {
+		return response.BadRequest(fmt.Errorf("Missing search term in \"q\" parameter"))
+	}
+
+	limit := -1
+	if r.URL.Query().Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			return response.BadRequest(fmt.Errorf("Invalid limit value %q, expected a positive integer", r.URL.Query().Get("limit")))
+		}
+	}
+
+	matches, err := sunbeam.SearchManifests(r.Context(), s, text, limit)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, matches)
+}
+
+func cmdManifestsStatsGet(s *state.State, r *http.Request) response.Response {
+	stats, err := sunbeam.GetManifestStats(r.Context(), s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, stats)
+}
+
+func cmdManifestsExportGet(s *state.State, r *http.Request) response.Response {
+	n := -1
+	last := r.URL.Query().Get("last")
+	if last != "" {
+		var err error
+		n, err = strconv.Atoi(last)
+		if err != nil || n < 1 {
+			return response.BadRequest(fmt.Errorf("Invalid last value %q, expected a positive integer", last))
+		}
+	}
+
+	// The manifests are listed before the status is sent, streaming them afterwards.
+	ids, err := sunbeam.ExportManifestIDs(r.Context(), s, n)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="manifests.tar"`)
+		w.WriteHeader(http.StatusOK)
+
+		tw := tar.NewWriter(w)
+		err := sunbeam.ExportManifests(r.Context(), s, ids, func(manifest types.Manifest) error {
+			err := tw.WriteHeader(&tar.Header{
+				Name:    manifestArchiveName(manifest),
+				Mode:    0o644,
+				Size:    int64(len(manifest.Data)),
+				ModTime: manifestArchiveTime(manifest),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = io.WriteString(tw, manifest.Data)
+			return err
+		})
+		if err != nil {
+			// The status is already sent, the archive is left truncated.
+			logger.Warn("Failed to export manifests", logger.Ctx{"err": err})
+			return err
+		}
+
+		return tw.Close()
+	})
+}
+
+func cmdManifestBlobsDelete(s *state.State, r *http.Request) response.Response {
+	removed, err := sunbeam.CollectManifestBlobs(r.Context(), s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, removed)
+}
+
+func cmdManifestBlobGet(s *state.State, r *http.Request) response.Response {
+	data, err := sunbeam.GetManifestBlob(s, mux.Vars(r)["hash"])
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, data)
 }
 
 func cmdManifestGet(s *state.State, r *http.Request) response.Response {
@@ -48,12 +242,10 @@ func cmdManifestGet(s *state.State, r *http.Request) response.Response {
 	if err != nil {
 		return response.InternalError(err)
 	}
-	manifest, err := sunbeam.GetManifest(s, manifestid)
+	manifest, err := sunbeam.GetManifest(r.Context(), s, manifestid)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return notFoundResponse("manifest", manifestid)
 		}
 		return response.InternalError(err)
 	}
@@ -69,9 +261,24 @@ func cmdManifestsPost(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.AddManifest(s, req.ManifestID, req.Data)
+	// The manifest is refused if the latest one was applied after the given time, e.g. by a newer run.
+	var afterDate *time.Time
+	if r.URL.Query().Get("afterDate") != "" {
+		value, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("afterDate"))
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid afterDate %q, expected an RFC 3339 time", r.URL.Query().Get("afterDate")))
+		}
+		afterDate = &value
+	}
+
+	manifestid, err := sunbeam.AddManifest(r.Context(), s, req.ManifestID, req.Data, req.Parent, requestCreator(r), req.StartedAt, req.FinishedAt, req.Status, afterDate)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
+	}
+
+	// Return the id assigned by the daemon to the client.
+	if req.ManifestID == "" {
+		return response.SyncResponse(true, types.Manifest{ManifestID: manifestid})
 	}
 
 	return response.EmptySyncResponse
@@ -82,10 +289,91 @@ func cmdManifestDelete(s *state.State, r *http.Request) response.Response {
 	if err != nil {
 		return response.SmartError(err)
 	}
-	err = sunbeam.DeleteManifest(s, manifestid)
+	dryRun := shared.IsTrue(r.URL.Query().Get("dryRun"))
+
+	plan, err := sunbeam.DeleteManifest(r.Context(), s, manifestid, dryRun)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	// A dry run reports what the delete would do instead.
+	if dryRun {
+		return response.SyncResponse(true, plan)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdManifestTagPost(s *state.State, r *http.Request) response.Response {
+	manifestid, err := url.PathUnescape(mux.Vars(r)["manifestid"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	tag, err := url.PathUnescape(mux.Vars(r)["tag"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	// Tags share the namespace of manifest ids when resolving a manifest.
+	if tag == "latest" {
+		return response.BadRequest(fmt.Errorf("Tag %q is reserved", tag))
+	}
+
+	err = sunbeam.TagManifest(r.Context(), s, manifestid, tag, requestCreator(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdManifestTagDelete(s *state.State, r *http.Request) response.Response {
+	manifestid, err := url.PathUnescape(mux.Vars(r)["manifestid"])
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	tag, err := url.PathUnescape(mux.Vars(r)["tag"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = sunbeam.UntagManifest(r.Context(), s, manifestid, tag)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	return response.EmptySyncResponse
 }
+
+func cmdManifestNodesGet(s *state.State, r *http.Request) response.Response {
+	manifestid, err := url.PathUnescape(mux.Vars(r)["manifestid"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	nodes, err := sunbeam.ListManifestNodes(r.Context(), s, manifestid)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return paginatedResponse(r, nodes)
+}
+
+// manifestArchiveName returns the name of the manifest file in the export archive,
+// made of the applied date and the id with the path separators replaced.
+func manifestArchiveName(manifest types.Manifest) string {
+	replacer := strings.NewReplacer("/", "_", "\\", "_", ":", "", " ", "T")
+	return replacer.Replace(manifest.AppliedDate+"_"+manifest.ManifestID) + ".yaml"
+}
+
+// manifestArchiveTime returns the modification time of the manifest file in the export archive
+func manifestArchiveTime(manifest types.Manifest) time.Time {
+	applied, err := sunbeam.ParseManifestAppliedDate(manifest.AppliedDate)
+	if err != nil {
+		return time.Now()
+	}
+
+	return applied
+}
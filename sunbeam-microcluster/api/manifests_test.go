@@ -0,0 +1,133 @@
+package api
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+func TestManifestsGetAllByStatus(t *testing.T) {
+	s := newTestState(t)
+	for _, manifest := range []types.Manifest{
+		{ManifestID: "m1", Status: sunbeam.ManifestStatusFailed},
+		{ManifestID: "m2", Status: sunbeam.ManifestStatusSucceeded},
+		{ManifestID: "m3"},
+		{ManifestID: "m4", Status: sunbeam.ManifestStatusFailed},
+	} {
+		_, err := sunbeam.AddManifest(context.Background(), s, manifest.ManifestID, "{}", "", "test", "", "", manifest.Status, nil)
+		if err != nil {
+			t.Fatalf("Failed to add manifest %q: %v", manifest.ManifestID, err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		ids    []string
+	}{
+		{query: "?status=failed", status: http.StatusOK, ids: []string{"m4", "m1"}},
+		{query: "?status=failed&last=1", status: http.StatusOK, ids: []string{"m4"}},
+		{query: "?status=succeeded", status: http.StatusOK, ids: []string{"m2"}},
+		{query: "?status=", status: http.StatusBadRequest},
+		{query: "?status=pending", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/1.0/manifests"+tt.query, nil)
+
+			var manifests types.Manifests
+			status := renderMetadata(t, cmdManifestsGetAll(s, r).Render, &manifests)
+			if status != tt.status {
+				t.Fatalf("GET /1.0/manifests%s returned %d, expected %d", tt.query, status, tt.status)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			ids := []string{}
+			for _, manifest := range manifests {
+				ids = append(ids, manifest.ManifestID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("GET /1.0/manifests%s returned %v, expected %v", tt.query, ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestManifestsExport(t *testing.T) {
+	s := newTestState(t)
+	for _, manifestid := range []string{"m1", "m2", "m3"} {
+		_, err := sunbeam.AddManifest(context.Background(), s, manifestid, "{"+manifestid+": true}", "", "test", "", "", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to add manifest %q: %v", manifestid, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	err := cmdManifestsExportGet(s, httptest.NewRequest(http.MethodGet, "/1.0/manifests/export?last=2", nil)).Render(rec)
+	if err != nil {
+		t.Fatalf("Failed to render export: %v", err)
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Export returned %d, expected %d", rec.Code, http.StatusOK)
+	}
+
+	// The archive holds the most recent manifests, oldest first.
+	data := []string{}
+	tr := tar.NewReader(rec.Body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to read export archive: %v", err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read %q from export archive: %v", header.Name, err)
+		}
+
+		data = append(data, string(content))
+	}
+
+	expected := []string{"{m2: true}", "{m3: true}"}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Export archive holds %v, expected %v", data, expected)
+	}
+}
+
+func TestManifestsExportListFailure(t *testing.T) {
+	s := newTestState(t)
+
+	// The manifests cannot be listed from a closed database.
+	db := dbtest.NewDB(t, database.SchemaExtensions)
+	_ = db.Close()
+	t.Cleanup(sunbeam.UseTestDatabase(db))
+
+	rec := httptest.NewRecorder()
+	err := cmdManifestsExportGet(s, httptest.NewRequest(http.MethodGet, "/1.0/manifests/export", nil)).Render(rec)
+	if err != nil {
+		t.Fatalf("Failed to render export: %v", err)
+	}
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") == "application/x-tar" {
+		t.Errorf("Export returned %d with %q, expected an error before the archive is sent", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/metrics endpoint.
+// Exposes daemon telemetry in the Prometheus text format, or in the OpenMetrics
+// text format when the client accepts it.
+var metricsCmd = rest.Endpoint{
+	Path: "metrics",
+
+	Get: rest.EndpointAction{Handler: cmdMetricsGet, ProxyTarget: true},
+}
+
+func cmdMetricsGet(s *state.State, r *http.Request) response.Response {
+	lockMetrics, err := sunbeam.GetTerraformLockMetrics(r.Context(), s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		if openMetrics {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		}
+		w.WriteHeader(http.StatusOK)
+		return writeTerraformLockMetrics(w, lockMetrics, openMetrics)
+	})
+}
+
+// writeTerraformLockMetrics writes the terraform lock metrics in the Prometheus text format,
+// or in the OpenMetrics text format if openMetrics is set. OpenMetrics names the counter
+// families without their _total suffix, adds their _created series and ends with # EOF.
+func writeTerraformLockMetrics(w io.Writer, metrics types.TerraformLockMetrics, openMetrics bool) error {
+	var b strings.Builder
+
+	plans := make([]string, 0, len(metrics.LockAges))
+	for plan := range metrics.LockAges {
+		plans = append(plans, plan)
+	}
+	sort.Strings(plans)
+
+	oldest := 0.0
+	b.WriteString("# HELP sunbeam_terraform_lock_age_seconds Age of the held terraform lock.\n")
+	b.WriteString("# TYPE sunbeam_terraform_lock_age_seconds gauge\n")
+	for _, plan := range plans {
+		age := metrics.LockAges[plan]
+		fmt.Fprintf(&b, "sunbeam_terraform_lock_age_seconds{plan=\"%s\"} %g\n", escapeLabelValue(plan), age)
+		if age > oldest {
+			oldest = age
+		}
+	}
+
+	b.WriteString("# HELP sunbeam_terraform_lock_oldest_age_seconds Age of the oldest held terraform lock.\n")
+	b.WriteString("# TYPE sunbeam_terraform_lock_oldest_age_seconds gauge\n")
+	fmt.Fprintf(&b, "sunbeam_terraform_lock_oldest_age_seconds %g\n", oldest)
+
+	if openMetrics {
+		b.WriteString("# HELP sunbeam_terraform_lock_conflicts Terraform lock conflicts served since the daemon started.\n")
+		b.WriteString("# TYPE sunbeam_terraform_lock_conflicts counter\n")
+		fmt.Fprintf(&b, "sunbeam_terraform_lock_conflicts_total %d\n", metrics.LockConflicts)
+		fmt.Fprintf(&b, "sunbeam_terraform_lock_conflicts_created %.3f\n", float64(metrics.LockConflictsSince.UnixMilli())/1000)
+		b.WriteString("# EOF\n")
+	} else {
+		b.WriteString("# HELP sunbeam_terraform_lock_conflicts_total Terraform lock conflicts served since the daemon started.\n")
+		b.WriteString("# TYPE sunbeam_terraform_lock_conflicts_total counter\n")
+		fmt.Fprintf(&b, "sunbeam_terraform_lock_conflicts_total %d\n", metrics.LockConflicts)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeLabelValue escapes a Prometheus label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+// handlerFunc is the signature of endpoint action handlers.
+type handlerFunc func(s *state.State, r *http.Request) response.Response
+
+// middleware wraps the handler of an endpoint action.
+type middleware func(e rest.Endpoint, action rest.EndpointAction, next handlerFunc) handlerFunc
+
+// applyMiddleware wraps the handlers of all actions of the endpoints with the
+// middlewares. The first middleware is the outermost one.
+func applyMiddleware(endpoints []rest.Endpoint, middlewares ...middleware) []rest.Endpoint {
+	for i := range endpoints {
+		e := &endpoints[i]
+		for _, action := range []*rest.EndpointAction{&e.Get, &e.Put, &e.Post, &e.Delete, &e.Patch} {
+			if action.Handler == nil {
+				continue
+			}
+
+			for j := len(middlewares) - 1; j >= 0; j-- {
+				action.Handler = middlewares[j](*e, *action, action.Handler)
+			}
+		}
+	}
+
+	return endpoints
+}
@@ -2,11 +2,21 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/lxd/util"
+	"github.com/canonical/lxd/shared"
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/units"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
 	"github.com/gorilla/mux"
@@ -16,11 +26,14 @@ import (
 )
 
 // /1.0/nodes endpoint.
+// The writes allowing untrusted clients are not forwarded with ?target=, the nodes
+// being recorded in the database shared by all the members.
 var nodesCmd = rest.Endpoint{
 	Path: "nodes",
 
-	Get:  rest.EndpointAction{Handler: cmdNodesGetAll, ProxyTarget: true, AllowUntrusted: true},
-	Post: rest.EndpointAction{Handler: cmdNodesPost, ProxyTarget: true, AllowUntrusted: true},
+	Get:    rest.EndpointAction{Handler: cmdNodesGetAll, ProxyTarget: true, AllowUntrusted: true},
+	Post:   rest.EndpointAction{Handler: cmdNodesPost, AllowUntrusted: true},
+	Delete: rest.EndpointAction{Handler: cmdNodesDeleteAll, ProxyTarget: true},
 }
 
 // /1.0/nodes/<name> endpoint.
@@ -28,16 +41,195 @@ var nodeCmd = rest.Endpoint{
 	Path: "nodes/{name}",
 
 	Get:    rest.EndpointAction{Handler: cmdNodesGet, ProxyTarget: true, AllowUntrusted: true},
-	Put:    rest.EndpointAction{Handler: cmdNodesPut, ProxyTarget: true, AllowUntrusted: true},
-	Delete: rest.EndpointAction{Handler: cmdNodesDelete, ProxyTarget: true, AllowUntrusted: true},
+	Put:    rest.EndpointAction{Handler: cmdNodesPut, AllowUntrusted: true},
+	Patch:  rest.EndpointAction{Handler: cmdNodesPatch, AllowUntrusted: true},
+	Delete: rest.EndpointAction{Handler: cmdNodesDelete, AllowUntrusted: true},
+}
+
+// /1.0/nodes/roles/plan endpoint.
+// Computes the role changes needed to reach the desired roles without applying them.
+var nodeRolesPlanCmd = rest.Endpoint{
+	Path: "nodes/roles/plan",
+
+	Post: rest.EndpointAction{Handler: cmdNodesRolesPlanPost, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/nodes/reconcile endpoint.
+// Updates the nodes to match the authoritative inventory of the machine provider.
+// Registered before /1.0/nodes/<name> so that it takes precedence over the node named reconcile.
+var nodesReconcileCmd = rest.Endpoint{
+	Path: "nodes/reconcile",
+
+	Post: rest.EndpointAction{Handler: cmdNodesReconcilePost, AllowUntrusted: true},
+}
+
+// /1.0/nodes/inventory endpoint.
+// Exports the nodes as an inventory, in the format given with ?format=.
+// Registered before /1.0/nodes/<name> so that it takes precedence over the node named inventory.
+var nodesInventoryCmd = rest.Endpoint{
+	Path: "nodes/inventory",
+
+	Get: rest.EndpointAction{Handler: cmdNodesInventoryGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/nodes/enroll-tokens endpoint.
+// Issues the single-use tokens letting new nodes add themselves with ?enrollToken=.
+// Registered before /1.0/nodes/<name> so that it takes precedence over the node named enroll-tokens.
+var nodesEnrollTokensCmd = rest.Endpoint{
+	Path: "nodes/enroll-tokens",
+
+	Post: rest.EndpointAction{Handler: cmdNodesEnrollTokensPost, ProxyTarget: true},
+}
+
+// /1.0/nodes/by-member endpoint.
+// Groups the nodes by the cluster member that recorded them.
+// Registered before /1.0/nodes/<name> so that it takes precedence over the node named by-member.
+var nodesByMemberCmd = rest.Endpoint{
+	Path: "nodes/by-member",
+
+	Get: rest.EndpointAction{Handler: cmdNodesByMemberGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/nodes/<name>/config/<key> endpoint.
+// Resolves the value of the key for the node through the node, role and global layers.
+var nodeConfigCmd = rest.Endpoint{
+	Path: "nodes/{name}/config/{key}",
+
+	Get: rest.EndpointAction{Handler: cmdNodeConfigGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/nodes/<name>/cordon endpoint.
+var nodeCordonCmd = rest.Endpoint{
+	Path: "nodes/{name}/cordon",
+
+	Post: rest.EndpointAction{Handler: cmdNodeCordonPost, AllowUntrusted: true},
+}
+
+// /1.0/nodes/<name>/uncordon endpoint.
+var nodeUncordonCmd = rest.Endpoint{
+	Path: "nodes/{name}/uncordon",
+
+	Post: rest.EndpointAction{Handler: cmdNodeUncordonPost, AllowUntrusted: true},
 }
 
 func cmdNodesGetAll(s *state.State, r *http.Request) response.Response {
-	roles := r.URL.Query()["role"]
+	filter := sunbeam.NodeFilter{
+		Roles:   r.URL.Query()["role"],
+		Labels:  map[string]string{},
+		Missing: r.URL.Query()["missing"],
+	}
+	names := r.URL.Query()["name"]
+
+	// Nodes holding exactly the given comma separated roles, an empty list selects the nodes without roles.
+	if r.URL.Query().Has("exactRoles") {
+		filter.ExactRoles = []string{}
+		for _, role := range strings.Split(r.URL.Query().Get("exactRoles"), ",") {
+			role = strings.TrimSpace(role)
+			if role != "" && !slices.Contains(filter.ExactRoles, role) {
+				filter.ExactRoles = append(filter.ExactRoles, role)
+			}
+		}
+	}
+
+	cordoned := r.URL.Query().Get("cordoned")
+	if cordoned != "" {
+		value, err := strconv.ParseBool(cordoned)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid cordoned filter %q", cordoned))
+		}
+		filter.Cordoned = &value
+	}
+
+	// Nodes written after the given time, for clients keeping a cache up to date.
+	changedSince := r.URL.Query().Get("changedSince")
+	if changedSince != "" {
+		value, err := time.Parse(time.RFC3339Nano, changedSince)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid changedSince filter %q, expected an RFC 3339 time", changedSince))
+		}
+		filter.ChangedSince = &value
+	}
+
+	// Nodes having at least the given capacity, the sizes may have units such as GiB.
+	if r.URL.Query().Get("minCPUs") != "" {
+		value, err := strconv.Atoi(r.URL.Query().Get("minCPUs"))
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid minCPUs filter %q", r.URL.Query().Get("minCPUs")))
+		}
+		filter.MinCapacity.CPUs = value
+	}
+
+	for param, size := range map[string]*int64{"minMemory": &filter.MinCapacity.Memory, "minDisk": &filter.MinCapacity.Disk} {
+		if r.URL.Query().Get(param) == "" {
+			continue
+		}
+
+		value, err := units.ParseByteSizeString(r.URL.Query().Get(param))
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid %s filter %q: %w", param, r.URL.Query().Get(param), err))
+		}
+		*size = value
+	}
+
+	// Labels are given as key=value pairs.
+	for _, label := range r.URL.Query()["label"] {
+		key, value, found := strings.Cut(label, "=")
+		if !found || key == "" {
+			return response.BadRequest(fmt.Errorf("Invalid label filter %q, expected key=value", label))
+		}
+		filter.Labels[key] = value
+	}
+
+	// The selector is applied to the listed nodes, e.g. "zone in (a,b),!gpu".
+	var selector labelSelector
+	if r.URL.Query().Get("selector") != "" {
+		var err error
+		selector, err = parseLabelSelector(r.URL.Query().Get("selector"))
+		if err != nil {
+			return response.BadRequest(err)
+		}
+	}
+
+	if len(names) > 0 {
+		nodes, err := sunbeam.ListNodesByNames(r.Context(), s, names, filter)
+		if err != nil {
+			return response.SmartError(err)
+		}
+		nodes.Nodes = selector.filter(nodes.Nodes)
 
-	nodes, err := sunbeam.ListNodes(s, roles)
+		return response.SyncResponse(true, nodes)
+	}
+
+	nodes, err := sunbeam.ListNodes(r.Context(), s, filter)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
+	}
+	nodes = selector.filter(nodes)
+
+	return paginatedResponse(r, nodes)
+}
+
+func cmdNodesInventoryGet(s *state.State, r *http.Request) response.Response {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "ansible" {
+		return response.BadRequest(fmt.Errorf("Invalid inventory format %q, expected ansible", format))
+	}
+
+	inventory, err := sunbeam.GetAnsibleInventory(r.Context(), s, sunbeam.NodeFilter{Roles: r.URL.Query()["role"]})
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	// Ansible expects the inventory itself, not wrapped in a SyncResponse.
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		return util.WriteJSON(w, inventory, nil)
+	})
+}
+
+func cmdNodesByMemberGet(s *state.State, r *http.Request) response.Response {
+	nodes, err := sunbeam.ListNodesByMember(r.Context(), s, sunbeam.NodeFilter{Roles: r.URL.Query()["role"]})
+	if err != nil {
+		return response.SmartError(err)
 	}
 
 	return response.SyncResponse(true, nodes)
@@ -49,12 +241,10 @@ func cmdNodesGet(s *state.State, r *http.Request) response.Response {
 	if err != nil {
 		return response.InternalError(err)
 	}
-	node, err := sunbeam.GetNode(s, name)
+	node, err := sunbeam.GetNode(r.Context(), s, name)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return notFoundResponse("node", name)
 		}
 		return response.InternalError(err)
 	}
@@ -70,14 +260,69 @@ func cmdNodesPost(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.AddNode(s, req.Name, req.Role, req.MachineID, req.SystemID)
+	// New nodes add themselves with an enrollment token, which may be required of the untrusted clients.
+	token := r.URL.Query().Get("enrollToken")
+	if token == "" && !isTrusted(r) {
+		required, err := sunbeam.NodeEnrollRequired(r.Context(), s)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		if required {
+			return response.Forbidden(fmt.Errorf("Adding a node requires an enrollment token"))
+		}
+	}
+
+	if token != "" {
+		err = sunbeam.AddEnrolledNode(r.Context(), s, token, req.Name, req.Role, req.MachineID, req.SystemID, req.Metadata, req.NodeCapacity, requestCreator(r))
+	} else {
+		err = sunbeam.AddNode(r.Context(), s, req.Name, req.Role, req.MachineID, req.SystemID, req.Metadata, req.NodeCapacity, requestCreator(r))
+	}
+
 	if err != nil {
-		return response.InternalError(err)
+		// Clients enrolling a machine twice get the node it is already enrolled as.
+		if errors.Is(err, sunbeam.ErrDuplicateSystemID) {
+			conflict, err := sunbeam.GetNodeBySystemID(r.Context(), s, req.SystemID)
+			if err != nil {
+				return response.SmartError(err)
+			}
+
+			return response.ManualResponse(func(w http.ResponseWriter) error {
+				w.WriteHeader(http.StatusConflict)
+				return util.WriteJSON(w, conflict, nil)
+			})
+		}
+
+		return response.SmartError(err)
 	}
 
 	return response.EmptySyncResponse
 }
 
+func cmdNodesEnrollTokensPost(s *state.State, r *http.Request) response.Response {
+	var req types.EnrollTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return response.BadRequest(err)
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid enrollment token TTL %q: %w", req.TTL, err))
+		}
+	}
+
+	token, err := sunbeam.IssueEnrollToken(r.Context(), s, ttl, requestCreator(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, token)
+}
+
 func cmdNodesPut(s *state.State, r *http.Request) response.Response {
 	req := types.Node{MachineID: -1}
 
@@ -91,23 +336,165 @@ func cmdNodesPut(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.UpdateNode(s, name, req.Role, req.MachineID, req.SystemID)
+	// Metadata replaces the existing one unless merge is requested.
+	mergeMetadata := shared.IsTrue(r.URL.Query().Get("merge"))
+
+	err = sunbeam.UpdateNode(r.Context(), s, name, req.Role, req.MachineID, req.SystemID, req.Metadata, mergeMetadata, req.LastManifestID, req.NodeCapacity)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdNodesPatch(s *state.State, r *http.Request) response.Response {
+	var req types.NodePatch
+
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	// Metadata replaces the existing one unless merge is requested.
+	mergeMetadata := shared.IsTrue(r.URL.Query().Get("merge"))
+
+	err = sunbeam.PatchNode(r.Context(), s, name, req, mergeMetadata)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	return response.EmptySyncResponse
 }
 
+func cmdNodesDeleteAll(s *state.State, r *http.Request) response.Response {
+	// Only the nodes holding all the given roles are deleted, e.g. to decommission the compute nodes.
+	if r.URL.Query().Has("role") {
+		force := shared.IsTrue(r.URL.Query().Get("force"))
+
+		deleted, err := sunbeam.DeleteNodesByRole(r.Context(), s, r.URL.Query()["role"], force)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, types.NodesDelete{Deleted: deleted})
+	}
+
+	err := confirmDeleteAll(r, "nodes")
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	count, err := sunbeam.DeleteAllNodes(r.Context(), s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, types.DeleteAll{Count: count})
+}
+
 func cmdNodesDelete(s *state.State, r *http.Request) response.Response {
 	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
 		return response.SmartError(err)
 	}
-	err = sunbeam.DeleteNode(s, name)
+	force := shared.IsTrue(r.URL.Query().Get("force"))
+	dryRun := shared.IsTrue(r.URL.Query().Get("dryRun"))
+
+	plan, err := sunbeam.DeleteNode(r.Context(), s, name, force, dryRun)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	// A dry run reports what the delete would do instead.
+	if dryRun {
+		return response.SyncResponse(true, plan)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdNodesRolesPlanPost(s *state.State, r *http.Request) response.Response {
+	var req map[string][]string
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	plan, err := sunbeam.PlanNodeRoles(r.Context(), s, req)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return response.NotFound(err)
+		}
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, plan)
+}
+
+func cmdNodesReconcilePost(s *state.State, r *http.Request) response.Response {
+	var req map[string]types.NodeInventory
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	changes, err := sunbeam.ReconcileNodes(r.Context(), s, req)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, changes)
+}
+
+func cmdNodeConfigGet(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	acl, err := configACL(s, r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	config, err := sunbeam.GetNodeConfig(r.Context(), s, name, key, acl)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, config)
+}
+
+func cmdNodeCordonPost(s *state.State, r *http.Request) response.Response {
+	return setNodeCordoned(s, r, true)
+}
+
+func cmdNodeUncordonPost(s *state.State, r *http.Request) response.Response {
+	return setNodeCordoned(s, r, false)
+}
+
+func setNodeCordoned(s *state.State, r *http.Request, cordoned bool) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	err = sunbeam.SetNodeCordoned(r.Context(), s, name, cordoned)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	return response.EmptySyncResponse
 }
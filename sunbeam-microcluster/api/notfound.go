@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// notFoundResponse returns a 404 error naming the missing resource, both in the
+// error message and in the metadata so that clients need not parse the message.
+// The body is otherwise the same as for response.NotFound.
+func notFoundResponse(resource string, name string) response.Response {
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusNotFound)
+
+		return json.NewEncoder(w).Encode(api.ResponseRaw{
+			Type:     api.ErrorResponse,
+			Code:     http.StatusNotFound,
+			Error:    fmt.Sprintf("No %s named %q", resource, name),
+			Metadata: types.NotFound{Resource: resource, Name: name},
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/canonical/lxd/lxd/response"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// paginatedResponse returns the page of items selected by the limit and
+// offset query parameters, wrapped in a types.Page.
+// The bare list of items is returned when neither parameter is given.
+func paginatedResponse[T any](r *http.Request, items []T) response.Response {
+	query := r.URL.Query()
+	if !query.Has("limit") && !query.Has("offset") {
+		return response.SyncResponse(true, items)
+	}
+
+	total := len(items)
+	limit := total
+	offset := 0
+
+	var err error
+	if query.Has("limit") {
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 {
+			return response.BadRequest(fmt.Errorf("Invalid limit value %q, expected a positive integer", query.Get("limit")))
+		}
+	}
+
+	if query.Has("offset") {
+		offset, err = strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			return response.BadRequest(fmt.Errorf("Invalid offset value %q, expected a non-negative integer", query.Get("offset")))
+		}
+	}
+
+	start := min(offset, total)
+	// Adding the limit as given may overflow, it is capped to the items left first.
+	end := start + min(limit, total-start)
+
+	page := types.Page[T]{
+		Items:  items[start:end],
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if end < total {
+		page.Next = &end
+	}
+
+	return response.SyncResponse(true, page)
+}
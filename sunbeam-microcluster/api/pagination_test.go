@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// renderMetadata renders the sync response and decodes its metadata into metadata.
+func renderMetadata(t *testing.T, render func(w http.ResponseWriter) error, metadata any) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	err := render(rec)
+	if err != nil {
+		t.Fatalf("Failed to render response: %v", err)
+	}
+
+	if rec.Code != http.StatusOK {
+		return rec.Code
+	}
+
+	var body struct {
+		Metadata json.RawMessage `json:"metadata"`
+	}
+
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	err = json.Unmarshal(body.Metadata, metadata)
+	if err != nil {
+		t.Fatalf("Failed to decode response metadata: %v", err)
+	}
+
+	return rec.Code
+}
+
+func TestPaginatedResponse(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	next := func(offset int) *int { return &offset }
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		page   types.Page[int]
+	}{
+		{name: "first page", query: "?limit=2", status: http.StatusOK, page: types.Page[int]{Items: []int{1, 2}, Total: 5, Limit: 2, Offset: 0, Next: next(2)}},
+		{name: "middle page", query: "?limit=2&offset=2", status: http.StatusOK, page: types.Page[int]{Items: []int{3, 4}, Total: 5, Limit: 2, Offset: 2, Next: next(4)}},
+		{name: "last page", query: "?limit=2&offset=4", status: http.StatusOK, page: types.Page[int]{Items: []int{5}, Total: 5, Limit: 2, Offset: 4}},
+		{name: "offset only", query: "?offset=3", status: http.StatusOK, page: types.Page[int]{Items: []int{4, 5}, Total: 5, Limit: 5, Offset: 3}},
+		{name: "beyond the end", query: "?offset=9", status: http.StatusOK, page: types.Page[int]{Items: []int{}, Total: 5, Limit: 5, Offset: 9}},
+		{name: "largest limit", query: "?limit=9223372036854775807&offset=1", status: http.StatusOK, page: types.Page[int]{Items: []int{2, 3, 4, 5}, Total: 5, Limit: 9223372036854775807, Offset: 1}},
+		{name: "zero limit", query: "?limit=0", status: http.StatusBadRequest},
+		{name: "negative offset", query: "?offset=-1", status: http.StatusBadRequest},
+		{name: "invalid limit", query: "?limit=all", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/1.0/items"+tt.query, nil)
+
+			var page types.Page[int]
+			status := renderMetadata(t, paginatedResponse(r, items).Render, &page)
+			if status != tt.status {
+				t.Fatalf("Response status is %d, expected %d", status, tt.status)
+			}
+
+			if status == http.StatusOK && !reflect.DeepEqual(page, tt.page) {
+				t.Errorf("Page is %+v, expected %+v", page, tt.page)
+			}
+		})
+	}
+}
+
+func TestPaginatedResponseBareList(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/1.0/items", nil)
+
+	var items []int
+	renderMetadata(t, paginatedResponse(r, []int{1, 2, 3}).Render, &items)
+	if !reflect.DeepEqual(items, []int{1, 2, 3}) {
+		t.Errorf("Items are %v, expected the bare list", items)
+	}
+}
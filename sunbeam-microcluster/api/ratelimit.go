@@ -0,0 +1,103 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+// untrustedLimiter limits the requests each client makes to the untrusted endpoints.
+var untrustedLimiter = &rateLimiter{buckets: map[string]*tokenBucket{}}
+
+// rateLimitAccessCheck rejects requests to untrusted endpoints with 429 once
+// the client has exhausted its token bucket. Running as an access check, it also
+// limits the requests forwarded to another member with ?target=. Local requests
+// over the unix socket and requests from trusted clients are never limited.
+func rateLimitAccessCheck(action rest.EndpointAction) handlerFunc {
+	if !action.AllowUntrusted {
+		return nil
+	}
+
+	return func(s *state.State, r *http.Request) response.Response {
+		if r.RemoteAddr == "@" || isTrusted(r) {
+			return response.EmptySyncResponse
+		}
+
+		settings := middlewareSettings.get(s)
+		if !untrustedLimiter.allow(settings.rate, settings.burst, clientIP(r)) {
+			return response.ErrorResponse(http.StatusTooManyRequests, fmt.Sprintf("Too many requests from %s", clientIP(r)))
+		}
+
+		return response.EmptySyncResponse
+	}
+}
+
+// clientIP returns the IP address the request was made from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// tokenBucket holds the tokens left to a client and when they were last refilled.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per client token bucket rate limiter.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// allow takes a token from the bucket of the client, returning false if there is
+// none left. rate is in requests per second, and no request is limited without.
+func (l *rateLimiter) allow(rate float64, burst int, client string) bool {
+	if rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	capacity := max(float64(burst), 1)
+
+	// Forget the clients whose bucket has refilled since.
+	if now.Sub(l.pruned) > settingsRefreshInterval {
+		l.pruned = now
+		for client, bucket := range l.buckets {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*rate >= capacity {
+				delete(l.buckets, client)
+			}
+		}
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
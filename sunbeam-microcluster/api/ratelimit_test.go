@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/request"
+	"github.com/canonical/microcluster/rest"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// testAccess mimics the access microcluster records in the request context.
+type testAccess struct {
+	Trusted bool
+}
+
+// newTestRequest returns a request from the remote address, trusted or not.
+func newTestRequest(remoteAddr string, trusted bool) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/1.0/config", nil)
+	r.RemoteAddr = remoteAddr
+
+	return r.WithContext(context.WithValue(r.Context(), request.CtxAccess, testAccess{Trusted: trusted}))
+}
+
+func TestRateLimitAccessCheck(t *testing.T) {
+	previous := untrustedLimiter
+	t.Cleanup(func() { untrustedLimiter = previous })
+
+	handler := rateLimitAccessCheck(rest.EndpointAction{AllowUntrusted: true})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		trusted    bool
+		limited    bool
+	}{
+		{name: "untrusted", remoteAddr: "10.0.0.1:1234", trusted: false, limited: true},
+		{name: "trusted", remoteAddr: "10.0.0.2:1234", trusted: true, limited: false},
+		{name: "unix socket", remoteAddr: "@", trusted: false, limited: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useEndpointSettings(t, endpointSettings{rate: 0.001, burst: 2})
+			untrustedLimiter = &rateLimiter{buckets: map[string]*tokenBucket{}}
+
+			limited := false
+			for i := 0; i < 5; i++ {
+				rec := httptest.NewRecorder()
+				err := handler(nil, newTestRequest(tt.remoteAddr, tt.trusted)).Render(rec)
+				if err != nil {
+					t.Fatalf("Failed to render response: %v", err)
+				}
+
+				if rec.Code == http.StatusTooManyRequests {
+					limited = true
+				}
+			}
+
+			if limited != tt.limited {
+				t.Errorf("Requests limited = %v, expected %v", limited, tt.limited)
+			}
+		})
+	}
+}
+
+func TestRateLimitTrustedOnlyEndpoints(t *testing.T) {
+	// Endpoints that are not open to untrusted clients are not checked.
+	if rateLimitAccessCheck(rest.EndpointAction{}) != nil {
+		t.Fatalf("Trusted-only endpoint is rate limited")
+	}
+}
+
+func TestRateLimitForwardedRequests(t *testing.T) {
+	s := newTestState(t)
+	err := sunbeam.CreateConfig(context.Background(), s, "daemon-ratelimit-rate", "0.001", "", "test")
+	if err != nil {
+		t.Fatalf("Failed to set the rate: %v", err)
+	}
+
+	err = sunbeam.CreateConfig(context.Background(), s, "daemon-ratelimit-burst", "1", "", "test")
+	if err != nil {
+		t.Fatalf("Failed to set the burst: %v", err)
+	}
+
+	previous := untrustedLimiter
+	t.Cleanup(func() { untrustedLimiter = previous })
+	untrustedLimiter = &rateLimiter{buckets: map[string]*tokenBucket{}}
+	reloadEndpointSettings(t)
+
+	endpoints := applyAccessChecks([]rest.Endpoint{{
+		Path: "config/{key}",
+
+		Get: rest.EndpointAction{Handler: cmdConfigGet, ProxyTarget: true, AllowUntrusted: true},
+	}}, rateLimitAccessCheck, targetAccessCheck)
+
+	// The requests are checked before microcluster forwards them, whatever their target.
+	statuses := []int{}
+	for _, target := range []string{"member1", "member2", "member1"} {
+		r := newTestRequest("10.0.0.4:1234", false)
+		r.URL.RawQuery = "target=" + target
+
+		rec := httptest.NewRecorder()
+		err := endpoints[0].Get.AccessHandler(s, r).Render(rec)
+		if err != nil {
+			t.Fatalf("Failed to render response: %v", err)
+		}
+
+		statuses = append(statuses, rec.Code)
+	}
+
+	expected := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Requests returned %v, expected %v", statuses, expected)
+	}
+}
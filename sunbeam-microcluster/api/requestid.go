@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+	"github.com/gorilla/mux"
+)
+
+// requestIDHeader carries the correlation id of a request and its response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest client supplied request id kept as is.
+const maxRequestIDLength = 128
+
+// requestIDMiddleware gives each request the id supplied by the client, or a new
+// one, echoes it in the response and logs a line per request once it is answered.
+func requestIDMiddleware(e rest.Endpoint, _ rest.EndpointAction, next handlerFunc) handlerFunc {
+	return func(s *state.State, r *http.Request) response.Response {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		// Handlers relaying the request to other members pass the id along.
+		r.Header.Set(requestIDHeader, id)
+
+		return &requestIDResponse{
+			Response: next(s, r),
+			id:       id,
+			request:  r,
+			endpoint: e.Path,
+			start:    time.Now(),
+		}
+	}
+}
+
+// validRequestID returns whether the client supplied request id can be kept.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random request id.
+func newRequestID() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// requestIDResponse renders the wrapped response with the request id header and logs the request.
+type requestIDResponse struct {
+	response.Response
+
+	id       string
+	request  *http.Request
+	endpoint string
+	start    time.Time
+}
+
+// Render implements response.Response.
+func (l *requestIDResponse) Render(w http.ResponseWriter) error {
+	w.Header().Set(requestIDHeader, l.id)
+
+	sw := &statusResponseWriter{ResponseWriter: w, code: http.StatusOK}
+	err := l.Response.Render(sw)
+
+	ctx := logger.Ctx{
+		"id":       l.id,
+		"method":   l.request.Method,
+		"path":     l.request.URL.Path,
+		"endpoint": l.endpoint,
+		"status":   sw.code,
+		"duration": time.Since(l.start).String(),
+	}
+
+	name, unescapeErr := url.PathUnescape(mux.Vars(l.request)["name"])
+	if unescapeErr == nil && name != "" {
+		ctx["resource"] = name
+	}
+
+	if err != nil {
+		ctx["err"] = err
+	}
+
+	logger.Info("Handled request", ctx)
+
+	return err
+}
+
+// statusResponseWriter records the status code of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+
+	code int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (s *statusResponseWriter) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
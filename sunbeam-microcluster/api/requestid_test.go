@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{name: "empty", id: "", valid: false},
+		{name: "uuid", id: "0f8fad5b-d9cb-469f-a165-70867728950e", valid: true},
+		{name: "punctuation", id: "run:42/step=3", valid: true},
+		{name: "space", id: "run 42", valid: false},
+		{name: "newline", id: "run\n42", valid: false},
+		{name: "non-ASCII", id: "run-é", valid: false},
+		{name: "longest", id: strings.Repeat("a", maxRequestIDLength), valid: true},
+		{name: "too long", id: strings.Repeat("a", maxRequestIDLength+1), valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if validRequestID(tt.id) != tt.valid {
+				t.Errorf("validRequestID(%q) = %v, expected %v", tt.id, !tt.valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		kept bool
+	}{
+		{name: "client id", id: "client-id-1", kept: true},
+		{name: "no id", id: "", kept: false},
+		{name: "invalid id", id: "client id", kept: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwarded string
+			next := func(_ *state.State, r *http.Request) response.Response {
+				forwarded = r.Header.Get(requestIDHeader)
+				return response.EmptySyncResponse
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/1.0/config", nil)
+			if tt.id != "" {
+				r.Header.Set(requestIDHeader, tt.id)
+			}
+
+			rec := httptest.NewRecorder()
+			err := requestIDMiddleware(rest.Endpoint{Path: "config"}, rest.EndpointAction{}, next)(nil, r).Render(rec)
+			if err != nil {
+				t.Fatalf("Failed to render response: %v", err)
+			}
+
+			id := rec.Header().Get(requestIDHeader)
+			if !validRequestID(id) {
+				t.Fatalf("Response has invalid request id %q", id)
+			}
+
+			if (id == tt.id) != tt.kept {
+				t.Errorf("Response request id is %q, client id %q kept %v", id, tt.id, tt.kept)
+			}
+
+			if forwarded != id {
+				t.Errorf("Handler got request id %q, response has %q", forwarded, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/schema endpoint.
+var schemaCmd = rest.Endpoint{
+	Path: "schema",
+
+	Get: rest.EndpointAction{Handler: cmdSchemaGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/schema/verify endpoint.
+// Checks the schema extensions were all applied and the tables match them.
+var schemaVerifyCmd = rest.Endpoint{
+	Path: "schema/verify",
+
+	Get: rest.EndpointAction{Handler: cmdSchemaVerifyGet, ProxyTarget: true, AllowUntrusted: true},
+}
+
+// /1.0/db/stats endpoint.
+// Reports the number of rows of the tables and the size of the config values.
+var dbStatsCmd = rest.Endpoint{
+	Path: "db/stats",
+
+	Get: rest.EndpointAction{Handler: cmdDBStatsGet, ProxyTarget: true},
+}
+
+func cmdSchemaGet(s *state.State, r *http.Request) response.Response {
+	schema, err := sunbeam.GetSchema(r.Context(), s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, schema)
+}
+
+func cmdSchemaVerifyGet(s *state.State, r *http.Request) response.Response {
+	verify, err := sunbeam.VerifySchema(r.Context(), s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, verify)
+}
+
+func cmdDBStatsGet(s *state.State, r *http.Request) response.Response {
+	stats, err := sunbeam.GetDBStats(r.Context(), s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, stats)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// Operators of the label selector requirements.
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorIn        = "in"
+	selectorNotIn     = "notin"
+	selectorExists    = "exists"
+	selectorAbsent    = "!"
+)
+
+// labelRequirement is a single requirement of a label selector on the node metadata
+type labelRequirement struct {
+	key      string
+	operator string
+	values   []string
+}
+
+// labelSelector is a list of requirements which must all be satisfied
+type labelSelector []labelRequirement
+
+// parseLabelSelector parses a comma separated list of requirements in the form of
+// the Kubernetes label selectors:
+//
+//	key=value, key==value, key!=value, key in (a,b), key notin (a,b), key, !key
+func parseLabelSelector(selector string) (labelSelector, error) {
+	parsed := labelSelector{}
+
+	for _, part := range splitSelector(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("Invalid selector %q, empty requirement", selector)
+		}
+
+		requirement, err := parseLabelRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, requirement)
+	}
+
+	return parsed, nil
+}
+
+// splitSelector splits the selector on the commas which are not within a set of values
+func splitSelector(selector string) []string {
+	parts := []string{}
+	depth := 0
+	start := 0
+
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, selector[start:])
+}
+
+// parseLabelRequirement parses a single requirement of a label selector
+func parseLabelRequirement(part string) (labelRequirement, error) {
+	if strings.HasPrefix(part, "!") && !strings.Contains(part, "=") {
+		key := strings.TrimSpace(part[1:])
+		if !validSelectorKey(key) {
+			return labelRequirement{}, fmt.Errorf("Invalid selector requirement %q", part)
+		}
+
+		return labelRequirement{key: key, operator: selectorAbsent}, nil
+	}
+
+	for _, operator := range []string{selectorNotEquals, "==", selectorEquals} {
+		key, value, found := strings.Cut(part, operator)
+		if !found {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !validSelectorKey(key) || strings.ContainsAny(value, "=!() ") {
+			return labelRequirement{}, fmt.Errorf("Invalid selector requirement %q", part)
+		}
+
+		if operator == "==" {
+			operator = selectorEquals
+		}
+
+		return labelRequirement{key: key, operator: operator, values: []string{value}}, nil
+	}
+
+	fields := strings.Fields(part)
+	if len(fields) == 1 {
+		if !validSelectorKey(fields[0]) {
+			return labelRequirement{}, fmt.Errorf("Invalid selector requirement %q", part)
+		}
+
+		return labelRequirement{key: fields[0], operator: selectorExists}, nil
+	}
+
+	// Set based requirements, the values may be separated from the operator by spaces.
+	key, rest, _ := strings.Cut(part, " ")
+	rest = strings.TrimSpace(rest)
+	for _, operator := range []string{selectorNotIn, selectorIn} {
+		if !strings.HasPrefix(rest, operator) {
+			continue
+		}
+
+		set := strings.TrimSpace(strings.TrimPrefix(rest, operator))
+		if !validSelectorKey(key) || !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+			return labelRequirement{}, fmt.Errorf("Invalid selector requirement %q", part)
+		}
+
+		values := []string{}
+		for _, value := range strings.Split(set[1:len(set)-1], ",") {
+			value = strings.TrimSpace(value)
+			if value == "" || strings.ContainsAny(value, "=!() ") {
+				return labelRequirement{}, fmt.Errorf("Invalid selector requirement %q", part)
+			}
+			values = append(values, value)
+		}
+
+		return labelRequirement{key: key, operator: operator, values: values}, nil
+	}
+
+	return labelRequirement{}, fmt.Errorf("Invalid selector requirement %q", part)
+}
+
+// validSelectorKey returns whether the key can be used in a selector requirement
+func validSelectorKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "=!(), ")
+}
+
+// matches returns whether the labels satisfy all the requirements of the selector
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.key]
+
+		switch requirement.operator {
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorAbsent:
+			if ok {
+				return false
+			}
+		case selectorEquals, selectorIn:
+			if !ok || !slices.Contains(requirement.values, value) {
+				return false
+			}
+		case selectorNotEquals, selectorNotIn:
+			// Like Kubernetes, nodes without the label satisfy the negative requirements.
+			if ok && slices.Contains(requirement.values, value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// filter returns the nodes whose metadata satisfies the selector
+func (s labelSelector) filter(nodes types.Nodes) types.Nodes {
+	selected := types.Nodes{}
+	for _, node := range nodes {
+		if s.matches(node.Metadata) {
+			selected = append(selected, node)
+		}
+	}
+
+	return selected
+}
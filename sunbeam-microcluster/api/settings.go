@@ -0,0 +1,107 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// settingsRefreshInterval is how often the settings applied by the middlewares are reloaded.
+const settingsRefreshInterval = 30 * time.Second
+
+// fallbackRequestTimeout bounds requests until the request timeout settings can be loaded.
+const fallbackRequestTimeout = time.Minute
+
+// middlewareSettings holds the settings the middlewares apply to every request.
+var middlewareSettings = &settingsSnapshot{}
+
+// endpointSettings are the daemon settings applied by the middlewares.
+type endpointSettings struct {
+	// leaderWrites is the leader-only write mode keyed by endpoint path
+	leaderWrites map[string]string
+	// timeouts is the request timeout keyed by endpoint path, "*" holding the default
+	timeouts map[string]time.Duration
+	// rate and burst limit the requests of each untrusted client
+	rate  float64
+	burst int
+}
+
+// timeout returns the request timeout of the endpoint with the given path.
+func (e endpointSettings) timeout(path string) time.Duration {
+	timeout, ok := e.timeouts[path]
+	if !ok {
+		timeout, ok = e.timeouts["*"]
+	}
+
+	if !ok {
+		timeout = fallbackRequestTimeout
+	}
+
+	return timeout
+}
+
+// settingsSnapshot caches the endpoint settings. A single request reloads them
+// once they are stale, without holding the lock, while the other requests keep
+// being served with the previous snapshot.
+type settingsSnapshot struct {
+	mu sync.Mutex
+
+	settings  endpointSettings
+	refreshed time.Time
+}
+
+// get returns the endpoint settings, reloading them once they are stale.
+func (c *settingsSnapshot) get(s *state.State) endpointSettings {
+	c.mu.Lock()
+	settings := c.settings
+	stale := time.Since(c.refreshed) > settingsRefreshInterval
+	if stale {
+		c.refreshed = time.Now()
+	}
+
+	c.mu.Unlock()
+
+	if !stale {
+		return settings
+	}
+
+	settings = loadEndpointSettings(s, settings)
+
+	c.mu.Lock()
+	c.settings = settings
+	c.mu.Unlock()
+
+	return settings
+}
+
+// loadEndpointSettings loads the endpoint settings from the database, replaced
+// by the tests. The previous settings are kept for those that cannot be loaded.
+var loadEndpointSettings = func(s *state.State, settings endpointSettings) endpointSettings {
+	modes, err := sunbeam.GetLeaderWrites(s.Context, s)
+	if err != nil {
+		logger.Warn("Failed to load leader-only write settings", logger.Ctx{"err": err})
+	} else {
+		settings.leaderWrites = modes
+	}
+
+	timeouts, err := sunbeam.GetRequestTimeouts(s.Context, s)
+	if err != nil {
+		logger.Warn("Failed to load request timeout settings", logger.Ctx{"err": err})
+	} else {
+		settings.timeouts = timeouts
+	}
+
+	rate, burst, err := sunbeam.GetRateLimit(s.Context, s)
+	if err != nil {
+		logger.Warn("Failed to load rate limit settings", logger.Ctx{"err": err})
+	} else {
+		settings.rate = rate
+		settings.burst = burst
+	}
+
+	return settings
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// useEndpointSettings makes the middlewares apply the given settings. They are
+// considered fresh so that they are not loaded from the database.
+func useEndpointSettings(t *testing.T, settings endpointSettings) {
+	t.Helper()
+
+	previous := middlewareSettings
+	t.Cleanup(func() { middlewareSettings = previous })
+	middlewareSettings = &settingsSnapshot{settings: settings, refreshed: time.Now()}
+}
+
+// reloadEndpointSettings makes the middlewares load their settings from the database.
+func reloadEndpointSettings(t *testing.T) {
+	t.Helper()
+
+	previous := middlewareSettings
+	t.Cleanup(func() { middlewareSettings = previous })
+	middlewareSettings = &settingsSnapshot{}
+}
+
+func TestSettingsSnapshot(t *testing.T) {
+	s := newTestState(t)
+	reloadEndpointSettings(t)
+
+	for key, value := range map[string]string{
+		"daemon-leader-writes":    "nodes=hint",
+		"daemon-request-timeouts": "nodes=2m",
+		"daemon-ratelimit-rate":   "5",
+	} {
+		err := sunbeam.CreateConfig(context.Background(), s, key, value, "", "test")
+		if err != nil {
+			t.Fatalf("Failed to set %q: %v", key, err)
+		}
+	}
+
+	load := loadEndpointSettings
+	t.Cleanup(func() { loadEndpointSettings = load })
+
+	loads := 0
+	loadEndpointSettings = func(s *state.State, settings endpointSettings) endpointSettings {
+		loads++
+		return load(s, settings)
+	}
+
+	// The middlewares of a request share a single load of the settings.
+	next := func(*state.State, *http.Request) response.Response {
+		return response.EmptySyncResponse
+	}
+
+	e := rest.Endpoint{Path: "nodes", Get: rest.EndpointAction{Handler: next, AllowUntrusted: true}}
+	handler := rateLimitAccessCheck(e.Get)
+	for _, m := range []middleware{leaderMiddleware, timeoutMiddleware} {
+		handler = m(e, e.Get, handler)
+	}
+
+	err := handler(s, newTestRequest("10.0.0.1:1234", false)).Render(httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("Failed to render the response: %v", err)
+	}
+
+	if loads != 1 {
+		t.Errorf("Settings were loaded %d times, expected once", loads)
+	}
+
+	settings := middlewareSettings.get(s)
+	if settings.leaderWrites["nodes"] != sunbeam.LeaderWritesHint || settings.timeout("nodes") != 2*time.Minute || settings.rate != 5 {
+		t.Errorf("Loaded settings %+v, expected the stored ones", settings)
+	}
+}
+
+func TestSettingsSnapshotRefresh(t *testing.T) {
+	useEndpointSettings(t, endpointSettings{rate: 1})
+	middlewareSettings.refreshed = time.Time{}
+
+	load := loadEndpointSettings
+	t.Cleanup(func() { loadEndpointSettings = load })
+
+	loading, release := make(chan struct{}), make(chan struct{})
+	loadEndpointSettings = func(_ *state.State, settings endpointSettings) endpointSettings {
+		close(loading)
+		<-release
+		settings.rate = 2
+		return settings
+	}
+
+	refreshed := make(chan endpointSettings)
+	go func() {
+		refreshed <- middlewareSettings.get(nil)
+	}()
+
+	<-loading
+
+	// The other requests are served the previous settings while they are reloaded.
+	done := make(chan endpointSettings)
+	go func() {
+		done <- middlewareSettings.get(nil)
+	}()
+
+	select {
+	case settings := <-done:
+		if settings.rate != 1 {
+			t.Errorf("Settings served during the reload have rate %v, expected 1", settings.rate)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Request was held up by the reload of the settings")
+	}
+
+	close(release)
+	if settings := <-refreshed; settings.rate != 2 {
+		t.Errorf("Reloaded settings have rate %v, expected 2", settings.rate)
+	}
+
+	if settings := middlewareSettings.get(nil); settings.rate != 2 {
+		t.Errorf("Settings after the reload have rate %v, expected 2", settings.rate)
+	}
+}
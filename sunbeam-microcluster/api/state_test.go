@@ -0,0 +1,26 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// newTestState returns a daemon state for the cluster member member1 whose
+// transactions run against a fresh in-memory database.
+func newTestState(t *testing.T) *state.State {
+	t.Helper()
+
+	restore := sunbeam.UseTestDatabase(dbtest.NewDB(t, database.SchemaExtensions))
+	t.Cleanup(restore)
+
+	return &state.State{
+		Context: context.Background(),
+		Name:    func() string { return "member1" },
+	}
+}
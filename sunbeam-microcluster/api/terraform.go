@@ -3,16 +3,24 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
 	"github.com/canonical/lxd/lxd/util"
+	"github.com/canonical/lxd/shared"
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
 	"github.com/gorilla/mux"
 
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 )
 
@@ -41,6 +49,48 @@ var terraformStateCmd = rest.Endpoint{
 	Delete: rest.EndpointAction{Handler: cmdStateDelete, AllowUntrusted: true},
 }
 
+// /1.0/terraformstate/{name}/diff endpoint.
+// Compares the resources of the state of the plan with the state of the plan given in ?with=,
+// in the same workspace or in the one given in ?withWorkspace=.
+// Only the current state of a plan is kept, so the serials of a plan cannot be compared.
+var terraformStateDiffCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/diff",
+
+	Get: rest.EndpointAction{Handler: cmdStateDiffGet, ProxyTarget: true},
+}
+
+// /1.0/terraformstate/{name}/verify endpoint.
+// Checks the integrity of the stored state of the plan.
+var terraformStateVerifyCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/verify",
+
+	Get: rest.EndpointAction{Handler: cmdStateVerifyGet, ProxyTarget: true},
+}
+
+// /1.0/terraformstate/{name}/access-log endpoint.
+// Lists the recorded accesses to the state of the plan, most recent first.
+var terraformStateAccessLogCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/access-log",
+
+	Get: rest.EndpointAction{Handler: cmdStateAccessLogGet, ProxyTarget: true},
+}
+
+// /1.0/terraformstate/{name}/import endpoint.
+// Creates the state of a plan without one, refusing to overwrite an existing state.
+var terraformStateImportCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/import",
+
+	Post: rest.EndpointAction{Handler: cmdStateImportPost, ProxyTarget: true},
+}
+
+// /1.0/terraformstate/{name}/backend endpoint.
+// Returns the terraform http backend configuration pointing to the endpoints of the plan.
+var terraformStateBackendCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/backend",
+
+	Get: rest.EndpointAction{Handler: cmdStateBackendGet, AllowUntrusted: true},
+}
+
 // /1.0/terraformlock endpoint.
 var terraformLockListCmd = rest.Endpoint{
 	Path: "terraformlock",
@@ -48,6 +98,15 @@ var terraformLockListCmd = rest.Endpoint{
 	Get: rest.EndpointAction{Handler: cmdLockList, AllowUntrusted: true},
 }
 
+// /1.0/terraformlock/conflicts endpoint.
+// Counts the lock conflicts by the identity of the conflicting requests.
+// Registered before /1.0/terraformlock/{name} so that it takes precedence over the plan named conflicts.
+var terraformLockConflictsCmd = rest.Endpoint{
+	Path: "terraformlock/conflicts",
+
+	Get: rest.EndpointAction{Handler: cmdLockConflictsGet, ProxyTarget: true},
+}
+
 // /1.0/terraformlock/{name} endpoint.
 var terraformLockCmd = rest.Endpoint{
 	Path: "terraformlock/{name}",
@@ -56,6 +115,14 @@ var terraformLockCmd = rest.Endpoint{
 	Put: rest.EndpointAction{Handler: cmdLockPut, AllowUntrusted: true},
 }
 
+// /1.0/terraformlock/{name}/steal endpoint.
+// Replaces the lock held on the plan after the grace given in ?grace=.
+var terraformLockStealCmd = rest.Endpoint{
+	Path: "terraformlock/{name}/steal",
+
+	Put: rest.EndpointAction{Handler: cmdLockStealPut, AllowUntrusted: true},
+}
+
 // /1.0/terraformunlock/{name} endpoint.
 var terraformUnlockCmd = rest.Endpoint{
 	Path: "terraformunlock/{name}",
@@ -63,30 +130,89 @@ var terraformUnlockCmd = rest.Endpoint{
 	Put: rest.EndpointAction{Handler: cmdUnlockPut, AllowUntrusted: true},
 }
 
-func cmdStateList(s *state.State, _ *http.Request) response.Response {
-	plans, err := sunbeam.GetTerraformStates(s)
+// /1.0/terraform/fsck endpoint.
+// Checks for terraform locks and states without their counterpart,
+// removing the orphaned locks older than the lock TTL with ?repair=true.
+var terraformFsckCmd = rest.Endpoint{
+	Path: "terraform/fsck",
+
+	Post: rest.EndpointAction{Handler: cmdTerraformFsckPost, ProxyTarget: true},
+}
 
+// terraformPlanName returns the plan name of the request, unescaped once.
+// The router matches the escaped path, so names may hold encoded slashes and
+// other separators which are kept as is in the plan keys.
+// The plans of the workspace given in ?workspace= are isolated from the plans
+// of the same name in the other workspaces.
+func terraformPlanName(r *http.Request) (string, error) {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
-		return response.InternalError(err)
+		return "", fmt.Errorf("Invalid plan name %q: %w", mux.Vars(r)["name"], err)
 	}
 
-	return response.SyncResponse(true, plans)
+	err = sunbeam.ValidateTerraformPlanName(name)
+	if err != nil {
+		return "", err
+	}
+
+	return sunbeam.TerraformWorkspacePlan(r.URL.Query().Get("workspace"), name)
 }
 
-func cmdStateGet(s *state.State, r *http.Request) response.Response {
-	var name string
+// terraformPlanList returns the plans of the workspace given in ?workspace=, or
+// all the plans grouped by workspace with ?grouped=true.
+func terraformPlanList(r *http.Request, plans []string) response.Response {
+	if shared.IsTrue(r.URL.Query().Get("grouped")) {
+		return response.SyncResponse(true, sunbeam.GroupTerraformPlans(plans))
+	}
+
+	return paginatedResponse(r, sunbeam.TerraformWorkspacePlans(plans, r.URL.Query().Get("workspace")))
+}
+
+func cmdStateList(s *state.State, r *http.Request) response.Response {
+	// The lock status of each plan of the workspace, to render all the plans in a single call.
+	if shared.IsTrue(r.URL.Query().Get("lockStatus")) {
+		statuses, err := sunbeam.GetTerraformLockStatus(r.Context(), s)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		workspace := r.URL.Query().Get("workspace")
+		if workspace == "" {
+			workspace = sunbeam.DefaultTerraformWorkspace
+		}
+
+		workspaceStatuses := map[string]types.TerraformLockStatus{}
+		for plan, status := range statuses {
+			planWorkspace, name := sunbeam.SplitTerraformWorkspacePlan(plan)
+			if planWorkspace == workspace {
+				workspaceStatuses[name] = status
+			}
+		}
+
+		return response.SyncResponse(true, workspaceStatuses)
+	}
+
+	plans, err := sunbeam.GetTerraformStates(r.Context(), s)
 
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	state, err := sunbeam.GetTerraformState(s, name)
+	return terraformPlanList(r, plans)
+}
+
+func cmdStateGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		return response.BadRequest(err)
+	}
+
+	recordStateAccess(s, r, name, sunbeam.TerraformStateAccessGet)
+
+	state, err := sunbeam.GetTerraformState(r.Context(), s, name)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return response.NotFound(err)
 		}
 		return response.InternalError(err)
 	}
@@ -97,21 +223,35 @@ func cmdStateGet(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
+	lock, err := sunbeam.GetTerraformStateLock(r.Context(), s, name)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
 	// Just send state data instead of SyncResponse Json object as
 	// terraform expects just state data.
+	// The lock held on the plan, if any, is reported in the headers.
 	return response.ManualResponse(func(w http.ResponseWriter) error {
+		if lock != nil {
+			w.Header().Set("X-TF-Locked", "true")
+			w.Header().Set("X-TF-Lock-ID", lock.ID)
+			w.Header().Set("X-TF-Lock-Holder", lock.Who)
+		} else {
+			w.Header().Set("X-TF-Locked", "false")
+		}
+
 		return util.WriteJSON(w, jsonState, nil)
 	})
 }
 
 func cmdStatePut(s *state.State, r *http.Request) response.Response {
-	var name string
-
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	name, err := terraformPlanName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.BadRequest(err)
 	}
 
+	recordStateAccess(s, r, name, sunbeam.TerraformStateAccessPut)
+
 	lockID := r.URL.Query().Get("ID")
 
 	var body bytes.Buffer
@@ -120,20 +260,33 @@ func cmdStatePut(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	dbLock, err := sunbeam.UpdateTerraformState(s, name, lockID, body.String())
+	// Forcing allows overwriting the state with one having a lower serial.
+	// It is limited to trusted clients, like forced unlocks.
+	force := shared.IsTrue(r.URL.Query().Get("force"))
+	if force && !isTrusted(r) {
+		return response.Forbidden(fmt.Errorf("Untrusted clients may not force a terraform state write"))
+	}
+
+	// Unlocking releases the lock given by ID along with storing the state.
+	unlock := shared.IsTrue(r.URL.Query().Get("unlock"))
+
+	dbLock, err := sunbeam.UpdateTerraformState(r.Context(), s, name, lockID, body.String(), force, unlock)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusConflict {
-				jsonDBLock, err := json.Marshal(dbLock)
-				if err != nil {
-					return response.InternalError(err)
-				}
+		if errors.Is(err, sunbeam.ErrStaleTerraformSerial) || errors.Is(err, sunbeam.ErrTerraformDraining) || errors.Is(err, sunbeam.ErrInvalidTerraformState) {
+			return response.SmartError(err)
+		}
 
-				return response.ManualResponse(func(w http.ResponseWriter) error {
-					w.WriteHeader(http.StatusConflict)
-					return util.WriteJSON(w, jsonDBLock, nil)
-				})
+		if api.StatusErrorCheck(err, http.StatusConflict) {
+			conflict, err := sunbeam.GetTerraformLockConflict(r.Context(), s, dbLock)
+			if err != nil {
+				return response.InternalError(err)
 			}
+
+			return response.ManualResponse(func(w http.ResponseWriter) error {
+				w.Header().Set("Retry-After", strconv.FormatInt(conflict.RetryAfter, 10))
+				w.WriteHeader(http.StatusConflict)
+				return util.WriteJSON(w, conflict, nil)
+			})
 		}
 		return response.InternalError(err)
 	}
@@ -142,19 +295,28 @@ func cmdStatePut(s *state.State, r *http.Request) response.Response {
 }
 
 func cmdStateDelete(s *state.State, r *http.Request) response.Response {
-	var name string
-
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	name, err := terraformPlanName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.BadRequest(err)
 	}
 
-	err = sunbeam.DeleteTerraformState(s, name)
+	recordStateAccess(s, r, name, sunbeam.TerraformStateAccessDelete)
+
+	// Deleting a missing state succeeds with ignoreMissing, like unlocking a missing lock.
+	ignoreMissing := shared.IsTrue(r.URL.Query().Get("ignoreMissing"))
+
+	err = sunbeam.DeleteTerraformState(r.Context(), s, name)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
+		if errors.Is(err, sunbeam.ErrTerraformDraining) {
+			return response.SmartError(err)
+		}
+
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			if ignoreMissing {
+				return response.EmptySyncResponse
 			}
+
+			return response.NotFound(err)
 		}
 		return response.InternalError(err)
 	}
@@ -162,30 +324,51 @@ func cmdStateDelete(s *state.State, r *http.Request) response.Response {
 	return response.EmptySyncResponse
 }
 
-func cmdLockList(s *state.State, _ *http.Request) response.Response {
-	plans, err := sunbeam.GetTerraformLocks(s)
+// recordStateAccess appends the access to the state of the plan to the access log.
+// The terraform endpoints accept untrusted clients, so the address the request
+// came from is recorded along with the identity, if any. A failure to record the
+// access is logged and does not fail the request.
+func recordStateAccess(s *state.State, r *http.Request, name string, operation string) {
+	err := sunbeam.RecordTerraformStateAccess(r.Context(), s, name, operation, clientIP(r), requestIdentity(r))
+	if err != nil {
+		logger.Warn("Failed to record terraform state access", logger.Ctx{"plan": name, "operation": operation, "err": err})
+	}
+}
 
+func cmdStateAccessLogGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.BadRequest(err)
+	}
+
+	accesses, err := sunbeam.GetTerraformStateAccessLog(r.Context(), s, name)
+	if err != nil {
+		return response.SmartError(err)
 	}
 
-	return response.SyncResponse(true, plans)
+	return paginatedResponse(r, accesses)
 }
 
-func cmdLockGet(s *state.State, r *http.Request) response.Response {
-	var name string
+func cmdLockList(s *state.State, r *http.Request) response.Response {
+	plans, err := sunbeam.GetTerraformLocks(r.Context(), s)
 
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	lock, err := sunbeam.GetTerraformLock(s, name)
+	return terraformPlanList(r, plans)
+}
+
+func cmdLockGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	lock, err := sunbeam.GetTerraformLock(r.Context(), s, name)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
-			}
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return response.NotFound(err)
 		}
 		return response.InternalError(err)
 	}
@@ -198,11 +381,9 @@ func cmdLockGet(s *state.State, r *http.Request) response.Response {
 }
 
 func cmdLockPut(s *state.State, r *http.Request) response.Response {
-	var name string
-
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	name, err := terraformPlanName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.BadRequest(err)
 	}
 
 	var body bytes.Buffer
@@ -211,24 +392,30 @@ func cmdLockPut(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	dbLock, err := sunbeam.UpdateTerraformLock(s, name, body.String())
+	dbLock, err := sunbeam.UpdateTerraformLock(r.Context(), s, name, body.String(), requestIdentity(r))
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
+		if errors.Is(err, sunbeam.ErrTerraformDraining) || errors.Is(err, sunbeam.ErrTerraformLockIdentity) || errors.Is(err, sunbeam.ErrTerraformLockPath) {
+			return response.SmartError(err)
+		}
+
+		status, found := api.StatusErrorMatch(err, http.StatusLocked, http.StatusConflict)
+		if found {
 			jsonDBLock, err1 := json.Marshal(dbLock)
 			if err1 != nil {
 				return response.InternalError(err1)
 			}
-			if err.Status() == http.StatusLocked {
-				return response.ManualResponse(func(w http.ResponseWriter) error {
-					w.WriteHeader(http.StatusLocked)
-					return util.WriteJSON(w, jsonDBLock, nil)
-				})
-			} else if err.Status() == http.StatusConflict {
-				return response.ManualResponse(func(w http.ResponseWriter) error {
-					w.WriteHeader(http.StatusConflict)
-					return util.WriteJSON(w, jsonDBLock, nil)
-				})
+
+			// Clients are told when the held lock may be released.
+			conflict, err1 := sunbeam.GetTerraformLockConflict(r.Context(), s, dbLock)
+			if err1 != nil {
+				return response.InternalError(err1)
 			}
+
+			return response.ManualResponse(func(w http.ResponseWriter) error {
+				w.Header().Set("Retry-After", strconv.FormatInt(conflict.RetryAfter, 10))
+				w.WriteHeader(status)
+				return util.WriteJSON(w, jsonDBLock, nil)
+			})
 		}
 		return response.InternalError(err)
 	}
@@ -236,36 +423,206 @@ func cmdLockPut(s *state.State, r *http.Request) response.Response {
 	return response.EmptySyncResponse
 }
 
-func cmdUnlockPut(s *state.State, r *http.Request) response.Response {
-	var name string
+func cmdLockConflictsGet(_ *state.State, _ *http.Request) response.Response {
+	return response.SyncResponse(true, sunbeam.GetTerraformLockConflicts())
+}
 
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+func cmdLockStealPut(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	var grace time.Duration
+	if r.URL.Query().Get("grace") != "" {
+		grace, err = time.ParseDuration(r.URL.Query().Get("grace"))
+		if err != nil || grace < 0 || grace > sunbeam.MaxTerraformStealGrace {
+			return response.BadRequest(fmt.Errorf("Invalid grace %q, expected a duration up to %s", r.URL.Query().Get("grace"), sunbeam.MaxTerraformStealGrace))
+		}
+	}
+
+	var body bytes.Buffer
+	_, err = body.ReadFrom(r.Body)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	steal, err := sunbeam.StealTerraformLock(r.Context(), s, name, body.String(), requestIdentity(r), grace)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, steal)
+}
+
+func cmdUnlockPut(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
 	var body bytes.Buffer
 	_, err = body.ReadFrom(r.Body)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	dbLock, err := sunbeam.DeleteTerraformLock(s, name, body.String())
+	// Forced unlocks are limited to the identities allowed to force-unlock the plan.
+	force := shared.IsTrue(r.URL.Query().Get("force"))
+
+	dbLock, err := sunbeam.DeleteTerraformLock(r.Context(), s, name, body.String(), requestIdentity(r), force)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
+		if errors.Is(err, sunbeam.ErrTerraformDraining) || errors.Is(err, sunbeam.ErrTerraformLockIdentity) || errors.Is(err, sunbeam.ErrTerraformForceUnlockDenied) {
+			return response.SmartError(err)
+		}
+
+		if api.StatusErrorCheck(err, http.StatusConflict) {
 			jsonDBLock, err1 := json.Marshal(dbLock)
 			if err1 != nil {
 				return response.InternalError(err1)
 			}
-			if err.Status() == http.StatusConflict {
-				return response.ManualResponse(func(w http.ResponseWriter) error {
-					w.WriteHeader(http.StatusConflict)
-					return util.WriteJSON(w, jsonDBLock, nil)
-				})
-			}
+
+			return response.ManualResponse(func(w http.ResponseWriter) error {
+				w.WriteHeader(http.StatusConflict)
+				return util.WriteJSON(w, jsonDBLock, nil)
+			})
 		}
 		return response.InternalError(err)
 	}
 
 	return response.EmptySyncResponse
 }
+
+func cmdStateDiffGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if r.URL.Query().Has("from") || r.URL.Query().Has("to") {
+		return response.BadRequest(fmt.Errorf("Comparing the serials of a plan is not supported as only its current state is kept, compare with another plan in \"with\" parameter"))
+	}
+
+	other := r.URL.Query().Get("with")
+	if other == "" {
+		return response.BadRequest(fmt.Errorf("Missing plan to compare with in \"with\" parameter"))
+	}
+
+	err = sunbeam.ValidateTerraformPlanName(other)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	// The plan compared with is in the same workspace, unless one is given in ?withWorkspace=.
+	withWorkspace := r.URL.Query().Get("workspace")
+	if r.URL.Query().Has("withWorkspace") {
+		withWorkspace = r.URL.Query().Get("withWorkspace")
+	}
+
+	other, err = sunbeam.TerraformWorkspacePlan(withWorkspace, other)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	diff, err := sunbeam.DiffTerraformStates(r.Context(), s, name, other)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, diff)
+}
+
+func cmdStateVerifyGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	report, err := sunbeam.VerifyTerraformState(r.Context(), s, name)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, report)
+}
+
+func cmdStateImportPost(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	var body bytes.Buffer
+	_, err = body.ReadFrom(r.Body)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = sunbeam.ImportTerraformState(r.Context(), s, name, body.String(), requestCreator(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdStateBackendGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformPlanName(r)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	capabilities, err := sunbeam.GetCapabilities(r.Context(), s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	// The addresses are derived from the routes so that they cannot drift apart.
+	backend := types.TerraformBackend{
+		Untrusted:         terraformStateCmd.Put.AllowUntrusted,
+		ClientCertificate: capabilities["tflock-identity"].Enabled,
+	}
+	backend.Config.Terraform.Backend.HTTP = types.TerraformHTTPBackend{
+		Address:       terraformRouteURL(s, terraformStateCmd.Path, name),
+		UpdateMethod:  http.MethodPut,
+		LockAddress:   terraformRouteURL(s, terraformLockCmd.Path, name),
+		LockMethod:    http.MethodPut,
+		UnlockAddress: terraformRouteURL(s, terraformUnlockCmd.Path, name),
+		UnlockMethod:  http.MethodPut,
+		// The daemon serves the self-signed cluster certificate.
+		SkipCertVerification: true,
+	}
+
+	return response.SyncResponse(true, backend)
+}
+
+// terraformRouteURL returns the URL of the route of this daemon for the given plan
+func terraformRouteURL(s *state.State, route string, plan string) string {
+	workspace, name := sunbeam.SplitTerraformWorkspacePlan(plan)
+
+	parts := []string{"1.0"}
+	for _, part := range strings.Split(route, "/") {
+		if part == "{name}" {
+			part = name
+		}
+		parts = append(parts, part)
+	}
+
+	u := api.NewURL().Scheme(s.Address().URL.Scheme).Host(s.Address().URL.Host).Path(parts...)
+	if workspace != sunbeam.DefaultTerraformWorkspace {
+		u = u.WithQuery("workspace", workspace)
+	}
+
+	return u.String()
+}
+
+func cmdTerraformFsckPost(s *state.State, r *http.Request) response.Response {
+	repair := shared.IsTrue(r.URL.Query().Get("repair"))
+
+	result, err := sunbeam.CheckTerraform(r.Context(), s, repair)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, result)
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+func TestStateGetLockHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		lock    string
+		headers map[string]string
+	}{
+		{name: "unlocked", headers: map[string]string{"X-TF-Locked": "false", "X-TF-Lock-ID": "", "X-TF-Lock-Holder": ""}},
+		{name: "locked", lock: `{"ID":"lock1","Who":"user@host"}`, headers: map[string]string{"X-TF-Locked": "true", "X-TF-Lock-ID": "lock1", "X-TF-Lock-Holder": "user@host"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			err := sunbeam.CreateConfig(context.Background(), s, "tfstate-plan1", `{"version":4}`, "", "test")
+			if err != nil {
+				t.Fatalf("Failed to store the state: %v", err)
+			}
+
+			if tt.lock != "" {
+				err = sunbeam.CreateConfig(context.Background(), s, "tflock-plan1", tt.lock, "", "test")
+				if err != nil {
+					t.Fatalf("Failed to store the lock: %v", err)
+				}
+			}
+
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/1.0/terraformstate/plan1", nil), map[string]string{"name": "plan1"})
+			w := httptest.NewRecorder()
+			err = cmdStateGet(s, r).Render(w)
+			if err != nil {
+				t.Fatalf("Failed to render the response: %v", err)
+			}
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("State GET returned %d: %s", w.Code, w.Body.String())
+			}
+
+			for header, value := range tt.headers {
+				if w.Header().Get(header) != value {
+					t.Errorf("Header %s is %q, expected %q", header, w.Header().Get(header), value)
+				}
+			}
+		})
+	}
+}
+
+func TestStateImportPost(t *testing.T) {
+	s := newTestState(t)
+
+	// Steps run in order against the same database.
+	steps := []struct {
+		name   string
+		state  string
+		status int
+	}{
+		{name: "invalid", state: `[]`, status: http.StatusBadRequest},
+		{name: "first write", state: `{"serial":3}`, status: http.StatusOK},
+		{name: "existing state", state: `{"serial":4}`, status: http.StatusConflict},
+	}
+
+	for _, step := range steps {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/1.0/terraformstate/plan1/import", strings.NewReader(step.state)), map[string]string{"name": "plan1"})
+		w := httptest.NewRecorder()
+		err := cmdStateImportPost(s, r).Render(w)
+		if err != nil {
+			t.Fatalf("Failed to render the response: %v", err)
+		}
+
+		if w.Code != step.status {
+			t.Errorf("%s: import returned %d, expected %d: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+	}
+
+	// The first imported state is kept.
+	state, err := sunbeam.GetTerraformState(context.Background(), s, "plan1")
+	if err != nil {
+		t.Fatalf("Failed to get the state: %v", err)
+	}
+
+	if state != `{"serial":3}` {
+		t.Errorf("Imported state is %q, expected the first write", state)
+	}
+}
+
+func TestStateDeleteIgnoreMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		stored bool
+		status int
+	}{
+		{name: "stored", stored: true, status: http.StatusOK},
+		{name: "missing", status: http.StatusNotFound},
+		{name: "missing ignored", query: "?ignoreMissing=true", status: http.StatusOK},
+		{name: "stored ignoring missing", query: "?ignoreMissing=true", stored: true, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			if tt.stored {
+				err := sunbeam.CreateConfig(context.Background(), s, "tfstate-plan1", `{"version":4}`, "", "test")
+				if err != nil {
+					t.Fatalf("Failed to store the state: %v", err)
+				}
+			}
+
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/1.0/terraformstate/plan1"+tt.query, nil), map[string]string{"name": "plan1"})
+			w := httptest.NewRecorder()
+			err := cmdStateDelete(s, r).Render(w)
+			if err != nil {
+				t.Fatalf("Failed to render the response: %v", err)
+			}
+
+			if w.Code != tt.status {
+				t.Errorf("State DELETE%s returned %d, expected %d: %s", tt.query, w.Code, tt.status, w.Body.String())
+			}
+
+			_, err = sunbeam.GetTerraformState(context.Background(), s, "plan1")
+			if err == nil {
+				t.Errorf("State is still stored after the DELETE")
+			}
+		})
+	}
+}
+
+func TestStateWorkspaces(t *testing.T) {
+	s := newTestState(t)
+
+	// The same plan is imported in two workspaces without conflicting.
+	states := map[string]string{"": `{"serial":1}`, "staging": `{"serial":2}`}
+	for workspace, state := range states {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/1.0/terraformstate/plan1/import?workspace="+workspace, strings.NewReader(state)), map[string]string{"name": "plan1"})
+		w := httptest.NewRecorder()
+		err := cmdStateImportPost(s, r).Render(w)
+		if err != nil {
+			t.Fatalf("Failed to render the response: %v", err)
+		}
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Import in workspace %q returned %d: %s", workspace, w.Code, w.Body.String())
+		}
+	}
+
+	for workspace, state := range states {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/1.0/terraformstate/plan1?workspace="+workspace, nil), map[string]string{"name": "plan1"})
+		w := httptest.NewRecorder()
+		err := cmdStateGet(s, r).Render(w)
+		if err != nil {
+			t.Fatalf("Failed to render the response: %v", err)
+		}
+
+		if strings.TrimSpace(w.Body.String()) != state {
+			t.Errorf("State of workspace %q is %q, expected %q", workspace, w.Body.String(), state)
+		}
+	}
+
+	tests := []struct {
+		query string
+		plans any
+	}{
+		{query: "", plans: []string{"plan1"}},
+		{query: "?workspace=staging", plans: []string{"plan1"}},
+		{query: "?workspace=production", plans: []string{}},
+		{query: "?grouped=true", plans: map[string][]string{"default": {"plan1"}, "staging": {"plan1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/1.0/terraformstate"+tt.query, nil)
+
+			plans := reflect.New(reflect.TypeOf(tt.plans))
+			status := renderMetadata(t, cmdStateList(s, r).Render, plans.Interface())
+			if status != http.StatusOK {
+				t.Fatalf("State list returned %d", status)
+			}
+
+			if !reflect.DeepEqual(plans.Elem().Interface(), tt.plans) {
+				t.Errorf("State list%s = %v, expected %v", tt.query, plans.Elem().Interface(), tt.plans)
+			}
+		})
+	}
+}
+
+func TestStateAccessLog(t *testing.T) {
+	s := newTestState(t)
+	err := sunbeam.CreateConfig(context.Background(), s, "tfstate-plan1", `{"serial":1}`, "", "test")
+	if err != nil {
+		t.Fatalf("Failed to create the state: %v", err)
+	}
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/1.0/terraformstate/plan1", nil), map[string]string{"name": "plan1"})
+	err = cmdStateGet(s, r).Render(httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("Failed to render the response: %v", err)
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/1.0/terraformstate/plan1", nil), map[string]string{"name": "plan1"})
+	err = cmdStateDelete(s, r).Render(httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("Failed to render the response: %v", err)
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/1.0/terraformstate/plan1/access-log", nil), map[string]string{"name": "plan1"})
+
+	var accesses []types.TerraformStateAccess
+	status := renderMetadata(t, cmdStateAccessLogGet(s, r).Render, &accesses)
+	if status != http.StatusOK {
+		t.Fatalf("Access log returned %d", status)
+	}
+
+	if len(accesses) != 2 || accesses[0].Operation != sunbeam.TerraformStateAccessDelete || accesses[1].Operation != sunbeam.TerraformStateAccessGet {
+		t.Fatalf("Access log is %+v, expected the delete then the get", accesses)
+	}
+
+	// httptest requests come from 192.0.2.1.
+	if accesses[0].Source != "192.0.2.1" {
+		t.Errorf("Access source is %q, expected the client address", accesses[0].Source)
+	}
+}
+
+func TestLockPutStrictPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "matching path", path: "plan1", status: http.StatusOK},
+		{name: "mismatched path", path: "plan2", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			err := sunbeam.CreateConfig(context.Background(), s, "daemon-tflock-strict-path", "true", "", "test")
+			if err != nil {
+				t.Fatalf("Failed to enable strict lock paths: %v", err)
+			}
+
+			body := strings.NewReader(`{"ID":"lock1","Path":"` + tt.path + `","Who":"user@host"}`)
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/1.0/terraformlock/plan1", body), map[string]string{"name": "plan1"})
+			w := httptest.NewRecorder()
+			err = cmdLockPut(s, r).Render(w)
+			if err != nil {
+				t.Fatalf("Failed to render the response: %v", err)
+			}
+
+			if w.Code != tt.status {
+				t.Errorf("Lock PUT returned %d, expected %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestStatePutForce(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted bool
+		status  int
+	}{
+		{name: "untrusted", status: http.StatusForbidden},
+		{name: "trusted", trusted: true, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			err := sunbeam.CreateConfig(context.Background(), s, "tflock-plan1", `{"ID":"lock1","Who":"user@host"}`, "", "test")
+			if err != nil {
+				t.Fatalf("Failed to store the lock: %v", err)
+			}
+
+			_, err = sunbeam.UpdateTerraformState(context.Background(), s, "plan1", "lock1", `{"serial":5}`, false, false)
+			if err != nil {
+				t.Fatalf("Failed to store the state: %v", err)
+			}
+
+			// The forced write goes back to a lower serial.
+			r := newTestRequest("10.0.0.1:1234", tt.trusted)
+			r = mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/1.0/terraformstate/plan1?ID=lock1&force=true", strings.NewReader(`{"serial":1}`)).WithContext(r.Context()), map[string]string{"name": "plan1"})
+			w := httptest.NewRecorder()
+			err = cmdStatePut(s, r).Render(w)
+			if err != nil {
+				t.Fatalf("Failed to render the response: %v", err)
+			}
+
+			if w.Code != tt.status {
+				t.Errorf("Forced state PUT returned %d, expected %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestStateDiffWorkspaces(t *testing.T) {
+	s := newTestState(t)
+
+	// Each workspace holds plan1 and plan2 with resources of its own.
+	for _, workspace := range []string{"", "staging"} {
+		for _, plan := range []string{"plan1", "plan2"} {
+			state := `{"resources":[{"mode":"managed","type":"test","name":"` + workspace + plan + `","instances":[{"attributes":{}}]}]}`
+			err := sunbeam.ImportTerraformState(context.Background(), s, workspacePlan(t, workspace, plan), state, "test")
+			if err != nil {
+				t.Fatalf("Failed to import %q in workspace %q: %v", plan, workspace, err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		added []string
+	}{
+		{name: "default workspace", query: "with=plan2", added: []string{"test.plan2"}},
+		{name: "same workspace", query: "workspace=staging&with=plan2", added: []string{"test.stagingplan2"}},
+		{name: "other workspace", query: "workspace=staging&with=plan2&withWorkspace=default", added: []string{"test.plan2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/1.0/terraformstate/plan1/diff?"+tt.query, nil), map[string]string{"name": "plan1"})
+
+			var diff types.TerraformStateDiff
+			status := renderMetadata(t, cmdStateDiffGet(s, r).Render, &diff)
+			if status != http.StatusOK {
+				t.Fatalf("State diff returned %d", status)
+			}
+
+			if !reflect.DeepEqual(diff.Added, tt.added) {
+				t.Errorf("Diff added %v, expected %v", diff.Added, tt.added)
+			}
+		})
+	}
+}
+
+// workspacePlan returns the name the plan of the workspace is stored under.
+func workspacePlan(t *testing.T, workspace string, name string) string {
+	t.Helper()
+
+	plan, err := sunbeam.TerraformWorkspacePlan(workspace, name)
+	if err != nil {
+		t.Fatalf("Invalid workspace %q: %v", workspace, err)
+	}
+
+	return plan
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+// timeoutMiddleware bounds the context of the request the handlers run with.
+// The handlers pass it on to the transactions they run, so that a transaction
+// still running once the timeout of the endpoint expires is cancelled and the
+// request answered with 504. The response of a handler is returned as is, as
+// the work it did was completed in time even if the deadline since expired.
+// The state is shared by all the requests and is passed on unchanged.
+//
+// Responses may be rendered after the handler returns, streaming their body
+// with the request context, so the context is only released once the response
+// has been rendered. The timeout therefore covers the rendering as well.
+func timeoutMiddleware(e rest.Endpoint, _ rest.EndpointAction, next handlerFunc) handlerFunc {
+	return func(s *state.State, r *http.Request) response.Response {
+		timeout := middlewareSettings.get(s).timeout(e.Path)
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+
+		return &timeoutResponse{Response: next(s, r.WithContext(ctx)), cancel: cancel}
+	}
+}
+
+// timeoutResponse releases the bounded request context once the response has been rendered.
+type timeoutResponse struct {
+	response.Response
+
+	cancel context.CancelFunc
+}
+
+// Render renders the response and releases the request context.
+func (r *timeoutResponse) Render(w http.ResponseWriter) error {
+	defer r.cancel()
+
+	return r.Response.Render(w)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	useEndpointSettings(t, endpointSettings{timeouts: map[string]time.Duration{"*": 50 * time.Millisecond}})
+
+	tests := []struct {
+		name   string
+		next   handlerFunc
+		status int
+	}{
+		{
+			name: "sync",
+			next: func(_ *state.State, _ *http.Request) response.Response {
+				return response.EmptySyncResponse
+			},
+			status: http.StatusOK,
+		},
+		{
+			// A transaction run once the deadline expired is cancelled.
+			name: "timed out",
+			next: func(s *state.State, r *http.Request) response.Response {
+				<-r.Context().Done()
+
+				_, err := sunbeam.GetConfig(r.Context(), s, "key")
+				return response.SmartError(err)
+			},
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			// The request context stays usable while a lazy response is rendered.
+			name: "manual",
+			next: func(_ *state.State, r *http.Request) response.Response {
+				ctx := r.Context()
+				return response.ManualResponse(func(w http.ResponseWriter) error {
+					if ctx.Err() != nil {
+						w.WriteHeader(http.StatusInternalServerError)
+						return nil
+					}
+
+					w.WriteHeader(http.StatusOK)
+					return nil
+				})
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			handler := timeoutMiddleware(rest.Endpoint{Path: "test"}, rest.EndpointAction{}, tt.next)
+
+			r := httptest.NewRequest(http.MethodGet, "/1.0/test", nil)
+			rec := httptest.NewRecorder()
+			err := handler(s, r).Render(rec)
+			if err != nil {
+				t.Fatalf("Failed to render response: %v", err)
+			}
+
+			if rec.Code != tt.status {
+				t.Errorf("Response status is %d, expected %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddlewareCommittedWrite(t *testing.T) {
+	useEndpointSettings(t, endpointSettings{timeouts: map[string]time.Duration{"*": 50 * time.Millisecond}})
+
+	s := newTestState(t)
+	next := func(s *state.State, r *http.Request) response.Response {
+		err := sunbeam.CreateConfig(r.Context(), s, "key", "value", "", "test")
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		// The rest of the request outlives its deadline.
+		<-r.Context().Done()
+		_, err = sunbeam.GetConfig(r.Context(), s, "key")
+		if !api.StatusErrorCheck(err, http.StatusGatewayTimeout) {
+			t.Errorf("Transaction run after the deadline returned %v, expected 504", err)
+		}
+
+		return response.EmptySyncResponse
+	}
+
+	rec := httptest.NewRecorder()
+	err := timeoutMiddleware(rest.Endpoint{Path: "test"}, rest.EndpointAction{}, next)(s, httptest.NewRequest(http.MethodPut, "/1.0/test", nil)).Render(rec)
+	if err != nil {
+		t.Fatalf("Failed to render response: %v", err)
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Response status is %d, expected %d", rec.Code, http.StatusOK)
+	}
+
+	value, err := sunbeam.GetConfig(context.Background(), s, "key")
+	if err != nil || value != "value" {
+		t.Errorf("Committed value is %q (%v), expected %q", value, err, "value")
+	}
+}
+
+func TestTimeoutMiddlewareReleasesContext(t *testing.T) {
+	useEndpointSettings(t, endpointSettings{timeouts: map[string]time.Duration{"*": time.Minute}})
+
+	var ctx context.Context
+	next := func(_ *state.State, r *http.Request) response.Response {
+		ctx = r.Context()
+		return response.EmptySyncResponse
+	}
+
+	resp := timeoutMiddleware(rest.Endpoint{Path: "test"}, rest.EndpointAction{}, next)(nil, httptest.NewRequest(http.MethodGet, "/1.0/test", nil))
+	if ctx.Err() != nil {
+		t.Fatalf("Request context was released before the response was rendered")
+	}
+
+	err := resp.Render(httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("Failed to render response: %v", err)
+	}
+
+	if ctx.Err() == nil {
+		t.Errorf("Request context is still live after the response was rendered")
+	}
+}
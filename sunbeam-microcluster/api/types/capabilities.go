@@ -0,0 +1,10 @@
+package types
+
+// Capabilities maps the name of each optional feature to its state
+type Capabilities map[string]Capability
+
+// Capability structure to hold whether a feature is enabled and the parameters it runs with
+type Capability struct {
+	Enabled    bool              `json:"enabled" yaml:"enabled"`
+	Parameters map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+}
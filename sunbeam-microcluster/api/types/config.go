@@ -0,0 +1,39 @@
+package types
+
+// ConfigUsage structure to hold the storage used by the config namespaces
+type ConfigUsage struct {
+	// Namespaces is the size in bytes of the values in each namespace, keyed by
+	// key prefix. Keys without a prefix are counted under "other" and the
+	// manifests under "manifests".
+	Namespaces map[string]int64 `json:"namespaces" yaml:"namespaces"`
+	// Total is the size in bytes of all the namespaces
+	Total int64 `json:"total" yaml:"total"`
+}
+
+// ConfigVersion structure to hold a prior value of a config key
+type ConfigVersion struct {
+	// Version numbers the prior values of a key, the greatest being the most recent
+	Version int    `json:"version" yaml:"version"`
+	Value   string `json:"value" yaml:"value"`
+	Type    string `json:"type,omitempty" yaml:"type,omitempty"`
+	// RecordedAt is the time the value was replaced
+	RecordedAt string `json:"recordedat" yaml:"recordedat"`
+}
+
+// ConfigEntry structure to hold a config key along with its value
+type ConfigEntry struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+	Type  string `json:"type,omitempty" yaml:"type,omitempty"`
+	// UpdatedAt is the time the value was last written or touched
+	UpdatedAt string `json:"updatedat" yaml:"updatedat"`
+	// CreatedBy is the identity of the client that created the config key
+	CreatedBy string `json:"createdby" yaml:"createdby"`
+	// Truncated is set when Value was cut short to the requested maximum size
+	Truncated bool `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+}
+
+// ConfigWrite structure to hold whether writing a config key created it or updated it
+type ConfigWrite struct {
+	Created bool `json:"created" yaml:"created"`
+}
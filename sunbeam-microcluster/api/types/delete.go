@@ -0,0 +1,20 @@
+package types
+
+// DeletePlan structure to hold what a delete removes and what it affects.
+// Resources are given by their path relative to /1.0, e.g. nodes/node1.
+type DeletePlan struct {
+	// Deleted are the resources removed by the delete
+	Deleted []string `json:"deleted" yaml:"deleted"`
+	// Affected are the resources left referencing or depending on the deleted ones
+	Affected []string `json:"affected" yaml:"affected"`
+}
+
+// DeleteAll structure to hold the number of resources removed when clearing a resource type
+type DeleteAll struct {
+	Count int `json:"count" yaml:"count"`
+}
+
+// NodesDelete structure to hold the names of the nodes removed by a delete by role
+type NodesDelete struct {
+	Deleted []string `json:"deleted" yaml:"deleted"`
+}
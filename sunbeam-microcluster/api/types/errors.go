@@ -0,0 +1,9 @@
+package types
+
+// NotFound structure to hold the resource a request did not find, in the metadata of a 404 error
+type NotFound struct {
+	// Resource is the type of the resource, e.g. node
+	Resource string `json:"resource" yaml:"resource"`
+	// Name is the name or key of the resource requested
+	Name string `json:"name" yaml:"name"`
+}
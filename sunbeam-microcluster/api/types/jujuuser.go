@@ -8,4 +8,14 @@ type JujuUsers []JujuUser
 type JujuUser struct {
 	Username string `json:"username" yaml:"username"`
 	Token    string `json:"token" yaml:"token"`
+	// CreatedBy is the identity of the client that created the juju user
+	CreatedBy string `json:"createdby" yaml:"createdby"`
+}
+
+// JujuUsersBatch structure to hold the result of a batch juju user import
+type JujuUsersBatch struct {
+	// Added are the juju users added along with their tokens
+	Added JujuUsers `json:"added" yaml:"added"`
+	// Conflicts are the names of the juju users that already existed
+	Conflicts []string `json:"conflicts" yaml:"conflicts"`
 }
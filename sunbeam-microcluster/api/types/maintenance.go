@@ -0,0 +1,13 @@
+package types
+
+// Compaction structure to hold the result of a database compaction
+type Compaction struct {
+	// Purged is the number of records removed by each compaction task, keyed by task
+	Purged map[string]int `json:"purged" yaml:"purged"`
+}
+
+// Drain structure to hold the result of draining a member before shutdown
+type Drain struct {
+	// InFlight is the number of terraform writes still in flight when the grace period expired
+	InFlight int `json:"inflight" yaml:"inflight"`
+}
@@ -9,4 +9,38 @@ type Manifest struct {
 	ManifestID  string `json:"manifestid" yaml:"manifestid"`
 	AppliedDate string `json:"applieddate" yaml:"applieddate"`
 	Data        string `json:"data" yaml:"data"`
+	// Parent is the optional id of the manifest this one was derived from.
+	// When set, the manifest is only added if the parent is the latest manifest.
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"`
+	// CreatedBy is the identity of the client that created the manifest
+	CreatedBy string `json:"createdby" yaml:"createdby"`
+	// StartedAt and FinishedAt are the optional RFC 3339 times the application
+	// of the manifest started and finished
+	StartedAt  string `json:"startedat,omitempty" yaml:"startedat,omitempty"`
+	FinishedAt string `json:"finishedat,omitempty" yaml:"finishedat,omitempty"`
+	// Status is the optional outcome of the application of the manifest,
+	// either succeeded or failed
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// ManifestStats structure to hold the durations of the manifest applications.
+// Only the manifests reporting both their start and finish times are counted.
+type ManifestStats struct {
+	Count int `json:"count" yaml:"count"`
+	// AverageSeconds is the average duration of the applications in seconds
+	AverageSeconds float64 `json:"averageseconds" yaml:"averageseconds"`
+	// MaxSeconds is the duration of the longest application in seconds
+	MaxSeconds float64 `json:"maxseconds" yaml:"maxseconds"`
+	// MaxManifestID is the id of the manifest with the longest application
+	MaxManifestID string `json:"maxmanifestid,omitempty" yaml:"maxmanifestid,omitempty"`
+	// Failed is the number of the counted applications that failed
+	Failed int `json:"failed" yaml:"failed"`
+}
+
+// ManifestMatch structure to hold a manifest matching a search
+type ManifestMatch struct {
+	ManifestID  string `json:"manifestid" yaml:"manifestid"`
+	AppliedDate string `json:"applieddate" yaml:"applieddate"`
+	// Snippets are the occurrences of the search term with their surrounding context
+	Snippets []string `json:"snippets" yaml:"snippets"`
 }
@@ -1,6 +1,11 @@
 // Package types provides shared types and structs.
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Nodes holds list of Node type
 type Nodes []Node
 
@@ -12,4 +17,120 @@ type Node struct {
 	MachineID int `json:"machineid" yaml:"machineid"`
 	// SystemID is the unique identifier for the node in machine provider
 	SystemID string `json:"systemid" yaml:"systemid"`
+	// Member is the name of the cluster member that recorded the node
+	Member string `json:"member" yaml:"member"`
+	// Metadata holds free-form labels attached to the node
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+	// Cordoned marks the node to be skipped by schedulers, e.g. during maintenance
+	Cordoned bool `json:"cordoned" yaml:"cordoned"`
+	// LastManifestID is the id of the manifest the node was last configured by
+	LastManifestID string `json:"lastmanifestid,omitempty" yaml:"lastmanifestid,omitempty"`
+	// CreatedBy is the identity of the client that created the node
+	CreatedBy string `json:"createdby" yaml:"createdby"`
+	// UpdatedAt is the time the node was last written
+	UpdatedAt string `json:"updatedat" yaml:"updatedat"`
+	// NodeCapacity holds the capacity hints of the node
+	NodeCapacity `yaml:",inline"`
+}
+
+// NodeCapacity structure to hold the capacity reported for a node, as an informational
+// hint for the placement done by higher layers. Zero values are unknown.
+type NodeCapacity struct {
+	CPUs int `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	// Memory is in bytes
+	Memory int64 `json:"memory,omitempty" yaml:"memory,omitempty"`
+	// Disk is in bytes
+	Disk int64 `json:"disk,omitempty" yaml:"disk,omitempty"`
+}
+
+// NodesByName structure to hold the nodes found by name and the names without a node
+type NodesByName struct {
+	Nodes   Nodes    `json:"nodes" yaml:"nodes"`
+	Missing []string `json:"missing" yaml:"missing"`
+}
+
+// NodesByMember maps the name of each cluster member to the nodes it recorded
+type NodesByMember map[string]Nodes
+
+// NodeRolesPlan holds list of NodeRolesDelta type
+type NodeRolesPlan []NodeRolesDelta
+
+// NodeRolesDelta structure to hold the roles to be added to and removed from a node
+type NodeRolesDelta struct {
+	Name   string   `json:"name" yaml:"name"`
+	Add    []string `json:"add" yaml:"add"`
+	Remove []string `json:"remove" yaml:"remove"`
+}
+
+// NodeInventory structure to hold the authoritative details of a node, fields left unset are not reconciled
+type NodeInventory struct {
+	MachineID *int     `json:"machineid,omitempty" yaml:"machineid,omitempty"`
+	SystemID  *string  `json:"systemid,omitempty" yaml:"systemid,omitempty"`
+	Role      []string `json:"role,omitempty" yaml:"role,omitempty"`
+}
+
+// NodePatch structure to hold the changes to a node, fields left unset are not changed
+type NodePatch struct {
+	Role      []string          `json:"role,omitempty" yaml:"role,omitempty"`
+	MachineID *int              `json:"machineid,omitempty" yaml:"machineid,omitempty"`
+	SystemID  *string           `json:"systemid,omitempty" yaml:"systemid,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Cordoned  *bool             `json:"cordoned,omitempty" yaml:"cordoned,omitempty"`
+	// LastManifestID is cleared when set to an empty string
+	LastManifestID *string `json:"lastmanifestid,omitempty" yaml:"lastmanifestid,omitempty"`
+	CPUs           *int    `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	Memory         *int64  `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Disk           *int64  `json:"disk,omitempty" yaml:"disk,omitempty"`
+}
+
+// NodesReconcile maps the name of each reconciled node to the fields that were changed
+type NodesReconcile map[string][]string
+
+// NodeConfig structure to hold the effective value of a config key for a node
+type NodeConfig struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+	// Layer is where the value was resolved from, one of node, role or global
+	Layer string `json:"layer" yaml:"layer"`
+	// Source is the config key holding the value
+	Source string `json:"source" yaml:"source"`
+}
+
+// AnsibleInventory structure to hold the nodes in the Ansible dynamic inventory format
+type AnsibleInventory struct {
+	// Groups maps each group name to its hosts
+	Groups map[string]AnsibleGroup
+	// HostVars maps each host to its variables
+	HostVars map[string]map[string]string
+}
+
+// AnsibleGroup structure to hold the hosts and child groups of an Ansible inventory group
+type AnsibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Children []string `json:"children,omitempty" yaml:"children,omitempty"`
+}
+
+// MarshalJSON encodes the inventory with the groups at the top level and the host
+// variables under _meta, so that Ansible does not call back for each host.
+func (i AnsibleInventory) MarshalJSON() ([]byte, error) {
+	inventory := map[string]any{
+		"_meta": map[string]any{"hostvars": i.HostVars},
+	}
+	for name, group := range i.Groups {
+		inventory[name] = group
+	}
+
+	return json.Marshal(inventory)
+}
+
+// EnrollTokenRequest structure to hold the settings of an enrollment token to issue
+type EnrollTokenRequest struct {
+	// TTL is how long the token is valid, as a duration such as 30m, the daemon default if empty
+	TTL string `json:"ttl" yaml:"ttl"`
+}
+
+// EnrollToken structure to hold a single-use token letting a node add itself
+type EnrollToken struct {
+	Token     string    `json:"token" yaml:"token"`
+	ExpiresAt time.Time `json:"expiresat" yaml:"expiresat"`
 }
@@ -0,0 +1,20 @@
+package types
+
+// Page holds one page of the items of a list endpoint
+type Page[T any] struct {
+	Items []T `json:"items" yaml:"items"`
+	// Total is the number of items across all pages
+	Total  int `json:"total" yaml:"total"`
+	Limit  int `json:"limit" yaml:"limit"`
+	Offset int `json:"offset" yaml:"offset"`
+	// Next is the offset of the next page, unset on the last page
+	Next *int `json:"next,omitempty" yaml:"next,omitempty"`
+}
+
+// CursorPage holds one page of the items of a list endpoint walked by cursor
+type CursorPage[T any] struct {
+	Items []T `json:"items" yaml:"items"`
+	Limit int `json:"limit" yaml:"limit"`
+	// Next is the cursor of the next page, unset on the last page
+	Next string `json:"next,omitempty" yaml:"next,omitempty"`
+}
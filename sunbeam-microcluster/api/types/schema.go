@@ -0,0 +1,30 @@
+// Package types provides shared types and structs.
+package types
+
+// Schema structure to hold the applied schema version and the known schema extensions
+type Schema struct {
+	Version    int      `json:"version" yaml:"version"`
+	Extensions []string `json:"extensions" yaml:"extensions"`
+}
+
+// SchemaVerify structure to hold the result of checking the applied schema against the schema extensions
+type SchemaVerify struct {
+	// Version is the applied schema version
+	Version int `json:"version" yaml:"version"`
+	// Expected is the schema version once all the schema extensions are applied
+	Expected int `json:"expected" yaml:"expected"`
+	// Valid is set when the schema is up to date and the tables hold the expected columns
+	Valid bool `json:"valid" yaml:"valid"`
+	// Discrepancies describe each difference found
+	Discrepancies []string `json:"discrepancies" yaml:"discrepancies"`
+}
+
+// DBStats structure to hold the size of the database tables
+type DBStats struct {
+	// Version is the applied schema version
+	Version int `json:"version" yaml:"version"`
+	// Rows is the number of rows of each table
+	Rows map[string]int64 `json:"rows" yaml:"rows"`
+	// ConfigValueBytes is the total size in bytes of the config values
+	ConfigValueBytes int64 `json:"configvaluebytes" yaml:"configvaluebytes"`
+}
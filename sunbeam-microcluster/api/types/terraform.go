@@ -15,3 +15,147 @@ type Lock struct {
 	Created   time.Time `json:"Created" yaml:"Created"`
 	Path      string    `json:"Path" yaml:"Path"`
 }
+
+// LockConflict structure to hold the conflicting terraform lock and how stale it is
+type LockConflict struct {
+	Lock
+	// Age is the number of seconds since the lock was created
+	Age int64 `json:"Age" yaml:"Age"`
+	// Stale is set when the lock is older than the configured lock TTL
+	Stale bool `json:"Stale" yaml:"Stale"`
+	// RetryAfter is the number of seconds to wait before retrying, also sent in the Retry-After header
+	RetryAfter int64 `json:"RetryAfter" yaml:"RetryAfter"`
+}
+
+// LockSteal structure to hold the terraform lock taken over and the lock that replaced it
+type LockSteal struct {
+	// Previous is the lock that was held, unset if it was released during the grace
+	Previous *Lock `json:"Previous" yaml:"Previous"`
+	// Current is the lock now held
+	Current Lock `json:"Current" yaml:"Current"`
+}
+
+// TerraformLockStatus structure to hold whether the plan of a terraform state is locked and by whom
+type TerraformLockStatus struct {
+	Locked bool `json:"locked" yaml:"locked"`
+	// Who is the owner of the lock, empty when unlocked
+	Who string `json:"who" yaml:"who"`
+	// Age is the number of seconds since the lock was created, zero when unlocked
+	Age int64 `json:"age" yaml:"age"`
+}
+
+// TerraformStateAccess structure to hold an access to the terraform state of a plan
+type TerraformStateAccess struct {
+	// Operation is one of get, put or delete
+	Operation string `json:"operation" yaml:"operation"`
+	// Source is the address the request came from
+	Source string `json:"source" yaml:"source"`
+	// Identity is the common name of the client certificate, empty for untrusted clients
+	Identity string `json:"identity" yaml:"identity"`
+	// AccessedAt is the RFC 3339 time of the access
+	AccessedAt string `json:"accessedat" yaml:"accessedat"`
+}
+
+// TerraformLockMetrics structure to hold terraform lock telemetry
+type TerraformLockMetrics struct {
+	// LockAges is the age in seconds of each held lock, keyed by plan
+	LockAges map[string]float64 `json:"lockages" yaml:"lockages"`
+	// LockConflicts is the number of lock conflicts served since the daemon started
+	LockConflicts uint64 `json:"lockconflicts" yaml:"lockconflicts"`
+	// LockConflictsSince is the time the lock conflicts are counted from
+	LockConflictsSince time.Time `json:"lockconflictssince" yaml:"lockconflictssince"`
+}
+
+// TerraformLockConflicts structure to hold the lock conflicts served since the daemon started
+type TerraformLockConflicts struct {
+	// Total is the number of lock conflicts
+	Total uint64 `json:"total" yaml:"total"`
+	// ByWho is the number of lock conflicts by the Who of the conflicting requests
+	ByWho map[string]uint64 `json:"bywho" yaml:"bywho"`
+	// Untracked is the number of lock conflicts of the identities beyond the tracked ones
+	Untracked uint64 `json:"untracked" yaml:"untracked"`
+}
+
+// TerraformFsck structure to hold the result of a terraform state and lock consistency check
+type TerraformFsck struct {
+	// OrphanedLocks are the plans with a lock but no state
+	OrphanedLocks []string `json:"orphanedlocks" yaml:"orphanedlocks"`
+	// UnlockedStates are the plans with a state but no lock, which is expected for plans not being applied
+	UnlockedStates []string `json:"unlockedstates" yaml:"unlockedstates"`
+	// RemovedLocks are the orphaned locks older than the lock TTL removed during repair
+	RemovedLocks []string `json:"removedlocks" yaml:"removedlocks"`
+}
+
+// TerraformStateDiff structure to hold the differences between the resources of two terraform states
+type TerraformStateDiff struct {
+	// From is the plan whose state is compared
+	From string `json:"from" yaml:"from"`
+	// To is the plan whose state From is compared to
+	To string `json:"to" yaml:"to"`
+	// Added are the addresses of the resource instances only in To
+	Added []string `json:"added" yaml:"added"`
+	// Removed are the addresses of the resource instances only in From
+	Removed []string `json:"removed" yaml:"removed"`
+	// Changed are the resource instances in both states with different attributes
+	Changed []TerraformResourceChange `json:"changed" yaml:"changed"`
+}
+
+// TerraformResourceChange structure to hold the attributes of a resource instance changed between two states
+type TerraformResourceChange struct {
+	Address    string                     `json:"address" yaml:"address"`
+	Attributes []TerraformAttributeChange `json:"attributes" yaml:"attributes"`
+}
+
+// TerraformAttributeChange structure to hold the values of a resource attribute in both states.
+// From is unset for added attributes and To for removed ones.
+type TerraformAttributeChange struct {
+	Name string `json:"name" yaml:"name"`
+	From any    `json:"from,omitempty" yaml:"from,omitempty"`
+	To   any    `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+// TerraformBackend structure to hold the terraform http backend configuration of a plan
+type TerraformBackend struct {
+	// Config is the backend block in the terraform JSON configuration syntax
+	Config TerraformBackendConfig `json:"config" yaml:"config"`
+	// Untrusted is set when the terraform endpoints accept clients without a trusted certificate
+	Untrusted bool `json:"untrusted" yaml:"untrusted"`
+	// ClientCertificate is set when the lock owners are checked against the client certificate,
+	// which then must be set with client_certificate_pem and client_private_key_pem
+	ClientCertificate bool `json:"clientcertificate" yaml:"clientcertificate"`
+}
+
+// TerraformBackendConfig structure to hold the terraform block of a terraform JSON configuration
+type TerraformBackendConfig struct {
+	Terraform struct {
+		Backend struct {
+			HTTP TerraformHTTPBackend `json:"http" yaml:"http"`
+		} `json:"backend" yaml:"backend"`
+	} `json:"terraform" yaml:"terraform"`
+}
+
+// TerraformHTTPBackend structure to hold the settings of the terraform http backend
+type TerraformHTTPBackend struct {
+	Address              string `json:"address" yaml:"address"`
+	UpdateMethod         string `json:"update_method" yaml:"update_method"`
+	LockAddress          string `json:"lock_address" yaml:"lock_address"`
+	LockMethod           string `json:"lock_method" yaml:"lock_method"`
+	UnlockAddress        string `json:"unlock_address" yaml:"unlock_address"`
+	UnlockMethod         string `json:"unlock_method" yaml:"unlock_method"`
+	SkipCertVerification bool   `json:"skip_cert_verification" yaml:"skip_cert_verification"`
+}
+
+// TerraformStateVerify structure to hold the result of an integrity check of a terraform state
+type TerraformStateVerify struct {
+	// Size is the size in bytes of the stored state
+	Size int `json:"size" yaml:"size"`
+	// Valid is set when the stored state is a JSON object
+	Valid bool `json:"valid" yaml:"valid"`
+	// Error is why the stored state is not valid
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+	// SerialStatus is one of ok, mismatch when the serial of the state differs from the
+	// tracked one, or untracked when the state or the tracking has no serial
+	SerialStatus string `json:"serialstatus" yaml:"serialstatus"`
+	// ChecksumStatus is unavailable as no checksum is stored with the states
+	ChecksumStatus string `json:"checksumstatus" yaml:"checksumstatus"`
+}
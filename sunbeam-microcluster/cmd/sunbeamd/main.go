@@ -15,6 +15,7 @@ import (
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/version"
 )
 
@@ -83,9 +84,11 @@ func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
 		},
 
 		// OnStart is run after the daemon is started.
-		OnStart: func(_ *state.State) error {
+		OnStart: func(s *state.State) error {
 			logger.Info("This is a hook that runs after the daemon first starts")
 
+			go sunbeam.DrainTerraformOnShutdown(s)
+
 			return nil
 		},
 
@@ -118,10 +121,13 @@ func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
 		},
 
 		// OnHeartbeat is run after a successful heartbeat round.
-		OnHeartbeat: func(_ *state.State) error {
-			logger.Info("This is a hook that is run on the dqlite leader after a successful heartbeat")
+		OnHeartbeat: func(s *state.State) error {
+			err := sunbeam.ReapLocksOnHeartbeat(s)
+			if err != nil {
+				return err
+			}
 
-			return nil
+			return sunbeam.CompactOnHeartbeat(s)
 		},
 
 		// OnNewMember is run after a new member has joined.
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/cluster"
 )
 
 //go:generate -command mapper lxd-generate db mapper -t config.mapper.go
@@ -32,6 +36,11 @@ type ConfigItem struct {
 	ID    int
 	Key   string `db:"primary=yes"`
 	Value string
+	Type  string
+	// UpdatedAt is set by the database each time the ConfigItem is written
+	UpdatedAt string `db:"omit=create,update"`
+	// CreatedBy is the identity of the client that created the ConfigItem
+	CreatedBy string `db:"omit=update"`
 }
 
 // ConfigItemFilter is a required struct for use with lxd-generate. It is used for filtering fields on database fetches.
@@ -39,17 +48,46 @@ type ConfigItemFilter struct {
 	Key *string
 }
 
-// GetConfigItemKeys returns the list of ConfigItem keys from the database, filtered by prefix if provided.
-func GetConfigItemKeys(ctx context.Context, tx *sql.Tx, prefix *string) ([]string, error) {
-	stmt := `SELECT config.key FROM config`
+var configItemTouch = cluster.RegisterStmt(`
+UPDATE config
+  SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+ WHERE key = ?
+`)
 
-	args := make([]any, 0)
+// TouchConfigItem bumps the update time of the ConfigItem with the given key, leaving its value unchanged.
+func TouchConfigItem(_ context.Context, tx *sql.Tx, key string) error {
+	stmt, err := cluster.Stmt(tx, configItemTouch)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"configItemTouch\" prepared statement: %w", err)
+	}
 
-	if prefix != nil {
-		stmt += ` WHERE config.key LIKE ?`
-		args = append(args, *prefix+"%")
+	result, err := stmt.Exec(key)
+	if err != nil {
+		return fmt.Errorf("Touch \"config\" entry failed: %w", err)
 	}
 
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "ConfigItem not found")
+	}
+
+	return nil
+}
+
+// GetConfigItemKeys returns the list of ConfigItem keys from the database, filtered by prefix if provided.
+func GetConfigItemKeys(ctx context.Context, tx *sql.Tx, prefix *string) ([]string, error) {
+	return GetConfigItemKeysRange(ctx, tx, prefix, "", -1)
+}
+
+// GetConfigItemKeysRange returns at most limit ConfigItem keys ordered by key, starting
+// after the given key and filtered by prefix if provided. A negative limit returns all the keys.
+func GetConfigItemKeysRange(ctx context.Context, tx *sql.Tx, prefix *string, after string, limit int) ([]string, error) {
+	stmt, args := configItemRangeQuery(`SELECT config.key FROM config`, prefix, after, limit)
+
 	configs := make([]string, 0)
 
 	dest := func(scan func(dest ...any) error) error {
@@ -71,3 +109,91 @@ func GetConfigItemKeys(ctx context.Context, tx *sql.Tx, prefix *string) ([]strin
 
 	return configs, nil
 }
+
+// GetConfigItemsByPrefix returns the ConfigItems from the database, filtered by key prefix if provided.
+func GetConfigItemsByPrefix(ctx context.Context, tx *sql.Tx, prefix *string) ([]ConfigItem, error) {
+	return GetConfigItemsRange(ctx, tx, prefix, "", -1)
+}
+
+// GetConfigItemsRange returns at most limit ConfigItems ordered by key, starting after
+// the given key and filtered by key prefix if provided. A negative limit returns all the ConfigItems.
+func GetConfigItemsRange(ctx context.Context, tx *sql.Tx, prefix *string, after string, limit int) ([]ConfigItem, error) {
+	stmt, args := configItemRangeQuery(fmt.Sprintf(`SELECT %s FROM config`, configItemColumns()), prefix, after, limit)
+
+	return getConfigItemsRaw(ctx, tx, stmt, args...)
+}
+
+// configItemRangeQuery appends to the query the clauses selecting a range of the ConfigItems ordered by key.
+func configItemRangeQuery(stmt string, prefix *string, after string, limit int) (string, []any) {
+	args := make([]any, 0)
+	where := make([]string, 0)
+
+	if prefix != nil {
+		where = append(where, `config.key LIKE ?`)
+		args = append(args, *prefix+"%")
+	}
+
+	if after != "" {
+		where = append(where, `config.key > ?`)
+		args = append(args, after)
+	}
+
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	stmt += ` ORDER BY config.key LIMIT ?`
+	args = append(args, limit)
+
+	return stmt, args
+}
+
+// configItemsSize selects the total size in bytes of the ConfigItem values.
+const configItemsSize = `
+SELECT COALESCE(SUM(length(CAST(config.value AS BLOB))), 0) FROM config
+`
+
+// GetConfigItemsSize returns the total size in bytes of the ConfigItem values.
+func GetConfigItemsSize(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var size int64
+	err := tx.QueryRowContext(ctx, configItemsSize).Scan(&size)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return size, nil
+}
+
+// GetConfigItemSizes returns the total size in bytes of the ConfigItem values, grouped by key prefix.
+// The prefix of a key is everything up to and including its first dash, keys without a dash
+// are grouped under the empty prefix.
+func GetConfigItemSizes(ctx context.Context, tx *sql.Tx) (map[string]int64, error) {
+	stmt := `
+SELECT CASE WHEN instr(config.key, '-') > 0 THEN substr(config.key, 1, instr(config.key, '-')) ELSE '' END AS prefix,
+       SUM(length(CAST(config.value AS BLOB)))
+  FROM config
+  GROUP BY prefix
+`
+
+	sizes := make(map[string]int64)
+
+	dest := func(scan func(dest ...any) error) error {
+		var prefix string
+		var size int64
+		err := scan(&prefix, &size)
+		if err != nil {
+			return err
+		}
+
+		sizes[prefix] = size
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return sizes, nil
+}
@@ -18,13 +18,13 @@ import (
 var _ = api.ServerEnvironment{}
 
 var configItemObjects = cluster.RegisterStmt(`
-SELECT config.id, config.key, config.value
+SELECT config.id, config.key, config.value, config.type, config.updated_at, config.created_by
   FROM config
   ORDER BY config.key
 `)
 
 var configItemObjectsByKey = cluster.RegisterStmt(`
-SELECT config.id, config.key, config.value
+SELECT config.id, config.key, config.value, config.type, config.updated_at, config.created_by
   FROM config
   WHERE ( config.key = ? )
   ORDER BY config.key
@@ -36,8 +36,8 @@ SELECT config.id FROM config
 `)
 
 var configItemCreate = cluster.RegisterStmt(`
-INSERT INTO config (key, value)
-  VALUES (?, ?)
+INSERT INTO config (key, value, type, created_by)
+  VALUES (?, ?, ?, ?)
 `)
 
 var configItemDeleteByKey = cluster.RegisterStmt(`
@@ -46,14 +46,14 @@ DELETE FROM config WHERE key = ?
 
 var configItemUpdate = cluster.RegisterStmt(`
 UPDATE config
-  SET key = ?, value = ?
+  SET key = ?, value = ?, type = ?
  WHERE id = ?
 `)
 
 // configItemColumns returns a string of column names to be used with a SELECT statement for the entity.
 // Use this function when building statements to retrieve database entries matching the ConfigItem entity.
 func configItemColumns() string {
-	return "config.id, config.key, config.value"
+	return "config.id, config.key, config.value, config.type, config.updated_at, config.created_by"
 }
 
 // getConfigItems can be used to run handwritten sql.Stmts to return a slice of objects.
@@ -62,7 +62,7 @@ func getConfigItems(ctx context.Context, stmt *sql.Stmt, args ...any) ([]ConfigI
 
 	dest := func(scan func(dest ...any) error) error {
 		c := ConfigItem{}
-		err := scan(&c.ID, &c.Key, &c.Value)
+		err := scan(&c.ID, &c.Key, &c.Value, &c.Type, &c.UpdatedAt, &c.CreatedBy)
 		if err != nil {
 			return err
 		}
@@ -86,7 +86,7 @@ func getConfigItemsRaw(ctx context.Context, tx *sql.Tx, sql string, args ...any)
 
 	dest := func(scan func(dest ...any) error) error {
 		c := ConfigItem{}
-		err := scan(&c.ID, &c.Key, &c.Value)
+		err := scan(&c.ID, &c.Key, &c.Value, &c.Type, &c.UpdatedAt, &c.CreatedBy)
 		if err != nil {
 			return err
 		}
@@ -242,11 +242,13 @@ func CreateConfigItem(ctx context.Context, tx *sql.Tx, object ConfigItem) (int64
 		return -1, api.StatusErrorf(http.StatusConflict, "This \"config\" entry already exists")
 	}
 
-	args := make([]any, 2)
+	args := make([]any, 4)
 
 	// Populate the statement arguments.
 	args[0] = object.Key
 	args[1] = object.Value
+	args[2] = object.Type
+	args[3] = object.CreatedBy
 
 	// Prepared statement to use.
 	stmt, err := cluster.Stmt(tx, configItemCreate)
@@ -308,7 +310,7 @@ func UpdateConfigItem(ctx context.Context, tx *sql.Tx, key string, object Config
 		return fmt.Errorf("Failed to get \"configItemUpdate\" prepared statement: %w", err)
 	}
 
-	result, err := stmt.Exec(object.Key, object.Value, id)
+	result, err := stmt.Exec(object.Key, object.Value, object.Type, id)
 	if err != nil {
 		return fmt.Errorf("Update \"config\" entry failed: %w", err)
 	}
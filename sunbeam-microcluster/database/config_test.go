@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+)
+
+func TestConfigItemWrites(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := CreateConfigItem(ctx, tx, ConfigItem{Key: "key1", Value: "value1", CreatedBy: "creator"})
+		if err != nil {
+			return err
+		}
+
+		item, err := GetConfigItem(ctx, tx, "key1")
+		if err != nil {
+			return err
+		}
+
+		if item.UpdatedAt == "" {
+			t.Errorf("Created key has no update time")
+		}
+
+		item.Value = "value2"
+		item.CreatedBy = "other"
+		item.UpdatedAt = ""
+		err = UpdateConfigItem(ctx, tx, "key1", *item)
+		if err != nil {
+			return err
+		}
+
+		item, err = GetConfigItem(ctx, tx, "key1")
+		if err != nil {
+			return err
+		}
+
+		if item.Value != "value2" {
+			t.Errorf("Updated key holds %q, expected %q", item.Value, "value2")
+		}
+
+		if item.CreatedBy != "creator" {
+			t.Errorf("Update changed the creator of the key to %q", item.CreatedBy)
+		}
+
+		if item.UpdatedAt == "" {
+			t.Errorf("Updated key has no update time")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write config key: %v", err)
+	}
+}
+
+func TestGetConfigItemKeysRange(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		for _, key := range []string{"a1", "a2", "a3", "b1"} {
+			_, err := CreateConfigItem(ctx, tx, ConfigItem{Key: key, Value: key, CreatedBy: "test"})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create config keys: %v", err)
+	}
+
+	prefix := "a"
+	tests := []struct {
+		name   string
+		prefix *string
+		after  string
+		limit  int
+		keys   []string
+	}{
+		{name: "all", limit: -1, keys: []string{"a1", "a2", "a3", "b1"}},
+		{name: "prefix", prefix: &prefix, limit: -1, keys: []string{"a1", "a2", "a3"}},
+		{name: "after", prefix: &prefix, after: "a1", limit: -1, keys: []string{"a2", "a3"}},
+		{name: "limit", after: "a1", limit: 2, keys: []string{"a2", "a3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+				keys, err := GetConfigItemKeysRange(ctx, tx, tt.prefix, tt.after, tt.limit)
+				if err != nil {
+					return err
+				}
+
+				if !reflect.DeepEqual(keys, tt.keys) {
+					t.Errorf("Keys are %v, expected %v", keys, tt.keys)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to get config keys: %v", err)
+			}
+		})
+	}
+}
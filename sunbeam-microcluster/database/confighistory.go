@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/cluster"
+)
+
+// ConfigHistoryItem is a prior value of a ConfigItem.
+// Versions are numbered per key, the greatest version being the most recent.
+type ConfigHistoryItem struct {
+	ID         int
+	Key        string
+	Version    int
+	Value      string
+	Type       string
+	RecordedAt string
+}
+
+var configHistoryItemCreate = cluster.RegisterStmt(`
+INSERT INTO config_history (key, version, value, type, recorded_at)
+  VALUES (?, (SELECT COALESCE(MAX(version), 0) + 1 FROM config_history WHERE key = ?), ?, ?, strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+`)
+
+var configHistoryItemObjectsByKey = cluster.RegisterStmt(`
+SELECT config_history.id, config_history.key, config_history.version, config_history.value, config_history.type, config_history.recorded_at
+  FROM config_history
+  WHERE config_history.key = ?
+  ORDER BY config_history.version DESC
+`)
+
+var configHistoryItemObjectByKeyAndVersion = cluster.RegisterStmt(`
+SELECT config_history.id, config_history.key, config_history.version, config_history.value, config_history.type, config_history.recorded_at
+  FROM config_history
+  WHERE config_history.key = ? AND config_history.version = ?
+`)
+
+var configHistoryItemsTrim = cluster.RegisterStmt(`
+DELETE FROM config_history
+  WHERE key = ? AND version <= (SELECT MAX(version) FROM config_history WHERE key = ?) - ?
+`)
+
+var configHistoryItemsDeleteByKey = cluster.RegisterStmt(`
+DELETE FROM config_history WHERE key = ?
+`)
+
+var configHistoryItemsRename = cluster.RegisterStmt(`
+UPDATE config_history SET key = ? WHERE key = ?
+`)
+
+// CreateConfigHistoryItem records a prior value of the ConfigItem with the given key as its next version.
+func CreateConfigHistoryItem(_ context.Context, tx *sql.Tx, key string, value string, valueType string) error {
+	stmt, err := cluster.Stmt(tx, configHistoryItemCreate)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"configHistoryItemCreate\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec(key, key, value, valueType)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"config_history\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetConfigHistoryItems returns the prior values of the ConfigItem with the given key, most recent first.
+func GetConfigHistoryItems(ctx context.Context, tx *sql.Tx, key string) ([]ConfigHistoryItem, error) {
+	stmt, err := cluster.Stmt(tx, configHistoryItemObjectsByKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"configHistoryItemObjectsByKey\" prepared statement: %w", err)
+	}
+
+	objects := make([]ConfigHistoryItem, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		c := ConfigHistoryItem{}
+		err := scan(&c.ID, &c.Key, &c.Version, &c.Value, &c.Type, &c.RecordedAt)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, c)
+
+		return nil
+	}
+
+	err = query.SelectObjects(ctx, stmt, dest, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config_history\" table: %w", err)
+	}
+
+	return objects, nil
+}
+
+// GetConfigHistoryItem returns the given version of the ConfigItem with the given key.
+func GetConfigHistoryItem(ctx context.Context, tx *sql.Tx, key string, version int) (*ConfigHistoryItem, error) {
+	stmt, err := cluster.Stmt(tx, configHistoryItemObjectByKeyAndVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"configHistoryItemObjectByKeyAndVersion\" prepared statement: %w", err)
+	}
+
+	c := ConfigHistoryItem{}
+	err = stmt.QueryRowContext(ctx, key, version).Scan(&c.ID, &c.Key, &c.Version, &c.Value, &c.Type, &c.RecordedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, api.StatusErrorf(http.StatusNotFound, "ConfigHistoryItem not found")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config_history\" table: %w", err)
+	}
+
+	return &c, nil
+}
+
+// TrimConfigHistoryItems deletes all but the keep most recent versions of the ConfigItem with the given key
+// and returns the number of versions deleted.
+func TrimConfigHistoryItems(_ context.Context, tx *sql.Tx, key string, keep int) (int64, error) {
+	stmt, err := cluster.Stmt(tx, configHistoryItemsTrim)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get \"configHistoryItemsTrim\" prepared statement: %w", err)
+	}
+
+	result, err := stmt.Exec(key, key, keep)
+	if err != nil {
+		return -1, fmt.Errorf("Delete \"config_history\": %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// DeleteConfigHistoryItems deletes all the versions of the ConfigItem with the given key.
+func DeleteConfigHistoryItems(_ context.Context, tx *sql.Tx, key string) error {
+	stmt, err := cluster.Stmt(tx, configHistoryItemsDeleteByKey)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"configHistoryItemsDeleteByKey\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec(key)
+	if err != nil {
+		return fmt.Errorf("Delete \"config_history\": %w", err)
+	}
+
+	return nil
+}
+
+// RenameConfigHistoryItems moves the versions of the ConfigItem with the given key to the new key.
+func RenameConfigHistoryItems(_ context.Context, tx *sql.Tx, key string, newKey string) error {
+	stmt, err := cluster.Stmt(tx, configHistoryItemsRename)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"configHistoryItemsRename\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec(newKey, key)
+	if err != nil {
+		return fmt.Errorf("Update \"config_history\" entries failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package dbtest provides in-memory databases for the tests of the other packages.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/db/schema"
+	"github.com/canonical/microcluster/cluster"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// clusterSchema creates the microcluster tables the schema extensions and statements rely on.
+const clusterSchema = `
+CREATE TABLE schemas (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  version    INTEGER NOT NULL,
+  type       INTEGER NOT NULL,
+  updated_at DATETIME NOT NULL,
+  UNIQUE (version, type)
+);
+CREATE TABLE internal_token_records (
+  id           INTEGER         PRIMARY  KEY    AUTOINCREMENT  NOT  NULL,
+  name         TEXT            NOT      NULL,
+  secret       TEXT            NOT      NULL,
+  UNIQUE       (name),
+  UNIQUE       (secret)
+);
+CREATE TABLE internal_cluster_members (
+  id                   INTEGER   PRIMARY  KEY    AUTOINCREMENT  NOT  NULL,
+  name                 TEXT      NOT      NULL,
+  address              TEXT      NOT      NULL,
+  certificate          TEXT      NOT      NULL,
+  schema_internal      INTEGER   NOT      NULL,
+  schema_external      INTEGER   NOT      NULL,
+  heartbeat            DATETIME  NOT      NULL,
+  role                 TEXT      NOT      NULL,
+  UNIQUE(name),
+  UNIQUE(certificate)
+);
+INSERT INTO internal_cluster_members (name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES ('member1', '10.0.0.1:7000', 'cert1', 1, 1, '2024-01-01T00:00:00Z', 'voter');
+`
+
+// NewDB returns an in-memory database with the given schema extensions applied
+// and recorded in the schemas table as microcluster does, the registered
+// statements prepared, and a single cluster member named member1.
+func NewDB(t *testing.T, extensions []schema.Update) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=1")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	// Each connection to an in-memory database gets a database of its own.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.Exec(clusterSchema)
+	if err != nil {
+		t.Fatalf("Failed to create cluster schema: %v", err)
+	}
+
+	for i, update := range extensions {
+		err = Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+			err := update(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec("INSERT INTO schemas (version, type, updated_at) VALUES (?, 1, strftime('%s'))", i+1)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to apply schema extension %d: %v", i+1, err)
+		}
+	}
+
+	err = cluster.PrepareStmts(db, cluster.GetCallerProject(), false)
+	if err != nil {
+		t.Fatalf("Failed to prepare statements: %v", err)
+	}
+
+	return db
+}
+
+// Transaction runs f in a transaction of db, committing it if f succeeds.
+func Transaction(db *sql.DB, f func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = f(ctx, tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// ErrNotFound is matched by the errors of records that do not exist.
+var ErrNotFound = errors.New("Not found")
+
+// ErrConflict is matched by the errors of records conflicting with existing ones.
+var ErrConflict = errors.New("Conflict")
+
+// statusSentinels are the sentinel errors matching each status.
+var statusSentinels = map[int]error{
+	http.StatusNotFound: ErrNotFound,
+	http.StatusConflict: ErrConflict,
+}
+
+// sentinelError is a status error that also matches the sentinel error of its status.
+type sentinelError struct {
+	err      error
+	sentinel error
+}
+
+// Error returns the message of the status error, which the sentinel leaves unchanged.
+func (e *sentinelError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns both the status error and the sentinel error.
+func (e *sentinelError) Unwrap() []error {
+	return []error{e.err, e.sentinel}
+}
+
+// WrapStatusError makes err match ErrNotFound or ErrConflict with errors.Is when
+// it has their status, keeping its message and status. Like the generated
+// mappers, the functions of this package return plain status errors, which the
+// transactions of the daemon wrap.
+func WrapStatusError(err error) error {
+	status, found := api.StatusErrorMatch(err)
+	if !found {
+		return err
+	}
+
+	sentinel, ok := statusSentinels[status]
+	if !ok || errors.Is(err, sentinel) {
+		return err
+	}
+
+	return &sentinelError{err: err, sentinel: sentinel}
+}
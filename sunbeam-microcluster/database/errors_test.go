@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+)
+
+func TestWrapStatusError(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	var notFound, conflict error
+	_ = dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		_, notFound = GetConfigItem(ctx, tx, "missing")
+
+		_, err := CreateConfigItem(ctx, tx, ConfigItem{Key: "key1", Value: "value1"})
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+
+		_, conflict = CreateConfigItem(ctx, tx, ConfigItem{Key: "key1", Value: "value2"})
+		return nil
+	})
+
+	tests := []struct {
+		name     string
+		err      error
+		status   int
+		sentinel error
+	}{
+		{name: "not found", err: notFound, status: http.StatusNotFound, sentinel: ErrNotFound},
+		{name: "conflict", err: conflict, status: http.StatusConflict, sentinel: ErrConflict},
+		{name: "other status", err: api.StatusErrorf(http.StatusBadRequest, "Invalid"), status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fmt.Errorf("Failed: %w", WrapStatusError(tt.err))
+
+			for _, sentinel := range []error{ErrNotFound, ErrConflict} {
+				if errors.Is(err, sentinel) != (sentinel == tt.sentinel) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, sentinel, errors.Is(err, sentinel))
+				}
+			}
+
+			if !api.StatusErrorCheck(err, tt.status) {
+				t.Errorf("Wrapped error %v lost status %d", err, tt.status)
+			}
+
+			if err.Error() != "Failed: "+tt.err.Error() {
+				t.Errorf("Wrapped error message is %q, expected %q", err.Error(), "Failed: "+tt.err.Error())
+			}
+		})
+	}
+
+	if WrapStatusError(nil) != nil {
+		t.Errorf("WrapStatusError(nil) is not nil")
+	}
+}
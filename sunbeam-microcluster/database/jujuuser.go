@@ -1,5 +1,13 @@
 package database
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/microcluster/cluster"
+)
+
 //go:generate -command mapper lxd-generate db mapper -t jujuuser.mapper.go
 //go:generate mapper reset
 //
@@ -23,9 +31,35 @@ type JujuUser struct {
 	ID       int
 	Username string `db:"primary=yes"`
 	Token    string
+	// CreatedBy is the identity of the client that created the JujuUser
+	CreatedBy string `db:"omit=update"`
 }
 
 // JujuUserFilter is a required struct for use with lxd-generate. It is used for filtering fields on database fetches.
 type JujuUserFilter struct {
 	Username *string
 }
+
+var jujuUserDeleteAll = cluster.RegisterStmt(`
+DELETE FROM jujuuser
+`)
+
+// DeleteAllJujuUsers deletes all the JujuUsers and returns the number of JujuUsers deleted.
+func DeleteAllJujuUsers(_ context.Context, tx *sql.Tx) (int64, error) {
+	stmt, err := cluster.Stmt(tx, jujuUserDeleteAll)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get \"jujuUserDeleteAll\" prepared statement: %w", err)
+	}
+
+	result, err := stmt.Exec()
+	if err != nil {
+		return -1, fmt.Errorf("Delete \"jujuuser\": %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
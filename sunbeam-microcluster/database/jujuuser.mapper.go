@@ -18,13 +18,13 @@ import (
 var _ = api.ServerEnvironment{}
 
 var jujuUserObjects = cluster.RegisterStmt(`
-SELECT jujuuser.id, jujuuser.username, jujuuser.token
+SELECT jujuuser.id, jujuuser.username, jujuuser.token, jujuuser.created_by
   FROM jujuuser
   ORDER BY jujuuser.username
 `)
 
 var jujuUserObjectsByUsername = cluster.RegisterStmt(`
-SELECT jujuuser.id, jujuuser.username, jujuuser.token
+SELECT jujuuser.id, jujuuser.username, jujuuser.token, jujuuser.created_by
   FROM jujuuser
   WHERE ( jujuuser.username = ? )
   ORDER BY jujuuser.username
@@ -36,8 +36,8 @@ SELECT jujuuser.id FROM jujuuser
 `)
 
 var jujuUserCreate = cluster.RegisterStmt(`
-INSERT INTO jujuuser (username, token)
-  VALUES (?, ?)
+INSERT INTO jujuuser (username, token, created_by)
+  VALUES (?, ?, ?)
 `)
 
 var jujuUserDeleteByUsername = cluster.RegisterStmt(`
@@ -53,7 +53,7 @@ UPDATE jujuuser
 // jujuUserColumns returns a string of column names to be used with a SELECT statement for the entity.
 // Use this function when building statements to retrieve database entries matching the JujuUser entity.
 func jujuUserColumns() string {
-	return "jujuuser.id, jujuuser.username, jujuuser.token"
+	return "jujuuser.id, jujuuser.username, jujuuser.token, jujuuser.created_by"
 }
 
 // getJujuUsers can be used to run handwritten sql.Stmts to return a slice of objects.
@@ -62,7 +62,7 @@ func getJujuUsers(ctx context.Context, stmt *sql.Stmt, args ...any) ([]JujuUser,
 
 	dest := func(scan func(dest ...any) error) error {
 		j := JujuUser{}
-		err := scan(&j.ID, &j.Username, &j.Token)
+		err := scan(&j.ID, &j.Username, &j.Token, &j.CreatedBy)
 		if err != nil {
 			return err
 		}
@@ -86,7 +86,7 @@ func getJujuUsersRaw(ctx context.Context, tx *sql.Tx, sql string, args ...any) (
 
 	dest := func(scan func(dest ...any) error) error {
 		j := JujuUser{}
-		err := scan(&j.ID, &j.Username, &j.Token)
+		err := scan(&j.ID, &j.Username, &j.Token, &j.CreatedBy)
 		if err != nil {
 			return err
 		}
@@ -242,11 +242,12 @@ func CreateJujuUser(ctx context.Context, tx *sql.Tx, object JujuUser) (int64, er
 		return -1, api.StatusErrorf(http.StatusConflict, "This \"jujuuser\" entry already exists")
 	}
 
-	args := make([]any, 2)
+	args := make([]any, 3)
 
 	// Populate the statement arguments.
 	args[0] = object.Username
 	args[1] = object.Token
+	args[2] = object.CreatedBy
 
 	// Prepared statement to use.
 	stmt, err := cluster.Stmt(tx, jujuUserCreate)
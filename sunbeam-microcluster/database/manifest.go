@@ -3,9 +3,11 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/canonical/lxd/lxd/db/query"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/cluster"
 )
@@ -33,6 +35,20 @@ type ManifestItem struct {
 	ManifestID  string `db:"primary=yes"`
 	AppliedDate string
 	Data        string
+	// CreatedBy is the identity of the client that created the ManifestItem
+	CreatedBy string
+	// StartedAt and FinishedAt are the RFC 3339 times the manifest application
+	// started and finished, empty when not reported by the client
+	StartedAt  string
+	FinishedAt string
+	// Status is the outcome of the manifest application, empty when not reported by the client
+	Status string
+	// DataHash is the SHA-256 of the data stored as a blob outside of the database,
+	// in which case Data is empty
+	DataHash string
+	// DataMember is the cluster member holding the data blob, empty for the
+	// blobs stored before the member was recorded
+	DataMember string
 }
 
 // ManifestItemFilter is a required struct for use with lxd-generate. It is used for filtering fields on database fetches.
@@ -41,16 +57,65 @@ type ManifestItemFilter struct {
 }
 
 var manifestItemCreate = cluster.RegisterStmt(`
-INSERT INTO manifest (manifest_id, data)
-  VALUES (?, ?)
+INSERT INTO manifest (manifest_id, data, created_by, started_at, finished_at, status, data_hash, data_member)
+  VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 `)
 
 var latestManifestItemObject = cluster.RegisterStmt(`
-SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.created_by, manifest.started_at, manifest.finished_at, manifest.status, manifest.data_hash, manifest.data_member
   FROM manifest
   WHERE manifest.applied_date = (SELECT MAX(applied_date) FROM manifest)
 `)
 
+var recentManifestItemObjects = cluster.RegisterStmt(`
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.created_by, manifest.started_at, manifest.finished_at, manifest.status, manifest.data_hash, manifest.data_member
+  FROM manifest
+  ORDER BY manifest.applied_date DESC, manifest.id DESC
+  LIMIT ?
+`)
+
+var statusManifestItemObjects = cluster.RegisterStmt(`
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.created_by, manifest.started_at, manifest.finished_at, manifest.status, manifest.data_hash, manifest.data_member
+  FROM manifest
+  WHERE manifest.status = ?
+  ORDER BY manifest.applied_date DESC, manifest.id DESC
+  LIMIT ?
+`)
+
+var recentManifestItemIDs = cluster.RegisterStmt(`
+SELECT manifest.manifest_id
+  FROM manifest
+  ORDER BY manifest.applied_date DESC, manifest.id DESC
+  LIMIT ?
+`)
+
+var searchManifestItemObjects = cluster.RegisterStmt(`
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.created_by, manifest.started_at, manifest.finished_at, manifest.status, manifest.data_hash, manifest.data_member
+  FROM manifest
+  WHERE instr(manifest.data, ?) > 0 OR manifest.data_hash != ''
+  ORDER BY manifest.applied_date DESC, manifest.id DESC
+  LIMIT ?
+`)
+
+var manifestItemDataHashes = cluster.RegisterStmt(`
+SELECT DISTINCT manifest.data_hash
+  FROM manifest
+  WHERE manifest.data_hash != '' AND manifest.data_member IN (?, '')
+`)
+
+var lastManifestItemIDWithPrefix = cluster.RegisterStmt(`
+SELECT manifest.manifest_id
+  FROM manifest
+  WHERE manifest.manifest_id LIKE ?
+  ORDER BY manifest.manifest_id DESC
+  LIMIT 1
+`)
+
+var manifestItemsSize = cluster.RegisterStmt(`
+SELECT COALESCE(SUM(length(CAST(manifest.data AS BLOB))), 0)
+  FROM manifest
+`)
+
 // CreateManifestItem adds a new ManifestItem to the database.
 // generator: ManifestItem Create
 func CreateManifestItem(ctx context.Context, tx *sql.Tx, object ManifestItem) (int64, error) {
@@ -64,11 +129,17 @@ func CreateManifestItem(ctx context.Context, tx *sql.Tx, object ManifestItem) (i
 		return -1, api.StatusErrorf(http.StatusConflict, "This \"manifest\" entry already exists")
 	}
 
-	args := make([]any, 2)
+	args := make([]any, 8)
 
 	// Populate the statement arguments.
 	args[0] = object.ManifestID
 	args[1] = object.Data
+	args[2] = object.CreatedBy
+	args[3] = object.StartedAt
+	args[4] = object.FinishedAt
+	args[5] = object.Status
+	args[6] = object.DataHash
+	args[7] = object.DataMember
 
 	// Prepared statement to use.
 	stmt, err := cluster.Stmt(tx, manifestItemCreate)
@@ -117,3 +188,121 @@ func GetLatestManifestItem(ctx context.Context, tx *sql.Tx) (*ManifestItem, erro
 		return &objects[objectsLen-1], nil
 	}
 }
+
+// GetRecentManifestItems returns the n most recently inserted records in manifest table, newest first.
+func GetRecentManifestItems(ctx context.Context, tx *sql.Tx, n int) ([]ManifestItem, error) {
+	sqlStmt, err := cluster.Stmt(tx, recentManifestItemObjects)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"recentManifestItemObjects\" prepared statement: %w", err)
+	}
+
+	objects, err := getManifestItems(ctx, sqlStmt, n)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return objects, nil
+}
+
+// GetManifestItemsByStatus returns the n most recently inserted records in manifest table
+// with the given status, newest first. A negative n returns all the records with the status.
+func GetManifestItemsByStatus(ctx context.Context, tx *sql.Tx, status string, n int) ([]ManifestItem, error) {
+	sqlStmt, err := cluster.Stmt(tx, statusManifestItemObjects)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"statusManifestItemObjects\" prepared statement: %w", err)
+	}
+
+	objects, err := getManifestItems(ctx, sqlStmt, status, n)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return objects, nil
+}
+
+// GetLastManifestItemIDWithPrefix returns the greatest manifest id starting with the given prefix.
+// An empty id is returned if no manifest id has the prefix.
+func GetLastManifestItemIDWithPrefix(ctx context.Context, tx *sql.Tx, prefix string) (string, error) {
+	sqlStmt, err := cluster.Stmt(tx, lastManifestItemIDWithPrefix)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get \"lastManifestItemIDWithPrefix\" prepared statement: %w", err)
+	}
+
+	var manifestid string
+	err = sqlStmt.QueryRowContext(ctx, prefix+"%").Scan(&manifestid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return manifestid, nil
+}
+
+// GetRecentManifestItemIDs returns the ids of the n most recently inserted records in manifest table, newest first.
+// A negative n returns the ids of all the records.
+func GetRecentManifestItemIDs(ctx context.Context, tx *sql.Tx, n int) ([]string, error) {
+	stmt, err := cluster.StmtString(recentManifestItemIDs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"recentManifestItemIDs\" prepared statement: %w", err)
+	}
+
+	ids, err := query.SelectStrings(ctx, tx, stmt, n)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return ids, nil
+}
+
+// GetManifestItemsSize returns the total size in bytes of the manifest data.
+func GetManifestItemsSize(ctx context.Context, tx *sql.Tx) (int64, error) {
+	sqlStmt, err := cluster.Stmt(tx, manifestItemsSize)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get \"manifestItemsSize\" prepared statement: %w", err)
+	}
+
+	var size int64
+	err = sqlStmt.QueryRowContext(ctx).Scan(&size)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return size, nil
+}
+
+// SearchManifestItems returns at most n records whose data contains the given text, newest first.
+// A negative n returns all the matching records. The records with their data stored
+// as a blob are always returned, as their data cannot be searched by the database.
+func SearchManifestItems(ctx context.Context, tx *sql.Tx, text string, n int) ([]ManifestItem, error) {
+	sqlStmt, err := cluster.Stmt(tx, searchManifestItemObjects)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"searchManifestItemObjects\" prepared statement: %w", err)
+	}
+
+	objects, err := getManifestItems(ctx, sqlStmt, text, n)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return objects, nil
+}
+
+// GetManifestItemDataHashes returns the hashes of the data blobs held by the given member
+// that are referenced by the records in manifest table. The blobs of the records with no
+// member recorded may be held by any member, so their hashes are always returned.
+func GetManifestItemDataHashes(ctx context.Context, tx *sql.Tx, member string) ([]string, error) {
+	stmt, err := cluster.StmtString(manifestItemDataHashes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"manifestItemDataHashes\" prepared statement: %w", err)
+	}
+
+	hashes, err := query.SelectStrings(ctx, tx, stmt, member)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return hashes, nil
+}
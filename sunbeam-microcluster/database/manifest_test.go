@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+)
+
+func TestManifestItemStatus(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	tests := []struct {
+		manifestid string
+		status     string
+	}{
+		{manifestid: "unreported", status: ""},
+		{manifestid: "succeeded", status: "succeeded"},
+		{manifestid: "failed", status: "failed"},
+	}
+
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		for _, tt := range tests {
+			_, err := CreateManifestItem(ctx, tx, ManifestItem{ManifestID: tt.manifestid, Data: "{}", CreatedBy: "test", Status: tt.status})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manifests: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.manifestid, func(t *testing.T) {
+			err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+				record, err := GetManifestItem(ctx, tx, tt.manifestid)
+				if err != nil {
+					return err
+				}
+
+				if record.Status != tt.status {
+					t.Errorf("Manifest %q has status %q, expected %q", tt.manifestid, record.Status, tt.status)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to get manifest: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetManifestItemsByStatus(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		seeded := []ManifestItem{
+			{ManifestID: "m1", Status: "succeeded"},
+			{ManifestID: "m2", Status: "failed"},
+			{ManifestID: "m3"},
+			{ManifestID: "m4", Status: "failed"},
+			{ManifestID: "m5", Status: "succeeded"},
+			{ManifestID: "m6", Status: "failed"},
+		}
+
+		for _, record := range seeded {
+			record.Data = "{}"
+			record.CreatedBy = "test"
+			_, err := CreateManifestItem(ctx, tx, record)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manifests: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		status string
+		n      int
+		ids    []string
+	}{
+		{name: "failed", status: "failed", n: -1, ids: []string{"m6", "m4", "m2"}},
+		{name: "last failed", status: "failed", n: 1, ids: []string{"m6"}},
+		{name: "last succeeded", status: "succeeded", n: 5, ids: []string{"m5", "m1"}},
+		{name: "unreported", status: "", n: -1, ids: []string{"m3"}},
+		{name: "unknown", status: "pending", n: -1, ids: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+				records, err := GetManifestItemsByStatus(ctx, tx, tt.status, tt.n)
+				if err != nil {
+					return err
+				}
+
+				ids := []string{}
+				for _, record := range records {
+					if record.Status != tt.status {
+						t.Errorf("Manifest %q has status %q, expected %q", record.ManifestID, record.Status, tt.status)
+					}
+
+					ids = append(ids, record.ManifestID)
+				}
+
+				if !reflect.DeepEqual(ids, tt.ids) {
+					t.Errorf("Manifests are %v, expected %v", ids, tt.ids)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to get manifests: %v", err)
+			}
+		})
+	}
+}
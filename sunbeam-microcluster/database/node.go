@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/http"
 	"strings"
 
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/cluster"
 )
 
@@ -32,12 +35,23 @@ import (
 
 // Node is used to track Node information.
 type Node struct {
-	ID        int
-	Member    string `db:"join=internal_cluster_members.name&joinon=nodes.member_id"`
-	Name      string `db:"primary=yes"`
-	Role      string
-	MachineID int
-	SystemID  string
+	ID             int
+	Member         string `db:"join=internal_cluster_members.name&joinon=nodes.member_id"`
+	Name           string `db:"primary=yes"`
+	Role           string
+	MachineID      int
+	SystemID       string
+	Metadata       string
+	Cordoned       bool
+	LastManifestID string
+	// CreatedBy is the identity of the client that created the node
+	CreatedBy string `db:"omit=update"`
+	// UpdatedAt is set by the database each time the node is written
+	UpdatedAt string `db:"omit=create,update"`
+	// Cpus, Memory and Disk are the capacity reported for the node, zero if unknown
+	Cpus   int
+	Memory int64
+	Disk   int64
 }
 
 // NodeFilter is a required struct for use with lxd-generate. It is used for filtering fields on database fetches.
@@ -50,7 +64,96 @@ type NodeFilter struct {
 
 // GetNodesFromRoles returns a slice of Nodes that match the given roles.
 func GetNodesFromRoles(ctx context.Context, tx *sql.Tx, roles []string) ([]Node, error) {
+	conditions, args := rolesConditions(roles)
 
+	return getNodesWhere(ctx, tx, conditions, args)
+}
+
+// GetNodesFromRolesMissing returns a slice of Nodes that match the given roles and lack all the given fields.
+func GetNodesFromRolesMissing(ctx context.Context, tx *sql.Tx, roles []string, missing []string) ([]Node, error) {
+	conditions, args := rolesConditions(roles)
+
+	missingConds, err := missingConditions(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	return getNodesWhere(ctx, tx, append(conditions, missingConds...), args)
+}
+
+// GetNodesByNames returns a slice of Nodes with the given names that match the given roles
+// and lack all the given fields.
+func GetNodesByNames(ctx context.Context, tx *sql.Tx, names []string, roles []string, missing []string) ([]Node, error) {
+	if len(names) == 0 {
+		return []Node{}, nil
+	}
+
+	conditions, args := rolesConditions(roles)
+
+	missingConds, err := missingConditions(missing)
+	if err != nil {
+		return nil, err
+	}
+	conditions = append(conditions, missingConds...)
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	conditions = append(conditions, fmt.Sprintf("nodes.name IN (%s)", placeholders))
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	return getNodesWhere(ctx, tx, conditions, args)
+}
+
+// GetNodesByLastManifestID returns the Nodes last configured by the given manifest.
+func GetNodesByLastManifestID(ctx context.Context, tx *sql.Tx, manifestid string) ([]Node, error) {
+	return getNodesWhere(ctx, tx, []string{"nodes.last_manifest_id = ?"}, []any{manifestid})
+}
+
+// GetNodesBySystemID returns the Nodes with the given MAAS system id.
+func GetNodesBySystemID(ctx context.Context, tx *sql.Tx, systemid string) ([]Node, error) {
+	return getNodesWhere(ctx, tx, []string{"nodes.system_id = ?"}, []any{systemid})
+}
+
+// rolesConditions returns the WHERE conditions and arguments matching nodes having all the given roles.
+func rolesConditions(roles []string) ([]string, []any) {
+	conditions := make([]string, 0, len(roles))
+	args := make([]any, 0, len(roles))
+
+	for _, role := range roles {
+		conditions = append(conditions, nodeRolesCondition)
+		args = append(args, role)
+	}
+
+	return conditions, args
+}
+
+// nodeMissingConditions are the WHERE conditions matching nodes without a value for each field.
+// Nodes added without a machine id record -1.
+var nodeMissingConditions = map[string]string{
+	"systemid":  "(nodes.system_id IS NULL OR nodes.system_id = '')",
+	"machineid": "(nodes.machine_id IS NULL OR nodes.machine_id < 0)",
+	"role":      "(nodes.role IS NULL OR nodes.role IN ('', '[]', 'null'))",
+}
+
+// missingConditions returns the WHERE conditions matching nodes without any of the given fields.
+func missingConditions(fields []string) ([]string, error) {
+	conditions := make([]string, 0, len(fields))
+
+	for _, field := range fields {
+		condition, ok := nodeMissingConditions[field]
+		if !ok {
+			return nil, api.StatusErrorf(http.StatusBadRequest, "Unknown node field %q, expected one of systemid, machineid or role", field)
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+// getNodesWhere returns a slice of Nodes matching all the given WHERE conditions.
+func getNodesWhere(ctx context.Context, tx *sql.Tx, conditions []string, args []any) ([]Node, error) {
 	stmt, err := cluster.StmtString(nodeObjects)
 
 	if err != nil {
@@ -59,17 +162,8 @@ func GetNodesFromRoles(ctx context.Context, tx *sql.Tx, roles []string) ([]Node,
 
 	queryParts := strings.SplitN(stmt, "ORDER BY", 2)
 
-	args := make([]any, 0)
-
-	if len(roles) > 0 {
-		queryParts[0] += " WHERE"
-		for i, role := range roles {
-			if i > 0 {
-				queryParts[0] += " AND"
-			}
-			queryParts[0] += " instr(nodes.role, ?) > 0"
-			args = append(args, role)
-		}
+	if len(conditions) > 0 {
+		queryParts[0] += " WHERE " + strings.Join(conditions, " AND ") + " "
 	}
 
 	stmt = strings.Join(queryParts, " ORDER BY")
@@ -80,5 +174,48 @@ func GetNodesFromRoles(ctx context.Context, tx *sql.Tx, roles []string) ([]Node,
 	}
 
 	return nodes, nil
+}
+
+var nodeDeleteAll = cluster.RegisterStmt(`
+DELETE FROM nodes
+`)
+
+// DeleteAllNodes deletes all the nodes and their roles, and returns the number of nodes deleted.
+func DeleteAllNodes(ctx context.Context, tx *sql.Tx) (int64, error) {
+	err := deleteAllNodeRoles(ctx, tx)
+	if err != nil {
+		return -1, err
+	}
+
+	stmt, err := cluster.Stmt(tx, nodeDeleteAll)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get \"nodeDeleteAll\" prepared statement: %w", err)
+	}
+
+	result, err := stmt.Exec()
+	if err != nil {
+		return -1, fmt.Errorf("Delete \"nodes\": %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// clusterMemberNames selects the names of the cluster members the nodes may be recorded by.
+const clusterMemberNames = `
+SELECT name FROM internal_cluster_members ORDER BY name
+`
+
+// GetClusterMemberNames returns the names of the cluster members.
+func GetClusterMemberNames(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	names, err := query.SelectStrings(ctx, tx, clusterMemberNames)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"internal_cluster_members\" table: %w", err)
+	}
 
+	return names, nil
 }
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/canonical/microcluster/cluster"
+)
+
+// The node_roles table holds a row for each role of each node, kept in line with
+// the JSON list in nodes.role by the WithRoles functions below, so that the nodes
+// are filtered by their exact roles. They are used in place of the generated
+// CreateNode, UpdateNode and DeleteNode.
+
+var nodeRoleDeleteByNodeID = cluster.RegisterStmt(`
+DELETE FROM node_roles WHERE node_id = ?
+`)
+
+var nodeRoleDeleteByNodeName = cluster.RegisterStmt(`
+DELETE FROM node_roles WHERE node_id = (SELECT nodes.id FROM nodes WHERE nodes.name = ?)
+`)
+
+var nodeRoleDeleteAll = cluster.RegisterStmt(`
+DELETE FROM node_roles
+`)
+
+var nodeRoleCreate = cluster.RegisterStmt(`
+INSERT OR IGNORE INTO node_roles (node_id, role) VALUES (?, ?)
+`)
+
+// nodeRolesCondition is the WHERE condition matching the nodes holding a role.
+const nodeRolesCondition = "nodes.id IN (SELECT node_roles.node_id FROM node_roles WHERE node_roles.role = ?)"
+
+// CreateNodeWithRoles adds a new node to the database and records its roles.
+func CreateNodeWithRoles(ctx context.Context, tx *sql.Tx, object Node) (int64, error) {
+	id, err := CreateNode(ctx, tx, object)
+	if err != nil {
+		return -1, err
+	}
+
+	err = setNodeRoles(ctx, tx, id, object.Role)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// UpdateNodeWithRoles updates the node matching the given name and records its roles.
+func UpdateNodeWithRoles(ctx context.Context, tx *sql.Tx, name string, object Node) error {
+	err := UpdateNode(ctx, tx, name, object)
+	if err != nil {
+		return err
+	}
+
+	id, err := GetNodeID(ctx, tx, object.Name)
+	if err != nil {
+		return err
+	}
+
+	return setNodeRoles(ctx, tx, id, object.Role)
+}
+
+// DeleteNodeWithRoles deletes the node matching the given name along with its roles.
+func DeleteNodeWithRoles(ctx context.Context, tx *sql.Tx, name string) error {
+	err := deleteNodeRoles(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+
+	return DeleteNode(ctx, tx, name)
+}
+
+// setNodeRoles replaces the roles of the node with the given id by the roles of the JSON list.
+func setNodeRoles(_ context.Context, tx *sql.Tx, nodeID int64, role string) error {
+	roles, err := parseNodeRoles(role)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := cluster.Stmt(tx, nodeRoleDeleteByNodeID)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"nodeRoleDeleteByNodeID\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec(nodeID)
+	if err != nil {
+		return fmt.Errorf("Delete \"node_roles\": %w", err)
+	}
+
+	stmt, err = cluster.Stmt(tx, nodeRoleCreate)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"nodeRoleCreate\" prepared statement: %w", err)
+	}
+
+	for _, role := range roles {
+		_, err = stmt.Exec(nodeID, role)
+		if err != nil {
+			return fmt.Errorf("Failed to create \"node_roles\" entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteNodeRoles deletes the roles of the node with the given name.
+func deleteNodeRoles(_ context.Context, tx *sql.Tx, name string) error {
+	stmt, err := cluster.Stmt(tx, nodeRoleDeleteByNodeName)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"nodeRoleDeleteByNodeName\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec(name)
+	if err != nil {
+		return fmt.Errorf("Delete \"node_roles\": %w", err)
+	}
+
+	return nil
+}
+
+// deleteAllNodeRoles deletes the roles of all the nodes.
+func deleteAllNodeRoles(_ context.Context, tx *sql.Tx) error {
+	stmt, err := cluster.Stmt(tx, nodeRoleDeleteAll)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"nodeRoleDeleteAll\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		return fmt.Errorf("Delete \"node_roles\": %w", err)
+	}
+
+	return nil
+}
+
+// parseNodeRoles returns the roles of the JSON list stored in nodes.role, none if it is empty.
+func parseNodeRoles(role string) ([]string, error) {
+	if role == "" {
+		return nil, nil
+	}
+
+	var roles []string
+	err := json.Unmarshal([]byte(role), &roles)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse node roles %q: %w", role, err)
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+)
+
+func TestParseNodeRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		roles   []string
+		wantErr bool
+	}{
+		{name: "empty", role: "", roles: nil},
+		{name: "empty list", role: "[]", roles: []string{}},
+		{name: "single", role: `["control"]`, roles: []string{"control"}},
+		{name: "several", role: `["control","compute"]`, roles: []string{"control", "compute"}},
+		{name: "not a list", role: "control", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, err := parseNodeRoles(tt.role)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNodeRoles(%q) error = %v, wantErr %v", tt.role, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(roles, tt.roles) {
+				t.Errorf("parseNodeRoles(%q) = %v, expected %v", tt.role, roles, tt.roles)
+			}
+		})
+	}
+}
+
+// nodeNamesWithRoles returns the names of the nodes holding all the given roles.
+func nodeNamesWithRoles(t *testing.T, db *sql.DB, roles ...string) []string {
+	t.Helper()
+
+	names := []string{}
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		nodes, err := GetNodesFromRoles(ctx, tx, roles)
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to get nodes with roles %v: %v", roles, err)
+	}
+
+	return names
+}
+
+func TestNodeWithRoles(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := CreateNodeWithRoles(ctx, tx, Node{Member: "member1", Name: "node1", Role: `["control","compute"]`, MachineID: -1, CreatedBy: "test"})
+		if err != nil {
+			return err
+		}
+
+		_, err = CreateNodeWithRoles(ctx, tx, Node{Member: "member1", Name: "node2", Role: `["compute"]`, MachineID: -1, CreatedBy: "test"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to create nodes: %v", err)
+	}
+
+	tests := []struct {
+		roles []string
+		names []string
+	}{
+		{roles: []string{"compute"}, names: []string{"node1", "node2"}},
+		{roles: []string{"control"}, names: []string{"node1"}},
+		{roles: []string{"control", "compute"}, names: []string{"node1"}},
+		{roles: []string{"storage"}, names: []string{}},
+	}
+
+	for _, tt := range tests {
+		names := nodeNamesWithRoles(t, db, tt.roles...)
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("Nodes with roles %v are %v, expected %v", tt.roles, names, tt.names)
+		}
+	}
+
+	err = dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		node, err := GetNode(ctx, tx, "node1")
+		if err != nil {
+			return err
+		}
+
+		if node.UpdatedAt == "" {
+			t.Errorf("Created node has no update time")
+		}
+
+		node.Role = `["storage"]`
+		node.CreatedBy = "other"
+		err = UpdateNodeWithRoles(ctx, tx, "node1", *node)
+		if err != nil {
+			return err
+		}
+
+		node, err = GetNode(ctx, tx, "node1")
+		if err != nil {
+			return err
+		}
+
+		if node.CreatedBy != "test" {
+			t.Errorf("Update changed the creator of the node to %q", node.CreatedBy)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update node: %v", err)
+	}
+
+	if names := nodeNamesWithRoles(t, db, "control"); len(names) != 0 {
+		t.Errorf("Nodes with the removed role are %v, expected none", names)
+	}
+
+	if names := nodeNamesWithRoles(t, db, "storage"); !reflect.DeepEqual(names, []string{"node1"}) {
+		t.Errorf("Nodes with the added role are %v, expected [node1]", names)
+	}
+
+	err = dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		err := DeleteNodeWithRoles(ctx, tx, "node1")
+		if err != nil {
+			return err
+		}
+
+		var count int
+		err = tx.QueryRowContext(ctx, "SELECT count(*) FROM node_roles").Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count != 1 {
+			t.Errorf("%d node roles are left, expected the one of node2", count)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to delete node: %v", err)
+	}
+}
@@ -4,7 +4,12 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
 
+	"github.com/canonical/lxd/lxd/db/query"
 	"github.com/canonical/lxd/lxd/db/schema"
 )
 
@@ -16,6 +21,96 @@ var SchemaExtensions = []schema.Update{
 	JujuUserSchemaUpdate,
 	ManifestsSchemaUpdate,
 	AddSystemIDToNodes,
+	AddTypeToConfig,
+	AddMetadataToNodes,
+	AddCordonedToNodes,
+	AddLastManifestIDToNodes,
+	AddUpdatedAtToConfig,
+	AddCreatedBy,
+	AddApplyTimesToManifest,
+	AddStatusToManifest,
+	ConfigHistorySchemaUpdate,
+	AddDataHashToManifest,
+	AddUpdatedAtToNodes,
+	AddCapacityToNodes,
+	NodeRolesSchemaUpdate,
+	TerraformStateAccessSchemaUpdate,
+	AddDataMemberToManifest,
+}
+
+// schemaExtensionsVersion selects the highest applied version of the schema extensions.
+// Microcluster records internal updates with type 0 and extensions with type 1 in the schemas table.
+const schemaExtensionsVersion = `
+SELECT COALESCE(MAX(version), 0) FROM schemas WHERE type = 1
+`
+
+// GetSchemaVersion returns the version of the schema extensions applied to the database.
+func GetSchemaVersion(ctx context.Context, tx *sql.Tx) (int, error) {
+	versions, err := query.SelectIntegers(ctx, tx, schemaExtensionsVersion)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to fetch from \"schemas\" table: %w", err)
+	}
+
+	if len(versions) != 1 {
+		return -1, fmt.Errorf("Unexpected number of schema versions: %d", len(versions))
+	}
+
+	return versions[0], nil
+}
+
+// SchemaTables maps the tables created by the SchemaExtensions to the columns they
+// hold once all the extensions are applied. It must be kept in line with them.
+var SchemaTables = map[string][]string{
+	"nodes":          {"id", "member_id", "name", "role", "machine_id", "system_id", "metadata", "cordoned", "last_manifest_id", "created_by", "updated_at", "cpus", "memory", "disk"},
+	"config":         {"id", "key", "value", "type", "updated_at", "created_by"},
+	"jujuuser":       {"id", "username", "token", "created_by"},
+	"manifest":       {"id", "manifest_id", "applied_date", "data", "created_by", "started_at", "finished_at", "status", "data_hash", "data_member"},
+	"config_history": {"id", "key", "version", "value", "type", "recorded_at"},
+	"node_roles":     {"id", "node_id", "role"},
+	"tfstate_access": {"id", "plan", "operation", "source", "identity", "accessed_at"},
+}
+
+// tableColumns selects the names of the columns of a table.
+const tableColumns = `
+SELECT name FROM pragma_table_info(?) ORDER BY cid
+`
+
+// GetTableColumns returns the names of the columns of the table, in their order in
+// the table. A table that does not exist has no columns.
+func GetTableColumns(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	columns, err := query.SelectStrings(ctx, tx, tableColumns, table)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch the columns of %q table: %w", table, err)
+	}
+
+	return columns, nil
+}
+
+// CountTableRows returns the number of rows of one of the SchemaTables.
+func CountTableRows(ctx context.Context, tx *sql.Tx, table string) (int64, error) {
+	_, ok := SchemaTables[table]
+	if !ok {
+		return -1, fmt.Errorf("Unknown table %q", table)
+	}
+
+	var count int64
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %q", table)).Scan(&count)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to count the rows of %q table: %w", table, err)
+	}
+
+	return count, nil
+}
+
+// SchemaExtensionNames returns the names of the SchemaExtensions in the order they are applied.
+func SchemaExtensionNames() []string {
+	names := make([]string, len(SchemaExtensions))
+	for i, update := range SchemaExtensions {
+		name := runtime.FuncForPC(reflect.ValueOf(update).Pointer()).Name()
+		names[i] = name[strings.LastIndex(name, ".")+1:]
+	}
+
+	return names
 }
 
 // NodesSchemaUpdate is schema for table nodes
@@ -96,3 +191,262 @@ ALTER TABLE nodes ADD COLUMN system_id TEXT default '';
 
 	return err
 }
+
+// AddTypeToConfig is schema update for table config
+func AddTypeToConfig(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE config ADD COLUMN type TEXT default '';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddMetadataToNodes is schema update for table nodes
+func AddMetadataToNodes(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE nodes ADD COLUMN metadata TEXT default '{}';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddCordonedToNodes is schema update for table nodes
+func AddCordonedToNodes(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE nodes ADD COLUMN cordoned BOOLEAN default 0;
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddLastManifestIDToNodes is schema update for table nodes
+func AddLastManifestIDToNodes(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE nodes ADD COLUMN last_manifest_id TEXT default '';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddUpdatedAtToConfig is schema update for table config, setting updated_at each time a key is written
+func AddUpdatedAtToConfig(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE config ADD COLUMN updated_at TEXT NOT NULL default '';
+UPDATE config SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now');
+CREATE TRIGGER config_updated_at_insert AFTER INSERT ON config
+BEGIN
+  UPDATE config SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.id;
+END;
+CREATE TRIGGER config_updated_at_update AFTER UPDATE ON config
+BEGIN
+  UPDATE config SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.id;
+END;
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddCreatedBy is schema update for tables nodes, config, jujuuser and manifest
+func AddCreatedBy(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE nodes ADD COLUMN created_by TEXT NOT NULL default 'unknown';
+ALTER TABLE config ADD COLUMN created_by TEXT NOT NULL default 'unknown';
+ALTER TABLE jujuuser ADD COLUMN created_by TEXT NOT NULL default 'unknown';
+ALTER TABLE manifest ADD COLUMN created_by TEXT NOT NULL default 'unknown';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddApplyTimesToManifest is schema update for table manifest
+func AddApplyTimesToManifest(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE manifest ADD COLUMN started_at TEXT NOT NULL default '';
+ALTER TABLE manifest ADD COLUMN finished_at TEXT NOT NULL default '';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddStatusToManifest is schema update for table manifest
+func AddStatusToManifest(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE manifest ADD COLUMN status TEXT NOT NULL default '';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// ConfigHistorySchemaUpdate is schema for table config_history
+func ConfigHistorySchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE config_history (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  key                           TEXT     NOT  NULL,
+  version                       INTEGER  NOT  NULL,
+  value                         TEXT     NOT  NULL,
+  type                          TEXT     NOT  NULL default '',
+  recorded_at                   TEXT     NOT  NULL default '',
+  UNIQUE(key, version)
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddDataHashToManifest is schema update for table manifest
+func AddDataHashToManifest(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE manifest ADD COLUMN data_hash TEXT NOT NULL default '';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddUpdatedAtToNodes is schema update for table nodes, setting updated_at each time a node is written
+func AddUpdatedAtToNodes(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE nodes ADD COLUMN updated_at TEXT NOT NULL default '';
+UPDATE nodes SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now');
+CREATE TRIGGER nodes_updated_at_insert AFTER INSERT ON nodes
+BEGIN
+  UPDATE nodes SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.id;
+END;
+CREATE TRIGGER nodes_updated_at_update AFTER UPDATE ON nodes
+BEGIN
+  UPDATE nodes SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.id;
+END;
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddCapacityToNodes is schema update for table nodes
+func AddCapacityToNodes(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE nodes ADD COLUMN cpus INTEGER NOT NULL default 0;
+ALTER TABLE nodes ADD COLUMN memory INTEGER NOT NULL default 0;
+ALTER TABLE nodes ADD COLUMN disk INTEGER NOT NULL default 0;
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// NodeRolesSchemaUpdate is schema for table node_roles, populated from the roles of the existing nodes
+func NodeRolesSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE node_roles (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  node_id                       INTEGER  NOT  NULL,
+  role                          TEXT     NOT  NULL,
+  FOREIGN KEY (node_id) REFERENCES "nodes" (id) ON DELETE CASCADE
+  UNIQUE(node_id, role)
+);
+CREATE INDEX node_roles_role_idx ON node_roles (role);
+  `
+
+	_, err := tx.Exec(stmt)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, COALESCE(role, '') FROM nodes")
+	if err != nil {
+		return err
+	}
+
+	roles := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var role string
+		err = rows.Scan(&id, &role)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		roles[id] = role
+	}
+
+	err = rows.Err()
+	if err != nil {
+		_ = rows.Close()
+		return err
+	}
+
+	err = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	// The statements are prepared once the schema is updated, so they are not used here.
+	for id, role := range roles {
+		nodeRoles, err := parseNodeRoles(role)
+		if err != nil {
+			return fmt.Errorf("Failed to migrate the roles of node %d: %w", id, err)
+		}
+
+		for _, nodeRole := range nodeRoles {
+			_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO node_roles (node_id, role) VALUES (?, ?)", id, nodeRole)
+			if err != nil {
+				return fmt.Errorf("Failed to migrate the roles of node %d: %w", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TerraformStateAccessSchemaUpdate is schema for table tfstate_access
+func TerraformStateAccessSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE tfstate_access (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  plan                          TEXT     NOT  NULL,
+  operation                     TEXT     NOT  NULL,
+  source                        TEXT     NOT  NULL default '',
+  identity                      TEXT     NOT  NULL default '',
+  accessed_at                   TEXT     NOT  NULL default ''
+);
+CREATE INDEX tfstate_access_plan_idx ON tfstate_access (plan);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// AddDataMemberToManifest is schema update for table manifest
+func AddDataMemberToManifest(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+ALTER TABLE manifest ADD COLUMN data_member TEXT NOT NULL default '';
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
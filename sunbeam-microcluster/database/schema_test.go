@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+)
+
+func TestSchemaTables(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	for table, expected := range SchemaTables {
+		err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+			columns, err := GetTableColumns(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+
+			if !reflect.DeepEqual(columns, expected) {
+				t.Errorf("Table %q has columns %v, expected %v", table, columns, expected)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to get the columns of %q: %v", table, err)
+		}
+	}
+}
+
+func TestCountTableRowsUnknownTable(t *testing.T) {
+	db := dbtest.NewDB(t, SchemaExtensions)
+
+	// Only the SchemaTables are counted, so the name is never interpolated for other tables.
+	err := dbtest.Transaction(db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := CountTableRows(ctx, tx, "internal_token_records")
+		return err
+	})
+	if err == nil {
+		t.Errorf("Counting the rows of a table outside SchemaTables succeeded")
+	}
+}
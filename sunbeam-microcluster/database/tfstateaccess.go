@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/microcluster/cluster"
+)
+
+// TerraformStateAccess is an access to the terraform state of a plan.
+// Accesses are only appended, the oldest ones being trimmed.
+type TerraformStateAccess struct {
+	ID         int
+	Plan       string
+	Operation  string
+	Source     string
+	Identity   string
+	AccessedAt string
+}
+
+var terraformStateAccessCreate = cluster.RegisterStmt(`
+INSERT INTO tfstate_access (plan, operation, source, identity, accessed_at)
+  VALUES (?, ?, ?, ?, strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+`)
+
+var terraformStateAccessesByPlan = cluster.RegisterStmt(`
+SELECT tfstate_access.id, tfstate_access.plan, tfstate_access.operation, tfstate_access.source, tfstate_access.identity, tfstate_access.accessed_at
+  FROM tfstate_access
+  WHERE tfstate_access.plan = ?
+  ORDER BY tfstate_access.id DESC
+`)
+
+var terraformStateAccessTrim = cluster.RegisterStmt(`
+DELETE FROM tfstate_access
+  WHERE plan = ? AND id NOT IN (SELECT id FROM tfstate_access WHERE plan = ? ORDER BY id DESC LIMIT ?)
+`)
+
+// CreateTerraformStateAccess records an access to the terraform state of the plan.
+func CreateTerraformStateAccess(_ context.Context, tx *sql.Tx, plan string, operation string, source string, identity string) error {
+	stmt, err := cluster.Stmt(tx, terraformStateAccessCreate)
+	if err != nil {
+		return fmt.Errorf("Failed to get \"terraformStateAccessCreate\" prepared statement: %w", err)
+	}
+
+	_, err = stmt.Exec(plan, operation, source, identity)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"tfstate_access\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetTerraformStateAccesses returns the recorded accesses to the terraform state of the plan, most recent first.
+func GetTerraformStateAccesses(ctx context.Context, tx *sql.Tx, plan string) ([]TerraformStateAccess, error) {
+	stmt, err := cluster.Stmt(tx, terraformStateAccessesByPlan)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get \"terraformStateAccessesByPlan\" prepared statement: %w", err)
+	}
+
+	objects := make([]TerraformStateAccess, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		a := TerraformStateAccess{}
+		err := scan(&a.ID, &a.Plan, &a.Operation, &a.Source, &a.Identity, &a.AccessedAt)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, a)
+
+		return nil
+	}
+
+	err = query.SelectObjects(ctx, stmt, dest, plan)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"tfstate_access\" table: %w", err)
+	}
+
+	return objects, nil
+}
+
+// TrimTerraformStateAccesses deletes all but the keep most recent accesses to the terraform state
+// of the plan and returns the number of accesses deleted.
+func TrimTerraformStateAccesses(_ context.Context, tx *sql.Tx, plan string, keep int) (int64, error) {
+	stmt, err := cluster.Stmt(tx, terraformStateAccessTrim)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get \"terraformStateAccessTrim\" prepared statement: %w", err)
+	}
+
+	result, err := stmt.Exec(plan, plan, keep)
+	if err != nil {
+		return -1, fmt.Errorf("Delete \"tfstate_access\": %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,128 @@
+package sunbeam
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// GetCapabilities returns the optional features of the daemon along with whether
+// the current settings enable them, so that clients can adapt to the daemon.
+func GetCapabilities(ctx context.Context, s *state.State) (types.Capabilities, error) {
+	tflockTTL, err := getDurationSetting(ctx, s, tflockTTLSetting, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reapInterval, err := getDurationSetting(ctx, s, tflockReapIntervalSetting, defaultTflockReapInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	tflockIdentity, err := getBoolSetting(ctx, s, tflockIdentitySetting, false)
+	if err != nil {
+		return nil, err
+	}
+
+	historyLength, err := getIntSetting(ctx, s, configHistorySetting, defaultConfigHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	accessLogLength, err := getIntSetting(ctx, s, tfstateAccessLogSetting, defaultTfstateAccessLog)
+	if err != nil {
+		return nil, err
+	}
+
+	compactInterval, err := getDurationSetting(ctx, s, compactIntervalSetting, defaultCompactInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, burst, err := GetRateLimit(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	criticalRoles, err := getListSetting(ctx, s, criticalRolesSetting, defaultCriticalRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	systemIDUniqueness, err := GetSystemIDUniqueness(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	stateStore, err := getSetting(ctx, s, tfstateStoreSetting, "")
+	if err != nil {
+		return nil, err
+	}
+
+	stateStoreKind := "database"
+	if stateStore != "" {
+		stateStoreKind = "object"
+	}
+
+	blobThreshold, err := getIntSetting(ctx, s, manifestBlobThresholdSetting, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	tflockStrictPath, err := getBoolSetting(ctx, s, tflockStrictPathSetting, false)
+	if err != nil {
+		return nil, err
+	}
+
+	canonicalManifests, err := getBoolSetting(ctx, s, manifestCanonicalizeSetting, false)
+	if err != nil {
+		return nil, err
+	}
+
+	leaderWrites, err := GetLeaderWrites(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	leaderWritePaths := []string{}
+	for path, mode := range leaderWrites {
+		leaderWritePaths = append(leaderWritePaths, path+"="+mode)
+	}
+	sort.Strings(leaderWritePaths)
+
+	return types.Capabilities{
+		// Features always available in this build.
+		"pagination":      {Enabled: true, Parameters: map[string]string{"modes": "offset,cursor"}},
+		"config-etag":     {Enabled: true},
+		"config-types":    {Enabled: true, Parameters: map[string]string{"types": strings.Join([]string{ConfigTypeString, ConfigTypeInt, ConfigTypeBool, ConfigTypeJSON}, ",")}},
+		"dry-run-delete":  {Enabled: true},
+		"manifest-export": {Enabled: true, Parameters: map[string]string{"format": "tar"}},
+		"encryption":      {Enabled: false},
+		"tfstate-diff":    {Enabled: true, Parameters: map[string]string{"modes": "plan"}},
+		"tfstate-unlock":  {Enabled: true},
+		"tfstate-import":  {Enabled: true},
+		"tflock-steal":    {Enabled: true, Parameters: map[string]string{"maxgrace": MaxTerraformStealGrace.String()}},
+		"tf-workspaces":   {Enabled: true, Parameters: map[string]string{"default": DefaultTerraformWorkspace}},
+
+		// Features depending on the daemon settings.
+		"tflock-ttl":      {Enabled: tflockTTL > 0, Parameters: map[string]string{"ttl": tflockTTL.String()}},
+		"tflock-reaper":   {Enabled: tflockTTL > 0 && reapInterval > 0, Parameters: map[string]string{"interval": reapInterval.String()}},
+		"tflock-identity": {Enabled: tflockIdentity},
+		"tflock-strict":   {Enabled: tflockStrictPath},
+		"tfstate-store":   {Enabled: stateStore != "", Parameters: map[string]string{"store": stateStoreKind}},
+		"tfstate-access":  {Enabled: accessLogLength > 0, Parameters: map[string]string{"length": strconv.Itoa(max(accessLogLength, 0))}},
+		"config-history":  {Enabled: historyLength > 0, Parameters: map[string]string{"length": strconv.Itoa(max(historyLength, 0))}},
+		"compaction":      {Enabled: compactInterval > 0, Parameters: map[string]string{"interval": compactInterval.String()}},
+		"ratelimit":       {Enabled: rate > 0, Parameters: map[string]string{"rate": strconv.FormatFloat(rate, 'f', -1, 64), "burst": strconv.Itoa(burst)}},
+		"critical-roles":  {Enabled: len(criticalRoles) > 0, Parameters: map[string]string{"roles": strings.Join(criticalRoles, ",")}},
+		"manifest-blobs":  {Enabled: blobThreshold > 0, Parameters: map[string]string{"threshold": strconv.Itoa(max(blobThreshold, 0))}},
+		"manifest-canon":  {Enabled: canonicalManifests},
+		"leader-writes":   {Enabled: len(leaderWrites) > 0, Parameters: map[string]string{"endpoints": strings.Join(leaderWritePaths, ",")}},
+		"systemid-unique": {Enabled: systemIDUniqueness == SystemIDUniquenessEnforce, Parameters: map[string]string{"mode": systemIDUniqueness}},
+	}, nil
+}
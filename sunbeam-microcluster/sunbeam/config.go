@@ -3,20 +3,62 @@ package sunbeam
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/state"
 
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
+// Types that can be declared for a ConfigItem value.
+// ConfigItems without a declared type are treated as plain strings.
+const (
+	ConfigTypeString = "string"
+	ConfigTypeInt    = "int"
+	ConfigTypeBool   = "bool"
+	ConfigTypeJSON   = "json"
+)
+
+// secretConfigKeys are the keys holding secrets. The config endpoints return
+// RedactedConfigValue in place of their values, and their prior values are not kept.
+var secretConfigKeys = []string{tfstateStoreTokenSetting}
+
+// RedactedConfigValue is returned in place of the values of the secretConfigKeys
+const RedactedConfigValue = "<redacted>"
+
+// configHistoryExcludedPrefixes are the prefixes of the keys whose prior values
+// are not kept, terraform states being too large to keep several copies of.
+var configHistoryExcludedPrefixes = append(append([]string{}, tfPrefixes...), secretConfigKeys...)
+
+// isSecretConfigKey returns whether the key holds a secret
+func isSecretConfigKey(key string) bool {
+	for _, secret := range secretConfigKeys {
+		if key == secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnknownCreator is recorded as the creator of the resources whose client has no identity
+const UnknownCreator = "unknown"
+
 // GetConfig returns the ConfigItem based on key from the database
-func GetConfig(s *state.State, key string) (string, error) {
+func GetConfig(ctx context.Context, s *state.State, key string) (string, error) {
 	var value string
 
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
 		record, err := database.GetConfigItem(ctx, tx, key)
 		if err != nil {
 			return err
@@ -32,11 +74,37 @@ func GetConfig(s *state.State, key string) (string, error) {
 	return value, nil
 }
 
+// GetConfigEntry returns the ConfigItem based on key along with its declared type and update time
+func GetConfigEntry(ctx context.Context, s *state.State, key string) (types.ConfigEntry, error) {
+	var record *database.ConfigItem
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		record, err = database.GetConfigItem(ctx, tx, key)
+		return err
+	})
+	if err != nil {
+		return types.ConfigEntry{}, err
+	}
+
+	err = validateConfigValue(record.Type, record.Value)
+	if err != nil {
+		return types.ConfigEntry{}, fmt.Errorf("Stored value of %q does not match its type: %w", key, err)
+	}
+
+	entry := types.ConfigEntry{Key: record.Key, Value: record.Value, Type: record.Type, UpdatedAt: record.UpdatedAt, CreatedBy: record.CreatedBy}
+	if isSecretConfigKey(entry.Key) {
+		entry.Value = RedactedConfigValue
+	}
+
+	return entry, nil
+}
+
 // GetConfigItemKeys returns the list of ConfigItem keys from the database
-func GetConfigItemKeys(s *state.State, prefix *string) ([]string, error) {
+func GetConfigItemKeys(ctx context.Context, s *state.State, prefix *string) ([]string, error) {
 	var keys []string
 
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
 		var err error
 		keys, err = database.GetConfigItemKeys(ctx, tx, prefix)
 		if err != nil {
@@ -52,11 +120,144 @@ func GetConfigItemKeys(s *state.State, prefix *string) ([]string, error) {
 	return keys, nil
 }
 
-// CreateConfig adds a new ConfigItem to the database
-func CreateConfig(s *state.State, key string, value string) error {
+// GetConfigKeysPage returns at most limit ConfigItem keys ordered by key, starting after
+// the cursor and filtered by key prefix if provided. The cursor of the next page is
+// returned along with the keys, it is empty on the last page.
+func GetConfigKeysPage(ctx context.Context, s *state.State, prefix *string, cursor string, limit int) ([]string, string, error) {
+	var keys []string
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		// Fetch one more key to find out whether there is a next page.
+		keys, err = database.GetConfigItemKeysRange(ctx, tx, prefix, cursor, limit+1)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(keys) <= limit {
+		return keys, "", nil
+	}
+
+	keys = keys[:limit]
+
+	return keys, keys[limit-1], nil
+}
+
+// GetConfigEntries returns the ConfigItems along with their values, filtered by key prefix if provided.
+// Values longer than maxValueBytes are truncated, unless maxValueBytes is zero.
+func GetConfigEntries(ctx context.Context, s *state.State, prefix *string, maxValueBytes int) ([]types.ConfigEntry, error) {
+	entries, _, err := getConfigEntriesRange(ctx, s, prefix, "", -1, maxValueBytes)
+	return entries, err
+}
+
+// GetConfigEntriesPage returns at most limit ConfigItems along with their values like
+// GetConfigEntries, starting after the cursor. The cursor of the next page is returned
+// along with the entries, it is empty on the last page.
+func GetConfigEntriesPage(ctx context.Context, s *state.State, prefix *string, cursor string, limit int, maxValueBytes int) ([]types.ConfigEntry, string, error) {
+	return getConfigEntriesRange(ctx, s, prefix, cursor, limit, maxValueBytes)
+}
+
+// getConfigEntriesRange returns at most limit ConfigItems starting after the cursor,
+// along with the cursor of the next page. A negative limit returns all the ConfigItems.
+func getConfigEntriesRange(ctx context.Context, s *state.State, prefix *string, cursor string, limit int, maxValueBytes int) ([]types.ConfigEntry, string, error) {
+	var entries []types.ConfigEntry
+	var next string
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		// The transaction may be retried, start from an empty result.
+		entries = []types.ConfigEntry{}
+		next = ""
+
+		fetch := limit
+		if limit >= 0 {
+			// Fetch one more item to find out whether there is a next page.
+			fetch = limit + 1
+		}
+
+		records, err := database.GetConfigItemsRange(ctx, tx, prefix, cursor, fetch)
+		if err != nil {
+			return err
+		}
+
+		if limit >= 0 && len(records) > limit {
+			records = records[:limit]
+			next = records[limit-1].Key
+		}
+
+		for _, record := range records {
+			entry := types.ConfigEntry{Key: record.Key, Value: record.Value, Type: record.Type, UpdatedAt: record.UpdatedAt, CreatedBy: record.CreatedBy}
+			if isSecretConfigKey(entry.Key) {
+				entry.Value = RedactedConfigValue
+			}
+
+			if maxValueBytes > 0 && len(entry.Value) > maxValueBytes {
+				// Cut on a rune boundary to keep the value valid UTF-8.
+				cut := maxValueBytes
+				for cut > 0 && !utf8.RuneStart(entry.Value[cut]) {
+					cut--
+				}
+				entry.Value = entry.Value[:cut]
+				entry.Truncated = true
+			}
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return entries, next, nil
+}
+
+// GetConfigUsage returns the storage used by each config namespace and by the manifests
+func GetConfigUsage(ctx context.Context, s *state.State) (types.ConfigUsage, error) {
+	usage := types.ConfigUsage{Namespaces: map[string]int64{}}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		sizes, err := database.GetConfigItemSizes(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for prefix, size := range sizes {
+			if prefix == "" {
+				prefix = "other"
+			}
+			usage.Namespaces[prefix] = size
+		}
+
+		manifestsSize, err := database.GetManifestItemsSize(ctx, tx)
+		if err != nil {
+			return err
+		}
+		usage.Namespaces["manifests"] = manifestsSize
+
+		return nil
+	})
+	if err != nil {
+		return types.ConfigUsage{}, err
+	}
+
+	for _, size := range usage.Namespaces {
+		usage.Total += size
+	}
+
+	return usage, nil
+}
+
+// CreateConfig adds a new ConfigItem to the database, failing if the key already exists
+func CreateConfig(ctx context.Context, s *state.State, key string, value string, valueType string, createdBy string) error {
+	err := validateConfigValue(valueType, value)
+	if err != nil {
+		return err
+	}
 
-	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: key, Value: value})
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: key, Value: value, Type: valueType, CreatedBy: createdBy})
 		if err != nil {
 			return fmt.Errorf("Failed to record config item: %w", err)
 		}
@@ -64,14 +265,72 @@ func CreateConfig(s *state.State, key string, value string) error {
 	})
 }
 
-// UpdateConfig updates a ConfigItem in the database
-func UpdateConfig(s *state.State, key string, value string) error {
-	configItem := database.ConfigItem{Key: key, Value: value}
+// UpdateConfig updates a ConfigItem in the database, keeping its declared type
+func UpdateConfig(ctx context.Context, s *state.State, key string, value string) error {
+	return UpdateConfigWithType(ctx, s, key, value, "")
+}
+
+// UpdateConfigWithType updates a ConfigItem in the database along with its declared type.
+// The type already declared for the ConfigItem is kept if valueType is empty.
+func UpdateConfigWithType(ctx context.Context, s *state.State, key string, value string, valueType string) error {
+	var declared *string
+	if valueType != "" {
+		declared = &valueType
+	}
 
-	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		err := database.UpdateConfigItem(ctx, tx, key, configItem)
-		if err != nil && strings.Contains(err.Error(), "ConfigItem not found") {
+	_, err := UpdateConfigIfMatch(ctx, s, key, value, declared, "", UnknownCreator)
+	return err
+}
+
+// UpdateConfigIfMatch updates a ConfigItem in the database only if the stored value
+// still matches one of the ETags given in ifMatch, as sent in an If-Match header.
+// An empty ifMatch skips the check, "*" only requires the ConfigItem to exist.
+// The type already declared for the ConfigItem is kept if valueType is nil, and
+// cleared if it is empty. createdBy is recorded if the ConfigItem does not exist
+// yet. Returns whether the ConfigItem was created rather than updated.
+func UpdateConfigIfMatch(ctx context.Context, s *state.State, key string, value string, valueType *string, ifMatch string, createdBy string) (bool, error) {
+	historyLength, err := configHistoryLength(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+
+	unlock := configKeyLock.lock(key)
+	defer unlock()
+
+	created := false
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigItem(ctx, tx, key)
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return fmt.Errorf("Failed to record config item: %w", err)
+		}
+
+		if ifMatch != "" && (record == nil || !etagMatches(ifMatch, ConfigETag(record.Value))) {
+			return api.StatusErrorf(http.StatusPreconditionFailed, "Config key %q has been modified", key)
+		}
+
+		declared := ""
+		if valueType != nil {
+			declared = *valueType
+		} else if record != nil {
+			declared = record.Type
+		}
+
+		err = validateConfigValue(declared, value)
+		if err != nil {
+			return err
+		}
+
+		configItem := database.ConfigItem{Key: key, Value: value, Type: declared, CreatedBy: createdBy}
+		created = record == nil
+		if created {
 			_, err = database.CreateConfigItem(ctx, tx, configItem)
+		} else {
+			err = recordConfigHistory(ctx, tx, *record, configItem, historyLength)
+			if err != nil {
+				return err
+			}
+
+			err = database.UpdateConfigItem(ctx, tx, key, configItem)
 		}
 		if err != nil {
 			return fmt.Errorf("Failed to record config item: %w", err)
@@ -79,11 +338,275 @@ func UpdateConfig(s *state.State, key string, value string) error {
 
 		return nil
 	})
+
+	return created, err
+}
+
+// RenameConfig renames a ConfigItem in the database, failing if the new key is already used
+// or is the current key.
+func RenameConfig(ctx context.Context, s *state.State, key string, newKey string) error {
+	if newKey == key {
+		return api.StatusErrorf(http.StatusBadRequest, "Config key %q cannot be renamed to itself", key)
+	}
+
+	unlock := configKeyLock.lock(key, newKey)
+	defer unlock()
+
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigItem(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		exists, err := database.ConfigItemExists(ctx, tx, newKey)
+		if err != nil {
+			return fmt.Errorf("Failed to check for duplicates: %w", err)
+		}
+		if exists {
+			return api.StatusErrorf(http.StatusConflict, "Config key %q already exists", newKey)
+		}
+
+		record.Key = newKey
+		err = database.UpdateConfigItem(ctx, tx, key, *record)
+		if err != nil {
+			return fmt.Errorf("Failed to rename config item: %w", err)
+		}
+
+		err = database.RenameConfigHistoryItems(ctx, tx, key, newKey)
+		if err != nil {
+			return fmt.Errorf("Failed to rename config item history: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// TouchConfig bumps the update time of a ConfigItem without changing its value
+func TouchConfig(ctx context.Context, s *state.State, key string) error {
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		return database.TouchConfigItem(ctx, tx, key)
+	})
 }
 
-// DeleteConfig deletes a ConfigItem from the database
-func DeleteConfig(s *state.State, key string) error {
-	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+// PlanDeleteConfig returns what deleting the ConfigItem would remove, without deleting it
+func PlanDeleteConfig(ctx context.Context, s *state.State, key string) (types.DeletePlan, error) {
+	err := transactionOrDryRun(ctx, s, true, func(ctx context.Context, tx *sql.Tx) error {
 		return database.DeleteConfigItem(ctx, tx, key)
 	})
+	if err != nil {
+		return types.DeletePlan{}, err
+	}
+
+	return types.DeletePlan{Deleted: []string{"config/" + key}, Affected: []string{}}, nil
+}
+
+// DeleteConfig deletes a ConfigItem from the database along with its history
+func DeleteConfig(ctx context.Context, s *state.State, key string) error {
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		err := database.DeleteConfigItem(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		return database.DeleteConfigHistoryItems(ctx, tx, key)
+	})
+}
+
+// GetConfigHistory returns the prior values of a ConfigItem, most recent first
+func GetConfigHistory(ctx context.Context, s *state.State, key string) ([]types.ConfigVersion, error) {
+	var versions []types.ConfigVersion
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		exists, err := database.ConfigItemExists(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return api.StatusErrorf(http.StatusNotFound, "ConfigItem not found")
+		}
+
+		records, err := database.GetConfigHistoryItems(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		versions = make([]types.ConfigVersion, 0, len(records))
+		for _, record := range records {
+			version := types.ConfigVersion{Version: record.Version, Value: record.Value, Type: record.Type, RecordedAt: record.RecordedAt}
+			if isSecretConfigKey(key) {
+				version.Value = RedactedConfigValue
+			}
+
+			versions = append(versions, version)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// RestoreConfig sets a ConfigItem back to one of its prior values.
+// The value being replaced is kept in the history like any other update.
+func RestoreConfig(ctx context.Context, s *state.State, key string, version int) error {
+	historyLength, err := configHistoryLength(ctx, s, key)
+	if err != nil {
+		return err
+	}
+
+	unlock := configKeyLock.lock(key)
+	defer unlock()
+
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigItem(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		prior, err := database.GetConfigHistoryItem(ctx, tx, key, version)
+		if err != nil {
+			return err
+		}
+
+		configItem := database.ConfigItem{Key: key, Value: prior.Value, Type: prior.Type}
+		err = recordConfigHistory(ctx, tx, *record, configItem, historyLength)
+		if err != nil {
+			return err
+		}
+
+		err = database.UpdateConfigItem(ctx, tx, key, configItem)
+		if err != nil {
+			return fmt.Errorf("Failed to restore config item: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// configHistoryLength returns the number of prior values to keep for the key, zero if none
+func configHistoryLength(ctx context.Context, s *state.State, key string) (int, error) {
+	for _, prefix := range configHistoryExcludedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return 0, nil
+		}
+	}
+
+	length, err := getIntSetting(ctx, s, configHistorySetting, defaultConfigHistory)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(length, 0), nil
+}
+
+// recordConfigHistory keeps the current value of the ConfigItem in its history before it
+// is replaced by next, and drops the versions beyond the history length.
+// Nothing is recorded when the value and the type are unchanged.
+func recordConfigHistory(ctx context.Context, tx *sql.Tx, current database.ConfigItem, next database.ConfigItem, historyLength int) error {
+	if historyLength == 0 || (current.Value == next.Value && current.Type == next.Type) {
+		return nil
+	}
+
+	err := database.CreateConfigHistoryItem(ctx, tx, current.Key, current.Value, current.Type)
+	if err != nil {
+		return fmt.Errorf("Failed to record config item history: %w", err)
+	}
+
+	_, err = database.TrimConfigHistoryItems(ctx, tx, current.Key, historyLength)
+	if err != nil {
+		return fmt.Errorf("Failed to trim config item history: %w", err)
+	}
+
+	return nil
+}
+
+// ConfigValuePointer applies the RFC 6901 JSON pointer to the ConfigItem value
+// and returns the JSON encoded sub-value it refers to.
+func ConfigValuePointer(value string, pointer string) (string, error) {
+	var doc any
+	err := json.Unmarshal([]byte(value), &doc)
+	if err != nil {
+		return "", api.StatusErrorf(http.StatusUnprocessableEntity, "Config value is not JSON: %v", err)
+	}
+
+	if pointer != "" && !strings.HasPrefix(pointer, "/") {
+		return "", api.StatusErrorf(http.StatusBadRequest, "Invalid JSON pointer %q, expected a leading slash", pointer)
+	}
+
+	tokens := strings.Split(pointer, "/")[1:]
+	for _, token := range tokens {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		switch node := doc.(type) {
+		case map[string]any:
+			child, ok := node[token]
+			if !ok {
+				return "", database.WrapStatusError(api.StatusErrorf(http.StatusNotFound, "JSON pointer %q not found", pointer))
+			}
+			doc = child
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", database.WrapStatusError(api.StatusErrorf(http.StatusNotFound, "JSON pointer %q not found", pointer))
+			}
+			doc = node[index]
+		default:
+			return "", database.WrapStatusError(api.StatusErrorf(http.StatusNotFound, "JSON pointer %q not found", pointer))
+		}
+	}
+
+	subValue, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+
+	return string(subValue), nil
+}
+
+// ConfigETag returns the ETag identifying the given ConfigItem value
+func ConfigETag(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+}
+
+// etagMatches returns whether etag is one of the comma separated ETags in ifMatch.
+// If-Match uses the strong comparison of RFC 7232, so weak ETags never match.
+func etagMatches(ifMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateConfigValue checks the value can be parsed as the given type
+func validateConfigValue(valueType string, value string) error {
+	switch valueType {
+	case "", ConfigTypeString:
+		return nil
+	case ConfigTypeInt:
+		_, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return api.StatusErrorf(http.StatusBadRequest, "Value %q is not a valid %s", value, valueType)
+		}
+	case ConfigTypeBool:
+		_, err := strconv.ParseBool(value)
+		if err != nil {
+			return api.StatusErrorf(http.StatusBadRequest, "Value %q is not a valid %s", value, valueType)
+		}
+	case ConfigTypeJSON:
+		if !json.Valid([]byte(value)) {
+			return api.StatusErrorf(http.StatusBadRequest, "Value is not valid %s", valueType)
+		}
+	default:
+		return api.StatusErrorf(http.StatusBadRequest, "Unknown config type %q", valueType)
+	}
+
+	return nil
 }
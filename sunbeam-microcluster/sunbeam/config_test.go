@@ -0,0 +1,340 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+)
+
+func TestValidateConfigValue(t *testing.T) {
+	tests := []struct {
+		valueType string
+		value     string
+		wantErr   bool
+	}{
+		{valueType: "", value: "anything"},
+		{valueType: ConfigTypeString, value: ""},
+		{valueType: ConfigTypeInt, value: "-42"},
+		{valueType: ConfigTypeInt, value: "4.2", wantErr: true},
+		{valueType: ConfigTypeBool, value: "true"},
+		{valueType: ConfigTypeBool, value: "yes", wantErr: true},
+		{valueType: ConfigTypeJSON, value: `{"a": [1, 2]}`},
+		{valueType: ConfigTypeJSON, value: `{"a":`, wantErr: true},
+		{valueType: "float", value: "4.2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.valueType+"/"+tt.value, func(t *testing.T) {
+			err := validateConfigValue(tt.valueType, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfigValue(%q, %q) error = %v, wantErr %v", tt.valueType, tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenameConfig(t *testing.T) {
+	s := newTestState(t)
+	for key, value := range map[string]string{"key1": "value1", "key2": "value2"} {
+		err := CreateConfig(context.Background(), s, key, value, "", "test")
+		if err != nil {
+			t.Fatalf("Failed to create %q: %v", key, err)
+		}
+	}
+
+	// The steps run in order against the same keys.
+	steps := []struct {
+		name   string
+		key    string
+		newKey string
+		status int
+	}{
+		{name: "same key", key: "key1", newKey: "key1", status: http.StatusBadRequest},
+		{name: "renamed", key: "key1", newKey: "key3"},
+		{name: "new key in use", key: "key3", newKey: "key2", status: http.StatusConflict},
+		{name: "missing key", key: "key1", newKey: "key4", status: http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := RenameConfig(context.Background(), s, step.key, step.newKey)
+			if step.status != 0 {
+				if !api.StatusErrorCheck(err, step.status) {
+					t.Fatalf("RenameConfig(%q, %q) error = %v, expected status %d", step.key, step.newKey, err, step.status)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("RenameConfig(%q, %q) failed: %v", step.key, step.newKey, err)
+			}
+
+			value, err := GetConfig(context.Background(), s, step.newKey)
+			if err != nil || value != "value1" {
+				t.Errorf("Renamed key holds %q (err %v), expected %q", value, err, "value1")
+			}
+
+			_, err = GetConfig(context.Background(), s, step.key)
+			if !api.StatusErrorCheck(err, http.StatusNotFound) {
+				t.Errorf("Old key lookup error = %v, expected not found", err)
+			}
+		})
+	}
+
+	value, err := GetConfig(context.Background(), s, "key2")
+	if err != nil || value != "value2" {
+		t.Errorf("Conflicting key holds %q (err %v), expected %q", value, err, "value2")
+	}
+}
+
+// createTestConfig records the config items, keyed by config key.
+func createTestConfig(t *testing.T, s *state.State, items map[string]string) {
+	t.Helper()
+
+	for key, value := range items {
+		err := CreateConfig(context.Background(), s, key, value, "", "test")
+		if err != nil {
+			t.Fatalf("Failed to create %q: %v", key, err)
+		}
+	}
+}
+
+func TestCreateConfig(t *testing.T) {
+	s := newTestState(t)
+
+	// The steps run in order against the same key.
+	steps := []struct {
+		name      string
+		value     string
+		valueType string
+		status    int
+		stored    string
+	}{
+		{name: "invalid value", value: "four", valueType: ConfigTypeInt, status: http.StatusBadRequest},
+		{name: "created", value: "4", valueType: ConfigTypeInt, stored: "4"},
+		{name: "already exists", value: "5", valueType: ConfigTypeInt, status: http.StatusConflict, stored: "4"},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := CreateConfig(context.Background(), s, "key1", step.value, step.valueType, "test")
+			if step.status != 0 {
+				if !api.StatusErrorCheck(err, step.status) {
+					t.Errorf("CreateConfig error = %v, expected status %d", err, step.status)
+				}
+			} else if err != nil {
+				t.Errorf("CreateConfig failed: %v", err)
+			}
+
+			value, err := GetConfig(context.Background(), s, "key1")
+			if step.stored == "" {
+				if !api.StatusErrorCheck(err, http.StatusNotFound) {
+					t.Errorf("Key lookup error = %v, expected not found", err)
+				}
+			} else if err != nil || value != step.stored {
+				t.Errorf("Key holds %q (err %v), expected %q", value, err, step.stored)
+			}
+		})
+	}
+}
+
+func TestTouchConfig(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{"key1": "value1"})
+
+	created, err := GetConfigEntry(context.Background(), s, "key1")
+	if err != nil {
+		t.Fatalf("GetConfigEntry failed: %v", err)
+	}
+
+	if created.UpdatedAt == "" {
+		t.Fatalf("Created key has no update time")
+	}
+
+	// The update times have a millisecond resolution.
+	time.Sleep(5 * time.Millisecond)
+
+	err = TouchConfig(context.Background(), s, "key1")
+	if err != nil {
+		t.Fatalf("TouchConfig failed: %v", err)
+	}
+
+	touched, err := GetConfigEntry(context.Background(), s, "key1")
+	if err != nil {
+		t.Fatalf("GetConfigEntry failed: %v", err)
+	}
+
+	if touched.UpdatedAt <= created.UpdatedAt {
+		t.Errorf("Touched key was updated at %q, not after %q", touched.UpdatedAt, created.UpdatedAt)
+	}
+
+	if touched.Value != "value1" {
+		t.Errorf("Touched key holds %q, expected %q", touched.Value, "value1")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	err = UpdateConfig(context.Background(), s, "key1", "value2")
+	if err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+
+	updated, err := GetConfigEntry(context.Background(), s, "key1")
+	if err != nil {
+		t.Fatalf("GetConfigEntry failed: %v", err)
+	}
+
+	if updated.UpdatedAt <= touched.UpdatedAt {
+		t.Errorf("Updated key was updated at %q, not after %q", updated.UpdatedAt, touched.UpdatedAt)
+	}
+
+	err = TouchConfig(context.Background(), s, "key2")
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("TouchConfig of a missing key error = %v, expected not found", err)
+	}
+}
+
+func TestConfigHistory(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{configHistorySetting: "2", "key": "v1"})
+
+	for _, value := range []string{"v2", "v3", "v4"} {
+		_, err := UpdateConfigIfMatch(context.Background(), s, "key", value, nil, "", "test")
+		if err != nil {
+			t.Fatalf("UpdateConfigIfMatch(%q) failed: %v", value, err)
+		}
+	}
+
+	// Only the last two prior values are kept, most recent first.
+	versions, err := GetConfigHistory(context.Background(), s, "key")
+	if err != nil {
+		t.Fatalf("GetConfigHistory failed: %v", err)
+	}
+
+	if len(versions) != 2 || versions[0].Value != "v3" || versions[1].Value != "v2" {
+		t.Fatalf("GetConfigHistory = %+v, expected v3 and v2", versions)
+	}
+
+	err = RestoreConfig(context.Background(), s, "key", versions[1].Version)
+	if err != nil {
+		t.Fatalf("RestoreConfig failed: %v", err)
+	}
+
+	value, err := GetConfig(context.Background(), s, "key")
+	if err != nil {
+		t.Fatalf("GetConfig failed: %v", err)
+	}
+
+	if value != "v2" {
+		t.Errorf("Restored key holds %q, expected %q", value, "v2")
+	}
+
+	// The replaced value is kept in the history like any other update.
+	versions, err = GetConfigHistory(context.Background(), s, "key")
+	if err != nil {
+		t.Fatalf("GetConfigHistory failed: %v", err)
+	}
+
+	if len(versions) != 2 || versions[0].Value != "v4" || versions[1].Value != "v3" {
+		t.Errorf("GetConfigHistory after restore = %+v, expected v4 and v3", versions)
+	}
+
+	err = RestoreConfig(context.Background(), s, "key", 42)
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("RestoreConfig of a missing version error = %v, expected not found", err)
+	}
+}
+
+func TestCheckConfigWritable(t *testing.T) {
+	tests := []struct {
+		key      string
+		writable bool
+	}{
+		{key: "CredentialsK8S", writable: true},
+		{key: "daemon-tfstate-store", writable: true},
+		{key: "tfstate-openstack", writable: false},
+		{key: "tflock-openstack", writable: false},
+		{key: "tfserial-openstack", writable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := CheckConfigWritable(tt.key)
+			if tt.writable && err != nil {
+				t.Errorf("CheckConfigWritable(%q) failed: %v", tt.key, err)
+			}
+
+			if !tt.writable && !errors.Is(err, ErrTerraformRecordReadOnly) {
+				t.Errorf("CheckConfigWritable(%q) = %v, expected %v", tt.key, err, ErrTerraformRecordReadOnly)
+			}
+		})
+	}
+}
+
+func TestSecretConfigKeys(t *testing.T) {
+	tests := []struct {
+		key    string
+		secret bool
+	}{
+		{key: tfstateStoreTokenSetting, secret: true},
+		{key: tfstateStoreSetting, secret: false},
+		{key: "CredentialsK8S", secret: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if isSecretConfigKey(tt.key) != tt.secret {
+				t.Errorf("isSecretConfigKey(%q) = %v, expected %v", tt.key, !tt.secret, tt.secret)
+			}
+
+			// The prior values of the secrets are never kept.
+			if tt.secret && !hasConfigHistoryExcludedPrefix(tt.key) {
+				t.Errorf("Prior values of %q are kept", tt.key)
+			}
+		})
+	}
+}
+
+// hasConfigHistoryExcludedPrefix returns whether the prior values of the key are not kept
+func hasConfigHistoryExcludedPrefix(key string) bool {
+	for _, prefix := range configHistoryExcludedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := ConfigETag("value")
+	other := ConfigETag("other")
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		matches bool
+	}{
+		{name: "same", ifMatch: etag, matches: true},
+		{name: "any", ifMatch: "*", matches: true},
+		{name: "list", ifMatch: other + ", " + etag, matches: true},
+		{name: "other", ifMatch: other, matches: false},
+		{name: "weak", ifMatch: "W/" + etag, matches: false},
+		{name: "unquoted", ifMatch: strings.Trim(etag, `"`), matches: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if etagMatches(tt.ifMatch, etag) != tt.matches {
+				t.Errorf("etagMatches(%q, %q) = %v, expected %v", tt.ifMatch, etag, !tt.matches, tt.matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// Policies of configACLUntrustedSetting.
+const (
+	ConfigACLAllow = "allow"
+	ConfigACLDeny  = "deny"
+)
+
+// ErrConfigAccessDenied is returned when a client accesses a config key outside of its ACL.
+var ErrConfigAccessDenied = errors.New("Config access denied")
+
+// trustedOnlyConfigPrefixes are the prefixes of the config keys only trusted
+// clients may access, whatever the ACL: the daemon settings, the records kept
+// by the terraform endpoints and the node enrollment tokens.
+var trustedOnlyConfigPrefixes = []string{settingsPrefix, tfstatePrefix, tflockPrefix, tfserialPrefix, tfworkspacePrefix, enrollTokenPrefix}
+
+// isTrustedOnlyConfigKey returns whether only trusted clients may access the key
+func isTrustedOnlyConfigKey(key string) bool {
+	for _, prefix := range trustedOnlyConfigPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConfigACL holds the config keys a client may access
+type ConfigACL struct {
+	identity   string
+	trusted    bool
+	restricted bool
+	patterns   []string
+}
+
+// GetConfigACL returns the ACL of the client with the given identity.
+// Trusted clients are restricted to the keys matching their configACLSetting
+// entries, those without any entry are unrestricted. Untrusted clients are
+// restricted per the configACLUntrustedSetting, and never access the keys
+// with a trustedOnlyConfigPrefixes prefix.
+func GetConfigACL(ctx context.Context, s *state.State, identity string, trusted bool) (ConfigACL, error) {
+	acl := ConfigACL{identity: identity, trusted: trusted}
+
+	if !trusted {
+		policy, err := getSetting(ctx, s, configACLUntrustedSetting, ConfigACLAllow)
+		if err != nil {
+			return acl, err
+		}
+
+		switch strings.TrimSpace(policy) {
+		case ConfigACLAllow:
+		case ConfigACLDeny:
+			acl.restricted = true
+		default:
+			return acl, fmt.Errorf("Invalid value %q for setting %q, expected %s or %s", policy, configACLUntrustedSetting, ConfigACLAllow, ConfigACLDeny)
+		}
+
+		return acl, nil
+	}
+
+	if identity == "" {
+		return acl, nil
+	}
+
+	entries, err := getListSetting(ctx, s, configACLSetting, nil)
+	if err != nil {
+		return acl, err
+	}
+
+	for _, entry := range entries {
+		allowed, pattern, found := strings.Cut(entry, "=")
+		if !found {
+			return acl, fmt.Errorf("Invalid entry %q for setting %q, expected identity=key", entry, configACLSetting)
+		}
+
+		if strings.TrimSpace(allowed) != identity {
+			continue
+		}
+
+		pattern = strings.TrimSpace(pattern)
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return acl, fmt.Errorf("Invalid key pattern %q for setting %q: %w", pattern, configACLSetting, err)
+		}
+
+		acl.restricted = true
+		acl.patterns = append(acl.patterns, pattern)
+	}
+
+	return acl, nil
+}
+
+// Allows returns whether the client may access the key
+func (a ConfigACL) Allows(key string) bool {
+	if !a.trusted && isTrustedOnlyConfigKey(key) {
+		return false
+	}
+
+	if !a.restricted {
+		return true
+	}
+
+	for _, pattern := range a.patterns {
+		matched, _ := path.Match(pattern, key)
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check fails with a forbidden error unless the client may access all the keys
+func (a ConfigACL) Check(keys ...string) error {
+	for _, key := range keys {
+		if !a.Allows(key) {
+			if !a.trusted {
+				return api.StatusErrorf(http.StatusForbidden, "%w: untrusted clients may not access %q", ErrConfigAccessDenied, key)
+			}
+
+			return api.StatusErrorf(http.StatusForbidden, "%w: %q may not access %q", ErrConfigAccessDenied, a.identity, key)
+		}
+	}
+
+	return nil
+}
+
+// FilterKeys returns the keys the client may access
+func (a ConfigACL) FilterKeys(keys []string) []string {
+	if a.trusted && !a.restricted {
+		return keys
+	}
+
+	allowed := []string{}
+	for _, key := range keys {
+		if a.Allows(key) {
+			allowed = append(allowed, key)
+		}
+	}
+
+	return allowed
+}
+
+// FilterEntries returns the config entries the client may access
+func (a ConfigACL) FilterEntries(entries []types.ConfigEntry) []types.ConfigEntry {
+	if a.trusted && !a.restricted {
+		return entries
+	}
+
+	allowed := []types.ConfigEntry{}
+	for _, entry := range entries {
+		if a.Allows(entry.Key) {
+			allowed = append(allowed, entry)
+		}
+	}
+
+	return allowed
+}
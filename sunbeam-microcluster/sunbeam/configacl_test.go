@@ -0,0 +1,123 @@
+package sunbeam
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestConfigACLAllows(t *testing.T) {
+	untrusted := ConfigACL{}
+	untrustedDeny := ConfigACL{restricted: true}
+	trusted := ConfigACL{identity: "admin", trusted: true}
+	restricted := ConfigACL{identity: "client", trusted: true, restricted: true, patterns: []string{"client-*"}}
+
+	tests := []struct {
+		name    string
+		acl     ConfigACL
+		key     string
+		allowed bool
+	}{
+		{name: "untrusted user key", acl: untrusted, key: "CredentialsK8S", allowed: true},
+		{name: "untrusted setting", acl: untrusted, key: "daemon-config-acl-untrusted", allowed: false},
+		{name: "untrusted terraform state", acl: untrusted, key: "tfstate-openstack", allowed: false},
+		{name: "untrusted terraform lock", acl: untrusted, key: "tflock-openstack", allowed: false},
+		{name: "untrusted terraform serial", acl: untrusted, key: "tfserial-openstack", allowed: false},
+		{name: "untrusted terraform workspace", acl: untrusted, key: "tfws-openstack", allowed: false},
+		{name: "untrusted denied user key", acl: untrustedDeny, key: "CredentialsK8S", allowed: false},
+		{name: "trusted setting", acl: trusted, key: "daemon-config-acl-untrusted", allowed: true},
+		{name: "trusted terraform state", acl: trusted, key: "tfstate-openstack", allowed: true},
+		{name: "restricted matching key", acl: restricted, key: "client-key", allowed: true},
+		{name: "restricted other key", acl: restricted, key: "other-key", allowed: false},
+		{name: "restricted setting", acl: restricted, key: "daemon-config-acl", allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.acl.Allows(tt.key) != tt.allowed {
+				t.Errorf("Allows(%q) = %v, expected %v", tt.key, !tt.allowed, tt.allowed)
+			}
+
+			err := tt.acl.Check(tt.key)
+			if tt.allowed && err != nil {
+				t.Errorf("Check(%q) failed: %v", tt.key, err)
+			}
+
+			if !tt.allowed && !api.StatusErrorCheck(err, http.StatusForbidden) {
+				t.Errorf("Check(%q) = %v, expected a forbidden error", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestConfigACLFilterKeys(t *testing.T) {
+	keys := []string{"CredentialsK8S", "daemon-ratelimit-rate", "tfstate-openstack", "client-key"}
+
+	tests := []struct {
+		name     string
+		acl      ConfigACL
+		expected []string
+	}{
+		{name: "untrusted", acl: ConfigACL{}, expected: []string{"CredentialsK8S", "client-key"}},
+		{name: "trusted", acl: ConfigACL{trusted: true}, expected: keys},
+		{name: "restricted", acl: ConfigACL{trusted: true, restricted: true, patterns: []string{"client-*"}}, expected: []string{"client-key"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered := tt.acl.FilterKeys(keys)
+			if !reflect.DeepEqual(filtered, tt.expected) {
+				t.Errorf("FilterKeys() = %v, expected %v", filtered, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfigACLEnrollment(t *testing.T) {
+	// Untrusted clients adding nodes must neither lift the enrollment requirement
+	// nor record enrollment tokens of their own.
+	keys := []string{nodeEnrollRequiredSetting, enrollTokenKey("chosen-by-the-client")}
+
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			err := ConfigACL{}.Check(key)
+			if !api.StatusErrorCheck(err, http.StatusForbidden) {
+				t.Errorf("Untrusted Check(%q) = %v, expected a forbidden error", key, err)
+			}
+
+			err = ConfigACL{trusted: true}.Check(key)
+			if err != nil {
+				t.Errorf("Trusted Check(%q) failed: %v", key, err)
+			}
+		})
+	}
+
+	entries := ConfigACL{}.FilterKeys(keys)
+	if len(entries) != 0 {
+		t.Errorf("Untrusted clients list the keys %v", entries)
+	}
+}
+
+func TestConfigACLTrustedOnlySettings(t *testing.T) {
+	settings := []string{
+		rateLimitRateSetting,
+		rateLimitBurstSetting,
+		tflockIdentitySetting,
+		tflockForceUnlockSetting,
+		tflockStrictPathSetting,
+	}
+
+	for _, key := range settings {
+		t.Run(key, func(t *testing.T) {
+			if (ConfigACL{}).Allows(key) {
+				t.Errorf("Untrusted clients may access %q", key)
+			}
+
+			if !(ConfigACL{trusted: true}).Allows(key) {
+				t.Errorf("Trusted clients may not access %q", key)
+			}
+		})
+	}
+}
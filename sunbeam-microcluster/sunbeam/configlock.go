@@ -0,0 +1,65 @@
+package sunbeam
+
+import (
+	"sort"
+	"sync"
+)
+
+// configKeyLock serializes the read-modify-write of config items on this member.
+// Writers of the same key wait for each other while different keys proceed in
+// parallel. The database still serializes the writes of different members.
+var configKeyLock = keyedMutex{locks: map[string]*keyLock{}}
+
+// keyLock is the mutex of a key along with the number of holders and waiters.
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// keyedMutex is a set of mutexes created on demand for each key and dropped
+// once no longer used.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// lock acquires the mutexes of the keys, in order so that writers locking the
+// same keys cannot deadlock, and returns the function releasing them.
+func (m *keyedMutex) lock(keys ...string) func() {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	held := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if len(held) > 0 && held[len(held)-1] == key {
+			continue
+		}
+
+		m.mu.Lock()
+		l, ok := m.locks[key]
+		if !ok {
+			l = &keyLock{}
+			m.locks[key] = l
+		}
+		l.refs++
+		m.mu.Unlock()
+
+		l.mu.Lock()
+		held = append(held, key)
+	}
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		for _, key := range held {
+			l := m.locks[key]
+			l.mu.Unlock()
+
+			l.refs--
+			if l.refs == 0 {
+				delete(m.locks, key)
+			}
+		}
+	}
+}
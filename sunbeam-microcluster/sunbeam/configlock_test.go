@@ -0,0 +1,105 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := keyedMutex{locks: map[string]*keyLock{}}
+
+	// Writers of the same keys, locked in any order, do not lose updates.
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(keys ...string) {
+			defer wg.Done()
+
+			unlock := m.lock(keys...)
+			defer unlock()
+
+			value := counter
+			runtime.Gosched()
+			counter = value + 1
+		}([][]string{{"a", "b"}, {"b", "a"}, {"a", "a", "b"}}[i%3]...)
+	}
+
+	wg.Wait()
+
+	if counter != 50 {
+		t.Errorf("Counter is %d after 50 serialized increments", counter)
+	}
+
+	// A different key proceeds while a key is held.
+	unlock := m.lock("a")
+	m.lock("c")()
+	unlock()
+
+	if len(m.locks) != 0 {
+		t.Errorf("Mutexes of %d keys left after all were released", len(m.locks))
+	}
+}
+
+func TestUpdateConfigIfMatchConcurrent(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{"counter": "0"})
+
+	// Each writer increments the counter with a read-modify-write guarded by the
+	// ETag of the value it read, retrying when another writer got there first.
+	writers := 10
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				value, err := GetConfig(context.Background(), s, "counter")
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				_, err = UpdateConfigIfMatch(context.Background(), s, "counter", strconv.Itoa(n+1), nil, ConfigETag(value), "test")
+				if api.StatusErrorCheck(err, http.StatusPreconditionFailed) {
+					continue
+				}
+
+				errs <- err
+				return
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Failed to increment the counter: %v", err)
+		}
+	}
+
+	value, err := GetConfig(context.Background(), s, "counter")
+	if err != nil {
+		t.Fatalf("Failed to get the counter: %v", err)
+	}
+
+	if value != strconv.Itoa(writers) {
+		t.Errorf("Counter is %s after %d increments", value, writers)
+	}
+}
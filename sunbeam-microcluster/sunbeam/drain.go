@@ -0,0 +1,154 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+)
+
+// drainGraceSetting is how long a drain waits by default for the in-flight
+// terraform writes to complete.
+const drainGraceSetting = settingsPrefix + "drain-grace"
+
+// defaultDrainGrace is the drain grace period used when drainGraceSetting is unset.
+const defaultDrainGrace = 30 * time.Second
+
+// ErrTerraformDraining is returned for terraform writes started while the member is drained.
+var ErrTerraformDraining = errors.New("Member is draining before shutdown, retry later")
+
+// tfDrain tracks the terraform writes in flight on this member so that they
+// can complete before the daemon is stopped. drained is closed once the member
+// is drained to release the requests waiting on a lock.
+var tfDrain = struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+	drained  chan struct{}
+}{idle: make(chan struct{}), drained: make(chan struct{})}
+
+// beginTerraformWrite records the start of a terraform write and returns the
+// function recording its end. Writes are refused once the member is drained.
+func beginTerraformWrite() (func(), error) {
+	tfDrain.mu.Lock()
+	defer tfDrain.mu.Unlock()
+
+	if tfDrain.draining {
+		return nil, api.StatusErrorf(http.StatusServiceUnavailable, "%w", ErrTerraformDraining)
+	}
+
+	tfDrain.inFlight++
+
+	return func() {
+		tfDrain.mu.Lock()
+		defer tfDrain.mu.Unlock()
+
+		tfDrain.inFlight--
+		if tfDrain.inFlight == 0 {
+			close(tfDrain.idle)
+			tfDrain.idle = make(chan struct{})
+		}
+	}, nil
+}
+
+// waitTerraformLock waits for the grace given to the holder of a terraform lock.
+// The wait is cut short if the member is drained or ctx is done.
+func waitTerraformLock(ctx context.Context, grace time.Duration) error {
+	tfDrain.mu.Lock()
+	drained := tfDrain.drained
+	tfDrain.mu.Unlock()
+
+	select {
+	case <-time.After(grace):
+		return nil
+	case <-drained:
+		return api.StatusErrorf(http.StatusServiceUnavailable, "%w", ErrTerraformDraining)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DrainTerraform refuses new terraform lock acquisitions, unlocks and state writes
+// on this member, then waits for the ones in flight to complete. If grace is zero
+// the drainGraceSetting is used. It returns the number of writes still in
+// flight when the grace period expired.
+func DrainTerraform(ctx context.Context, s *state.State, grace time.Duration) (int, error) {
+	if grace <= 0 {
+		var err error
+		grace, err = getDurationSetting(ctx, s, drainGraceSetting, defaultDrainGrace)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return drainTerraform(ctx, grace), nil
+}
+
+// DrainTerraformOnShutdown drains this member once the daemon starts shutting
+// down, which microcluster signals by cancelling the state context. Microcluster
+// stops the database right after, without waiting for the hooks, so the writes
+// in flight cannot be given a grace period and are only reported. New writes are
+// refused and the requests waiting on a lock are released. Draining the member
+// with the maintenance endpoint before stopping the daemon lets them complete.
+func DrainTerraformOnShutdown(s *state.State) {
+	<-s.Context.Done()
+
+	inFlight := stopTerraformWrites()
+	if inFlight > 0 {
+		logger.Warn("Shutting down with terraform writes in flight", logger.Ctx{"inFlight": inFlight})
+	}
+}
+
+// stopTerraformWrites refuses new writes on this member and releases the requests
+// waiting on a lock, returning the number of writes in flight.
+func stopTerraformWrites() int {
+	tfDrain.mu.Lock()
+	defer tfDrain.mu.Unlock()
+
+	if !tfDrain.draining {
+		tfDrain.draining = true
+		close(tfDrain.drained)
+	}
+
+	return tfDrain.inFlight
+}
+
+// drainTerraform drains this member and waits up to grace for the writes in
+// flight, returning the number of writes still in flight.
+func drainTerraform(ctx context.Context, grace time.Duration) int {
+	tfDrain.mu.Lock()
+	idle := tfDrain.idle
+	tfDrain.mu.Unlock()
+
+	if stopTerraformWrites() == 0 {
+		return 0
+	}
+
+	select {
+	case <-idle:
+	case <-time.After(grace):
+	case <-ctx.Done():
+	}
+
+	tfDrain.mu.Lock()
+	defer tfDrain.mu.Unlock()
+
+	return tfDrain.inFlight
+}
+
+// UndrainTerraform accepts terraform writes on this member again
+func UndrainTerraform() {
+	tfDrain.mu.Lock()
+	defer tfDrain.mu.Unlock()
+
+	if tfDrain.draining {
+		tfDrain.draining = false
+		tfDrain.drained = make(chan struct{})
+	}
+}
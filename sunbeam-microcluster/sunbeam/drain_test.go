@@ -0,0 +1,140 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+)
+
+func TestDrainTerraform(t *testing.T) {
+	t.Cleanup(UndrainTerraform)
+
+	tests := []struct {
+		name     string
+		writes   int
+		finish   bool
+		grace    time.Duration
+		inFlight int
+	}{
+		{name: "idle", grace: time.Minute, inFlight: 0},
+		{name: "write completes", writes: 1, finish: true, grace: time.Minute, inFlight: 0},
+		{name: "grace expires", writes: 2, grace: 10 * time.Millisecond, inFlight: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UndrainTerraform()
+
+			writes := make([]func(), 0, tt.writes)
+			for i := 0; i < tt.writes; i++ {
+				done, err := beginTerraformWrite()
+				if err != nil {
+					t.Fatalf("Failed to begin write: %v", err)
+				}
+
+				writes = append(writes, sync.OnceFunc(done))
+			}
+
+			defer func() {
+				for _, done := range writes {
+					done()
+				}
+			}()
+
+			if tt.finish {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					for _, done := range writes {
+						done()
+					}
+				}()
+			}
+
+			inFlight := drainTerraform(context.Background(), tt.grace)
+			if inFlight != tt.inFlight {
+				t.Errorf("Drain left %d writes in flight, expected %d", inFlight, tt.inFlight)
+			}
+
+			_, err := beginTerraformWrite()
+			if !api.StatusErrorCheck(err, http.StatusServiceUnavailable) || !errors.Is(err, ErrTerraformDraining) {
+				t.Errorf("Write on a drained member returned %v, expected %v", err, ErrTerraformDraining)
+			}
+		})
+	}
+
+	UndrainTerraform()
+
+	done, err := beginTerraformWrite()
+	if err != nil {
+		t.Fatalf("Write on an undrained member failed: %v", err)
+	}
+
+	done()
+}
+
+func TestDrainTerraformWithPendingWaiter(t *testing.T) {
+	t.Cleanup(UndrainTerraform)
+	UndrainTerraform()
+
+	// A request waiting on the grace of a held lock is released by the shutdown.
+	waited := make(chan error)
+	go func() {
+		waited <- waitTerraformLock(context.Background(), time.Minute)
+	}()
+
+	// Give the waiter the time to start waiting.
+	time.Sleep(10 * time.Millisecond)
+
+	// The daemon shuts down, and its state context is cancelled.
+	shutdown, cancel := context.WithCancel(context.Background())
+	cancel()
+	DrainTerraformOnShutdown(&state.State{Context: shutdown})
+
+	select {
+	case err := <-waited:
+		if !errors.Is(err, ErrTerraformDraining) {
+			t.Errorf("Waiter returned %v, expected %v", err, ErrTerraformDraining)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Waiter was not released by the drain")
+	}
+
+	// Waits started after the drain return at once.
+	err := waitTerraformLock(context.Background(), time.Minute)
+	if !errors.Is(err, ErrTerraformDraining) {
+		t.Errorf("Wait on a drained member returned %v, expected %v", err, ErrTerraformDraining)
+	}
+
+	UndrainTerraform()
+
+	err = waitTerraformLock(context.Background(), time.Millisecond)
+	if err != nil {
+		t.Errorf("Wait on an undrained member failed: %v", err)
+	}
+}
+
+func TestDrainTerraformDeletes(t *testing.T) {
+	s := newTestState(t)
+	t.Cleanup(UndrainTerraform)
+
+	_, err := DrainTerraform(context.Background(), s, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to drain: %v", err)
+	}
+
+	err = DeleteTerraformState(context.Background(), s, "plan")
+	if !api.StatusErrorCheck(err, http.StatusServiceUnavailable) || !errors.Is(err, ErrTerraformDraining) {
+		t.Errorf("State delete on a drained member returned %v, expected %v", err, ErrTerraformDraining)
+	}
+
+	_, err = DeleteTerraformLock(context.Background(), s, "plan", "{}", "", false)
+	if !api.StatusErrorCheck(err, http.StatusServiceUnavailable) || !errors.Is(err, ErrTerraformDraining) {
+		t.Errorf("Unlock on a drained member returned %v, expected %v", err, ErrTerraformDraining)
+	}
+}
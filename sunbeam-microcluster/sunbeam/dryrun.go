@@ -0,0 +1,30 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/canonical/microcluster/state"
+)
+
+// errDryRun rolls back the transaction of a dry run once it succeeded
+var errDryRun = errors.New("Dry run")
+
+// transactionOrDryRun runs f in a transaction that is rolled back if dryRun is
+// set, so that a dry run goes through the same checks as the actual operation.
+func transactionOrDryRun(ctx context.Context, s *state.State, dryRun bool, f func(ctx context.Context, tx *sql.Tx) error) error {
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		err := f(ctx, tx)
+		if err == nil && dryRun {
+			return errDryRun
+		}
+
+		return err
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,155 @@
+package sunbeam
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// enrollTokenPrefix prefixes the config keys holding the node enrollment tokens,
+// followed by the SHA-256 of the token so that the tokens are never stored.
+const enrollTokenPrefix = "enrolltoken-"
+
+// DefaultEnrollTokenTTL is how long an enrollment token issued without a TTL is valid
+const DefaultEnrollTokenTTL = 15 * time.Minute
+
+// MaxEnrollTokenTTL bounds how long an enrollment token is valid
+const MaxEnrollTokenTTL = 24 * time.Hour
+
+// ErrInvalidEnrollToken is returned when redeeming an unknown, used or expired enrollment token.
+var ErrInvalidEnrollToken = errors.New("Invalid enrollment token")
+
+// enrollTokenRecord is recorded in the config for each enrollment token
+type enrollTokenRecord struct {
+	ExpiresAt time.Time `json:"expiresat"`
+}
+
+// enrollTokenKey returns the config key of the enrollment token
+func enrollTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return enrollTokenPrefix + hex.EncodeToString(sum[:])
+}
+
+// NodeEnrollRequired returns whether the untrusted clients adding a node must redeem an enrollment token
+func NodeEnrollRequired(ctx context.Context, s *state.State) (bool, error) {
+	return getBoolSetting(ctx, s, nodeEnrollRequiredSetting, false)
+}
+
+// IssueEnrollToken issues a single-use token letting a node add itself within the ttl
+func IssueEnrollToken(ctx context.Context, s *state.State, ttl time.Duration, createdBy string) (types.EnrollToken, error) {
+	if ttl == 0 {
+		ttl = DefaultEnrollTokenTTL
+	}
+
+	if ttl < 0 || ttl > MaxEnrollTokenTTL {
+		return types.EnrollToken{}, api.StatusErrorf(http.StatusBadRequest, "Invalid enrollment token TTL %s, expected a positive duration up to %s", ttl, MaxEnrollTokenTTL)
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return types.EnrollToken{}, err
+	}
+
+	record := enrollTokenRecord{ExpiresAt: time.Now().UTC().Add(ttl)}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return types.EnrollToken{}, err
+	}
+
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: enrollTokenKey(token), Value: string(value), CreatedBy: createdBy})
+		return err
+	})
+	if err != nil {
+		return types.EnrollToken{}, err
+	}
+
+	return types.EnrollToken{Token: token, ExpiresAt: record.ExpiresAt}, nil
+}
+
+// redeemEnrollToken consumes the enrollment token in the transaction, failing with
+// ErrInvalidEnrollToken if it is unknown, already used or expired. Expired tokens
+// are left to purgeEnrollTokens as the failed transaction is rolled back.
+func redeemEnrollToken(ctx context.Context, tx *sql.Tx, token string) error {
+	key := enrollTokenKey(token)
+
+	item, err := database.GetConfigItem(ctx, tx, key)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return api.StatusErrorf(http.StatusForbidden, "%w", ErrInvalidEnrollToken)
+		}
+		return err
+	}
+
+	var record enrollTokenRecord
+	err = json.Unmarshal([]byte(item.Value), &record)
+	if err != nil {
+		return fmt.Errorf("Failed to parse enrollment token: %w", err)
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		return api.StatusErrorf(http.StatusForbidden, "%w: expired at %s", ErrInvalidEnrollToken, record.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return database.DeleteConfigItem(ctx, tx, key)
+}
+
+// purgeEnrollTokens deletes the expired enrollment tokens, each in its own transaction
+func purgeEnrollTokens(ctx context.Context, s *state.State) (int, error) {
+	var keys []string
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		prefix := enrollTokenPrefix
+		var err error
+		keys, err = database.GetConfigItemKeys(ctx, tx, &prefix)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		deleted := false
+
+		err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+			deleted = false
+
+			item, err := database.GetConfigItem(ctx, tx, key)
+			if err != nil {
+				// Redeemed meanwhile.
+				return nil
+			}
+
+			var record enrollTokenRecord
+			err = json.Unmarshal([]byte(item.Value), &record)
+			if err == nil && time.Now().Before(record.ExpiresAt) {
+				return nil
+			}
+
+			deleted = true
+			return database.DeleteConfigItem(ctx, tx, key)
+		})
+		if err != nil {
+			return purged, err
+		}
+
+		if deleted {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,103 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+func TestAddEnrolledNode(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{enrollTokenKey("expired"): `{"expiresat":"2024-01-01T00:00:00Z"}`})
+
+	_, err := IssueEnrollToken(context.Background(), s, 2*MaxEnrollTokenTTL, "test")
+	if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+		t.Errorf("IssueEnrollToken beyond the maximum TTL error = %v, expected a bad request", err)
+	}
+
+	token, err := IssueEnrollToken(context.Background(), s, 0, "test")
+	if err != nil {
+		t.Fatalf("IssueEnrollToken failed: %v", err)
+	}
+
+	if time.Until(token.ExpiresAt) > DefaultEnrollTokenTTL || time.Until(token.ExpiresAt) < DefaultEnrollTokenTTL-time.Minute {
+		t.Errorf("Token expires at %s, expected in %s", token.ExpiresAt, DefaultEnrollTokenTTL)
+	}
+
+	addTestNodes(t, s, map[string][]string{"node1": {"compute"}})
+
+	// Steps run in order against the same tokens.
+	steps := []struct {
+		name    string
+		token   string
+		node    string
+		invalid bool
+		failed  bool
+	}{
+		{name: "node not added", token: token.Token, node: "node1", failed: true},
+		{name: "issued", token: token.Token, node: "node2"},
+		{name: "used", token: token.Token, node: "node3", invalid: true},
+		{name: "unknown", token: "unknown", node: "node3", invalid: true},
+		{name: "empty", token: "", node: "node3", invalid: true},
+		{name: "expired", token: "expired", node: "node3", invalid: true},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := AddEnrolledNode(context.Background(), s, step.token, step.node, []string{"compute"}, 0, "", nil, types.NodeCapacity{}, "test")
+			if step.invalid {
+				if !errors.Is(err, ErrInvalidEnrollToken) || !api.StatusErrorCheck(err, http.StatusForbidden) {
+					t.Errorf("AddEnrolledNode error = %v, expected a forbidden ErrInvalidEnrollToken", err)
+				}
+			} else if step.failed {
+				// The token is kept when the node is not added.
+				if err == nil || errors.Is(err, ErrInvalidEnrollToken) {
+					t.Errorf("AddEnrolledNode of existing node %q error = %v, expected a failure to add the node", step.node, err)
+				}
+			} else if err != nil {
+				t.Fatalf("AddEnrolledNode failed: %v", err)
+			}
+
+			nodes, err := ListNodesByNames(context.Background(), s, []string{step.node}, NodeFilter{})
+			if err != nil {
+				t.Fatalf("ListNodesByNames failed: %v", err)
+			}
+
+			added := len(nodes.Nodes) == 1
+			if added != (!step.invalid) {
+				t.Errorf("Node %q exists is %v, expected %v", step.node, added, !step.invalid)
+			}
+		})
+	}
+}
+
+func TestPurgeEnrollTokens(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{enrollTokenKey("expired"): `{"expiresat":"2024-01-01T00:00:00Z"}`})
+
+	token, err := IssueEnrollToken(context.Background(), s, time.Hour, "test")
+	if err != nil {
+		t.Fatalf("IssueEnrollToken failed: %v", err)
+	}
+
+	purged, err := purgeEnrollTokens(context.Background(), s)
+	if err != nil {
+		t.Fatalf("purgeEnrollTokens failed: %v", err)
+	}
+
+	if purged != 1 {
+		t.Errorf("Purged %d tokens, expected the expired one", purged)
+	}
+
+	// The valid token is kept.
+	err = AddEnrolledNode(context.Background(), s, token.Token, "node1", nil, 0, "", nil, types.NodeCapacity{}, "test")
+	if err != nil {
+		t.Errorf("AddEnrolledNode after the purge failed: %v", err)
+	}
+}
@@ -2,9 +2,14 @@ package sunbeam
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"strings"
 
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/state"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
@@ -12,11 +17,11 @@ import (
 )
 
 // ListJujuUsers returns the jujuusers from the database
-func ListJujuUsers(s *state.State) (types.JujuUsers, error) {
+func ListJujuUsers(ctx context.Context, s *state.State) (types.JujuUsers, error) {
 	users := types.JujuUsers{}
 
 	// Get the juju users from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
 		records, err := database.GetJujuUsers(ctx, tx)
 		if err != nil {
 			return fmt.Errorf("Failed to fetch juju user: %w", err)
@@ -24,8 +29,9 @@ func ListJujuUsers(s *state.State) (types.JujuUsers, error) {
 
 		for _, user := range records {
 			users = append(users, types.JujuUser{
-				Username: user.Username,
-				Token:    user.Token,
+				Username:  user.Username,
+				Token:     user.Token,
+				CreatedBy: user.CreatedBy,
 			})
 		}
 
@@ -39,9 +45,9 @@ func ListJujuUsers(s *state.State) (types.JujuUsers, error) {
 }
 
 // GetJujuUser returns a JujuUser with the given name
-func GetJujuUser(s *state.State, name string) (types.JujuUser, error) {
+func GetJujuUser(ctx context.Context, s *state.State, name string) (types.JujuUser, error) {
 	jujuUser := types.JujuUser{}
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
 		record, err := database.GetJujuUser(ctx, tx, name)
 		if err != nil {
 			return err
@@ -49,6 +55,7 @@ func GetJujuUser(s *state.State, name string) (types.JujuUser, error) {
 
 		jujuUser.Username = record.Username
 		jujuUser.Token = record.Token
+		jujuUser.CreatedBy = record.CreatedBy
 
 		return nil
 	})
@@ -56,11 +63,20 @@ func GetJujuUser(s *state.State, name string) (types.JujuUser, error) {
 	return jujuUser, err
 }
 
-// AddJujuUser adds a Jujuuser to the database
-func AddJujuUser(s *state.State, name string, token string) error {
+// AddJujuUser adds a Jujuuser to the database.
+// A random token is generated if token is empty. The stored token is returned.
+func AddJujuUser(ctx context.Context, s *state.State, name string, token string, createdBy string) (string, error) {
+	if token == "" {
+		var err error
+		token, err = generateToken()
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// Add juju user to the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateJujuUser(ctx, tx, database.JujuUser{Username: name, Token: token})
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.CreateJujuUser(ctx, tx, database.JujuUser{Username: name, Token: token, CreatedBy: createdBy})
 		if err != nil {
 			return fmt.Errorf("Failed to record juju user: %w", err)
 		}
@@ -68,16 +84,78 @@ func AddJujuUser(s *state.State, name string, token string) error {
 		return nil
 	})
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return token, nil
+}
+
+// AddJujuUsers adds the Jujuusers to the database in a single transaction.
+// Random tokens are generated for the users without one. Users that already
+// exist are reported as conflicts and skipped, unless atomic is set in which
+// case no user is added.
+func AddJujuUsers(ctx context.Context, s *state.State, users types.JujuUsers, atomic bool, createdBy string) (types.JujuUsersBatch, error) {
+	var result types.JujuUsersBatch
+
+	for i := range users {
+		if users[i].Token == "" {
+			token, err := generateToken()
+			if err != nil {
+				return result, err
+			}
+			users[i].Token = token
+		}
+		users[i].CreatedBy = createdBy
+	}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		// The transaction may be retried, start from an empty result.
+		result = types.JujuUsersBatch{Added: types.JujuUsers{}, Conflicts: []string{}}
+
+		for _, user := range users {
+			_, err := database.CreateJujuUser(ctx, tx, database.JujuUser{Username: user.Username, Token: user.Token, CreatedBy: createdBy})
+			if api.StatusErrorCheck(err, http.StatusConflict) {
+				result.Conflicts = append(result.Conflicts, user.Username)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to record juju user: %w", err)
+			}
+
+			result.Added = append(result.Added, user)
+		}
+
+		// Returning an error rolls back the users already added.
+		if atomic && len(result.Conflicts) > 0 {
+			return api.StatusErrorf(http.StatusConflict, "Juju users already exist: %s", strings.Join(result.Conflicts, ", "))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return types.JujuUsersBatch{}, err
+	}
+
+	return result, nil
+}
+
+// generateToken returns a cryptographically random URL safe token
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate token: %w", err)
 	}
 
-	return nil
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
 // DeleteJujuUser deletes the juju user record from the database
-func DeleteJujuUser(s *state.State, name string) error {
+func DeleteJujuUser(ctx context.Context, s *state.State, name string, dryRun bool) (types.DeletePlan, error) {
+	plan := types.DeletePlan{Deleted: []string{"jujuusers/" + name}, Affected: []string{}}
+
 	// Delete juju user from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err := transactionOrDryRun(ctx, s, dryRun, func(ctx context.Context, tx *sql.Tx) error {
 		err := database.DeleteJujuUser(ctx, tx, name)
 		if err != nil {
 			return fmt.Errorf("Failed to delete juju user: %w", err)
@@ -86,8 +164,24 @@ func DeleteJujuUser(s *state.State, name string) error {
 		return nil
 	})
 	if err != nil {
+		return types.DeletePlan{}, err
+	}
+
+	return plan, nil
+}
+
+// DeleteAllJujuUsers deletes all the juju users in a single transaction and returns the number of users deleted
+func DeleteAllJujuUsers(ctx context.Context, s *state.State) (int, error) {
+	var count int64
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		count, err = database.DeleteAllJujuUsers(ctx, tx)
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return int(count), nil
 }
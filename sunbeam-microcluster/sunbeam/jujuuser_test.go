@@ -0,0 +1,117 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+func TestAddJujuUser(t *testing.T) {
+	s := newTestState(t)
+
+	tests := []struct {
+		name  string
+		user  string
+		token string
+	}{
+		{name: "given token", user: "user1", token: "token1"},
+		{name: "generated token", user: "user2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := AddJujuUser(context.Background(), s, tt.user, tt.token, "test")
+			if err != nil {
+				t.Fatalf("AddJujuUser failed: %v", err)
+			}
+
+			if tt.token != "" && token != tt.token {
+				t.Errorf("AddJujuUser returned token %q, expected %q", token, tt.token)
+			}
+
+			if tt.token == "" && len(token) < 32 {
+				t.Errorf("AddJujuUser generated the short token %q", token)
+			}
+
+			user, err := GetJujuUser(context.Background(), s, tt.user)
+			if err != nil {
+				t.Fatalf("GetJujuUser failed: %v", err)
+			}
+
+			if user.Token != token {
+				t.Errorf("Stored token is %q, expected the returned %q", user.Token, token)
+			}
+		})
+	}
+
+	// Each generated token is distinct.
+	token, err := AddJujuUser(context.Background(), s, "user3", "", "test")
+	if err != nil {
+		t.Fatalf("AddJujuUser failed: %v", err)
+	}
+
+	other, err := GetJujuUser(context.Background(), s, "user2")
+	if err != nil {
+		t.Fatalf("GetJujuUser failed: %v", err)
+	}
+
+	if token == other.Token {
+		t.Errorf("Two users got the same generated token %q", token)
+	}
+}
+
+func TestAddJujuUsers(t *testing.T) {
+	tests := []struct {
+		name      string
+		atomic    bool
+		added     []string
+		conflicts []string
+		status    int
+	}{
+		{name: "best effort", added: []string{"user2"}, conflicts: []string{"user1"}},
+		{name: "atomic", atomic: true, status: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			_, err := AddJujuUser(context.Background(), s, "user1", "token1", "test")
+			if err != nil {
+				t.Fatalf("AddJujuUser failed: %v", err)
+			}
+
+			result, err := AddJujuUsers(context.Background(), s, types.JujuUsers{{Username: "user1", Token: "token2"}, {Username: "user2"}}, tt.atomic, "test")
+			if tt.status != 0 {
+				if !api.StatusErrorCheck(err, tt.status) {
+					t.Fatalf("AddJujuUsers error = %v, expected status %d", err, tt.status)
+				}
+			} else if err != nil {
+				t.Fatalf("AddJujuUsers failed: %v", err)
+			}
+
+			added := []string{}
+			for _, user := range result.Added {
+				added = append(added, user.Username)
+			}
+
+			if tt.status == 0 && (!reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(result.Conflicts, tt.conflicts)) {
+				t.Errorf("AddJujuUsers added %v with conflicts %v, expected %v with %v", added, result.Conflicts, tt.added, tt.conflicts)
+			}
+
+			_, err = GetJujuUser(context.Background(), s, "user2")
+			if (err == nil) != (len(tt.added) > 0) {
+				t.Errorf("New user lookup error = %v after an import with %d added", err, len(tt.added))
+			}
+
+			user, err := GetJujuUser(context.Background(), s, "user1")
+			if err != nil || user.Token != "token1" {
+				t.Errorf("Existing user has token %q (err %v), expected it unchanged", user.Token, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package sunbeam
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/microcluster/state"
+)
+
+// leaderLookupTimeout bounds the lookup of the dqlite leader
+const leaderLookupTimeout = 5 * time.Second
+
+// GetLeader returns the address of the dqlite leader and whether this member is the leader
+func GetLeader(ctx context.Context, s *state.State) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, leaderLookupTimeout)
+	defer cancel()
+
+	c, err := s.Database.Leader(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to reach the dqlite leader: %w", err)
+	}
+	defer c.Close()
+
+	info, err := c.Leader(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to get the dqlite leader: %w", err)
+	}
+
+	if info == nil || info.Address == "" {
+		return "", false, fmt.Errorf("No dqlite leader is elected")
+	}
+
+	return info.Address, info.Address == s.Address().URL.Host, nil
+}
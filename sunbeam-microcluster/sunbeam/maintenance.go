@@ -0,0 +1,96 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// compactionTask removes one kind of data that is no longer needed and returns
+// the number of records removed. Tasks must use their own short transactions
+// so that compaction never blocks writes for long.
+type compactionTask struct {
+	name string
+	run  func(ctx context.Context, s *state.State) (int, error)
+}
+
+// compactionTasks are run in order by Compact. Features keeping expiring data
+// register their purge task here.
+//
+// VACUUM is not part of compaction as it cannot run inside a transaction, which
+// is the only way the database is accessed.
+var compactionTasks = []compactionTask{
+	{name: "manifest-blobs", run: collectClusterManifestBlobs},
+	{name: "enroll-tokens", run: purgeEnrollTokens},
+}
+
+// compactionMu is held while a compaction runs
+var compactionMu sync.Mutex
+
+// lastCompaction is the time the last background compaction started
+var lastCompaction time.Time
+
+// Compact runs all the compaction tasks
+func Compact(ctx context.Context, s *state.State) (types.Compaction, error) {
+	if !compactionMu.TryLock() {
+		return types.Compaction{}, database.WrapStatusError(api.StatusErrorf(http.StatusConflict, "Compaction already running"))
+	}
+	defer compactionMu.Unlock()
+
+	return compact(ctx, s)
+}
+
+func compact(ctx context.Context, s *state.State) (types.Compaction, error) {
+	result := types.Compaction{Purged: map[string]int{}}
+	for _, task := range compactionTasks {
+		n, err := task.run(ctx, s)
+		if err != nil {
+			return result, err
+		}
+		result.Purged[task.name] = n
+	}
+
+	return result, nil
+}
+
+// CompactOnHeartbeat starts a background compaction if the compaction interval
+// elapsed since the last one. It is run after each heartbeat, which only happens
+// on the dqlite leader, so that a single member compacts the database.
+func CompactOnHeartbeat(s *state.State) error {
+	interval, err := getDurationSetting(s.Context, s, compactIntervalSetting, defaultCompactInterval)
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 || !compactionMu.TryLock() {
+		return nil
+	}
+
+	if time.Since(lastCompaction) < interval {
+		compactionMu.Unlock()
+		return nil
+	}
+
+	lastCompaction = time.Now()
+	go func() {
+		defer compactionMu.Unlock()
+
+		result, err := compact(s.Context, s)
+		if err != nil {
+			logger.Warn("Failed to compact database", logger.Ctx{"err": err})
+			return
+		}
+
+		logger.Debug("Compacted database", logger.Ctx{"purged": result.Purged})
+	}()
+
+	return nil
+}
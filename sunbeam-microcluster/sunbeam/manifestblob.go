@@ -0,0 +1,291 @@
+package sunbeam
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/cluster"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// manifestBlobDir is the directory of the state directory holding the manifest data blobs
+const manifestBlobDir = "manifest-blobs"
+
+// manifestBlobGrace is the age under which unreferenced blobs are kept, as the
+// manifests referencing them may still be being added.
+const manifestBlobGrace = time.Hour
+
+// storeManifestData returns the data to record in the manifest row along with the
+// hash of the blob holding the data, which is empty if the data is kept inline.
+// Data larger than the manifestBlobThresholdSetting is written to a blob named
+// after its SHA-256, so that identical manifests share the same blob. The blob is
+// held by this member, which is recorded along with the manifest.
+func storeManifestData(ctx context.Context, s *state.State, data string) (string, string, error) {
+	threshold, err := getIntSetting(ctx, s, manifestBlobThresholdSetting, 0)
+	if err != nil {
+		return "", "", err
+	}
+
+	if threshold <= 0 || len(data) <= threshold {
+		return data, "", nil
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	hash := hex.EncodeToString(sum[:])
+
+	dir := filepath.Join(s.OS.StateDir, manifestBlobDir)
+	path := filepath.Join(dir, hash)
+	_, err = os.Stat(path)
+	if err == nil {
+		// Refresh the blob so that it is not collected before the manifest is recorded.
+		now := time.Now()
+		return "", hash, os.Chtimes(path, now, now)
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to create manifest blob directory: %w", err)
+	}
+
+	// Write to a temporary file first so that a partial blob is never referenced.
+	f, err := os.CreateTemp(dir, ".tmp-"+hash)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to create manifest blob: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to write manifest blob: %w", err)
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to write manifest blob: %w", err)
+	}
+
+	return "", hash, nil
+}
+
+// manifestRecordData returns the data of the manifest record, reading it from its blob if needed.
+// The blob is read from the member holding it when that is not this member.
+func manifestRecordData(ctx context.Context, s *state.State, record database.ManifestItem) (string, error) {
+	if record.DataHash == "" {
+		return record.Data, nil
+	}
+
+	var data string
+	var err error
+	if record.DataMember == "" || record.DataMember == s.Name() {
+		data, err = GetManifestBlob(s, record.DataHash)
+	} else {
+		data, err = fetchManifestBlob(ctx, s, record.DataMember, record.DataHash)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to read data blob of manifest %q: %w", record.ManifestID, err)
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	if hex.EncodeToString(sum[:]) != record.DataHash {
+		return "", fmt.Errorf("Data blob of manifest %q does not match its hash", record.ManifestID)
+	}
+
+	return data, nil
+}
+
+// manifestFromRecord converts a manifest database record to the API type
+func manifestFromRecord(ctx context.Context, s *state.State, record database.ManifestItem) (types.Manifest, error) {
+	data, err := manifestRecordData(ctx, s, record)
+	if err != nil {
+		return types.Manifest{}, err
+	}
+
+	return types.Manifest{
+		ManifestID:  record.ManifestID,
+		AppliedDate: record.AppliedDate,
+		Data:        data,
+		CreatedBy:   record.CreatedBy,
+		StartedAt:   record.StartedAt,
+		FinishedAt:  record.FinishedAt,
+		Status:      record.Status,
+	}, nil
+}
+
+// manifestsFromRecords converts manifest database records to the API type. As
+// the blobs may be read from other members, it is not run in a transaction.
+func manifestsFromRecords(ctx context.Context, s *state.State, records []database.ManifestItem) (types.Manifests, error) {
+	manifests := types.Manifests{}
+	for _, record := range records {
+		manifest, err := manifestFromRecord(ctx, s, record)
+		if err != nil {
+			return nil, err
+		}
+
+		manifests = append(manifests, manifest)
+	}
+
+	return manifests, nil
+}
+
+// GetManifestBlob returns the data of the blob with the given hash held by this member
+func GetManifestBlob(s *state.State, hash string) (string, error) {
+	if !validManifestBlobHash(hash) {
+		return "", api.StatusErrorf(http.StatusBadRequest, "Invalid manifest blob hash %q", hash)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.OS.StateDir, manifestBlobDir, hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", database.WrapStatusError(api.StatusErrorf(http.StatusNotFound, "Manifest blob %q not found", hash))
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to read manifest blob %q: %w", hash, err)
+	}
+
+	return string(data), nil
+}
+
+// validManifestBlobHash returns whether hash is the hex encoded SHA-256 naming a blob
+func validManifestBlobHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// fetchManifestBlob returns the data of the blob with the given hash held by another member.
+// The request is sent through the dqlite leader, which forwards it to the member.
+var fetchManifestBlob = func(ctx context.Context, s *state.State, member string, hash string) (string, error) {
+	c, err := s.Leader()
+	if err != nil {
+		return "", err
+	}
+
+	var data string
+	err = c.UseTarget(member).Query(ctx, http.MethodGet, api.NewURL().Path("manifests", "blobs", hash), nil, &data)
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch manifest blob from %q: %w", member, err)
+	}
+
+	return data, nil
+}
+
+// collectMemberManifestBlobs has another member remove its unreferenced blobs and
+// returns the number of blobs removed.
+var collectMemberManifestBlobs = func(ctx context.Context, s *state.State, member string) (int, error) {
+	c, err := s.Leader()
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	err = c.UseTarget(member).Query(ctx, http.MethodDelete, api.NewURL().Path("manifests", "blobs"), nil, &removed)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to collect manifest blobs of %q: %w", member, err)
+	}
+
+	return removed, nil
+}
+
+// collectClusterManifestBlobs removes the unreferenced blobs of every member and
+// returns the number of blobs removed. Compaction only runs on one member, which
+// has the other members collect their own blobs.
+func collectClusterManifestBlobs(ctx context.Context, s *state.State) (int, error) {
+	removed, err := CollectManifestBlobs(ctx, s)
+	if err != nil {
+		return removed, err
+	}
+
+	var members []cluster.InternalClusterMember
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		members, err = cluster.GetInternalClusterMembers(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	for _, member := range members {
+		if member.Name == s.Name() {
+			continue
+		}
+
+		n, err := collectMemberManifestBlobs(ctx, s, member.Name)
+		if err != nil {
+			// An unreachable member collects its blobs on a later compaction.
+			logger.Warn("Failed to collect manifest blobs", logger.Ctx{"member": member.Name, "err": err})
+			continue
+		}
+
+		removed += n
+	}
+
+	return removed, nil
+}
+
+// CollectManifestBlobs removes the blobs of this member no longer referenced by any
+// manifest and returns the number of blobs removed.
+func CollectManifestBlobs(ctx context.Context, s *state.State) (int, error) {
+	dir := filepath.Join(s.OS.StateDir, manifestBlobDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("Failed to list manifest blobs: %w", err)
+	}
+
+	var hashes []string
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		hashes, err = database.GetManifestItemDataHashes(ctx, tx, s.Name())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if slices.Contains(hashes, entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < manifestBlobGrace {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(dir, entry.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("Failed to remove manifest blob: %w", err)
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
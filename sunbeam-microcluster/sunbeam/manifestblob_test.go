@@ -0,0 +1,239 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// withStateDir gives the state a state directory of its own. The type of the
+// OS field is internal to microcluster, so it is set through reflection.
+func withStateDir(t *testing.T, s *state.State) {
+	t.Helper()
+
+	fs := reflect.New(reflect.TypeOf(s.OS).Elem())
+	fs.Elem().FieldByName("StateDir").SetString(t.TempDir())
+	reflect.ValueOf(s).Elem().FieldByName("OS").Set(fs)
+}
+
+// largeManifest returns manifest data above the blob threshold set by setBlobThreshold
+func largeManifest(name string) string {
+	return `{"` + name + `": "` + strings.Repeat("x", 64) + `"}`
+}
+
+func setBlobThreshold(t *testing.T, s *state.State) {
+	t.Helper()
+
+	err := CreateConfig(context.Background(), s, manifestBlobThresholdSetting, "32", "", "test")
+	if err != nil {
+		t.Fatalf("Failed to set the blob threshold: %v", err)
+	}
+}
+
+// getManifestRecord returns the database record of the manifest
+func getManifestRecord(t *testing.T, s *state.State, manifestid string) database.ManifestItem {
+	t.Helper()
+
+	var record *database.ManifestItem
+	err := transaction(context.Background(), s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		record, err = database.GetManifestItem(ctx, tx, manifestid)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to get manifest %q: %v", manifestid, err)
+	}
+
+	return *record
+}
+
+// ageManifestBlobs makes the blobs of the state old enough to be collected
+func ageManifestBlobs(t *testing.T, s *state.State) {
+	t.Helper()
+
+	dir := filepath.Join(s.OS.StateDir, manifestBlobDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list manifest blobs: %v", err)
+	}
+
+	old := time.Now().Add(-2 * manifestBlobGrace)
+	for _, entry := range entries {
+		err := os.Chtimes(filepath.Join(dir, entry.Name()), old, old)
+		if err != nil {
+			t.Fatalf("Failed to age manifest blob: %v", err)
+		}
+	}
+}
+
+func TestManifestBlobs(t *testing.T) {
+	s := newTestState(t)
+	withStateDir(t, s)
+	setBlobThreshold(t, s)
+
+	tests := []struct {
+		name string
+		data string
+		blob bool
+	}{
+		{name: "small", data: `{"small": "x"}`},
+		{name: "large", data: largeManifest("large"), blob: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AddManifest(context.Background(), s, tt.name, tt.data, "", "test", "", "", "", nil)
+			if err != nil {
+				t.Fatalf("Failed to add manifest: %v", err)
+			}
+
+			record := getManifestRecord(t, s, tt.name)
+			if tt.blob != (record.DataHash != "") {
+				t.Errorf("Manifest stored as a blob is %v, expected %v", record.DataHash != "", tt.blob)
+			}
+
+			if tt.blob && (record.Data != "" || record.DataMember != "member1") {
+				t.Errorf("Blob manifest recorded data %q held by %q, expected no data held by member1", record.Data, record.DataMember)
+			}
+
+			manifest, err := GetManifest(context.Background(), s, tt.name)
+			if err != nil {
+				t.Fatalf("Failed to get manifest: %v", err)
+			}
+
+			if manifest.Data != tt.data {
+				t.Errorf("Manifest data is %q, expected %q", manifest.Data, tt.data)
+			}
+		})
+	}
+}
+
+func TestCollectManifestBlobs(t *testing.T) {
+	s := newTestState(t)
+	withStateDir(t, s)
+	setBlobThreshold(t, s)
+
+	for _, manifestid := range []string{"kept", "deleted"} {
+		_, err := AddManifest(context.Background(), s, manifestid, largeManifest(manifestid), "", "test", "", "", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to add manifest %q: %v", manifestid, err)
+		}
+	}
+
+	_, err := DeleteManifest(context.Background(), s, "deleted", false)
+	if err != nil {
+		t.Fatalf("Failed to delete manifest: %v", err)
+	}
+
+	// Unreferenced blobs are kept until they are older than the grace period.
+	removed, err := CollectManifestBlobs(context.Background(), s)
+	if err != nil || removed != 0 {
+		t.Fatalf("Collected %d fresh blobs (%v), expected none", removed, err)
+	}
+
+	ageManifestBlobs(t, s)
+	removed, err = CollectManifestBlobs(context.Background(), s)
+	if err != nil || removed != 1 {
+		t.Fatalf("Collected %d blobs (%v), expected 1", removed, err)
+	}
+
+	manifest, err := GetManifest(context.Background(), s, "kept")
+	if err != nil || manifest.Data != largeManifest("kept") {
+		t.Errorf("Referenced blob was not kept: %q (%v)", manifest.Data, err)
+	}
+}
+
+func TestManifestBlobsTwoMembers(t *testing.T) {
+	s1 := newTestState(t)
+	withStateDir(t, s1)
+	setBlobThreshold(t, s1)
+
+	// Both members share the database of the test, each with its own state directory.
+	s2 := &state.State{Context: context.Background(), Name: func() string { return "member2" }}
+	withStateDir(t, s2)
+
+	err := transaction(context.Background(), s1, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO internal_cluster_members (name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES ('member2', '10.0.0.2:7000', 'cert2', 1, 1, '2024-01-01T00:00:00Z', 'voter')`)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to add member2: %v", err)
+	}
+
+	// Requests to the other member are served by its state, as microcluster would.
+	members := map[string]*state.State{"member1": s1, "member2": s2}
+	fetch, collect := fetchManifestBlob, collectMemberManifestBlobs
+	t.Cleanup(func() { fetchManifestBlob, collectMemberManifestBlobs = fetch, collect })
+
+	fetched := 0
+	fetchManifestBlob = func(_ context.Context, _ *state.State, member string, hash string) (string, error) {
+		fetched++
+		return GetManifestBlob(members[member], hash)
+	}
+
+	collectMemberManifestBlobs = func(ctx context.Context, _ *state.State, member string) (int, error) {
+		return CollectManifestBlobs(ctx, members[member])
+	}
+
+	data := largeManifest("remote")
+	_, err = AddManifest(context.Background(), s2, "remote", data, "", "test", "", "", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to add manifest on member2: %v", err)
+	}
+
+	manifest, err := GetManifest(context.Background(), s1, "remote")
+	if err != nil || manifest.Data != data {
+		t.Fatalf("Manifest read on member1 is %q (%v), expected %q", manifest.Data, err, data)
+	}
+
+	matches, err := SearchManifests(context.Background(), s1, "remote", -1)
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("Search on member1 returned %v (%v), expected the manifest of member2", matches, err)
+	}
+
+	if fetched != 2 {
+		t.Errorf("Blob was fetched %d times from member2, expected 2", fetched)
+	}
+
+	// The compaction on member1 collects the blobs of member2.
+	_, err = DeleteManifest(context.Background(), s1, "remote", false)
+	if err != nil {
+		t.Fatalf("Failed to delete manifest: %v", err)
+	}
+
+	ageManifestBlobs(t, s2)
+	removed, err := collectClusterManifestBlobs(context.Background(), s1)
+	if err != nil || removed != 1 {
+		t.Fatalf("Collected %d blobs (%v), expected the blob of member2", removed, err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(s2.OS.StateDir, manifestBlobDir))
+	if len(entries) != 0 {
+		t.Errorf("Blobs of member2 were not collected: %v", entries)
+	}
+}
+
+func TestGetManifestBlobInvalidHash(t *testing.T) {
+	s := newTestState(t)
+	withStateDir(t, s)
+
+	for _, hash := range []string{"", "../database", strings.Repeat("z", 64)} {
+		_, err := GetManifestBlob(s, hash)
+		if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+			t.Errorf("GetManifestBlob(%q) error = %v, expected 400", hash, err)
+		}
+	}
+}
@@ -3,31 +3,77 @@ package sunbeam
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/state"
+	"gopkg.in/yaml.v2"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
+const manifestTagPrefix = "manifesttag-"
+
+// generatedManifestIDPrefix prefixes the manifest ids assigned by the daemon.
+// It is followed by a zero padded nanosecond timestamp so that the ids sort
+// in the order the manifests were added.
+const generatedManifestIDPrefix = "manifest-"
+
+// Outcomes of the manifest applications reported by the clients.
+const (
+	ManifestStatusSucceeded = "succeeded"
+	ManifestStatusFailed    = "failed"
+)
+
+// manifestStatuses are the outcomes a manifest application can be recorded with.
+var manifestStatuses = []string{ManifestStatusSucceeded, ManifestStatusFailed}
+
+// checkManifestStatus returns an error unless status is empty or one of the manifestStatuses.
+func checkManifestStatus(status string) error {
+	if status != "" && !slices.Contains(manifestStatuses, status) {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid manifest status %q, expected one of %s", status, strings.Join(manifestStatuses, ", "))
+	}
+
+	return nil
+}
+
 // ListManifests return all the manifests
-func ListManifests(s *state.State) (types.Manifests, error) {
-	manifests := types.Manifests{}
+func ListManifests(ctx context.Context, s *state.State) (types.Manifests, error) {
+	var records []database.ManifestItem
 
 	// Get the manifests from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		records, err := database.GetManifestItems(ctx, tx)
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		records, err = database.GetManifestItems(ctx, tx)
 		if err != nil {
 			return fmt.Errorf("Failed to fetch manifests: %w", err)
 		}
 
-		for _, manifest := range records {
-			manifests = append(manifests, types.Manifest{
-				ManifestID:  manifest.ManifestID,
-				AppliedDate: manifest.AppliedDate,
-				Data:        manifest.Data,
-			})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return manifestsFromRecords(ctx, s, records)
+}
+
+// ListRecentManifests returns the n most recent manifests, newest first
+func ListRecentManifests(ctx context.Context, s *state.State, n int) (types.Manifests, error) {
+	var records []database.ManifestItem
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		records, err = database.GetRecentManifestItems(ctx, tx, n)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch manifests: %w", err)
 		}
 
 		return nil
@@ -36,67 +82,641 @@ func ListManifests(s *state.State) (types.Manifests, error) {
 		return nil, err
 	}
 
-	return manifests, nil
+	return manifestsFromRecords(ctx, s, records)
 }
 
-// GetManifest returns a Manifest with the given id
-func GetManifest(s *state.State, manifestid string) (types.Manifest, error) {
-	manifest := types.Manifest{}
+// ListManifestsByStatus returns the n most recent manifests with the given status, newest first.
+// A negative n returns all the manifests with the status.
+func ListManifestsByStatus(ctx context.Context, s *state.State, status string, n int) (types.Manifests, error) {
+	if status == "" {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Manifest status must be specified")
+	}
 
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		var record *database.ManifestItem
+	err := checkManifestStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []database.ManifestItem
+
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
 		var err error
-		// If manifest id is latest, retrieve the latest inserted record.
-		if manifestid == "latest" {
-			record, err = database.GetLatestManifestItem(ctx, tx)
-		} else {
-			record, err = database.GetManifestItem(ctx, tx, manifestid)
+		records, err = database.GetManifestItemsByStatus(ctx, tx, status, n)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch manifests: %w", err)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return manifestsFromRecords(ctx, s, records)
+}
+
+// Context kept around each occurrence of a search term, and maximum number of
+// snippets returned per manifest.
+const (
+	manifestSnippetContext = 40
+	manifestSnippetMax     = 5
+)
+
+// SearchManifests returns at most limit manifests whose data contains text, newest first.
+// A negative limit returns all the matching manifests.
+func SearchManifests(ctx context.Context, s *state.State, text string, limit int) ([]types.ManifestMatch, error) {
+	var records []database.ManifestItem
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		// The manifests with their data in a blob are searched below, so the limit
+		// is applied once their data has been checked.
+		var err error
+		records, err = database.SearchManifestItems(ctx, tx, text, -1)
+		if err != nil {
+			return fmt.Errorf("Failed to search manifests: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []types.ManifestMatch{}
+	for _, record := range records {
+		if limit >= 0 && len(matches) >= limit {
+			break
+		}
+
+		data, err := manifestRecordData(ctx, s, record)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.Contains(data, text) {
+			continue
+		}
+
+		matches = append(matches, types.ManifestMatch{
+			ManifestID:  record.ManifestID,
+			AppliedDate: record.AppliedDate,
+			Snippets:    manifestSnippets(data, text),
+		})
+	}
+
+	return matches, nil
+}
+
+// manifestSnippets returns the occurrences of text in data with their surrounding context
+func manifestSnippets(data string, text string) []string {
+	snippets := []string{}
+
+	offset := 0
+	for len(snippets) < manifestSnippetMax {
+		index := strings.Index(data[offset:], text)
+		if index < 0 {
+			break
+		}
+		index += offset
+
+		start := max(index-manifestSnippetContext, 0)
+		end := min(index+len(text)+manifestSnippetContext, len(data))
+		snippets = append(snippets, strings.ToValidUTF8(data[start:end], ""))
+
+		offset = index + len(text)
+	}
+
+	return snippets
+}
+
+// GetManifest returns a Manifest with the given id
+func GetManifest(ctx context.Context, s *state.State, manifestid string) (types.Manifest, error) {
+	var record *database.ManifestItem
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		record, err = resolveManifest(ctx, tx, manifestid)
+		return err
+	})
+	if err != nil {
+		return types.Manifest{}, err
+	}
+
+	return manifestFromRecord(ctx, s, *record)
+}
+
+// ListManifestNodes returns the nodes last configured by the manifest with the given id
+func ListManifestNodes(ctx context.Context, s *state.State, manifestid string) (types.Nodes, error) {
+	nodes := types.Nodes{}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		manifest, err := resolveManifest(ctx, tx, manifestid)
 		if err != nil {
 			return err
 		}
 
-		manifest.ManifestID = record.ManifestID
-		manifest.AppliedDate = record.AppliedDate
-		manifest.Data = record.Data
+		records, err := database.GetNodesByLastManifestID(ctx, tx, manifest.ManifestID)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch nodes: %w", err)
+		}
+
+		for _, record := range records {
+			node, err := nodeFromRecord(record)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
 
-	return manifest, err
+// resolveManifest returns the manifest record with the given id.
+// The id can also be "latest" or a manifest tag.
+func resolveManifest(ctx context.Context, tx *sql.Tx, manifestid string) (*database.ManifestItem, error) {
+	// If manifest id is latest, retrieve the latest inserted record.
+	if manifestid == "latest" {
+		return database.GetLatestManifestItem(ctx, tx)
+	}
+
+	record, err := database.GetManifestItem(ctx, tx, manifestid)
+	// Fall back to resolving manifestid as a tag.
+	if api.StatusErrorCheck(err, http.StatusNotFound) {
+		tag, tagErr := database.GetConfigItem(ctx, tx, manifestTagPrefix+manifestid)
+		if tagErr == nil {
+			return database.GetManifestItem(ctx, tx, tag.Value)
+		}
+	}
+
+	return record, err
+}
+
+// ParseManifestAppliedDate returns the applied date of a manifest as read from the database,
+// which is either an RFC 3339 time or the UTC date and time of CURRENT_TIMESTAMP.
+func ParseManifestAppliedDate(appliedDate string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime} {
+		applied, err := time.Parse(layout, appliedDate)
+		if err == nil {
+			return applied.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid manifest applied date %q", appliedDate)
 }
 
-// AddManifest adds a manifest to the database
-func AddManifest(s *state.State, manifestid string, data string) error {
+// AddManifest adds a manifest to the database and returns its id.
+// An id is assigned if manifestid is empty, the assigned ids sort in the order the manifests were added.
+// The oldest manifests beyond the manifestMaxSetting are dropped, or the manifest is refused
+// if the manifestMaxStrictSetting is set.
+// If parent is not empty, the manifest is only added if parent is the latest manifest.
+// startedAt and finishedAt are the optional RFC 3339 times the manifest application started and finished,
+// status is its optional outcome. If afterDate is set, the manifest is only added if the latest manifest
+// was not applied after it.
+func AddManifest(ctx context.Context, s *state.State, manifestid string, data string, parent string, createdBy string, startedAt string, finishedAt string, status string, afterDate *time.Time) (string, error) {
+	if strings.HasPrefix(manifestid, generatedManifestIDPrefix) {
+		return "", api.StatusErrorf(http.StatusBadRequest, "Manifest ids starting with %q are reserved for the assigned ids", generatedManifestIDPrefix)
+	}
+
+	_, err := applyDuration(startedAt, finishedAt)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkManifestStatus(status)
+	if err != nil {
+		return "", err
+	}
+
+	canonicalize, err := getBoolSetting(ctx, s, manifestCanonicalizeSetting, false)
+	if err != nil {
+		return "", err
+	}
+
+	if canonicalize {
+		data, err = canonicalManifestData(data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Large data is kept out of the replicated database.
+	inlineData, dataHash, err := storeManifestData(ctx, s, data)
+	if err != nil {
+		return "", err
+	}
+
+	dataMember := ""
+	if dataHash != "" {
+		dataMember = s.Name()
+	}
+
+	maxManifests, err := getIntSetting(ctx, s, manifestMaxSetting, 0)
+	if err != nil {
+		return "", err
+	}
+
+	strict, err := getBoolSetting(ctx, s, manifestMaxStrictSetting, false)
+	if err != nil {
+		return "", err
+	}
+
+	assignedID := manifestid
+
 	// Add manifest to the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateManifestItem(ctx, tx, database.ManifestItem{ManifestID: manifestid, Data: data})
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		if manifestid == "" {
+			var err error
+			assignedID, err = generateManifestID(ctx, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if parent != "" {
+			latest, err := database.GetLatestManifestItem(ctx, tx)
+			if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+				return err
+			}
+
+			if latest == nil || latest.ManifestID != parent {
+				return api.StatusErrorf(http.StatusConflict, "Manifest %q is not the latest manifest", parent)
+			}
+		}
+
+		if afterDate != nil {
+			latest, err := database.GetLatestManifestItem(ctx, tx)
+			if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+				return err
+			}
+
+			if latest != nil {
+				applied, err := ParseManifestAppliedDate(latest.AppliedDate)
+				if err != nil {
+					return err
+				}
+
+				if applied.After(*afterDate) {
+					return api.StatusErrorf(http.StatusPreconditionFailed, "Latest manifest %q was applied at %s, after %s", latest.ManifestID, applied.Format(time.RFC3339Nano), afterDate.UTC().Format(time.RFC3339Nano))
+				}
+			}
+		}
+
+		_, err := database.CreateManifestItem(ctx, tx, database.ManifestItem{ManifestID: assignedID, Data: inlineData, DataHash: dataHash, DataMember: dataMember, CreatedBy: createdBy, StartedAt: startedAt, FinishedAt: finishedAt, Status: status})
 		if err != nil {
 			return fmt.Errorf("Failed to record manifest: %w", err)
 		}
 
+		if maxManifests <= 0 {
+			return nil
+		}
+
+		// The new manifest is the most recent one, so it is never dropped.
+		ids, err := database.GetRecentManifestItemIDs(ctx, tx, -1)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) <= maxManifests {
+			return nil
+		}
+
+		if strict {
+			return api.StatusErrorf(http.StatusConflict, "Manifest limit of %d reached", maxManifests)
+		}
+
+		for _, id := range ids[maxManifests:] {
+			err = database.DeleteManifestItem(ctx, tx, id)
+			if err != nil {
+				return fmt.Errorf("Failed to drop manifest %q: %w", id, err)
+			}
+
+			_, err = deleteManifestTags(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return assignedID, nil
+}
+
+// canonicalManifestData re-serializes the manifest YAML with its keys sorted, so that
+// manifests holding the same values are stored the same way whatever their formatting.
+func canonicalManifestData(data string) (string, error) {
+	if strings.TrimSpace(data) == "" {
+		return data, nil
+	}
+
+	var doc any
+	err := yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return "", api.StatusErrorf(http.StatusBadRequest, "Manifest data is not valid YAML: %v", err)
+	}
+
+	canonical, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("Failed to serialize manifest data: %w", err)
+	}
+
+	return string(canonical), nil
+}
+
+// generateManifestID returns a new manifest id greater than all the ids assigned before.
+// The timestamp of the id is bumped past the last assigned one if the clock has not
+// moved on, or moved backwards, since it was assigned.
+func generateManifestID(ctx context.Context, tx *sql.Tx) (string, error) {
+	next := time.Now().UnixNano()
+
+	last, err := database.GetLastManifestItemIDWithPrefix(ctx, tx, generatedManifestIDPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	if last != "" {
+		lastNano, err := strconv.ParseInt(strings.TrimPrefix(last, generatedManifestIDPrefix), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("Invalid assigned manifest id %q: %w", last, err)
+		}
+
+		next = max(next, lastNano+1)
+	}
+
+	return fmt.Sprintf("%s%020d", generatedManifestIDPrefix, next), nil
 }
 
-// DeleteManifest deletes a manifest from database
-func DeleteManifest(s *state.State, manifestid string) error {
+// DeleteManifest deletes a manifest from database.
+// Deleting a manifest that does not exist succeeds without deleting anything.
+func DeleteManifest(ctx context.Context, s *state.State, manifestid string, dryRun bool) (types.DeletePlan, error) {
+	var plan types.DeletePlan
+
 	// Delete manifest from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err := transactionOrDryRun(ctx, s, dryRun, func(ctx context.Context, tx *sql.Tx) error {
+		plan = types.DeletePlan{Deleted: []string{"manifests/" + manifestid}, Affected: []string{}}
+
 		err := database.DeleteManifestItem(ctx, tx, manifestid)
 		if err != nil {
+			// The manifest is already gone, e.g. deleted by a concurrent request.
+			// Like for the terraform locks, deleting it again succeeds.
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				plan.Deleted = []string{}
+				return nil
+			}
+
 			return fmt.Errorf("Failed to delete manifest: %w", err)
 		}
 
+		// The nodes last configured by the manifest keep referencing it.
+		nodes, err := database.GetNodesByLastManifestID(ctx, tx, manifestid)
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			plan.Affected = append(plan.Affected, "nodes/"+node.Name)
+		}
+
+		// Drop the tags pointing to the deleted manifest.
+		tags, err := deleteManifestTags(ctx, tx, manifestid)
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range tags {
+			plan.Deleted = append(plan.Deleted, fmt.Sprintf("manifests/%s/tags/%s", manifestid, tag))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return types.DeletePlan{}, err
+	}
+
+	return plan, nil
+}
+
+// deleteManifestTags deletes the tags pointing to the manifest with the given id and returns their names
+func deleteManifestTags(ctx context.Context, tx *sql.Tx, manifestid string) ([]string, error) {
+	prefix := manifestTagPrefix
+	tagKeys, err := database.GetConfigItemKeys(ctx, tx, &prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, tagKey := range tagKeys {
+		tag, err := database.GetConfigItem(ctx, tx, tagKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if tag.Value != manifestid {
+			continue
+		}
+
+		err = database.DeleteConfigItem(ctx, tx, tagKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to delete manifest tag: %w", err)
+		}
+		tags = append(tags, strings.TrimPrefix(tagKey, manifestTagPrefix))
+	}
+
+	return tags, nil
+}
+
+// TagManifest points the tag to the manifest with the given id.
+// A tag already pointing to another manifest is reassigned.
+func TagManifest(ctx context.Context, s *state.State, manifestid string, tag string, createdBy string) error {
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetManifestItem(ctx, tx, manifestid)
+		if err != nil {
+			return err
+		}
+
+		tagKey := manifestTagPrefix + tag
+		exists, err := database.ConfigItemExists(ctx, tx, tagKey)
+		if err != nil {
+			return err
+		}
+
+		configItem := database.ConfigItem{Key: tagKey, Value: manifestid, CreatedBy: createdBy}
+		if exists {
+			err = database.UpdateConfigItem(ctx, tx, tagKey, configItem)
+		} else {
+			_, err = database.CreateConfigItem(ctx, tx, configItem)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to record manifest tag: %w", err)
+		}
+
 		return nil
 	})
+}
+
+// UntagManifest removes the tag from the manifest with the given id
+func UntagManifest(ctx context.Context, s *state.State, manifestid string, tag string) error {
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		tagKey := manifestTagPrefix + tag
+		record, err := database.GetConfigItem(ctx, tx, tagKey)
+		if err != nil {
+			return api.StatusErrorf(http.StatusNotFound, "Manifest tag %q not found", tag)
+		}
+
+		if record.Value != manifestid {
+			return api.StatusErrorf(http.StatusNotFound, "Manifest tag %q does not point to manifest %q", tag, manifestid)
+		}
+
+		return database.DeleteConfigItem(ctx, tx, tagKey)
+	})
+}
+
+// GetManifestStats returns the average and longest durations of the manifest applications
+func GetManifestStats(ctx context.Context, s *state.State) (types.ManifestStats, error) {
+	var stats types.ManifestStats
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetManifestItems(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch manifests: %w", err)
+		}
+
+		stats, err = manifestStats(records)
+		return err
+	})
 	if err != nil {
+		return types.ManifestStats{}, err
+	}
+
+	return stats, nil
+}
+
+// manifestStats returns the stats of the applications of the manifest records
+// reporting both their start and finish times.
+func manifestStats(records []database.ManifestItem) (types.ManifestStats, error) {
+	var stats types.ManifestStats
+	var total time.Duration
+	var longest time.Duration
+
+	for _, record := range records {
+		if record.StartedAt == "" || record.FinishedAt == "" {
+			continue
+		}
+
+		duration, err := applyDuration(record.StartedAt, record.FinishedAt)
+		if err != nil {
+			return types.ManifestStats{}, err
+		}
+
+		stats.Count++
+		total += duration
+		if stats.MaxManifestID == "" || duration > longest {
+			longest = duration
+			stats.MaxManifestID = record.ManifestID
+		}
+
+		if record.Status == ManifestStatusFailed {
+			stats.Failed++
+		}
+	}
+
+	if stats.Count > 0 {
+		stats.AverageSeconds = total.Seconds() / float64(stats.Count)
+		stats.MaxSeconds = longest.Seconds()
+	}
+
+	return stats, nil
+}
+
+// applyDuration parses the optional RFC 3339 start and finish times of a manifest
+// application and returns its duration, zero unless both times are given.
+func applyDuration(startedAt string, finishedAt string) (time.Duration, error) {
+	var started, finished time.Time
+	var err error
+
+	if startedAt != "" {
+		started, err = time.Parse(time.RFC3339Nano, startedAt)
+		if err != nil {
+			return 0, api.StatusErrorf(http.StatusBadRequest, "Invalid startedat time %q, expected RFC 3339", startedAt)
+		}
+	}
+
+	if finishedAt != "" {
+		finished, err = time.Parse(time.RFC3339Nano, finishedAt)
+		if err != nil {
+			return 0, api.StatusErrorf(http.StatusBadRequest, "Invalid finishedat time %q, expected RFC 3339", finishedAt)
+		}
+	}
+
+	if startedAt == "" || finishedAt == "" {
+		return 0, nil
+	}
+
+	if finished.Before(started) {
+		return 0, api.StatusErrorf(http.StatusBadRequest, "Manifest application finished at %s before it started at %s", finishedAt, startedAt)
+	}
+
+	return finished.Sub(started), nil
+}
+
+// ExportManifestIDs returns the ids of the n most recent manifests, oldest first,
+// for ExportManifests to export. All the ids are returned if n is negative. They
+// are listed up front so that an export failing to list them is refused before
+// any of it is sent.
+func ExportManifestIDs(ctx context.Context, s *state.State, n int) ([]string, error) {
+	var ids []string
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		ids, err = database.GetRecentManifestItemIDs(ctx, tx, n)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(ids)
+
+	return ids, nil
+}
+
+// ExportManifests calls export with each of the manifests with the given ids, in order.
+// The manifests are fetched one at a time so that large histories are never held in
+// memory, those deleted meanwhile are skipped.
+func ExportManifests(ctx context.Context, s *state.State, ids []string, export func(manifest types.Manifest) error) error {
+	for _, id := range ids {
+		var record *database.ManifestItem
+
+		err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+			var err error
+			record, err = database.GetManifestItem(ctx, tx, id)
+			return err
+		})
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		manifest, err := manifestFromRecord(ctx, s, *record)
+		if err != nil {
+			return err
+		}
+
+		err = export(manifest)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,268 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestCheckManifestStatus(t *testing.T) {
+	tests := []struct {
+		status  string
+		wantErr bool
+	}{
+		{status: ""},
+		{status: ManifestStatusSucceeded},
+		{status: ManifestStatusFailed},
+		{status: "pending", wantErr: true},
+		{status: "FAILED", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			err := checkManifestStatus(tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkManifestStatus(%q) error = %v, wantErr %v", tt.status, err, tt.wantErr)
+			}
+
+			if err != nil && !api.StatusErrorCheck(err, http.StatusBadRequest) {
+				t.Errorf("checkManifestStatus(%q) = %v, expected a bad request", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestManifestStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []database.ManifestItem
+		stats   types.ManifestStats
+		wantErr bool
+	}{
+		{name: "empty"},
+		{
+			name: "seeded",
+			records: []database.ManifestItem{
+				{ManifestID: "m1", StartedAt: "2024-05-01T10:00:00Z", FinishedAt: "2024-05-01T10:01:00Z", Status: ManifestStatusSucceeded},
+				{ManifestID: "m2", StartedAt: "2024-05-02T10:00:00Z", FinishedAt: "2024-05-02T10:03:00Z", Status: ManifestStatusFailed},
+				{ManifestID: "m3", StartedAt: "2024-05-03T10:00:00Z", FinishedAt: "2024-05-03T10:02:00Z"},
+				// Applications missing one of their times are not counted.
+				{ManifestID: "m4", StartedAt: "2024-05-04T10:00:00Z", Status: ManifestStatusFailed},
+				{ManifestID: "m5"},
+			},
+			stats: types.ManifestStats{Count: 3, AverageSeconds: 120, MaxSeconds: 180, MaxManifestID: "m2", Failed: 1},
+		},
+		{
+			name: "first longest kept",
+			records: []database.ManifestItem{
+				{ManifestID: "m1", StartedAt: "2024-05-01T10:00:00Z", FinishedAt: "2024-05-01T10:00:30Z"},
+				{ManifestID: "m2", StartedAt: "2024-05-02T10:00:00Z", FinishedAt: "2024-05-02T10:00:30Z"},
+			},
+			stats: types.ManifestStats{Count: 2, AverageSeconds: 30, MaxSeconds: 30, MaxManifestID: "m1"},
+		},
+		{
+			name: "broken times",
+			records: []database.ManifestItem{
+				{ManifestID: "m1", StartedAt: "yesterday", FinishedAt: "2024-05-01T10:00:30Z"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := manifestStats(tt.records)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("manifestStats error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if stats != tt.stats {
+				t.Errorf("manifestStats = %+v, expected %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestApplyDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		startedAt  string
+		finishedAt string
+		duration   time.Duration
+		wantErr    bool
+	}{
+		{name: "unreported"},
+		{name: "started only", startedAt: "2024-05-01T10:00:00Z"},
+		{name: "both", startedAt: "2024-05-01T10:00:00Z", finishedAt: "2024-05-01T10:00:01.5Z", duration: 1500 * time.Millisecond},
+		{name: "time zones", startedAt: "2024-05-01T12:00:00+02:00", finishedAt: "2024-05-01T10:01:00Z", duration: time.Minute},
+		{name: "finished before started", startedAt: "2024-05-01T10:00:00Z", finishedAt: "2024-05-01T09:00:00Z", wantErr: true},
+		{name: "invalid start", startedAt: "2024-05-01 10:00:00", wantErr: true},
+		{name: "invalid finish", finishedAt: "now", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, err := applyDuration(tt.startedAt, tt.finishedAt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyDuration(%q, %q) error = %v, wantErr %v", tt.startedAt, tt.finishedAt, err, tt.wantErr)
+			}
+
+			if duration != tt.duration {
+				t.Errorf("applyDuration(%q, %q) = %s, expected %s", tt.startedAt, tt.finishedAt, duration, tt.duration)
+			}
+		})
+	}
+}
+
+func TestManifestSnippets(t *testing.T) {
+	long := strings.Repeat("a", 2*manifestSnippetContext)
+
+	tests := []struct {
+		name     string
+		data     string
+		text     string
+		snippets []string
+	}{
+		{name: "no match", data: "core: {}", text: "ceph", snippets: []string{}},
+		{name: "short data", data: "ceph: true", text: "ceph", snippets: []string{"ceph: true"}},
+		{name: "context", data: long + "ceph" + long, text: "ceph", snippets: []string{long[:manifestSnippetContext] + "ceph" + long[:manifestSnippetContext]}},
+		{name: "capped", data: strings.Repeat("ceph ", 2*manifestSnippetMax), text: "ceph", snippets: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets := manifestSnippets(tt.data, tt.text)
+			if tt.snippets == nil {
+				if len(snippets) != manifestSnippetMax {
+					t.Errorf("manifestSnippets returned %d snippets, expected %d", len(snippets), manifestSnippetMax)
+				}
+
+				return
+			}
+
+			if !reflect.DeepEqual(snippets, tt.snippets) {
+				t.Errorf("manifestSnippets = %q, expected %q", snippets, tt.snippets)
+			}
+		})
+	}
+}
+
+func TestAddManifestStatus(t *testing.T) {
+	s := newTestState(t)
+
+	// Steps run in order against the same database.
+	steps := []struct {
+		manifestid string
+		status     string
+		wantStatus int
+	}{
+		{manifestid: "m1", status: ManifestStatusSucceeded},
+		{manifestid: "m2", status: ManifestStatusFailed},
+		{manifestid: "m3"},
+		{manifestid: "m4", status: "pending", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, step := range steps {
+		_, err := AddManifest(context.Background(), s, step.manifestid, "{}", "", "test", "2024-05-01T10:00:00Z", "2024-05-01T10:01:00Z", step.status, nil)
+		if step.wantStatus != 0 {
+			if !api.StatusErrorCheck(err, step.wantStatus) {
+				t.Fatalf("AddManifest(%q) error = %v, expected status %d", step.manifestid, err, step.wantStatus)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("AddManifest(%q) failed: %v", step.manifestid, err)
+		}
+
+		manifest, err := GetManifest(context.Background(), s, step.manifestid)
+		if err != nil {
+			t.Fatalf("GetManifest(%q) failed: %v", step.manifestid, err)
+		}
+
+		if manifest.Status != step.status {
+			t.Errorf("Manifest %q has status %q, expected %q", step.manifestid, manifest.Status, step.status)
+		}
+	}
+
+	stats, err := GetManifestStats(context.Background(), s)
+	if err != nil {
+		t.Fatalf("GetManifestStats failed: %v", err)
+	}
+
+	if stats.Count != 3 || stats.Failed != 1 {
+		t.Errorf("GetManifestStats = %+v, expected 3 applications with 1 failed", stats)
+	}
+}
+
+func TestParseManifestAppliedDate(t *testing.T) {
+	tests := []struct {
+		appliedDate string
+		applied     time.Time
+		wantErr     bool
+	}{
+		{appliedDate: "2024-05-01 10:00:00", applied: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{appliedDate: "2024-05-01T12:00:00.5+02:00", applied: time.Date(2024, 5, 1, 10, 0, 0, 5e8, time.UTC)},
+		{appliedDate: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.appliedDate, func(t *testing.T) {
+			applied, err := ParseManifestAppliedDate(tt.appliedDate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseManifestAppliedDate(%q) error = %v, wantErr %v", tt.appliedDate, err, tt.wantErr)
+			}
+
+			if !applied.Equal(tt.applied) || (!tt.wantErr && applied.Location() != time.UTC) {
+				t.Errorf("ParseManifestAppliedDate(%q) = %v, expected %v", tt.appliedDate, applied, tt.applied)
+			}
+		})
+	}
+}
+
+func TestAddManifestAfterDate(t *testing.T) {
+	s := newTestState(t)
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	// Steps run in order against the same database.
+	steps := []struct {
+		manifestid string
+		afterDate  *time.Time
+		wantStatus int
+	}{
+		{manifestid: "m1", afterDate: &past},
+		{manifestid: "m2", afterDate: &future},
+		{manifestid: "m3", afterDate: &past, wantStatus: http.StatusPreconditionFailed},
+		{manifestid: "m4"},
+	}
+
+	for _, step := range steps {
+		_, err := AddManifest(context.Background(), s, step.manifestid, "{}", "", "test", "", "", "", step.afterDate)
+		if step.wantStatus != 0 {
+			if !api.StatusErrorCheck(err, step.wantStatus) {
+				t.Fatalf("AddManifest(%q) error = %v, expected status %d", step.manifestid, err, step.wantStatus)
+			}
+
+			_, err = GetManifest(context.Background(), s, step.manifestid)
+			if !api.StatusErrorCheck(err, http.StatusNotFound) {
+				t.Errorf("Refused manifest %q was recorded", step.manifestid)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("AddManifest(%q) failed: %v", step.manifestid, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// Config keys holding the node specific values and the role defaults of a config key.
+const (
+	nodeConfigPrefix  = "nodeconfig-"
+	nodeDefaultPrefix = "nodedefault-"
+)
+
+// Layers a node config value can be resolved from.
+const (
+	NodeConfigLayerNode   = "node"
+	NodeConfigLayerRole   = "role"
+	NodeConfigLayerGlobal = "global"
+)
+
+// GetNodeConfig resolves the effective value of a config key for a node. The
+// node specific value nodeconfig-<node>-<key> is used first, then the role
+// default nodedefault-<role>-<key> of each of the node roles in order, and
+// finally the global value <key>. The client must be allowed by the acl to
+// access the key of every layer, and the values of secrets are redacted.
+func GetNodeConfig(ctx context.Context, s *state.State, name string, key string, acl ConfigACL) (types.NodeConfig, error) {
+	node, err := GetNode(ctx, s, name)
+	if err != nil {
+		return types.NodeConfig{}, err
+	}
+
+	candidates := []types.NodeConfig{{Layer: NodeConfigLayerNode, Source: fmt.Sprintf("%s%s-%s", nodeConfigPrefix, node.Name, key)}}
+	for _, role := range node.Role {
+		candidates = append(candidates, types.NodeConfig{Layer: NodeConfigLayerRole, Source: fmt.Sprintf("%s%s-%s", nodeDefaultPrefix, role, key)})
+	}
+	candidates = append(candidates, types.NodeConfig{Layer: NodeConfigLayerGlobal, Source: key})
+
+	// All the layers are checked, so that which of them is set is not disclosed either.
+	for _, candidate := range candidates {
+		err := acl.Check(candidate.Source)
+		if err != nil {
+			return types.NodeConfig{}, err
+		}
+	}
+
+	for _, candidate := range candidates {
+		value, err := GetConfig(ctx, s, candidate.Source)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return types.NodeConfig{}, err
+		}
+
+		candidate.Key = key
+		candidate.Value = value
+		if isSecretConfigKey(key) {
+			candidate.Value = RedactedConfigValue
+		}
+
+		return candidate, nil
+	}
+
+	return types.NodeConfig{}, database.WrapStatusError(api.StatusErrorf(http.StatusNotFound, "Config key %q not set for node %q", key, name))
+}
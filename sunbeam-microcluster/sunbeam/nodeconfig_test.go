@@ -0,0 +1,67 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestGetNodeConfig(t *testing.T) {
+	s := newTestState(t)
+	addTestNodes(t, s, map[string][]string{"node1": {"compute"}})
+	createTestConfig(t, s, map[string]string{
+		"nodeconfig-node1-key1":    "node",
+		"nodedefault-compute-key1": "role",
+		"nodedefault-compute-key2": "role",
+		"key1":                     "global",
+		"key3":                     "global",
+		"daemon-ratelimit-rate":    "10",
+		tfstateStoreTokenSetting:   "secret",
+	})
+
+	untrusted := ConfigACL{}
+	trusted := ConfigACL{identity: "admin", trusted: true}
+	restricted := ConfigACL{identity: "client", trusted: true, restricted: true, patterns: []string{"key*", "nodedefault-*"}}
+
+	tests := []struct {
+		name   string
+		acl    ConfigACL
+		key    string
+		layer  string
+		value  string
+		status int
+	}{
+		{name: "node layer", acl: untrusted, key: "key1", layer: NodeConfigLayerNode, value: "node"},
+		{name: "role layer", acl: untrusted, key: "key2", layer: NodeConfigLayerRole, value: "role"},
+		{name: "global layer", acl: untrusted, key: "key3", layer: NodeConfigLayerGlobal, value: "global"},
+		{name: "unset", acl: untrusted, key: "key4", status: http.StatusNotFound},
+		{name: "untrusted setting", acl: untrusted, key: "daemon-ratelimit-rate", status: http.StatusForbidden},
+		{name: "untrusted denied", acl: ConfigACL{restricted: true}, key: "key3", status: http.StatusForbidden},
+		{name: "trusted setting", acl: trusted, key: "daemon-ratelimit-rate", layer: NodeConfigLayerGlobal, value: "10"},
+		{name: "trusted secret", acl: trusted, key: tfstateStoreTokenSetting, layer: NodeConfigLayerGlobal, value: RedactedConfigValue},
+		{name: "restricted layer", acl: restricted, key: "key3", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := GetNodeConfig(context.Background(), s, "node1", tt.key, tt.acl)
+			if tt.status != 0 {
+				if !api.StatusErrorCheck(err, tt.status) {
+					t.Fatalf("GetNodeConfig(%q) error = %v, expected status %d", tt.key, err, tt.status)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("GetNodeConfig(%q) failed: %v", tt.key, err)
+			}
+
+			if config.Layer != tt.layer || config.Value != tt.value {
+				t.Errorf("GetNodeConfig(%q) = %s %q, expected %s %q", tt.key, config.Layer, config.Value, tt.layer, tt.value)
+			}
+		})
+	}
+}
@@ -4,37 +4,108 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/state"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
-// ListNodes return all the nodes, filterable by role (Optional)
-func ListNodes(s *state.State, roles []string) (types.Nodes, error) {
+// ErrDuplicateSystemID is returned when adding a node with the system id of another node.
+var ErrDuplicateSystemID = errors.New("Duplicate system id")
+
+// NodeFilter holds the optional criteria to list nodes with
+type NodeFilter struct {
+	// Roles the nodes must all hold
+	Roles []string
+	// Labels the node metadata must all match
+	Labels map[string]string
+	// Cordoned status the nodes must have
+	Cordoned *bool
+	// Missing fields the nodes must all lack, one of systemid, machineid or role
+	Missing []string
+	// ExactRoles the nodes must hold and no other role, unset to hold any roles
+	ExactRoles []string
+	// ChangedSince is the time after which the nodes must have been last written, unset for any time
+	ChangedSince *time.Time
+	// MinCapacity is the capacity the nodes must have at least, zero values are not checked
+	MinCapacity types.NodeCapacity
+}
+
+// matches returns whether the node satisfies the criteria not applied by the database query
+func (f NodeFilter) matches(node types.Node) bool {
+	if f.Cordoned != nil && node.Cordoned != *f.Cordoned {
+		return false
+	}
+
+	if node.CPUs < f.MinCapacity.CPUs || node.Memory < f.MinCapacity.Memory || node.Disk < f.MinCapacity.Disk {
+		return false
+	}
+
+	if f.ChangedSince != nil {
+		updatedAt, err := time.Parse(time.RFC3339Nano, node.UpdatedAt)
+		if err != nil || !updatedAt.After(*f.ChangedSince) {
+			return false
+		}
+	}
+
+	// Roles are stored sorted, so the role sets are equal if their canonical forms are.
+	if f.ExactRoles != nil {
+		nodeRole, err := roleToStr(append([]string{}, node.Role...))
+		if err != nil {
+			return false
+		}
+
+		exactRole, err := roleToStr(append([]string{}, f.ExactRoles...))
+		if err != nil || nodeRole != exactRole {
+			return false
+		}
+	}
+
+	for key, value := range f.Labels {
+		nodeValue, ok := node.Metadata[key]
+		if !ok || nodeValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListNodes return all the nodes, filterable by role, labels and missing fields (Optional)
+func ListNodes(ctx context.Context, s *state.State, filter NodeFilter) (types.Nodes, error) {
 	nodes := types.Nodes{}
 
+	err := checkFilterRoles(ctx, s, filter)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get the nodes from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		records, err := database.GetNodesFromRoles(ctx, tx, roles)
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetNodesFromRolesMissing(ctx, tx, filter.Roles, filter.Missing)
 		if err != nil {
 			return fmt.Errorf("Failed to fetch nodes: %w", err)
 		}
 
-		for _, node := range records {
-			nodeRole, err := roleFromStr(node.Role)
+		for _, record := range records {
+			node, err := nodeFromRecord(record)
 			if err != nil {
 				return err
 			}
-			nodes = append(nodes, types.Node{
-				Name:      node.Name,
-				Role:      nodeRole,
-				MachineID: node.MachineID,
-				SystemID:  node.SystemID,
-			})
+			if filter.matches(node) {
+				nodes = append(nodes, node)
+			}
 		}
 
 		return nil
@@ -46,39 +117,252 @@ func ListNodes(s *state.State, roles []string) (types.Nodes, error) {
 	return nodes, nil
 }
 
-// GetNode returns a Node with the given name
-func GetNode(s *state.State, name string) (types.Node, error) {
-	node := types.Node{MachineID: -1}
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		record, err := database.GetNode(ctx, tx, name)
+// checkFilterRoles returns a bad request error if the filter is on a role missing from
+// the knownNodeRolesSetting while the strictNodeRoleFilterSetting is enabled
+func checkFilterRoles(ctx context.Context, s *state.State, filter NodeFilter) error {
+	strict, err := getBoolSetting(ctx, s, strictNodeRoleFilterSetting, false)
+	if err != nil || !strict {
+		return err
+	}
+
+	knownRoles, err := getListSetting(ctx, s, knownNodeRolesSetting, nil)
+	if err != nil || len(knownRoles) == 0 {
+		return err
+	}
+
+	for _, role := range append(append([]string{}, filter.Roles...), filter.ExactRoles...) {
+		if !slices.Contains(knownRoles, role) {
+			return api.StatusErrorf(http.StatusBadRequest, "Unknown role %q, expected one of %s", role, strings.Join(knownRoles, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ansibleUngroupedGroup is the Ansible group of the hosts without a role
+const ansibleUngroupedGroup = "ungrouped"
+
+// ListNodesByMember returns the nodes matching the filter grouped by the cluster member
+// that recorded them. Members without nodes are listed with none.
+func ListNodesByMember(ctx context.Context, s *state.State, filter NodeFilter) (types.NodesByMember, error) {
+	byMember := types.NodesByMember{}
+
+	nodes, err := ListNodes(ctx, s, filter)
+	if err != nil {
+		return byMember, err
+	}
+
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		members, err := database.GetClusterMemberNames(ctx, tx)
 		if err != nil {
 			return err
 		}
 
-		nodeRole, err := roleFromStr(record.Role)
+		for _, member := range members {
+			byMember[member] = types.Nodes{}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return byMember, err
+	}
+
+	for _, node := range nodes {
+		byMember[node.Member] = append(byMember[node.Member], node)
+	}
+
+	return byMember, nil
+}
+
+// GetAnsibleInventory returns the nodes matching the filter in the Ansible inventory format.
+// The nodes are grouped by role and their metadata are the host variables.
+func GetAnsibleInventory(ctx context.Context, s *state.State, filter NodeFilter) (types.AnsibleInventory, error) {
+	inventory := types.AnsibleInventory{Groups: map[string]types.AnsibleGroup{}, HostVars: map[string]map[string]string{}}
+
+	nodes, err := ListNodes(ctx, s, filter)
+	if err != nil {
+		return inventory, err
+	}
+
+	for _, node := range nodes {
+		groups := node.Role
+		if len(groups) == 0 {
+			groups = []string{ansibleUngroupedGroup}
+		}
+
+		for _, name := range groups {
+			group := inventory.Groups[name]
+			group.Hosts = append(group.Hosts, node.Name)
+			inventory.Groups[name] = group
+		}
+
+		inventory.HostVars[node.Name] = node.Metadata
+	}
+
+	children := make([]string, 0, len(inventory.Groups))
+	for name := range inventory.Groups {
+		children = append(children, name)
+	}
+	sort.Strings(children)
+
+	inventory.Groups["all"] = types.AnsibleGroup{Children: children}
+
+	return inventory, nil
+}
+
+// maxNodeNames bounds the names listed at once, each being a parameter of the query.
+const maxNodeNames = 256
+
+// ListNodesByNames returns the nodes with the given names, filterable by role and labels (Optional).
+// Names that do not belong to any node are returned separately.
+func ListNodesByNames(ctx context.Context, s *state.State, names []string, filter NodeFilter) (types.NodesByName, error) {
+	if len(names) > maxNodeNames {
+		return types.NodesByName{}, api.StatusErrorf(http.StatusBadRequest, "Too many node names, got %d, expected at most %d", len(names), maxNodeNames)
+	}
+
+	err := checkFilterRoles(ctx, s, filter)
+	if err != nil {
+		return types.NodesByName{}, err
+	}
+
+	var result types.NodesByName
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		result = types.NodesByName{Nodes: types.Nodes{}, Missing: []string{}}
+
+		records, err := database.GetNodesByNames(ctx, tx, names, filter.Roles, filter.Missing)
 		if err != nil {
-			return err
+			return fmt.Errorf("Failed to fetch nodes: %w", err)
+		}
+
+		found := make(map[string]bool, len(records))
+		for _, record := range records {
+			node, err := nodeFromRecord(record)
+			if err != nil {
+				return err
+			}
+			found[record.Name] = true
+			if filter.matches(node) {
+				result.Nodes = append(result.Nodes, node)
+			}
+		}
+
+		for _, name := range names {
+			if found[name] || slices.Contains(result.Missing, name) {
+				continue
+			}
+
+			// Nodes filtered out by role exist and are not reported as missing.
+			exists, err := database.NodeExists(ctx, tx, name)
+			if err != nil {
+				return fmt.Errorf("Failed to check node %q: %w", name, err)
+			}
+			if !exists {
+				result.Missing = append(result.Missing, name)
+			}
 		}
-		node.Name = record.Name
-		node.Role = nodeRole
-		node.MachineID = record.MachineID
-		node.SystemID = record.SystemID
 
 		return nil
 	})
+	if err != nil {
+		return types.NodesByName{}, err
+	}
+
+	return result, nil
+}
+
+// GetNode returns a Node with the given name
+func GetNode(ctx context.Context, s *state.State, name string) (types.Node, error) {
+	node := types.Node{MachineID: -1}
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		node, err = nodeFromRecord(*record)
+
+		return err
+	})
 
 	return node, err
 }
 
 // AddNode adds a node to the database
-func AddNode(s *state.State, name string, role []string, machineid int, systemid string) error {
+func AddNode(ctx context.Context, s *state.State, name string, role []string, machineid int, systemid string, metadata map[string]string, capacity types.NodeCapacity, createdBy string) error {
+	return addNode(ctx, s, "", name, role, machineid, systemid, metadata, capacity, createdBy)
+}
+
+// AddEnrolledNode adds a node to the database, redeeming the enrollment token in
+// the same transaction so that the token is only consumed if the node is added.
+func AddEnrolledNode(ctx context.Context, s *state.State, token string, name string, role []string, machineid int, systemid string, metadata map[string]string, capacity types.NodeCapacity, createdBy string) error {
+	if token == "" {
+		return api.StatusErrorf(http.StatusForbidden, "%w", ErrInvalidEnrollToken)
+	}
+
+	return addNode(ctx, s, token, name, role, machineid, systemid, metadata, capacity, createdBy)
+}
+
+// addNode adds a node to the database, redeeming the enrollment token if not empty
+func addNode(ctx context.Context, s *state.State, token string, name string, role []string, machineid int, systemid string, metadata map[string]string, capacity types.NodeCapacity, createdBy string) error {
+	err := checkNodeCapacity(capacity)
+	if err != nil {
+		return err
+	}
+	role, err = nodeRolesOrDefault(ctx, s, role)
+	if err != nil {
+		return err
+	}
 	nodeRole, err := roleToStr(role)
 	if err != nil {
 		return err
 	}
+	maxMetadataBytes, err := getIntSetting(ctx, s, nodeMetadataMaxBytesSetting, defaultNodeMetadataMaxBytes)
+	if err != nil {
+		return err
+	}
+	err = checkMetadataSize(metadata, maxMetadataBytes)
+	if err != nil {
+		return err
+	}
+	nodeMetadata, err := metadataToStr(metadata)
+	if err != nil {
+		return err
+	}
+	uniqueness, err := GetSystemIDUniqueness(ctx, s)
+	if err != nil {
+		return err
+	}
 	// Add node to the database.
-	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateNode(ctx, tx, database.Node{Member: s.Name(), Name: name, Role: nodeRole, MachineID: machineid, SystemID: systemid})
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		if token != "" {
+			err := redeemEnrollToken(ctx, tx, token)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The same machine must not be enrolled twice under different names.
+		if systemid != "" {
+			duplicates, err := database.GetNodesBySystemID(ctx, tx, systemid)
+			if err != nil {
+				return err
+			}
+
+			for _, duplicate := range duplicates {
+				if duplicate.Name == name {
+					continue
+				}
+
+				if uniqueness == SystemIDUniquenessEnforce {
+					return api.StatusErrorf(http.StatusConflict, "%w: system id %q is used by node %q", ErrDuplicateSystemID, systemid, duplicate.Name)
+				}
+
+				logger.Warn("Adding node with the system id of another node", logger.Ctx{"name": name, "systemid": systemid, "node": duplicate.Name})
+			}
+		}
+
+		_, err := database.CreateNodeWithRoles(ctx, tx, database.Node{Member: s.Name(), Name: name, Role: nodeRole, MachineID: machineid, SystemID: systemid, Metadata: nodeMetadata, CreatedBy: createdBy, Cpus: capacity.CPUs, Memory: capacity.Memory, Disk: capacity.Disk})
 		if err != nil {
 			return fmt.Errorf("Failed to record node: %w", err)
 		}
@@ -92,14 +376,43 @@ func AddNode(s *state.State, name string, role []string, machineid int, systemid
 	return nil
 }
 
-// UpdateNode updates a node record in the database
-func UpdateNode(s *state.State, name string, role []string, machineid int, systemid string) error {
+// nodeRolesOrDefault returns the roles of a node being added, which are the
+// defaultNodeRolesSetting roles if none is given. Nodes without any role are
+// refused if the strictNodeRolesSetting is set.
+func nodeRolesOrDefault(ctx context.Context, s *state.State, role []string) ([]string, error) {
+	if len(role) > 0 {
+		return role, nil
+	}
+
+	strict, err := getBoolSetting(ctx, s, strictNodeRolesSetting, false)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Node must have at least one role")
+	}
+
+	return getListSetting(ctx, s, defaultNodeRolesSetting, role)
+}
+
+// UpdateNode updates a node record in the database.
+// Metadata replaces the existing node metadata, or is merged into it if mergeMetadata is set.
+// The capacity values left to zero are not changed.
+func UpdateNode(ctx context.Context, s *state.State, name string, role []string, machineid int, systemid string, metadata map[string]string, mergeMetadata bool, lastManifestID string, capacity types.NodeCapacity) error {
+	err := checkNodeCapacity(capacity)
+	if err != nil {
+		return err
+	}
 	nodeRole, err := roleToStr(role)
 	if err != nil {
 		return err
 	}
+	maxMetadataBytes, err := getIntSetting(ctx, s, nodeMetadataMaxBytesSetting, defaultNodeMetadataMaxBytes)
+	if err != nil {
+		return err
+	}
 	// Update node to the database.
-	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
 		node, err := database.GetNode(ctx, tx, name)
 		if err != nil {
 			return fmt.Errorf("Failed to retrieve node details: %w", err)
@@ -114,8 +427,50 @@ func UpdateNode(s *state.State, name string, role []string, machineid int, syste
 		if systemid == "" {
 			systemid = node.SystemID
 		}
+		if capacity.CPUs == 0 {
+			capacity.CPUs = node.Cpus
+		}
+		if capacity.Memory == 0 {
+			capacity.Memory = node.Memory
+		}
+		if capacity.Disk == 0 {
+			capacity.Disk = node.Disk
+		}
+		if lastManifestID == "" {
+			lastManifestID = node.LastManifestID
+		} else {
+			_, err = database.GetManifestItem(ctx, tx, lastManifestID)
+			if err != nil {
+				if api.StatusErrorCheck(err, http.StatusNotFound) {
+					return api.StatusErrorf(http.StatusBadRequest, "Unknown manifest %q", lastManifestID)
+				}
+				return err
+			}
+		}
+
+		nodeMetadata := node.Metadata
+		if metadata != nil {
+			if mergeMetadata {
+				current, err := metadataFromStr(node.Metadata)
+				if err != nil {
+					return err
+				}
+				maps.Copy(current, metadata)
+				metadata = current
+			}
 
-		err = database.UpdateNode(ctx, tx, name, database.Node{Member: s.Name(), Name: name, Role: nodeRole, MachineID: machineid, SystemID: systemid})
+			err = checkMetadataSize(metadata, maxMetadataBytes)
+			if err != nil {
+				return err
+			}
+
+			nodeMetadata, err = metadataToStr(metadata)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = database.UpdateNodeWithRoles(ctx, tx, name, database.Node{Member: node.Member, Name: name, Role: nodeRole, MachineID: machineid, SystemID: systemid, Metadata: nodeMetadata, Cordoned: node.Cordoned, LastManifestID: lastManifestID, Cpus: capacity.CPUs, Memory: capacity.Memory, Disk: capacity.Disk})
 		if err != nil {
 			return fmt.Errorf("Failed to update record node: %w", err)
 		}
@@ -129,24 +484,508 @@ func UpdateNode(s *state.State, name string, role []string, machineid int, syste
 	return nil
 }
 
-// DeleteNode deletes a node from database
-func DeleteNode(s *state.State, name string) error {
+// PatchNode changes the fields of a node record set in the patch, leaving the others as they are.
+// Unlike UpdateNode, zero values are applied, e.g. an empty system id clears it.
+// Metadata replaces the existing node metadata, or is merged into it if mergeMetadata is set.
+func PatchNode(ctx context.Context, s *state.State, name string, patch types.NodePatch, mergeMetadata bool) error {
+	maxMetadataBytes, err := getIntSetting(ctx, s, nodeMetadataMaxBytesSetting, defaultNodeMetadataMaxBytes)
+	if err != nil {
+		return err
+	}
+
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		node, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return fmt.Errorf("Failed to retrieve node details: %w", err)
+		}
+
+		if patch.Role != nil {
+			node.Role, err = roleToStr(patch.Role)
+			if err != nil {
+				return err
+			}
+		}
+		if patch.MachineID != nil {
+			node.MachineID = *patch.MachineID
+		}
+		if patch.SystemID != nil {
+			node.SystemID = *patch.SystemID
+		}
+		if patch.Cordoned != nil {
+			node.Cordoned = *patch.Cordoned
+		}
+		if patch.CPUs != nil {
+			node.Cpus = *patch.CPUs
+		}
+		if patch.Memory != nil {
+			node.Memory = *patch.Memory
+		}
+		if patch.Disk != nil {
+			node.Disk = *patch.Disk
+		}
+		if patch.LastManifestID != nil {
+			if *patch.LastManifestID != "" {
+				_, err = database.GetManifestItem(ctx, tx, *patch.LastManifestID)
+				if err != nil {
+					if api.StatusErrorCheck(err, http.StatusNotFound) {
+						return api.StatusErrorf(http.StatusBadRequest, "Unknown manifest %q", *patch.LastManifestID)
+					}
+					return err
+				}
+			}
+			node.LastManifestID = *patch.LastManifestID
+		}
+
+		if patch.Metadata != nil {
+			metadata := patch.Metadata
+			if mergeMetadata {
+				metadata, err = metadataFromStr(node.Metadata)
+				if err != nil {
+					return err
+				}
+				maps.Copy(metadata, patch.Metadata)
+			}
+
+			err = checkMetadataSize(metadata, maxMetadataBytes)
+			if err != nil {
+				return err
+			}
+
+			node.Metadata, err = metadataToStr(metadata)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = checkNodeCapacity(types.NodeCapacity{CPUs: node.Cpus, Memory: node.Memory, Disk: node.Disk})
+		if err != nil {
+			return err
+		}
+
+		err = database.UpdateNodeWithRoles(ctx, tx, name, *node)
+		if err != nil {
+			return fmt.Errorf("Failed to update record node: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// SetNodeCordoned sets whether the node is cordoned
+func SetNodeCordoned(ctx context.Context, s *state.State, name string, cordoned bool) error {
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		node, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		node.Cordoned = cordoned
+		err = database.UpdateNodeWithRoles(ctx, tx, name, *node)
+		if err != nil {
+			return fmt.Errorf("Failed to update record node: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// DeleteNode deletes a node from database.
+// Deleting the last node holding a critical role is refused unless force is set.
+func DeleteNode(ctx context.Context, s *state.State, name string, force bool, dryRun bool) (types.DeletePlan, error) {
+	var plan types.DeletePlan
+
+	criticalRoles, err := getListSetting(ctx, s, criticalRolesSetting, defaultCriticalRoles)
+	if err != nil {
+		return plan, err
+	}
+
 	// Delete node from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		err := database.DeleteNode(ctx, tx, name)
+	err = transactionOrDryRun(ctx, s, dryRun, func(ctx context.Context, tx *sql.Tx) error {
+		plan = types.DeletePlan{Deleted: []string{"nodes/" + name}, Affected: []string{}}
+
+		if !force {
+			err := checkCriticalRoles(ctx, tx, name, criticalRoles)
+			if err != nil {
+				return err
+			}
+		}
+
+		node, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		nodeRole, err := roleFromStr(node.Role)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteNodeWithRoles(ctx, tx, name)
 		if err != nil {
 			return fmt.Errorf("Failed to delete node: %w", err)
 		}
 
+		// Report the roles no node holds anymore.
+		for _, role := range nodeRole {
+			records, err := database.GetNodesFromRoles(ctx, tx, []string{role})
+			if err != nil {
+				return fmt.Errorf("Failed to fetch nodes: %w", err)
+			}
+
+			if len(records) == 0 {
+				plan.Affected = append(plan.Affected, "roles/"+role)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return types.DeletePlan{}, err
+	}
+
+	return plan, nil
+}
+
+// checkCriticalRoles returns a conflict error if the node is the last one holding any of the critical roles
+func checkCriticalRoles(ctx context.Context, tx *sql.Tx, name string, criticalRoles []string) error {
+	node, err := database.GetNode(ctx, tx, name)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve node details: %w", err)
+	}
+
+	nodeRole, err := roleFromStr(node.Role)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range criticalRoles {
+		if !slices.Contains(nodeRole, role) {
+			continue
+		}
+
+		records, err := database.GetNodesFromRoles(ctx, tx, []string{role})
+		if err != nil {
+			return fmt.Errorf("Failed to fetch nodes: %w", err)
+		}
+
+		if len(records) <= 1 {
+			return api.StatusErrorf(http.StatusConflict, "Node %q is the last node with critical role %q", name, role)
+		}
+	}
+
+	return nil
+}
+
+// PlanNodeRoles computes the roles to add and remove for each node to reach
+// the desired roles. Nothing is written to the database.
+func PlanNodeRoles(ctx context.Context, s *state.State, desired map[string][]string) (types.NodeRolesPlan, error) {
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var plan types.NodeRolesPlan
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		// The transaction may be retried, start from an empty plan.
+		plan = types.NodeRolesPlan{}
+
+		for _, name := range names {
+			record, err := database.GetNode(ctx, tx, name)
+			if err != nil {
+				return err
+			}
+
+			delta, err := planNodeRoleDelta(name, record.Role, desired[name])
+			if err != nil {
+				return err
+			}
+
+			plan = append(plan, delta)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// planNodeRoleDelta computes the roles to add to and remove from the stored roles of a node to reach the desired roles.
+func planNodeRoleDelta(name string, stored string, desired []string) (types.NodeRolesDelta, error) {
+	current, err := roleFromStr(stored)
+	if err != nil {
+		return types.NodeRolesDelta{}, err
+	}
+
+	// Round trip the desired roles to get the same canonical form as stored roles.
+	desiredStr, err := roleToStr(desired)
+	if err != nil {
+		return types.NodeRolesDelta{}, err
+	}
+
+	wanted, err := roleFromStr(desiredStr)
+	if err != nil {
+		return types.NodeRolesDelta{}, err
+	}
+
+	return types.NodeRolesDelta{
+		Name:   name,
+		Add:    roleDifference(wanted, current),
+		Remove: roleDifference(current, wanted),
+	}, nil
+}
+
+// ReconcileNodes updates the nodes to match the authoritative inventory, keyed
+// by node name, in a single transaction. It returns the fields changed for
+// each node, nodes that were already up to date have no changed fields.
+func ReconcileNodes(ctx context.Context, s *state.State, inventory map[string]types.NodeInventory) (types.NodesReconcile, error) {
+	var changes types.NodesReconcile
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		// The transaction may be retried, start from no changes.
+		changes = types.NodesReconcile{}
+
+		for name, item := range inventory {
+			record, err := database.GetNode(ctx, tx, name)
+			if err != nil {
+				return err
+			}
+
+			changed := []string{}
+			if item.MachineID != nil && *item.MachineID != record.MachineID {
+				record.MachineID = *item.MachineID
+				changed = append(changed, "machineid")
+			}
+			if item.SystemID != nil && *item.SystemID != record.SystemID {
+				record.SystemID = *item.SystemID
+				changed = append(changed, "systemid")
+			}
+			if item.Role != nil {
+				current, err := roleFromStr(record.Role)
+				if err != nil {
+					return err
+				}
+
+				if len(roleDifference(item.Role, current)) > 0 || len(roleDifference(current, item.Role)) > 0 {
+					record.Role, err = roleToStr(item.Role)
+					if err != nil {
+						return err
+					}
+					changed = append(changed, "role")
+				}
+			}
+
+			changes[name] = changed
+			if len(changed) == 0 {
+				continue
+			}
+
+			err = database.UpdateNodeWithRoles(ctx, tx, name, *record)
+			if err != nil {
+				return fmt.Errorf("Failed to update record node: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// roleDifference returns the roles in a that are not in b
+func roleDifference(a []string, b []string) []string {
+	diff := []string{}
+	for _, role := range a {
+		if !slices.Contains(b, role) && !slices.Contains(diff, role) {
+			diff = append(diff, role)
+		}
+	}
+	return diff
+}
+
+// GetNodeBySystemID returns the node with the given MAAS system id
+func GetNodeBySystemID(ctx context.Context, s *state.State, systemid string) (types.Node, error) {
+	var record database.Node
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetNodesBySystemID(ctx, tx, systemid)
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			return api.StatusErrorf(http.StatusNotFound, "No node with system id %q", systemid)
+		}
+
+		record = records[0]
+
 		return nil
 	})
 	if err != nil {
+		return types.Node{}, err
+	}
+
+	return nodeFromRecord(record)
+}
+
+// nodeFromRecord converts a node database record to the API type
+func nodeFromRecord(record database.Node) (types.Node, error) {
+	nodeRole, err := roleFromStr(record.Role)
+	if err != nil {
+		return types.Node{}, err
+	}
+
+	nodeMetadata, err := metadataFromStr(record.Metadata)
+	if err != nil {
+		return types.Node{}, err
+	}
+
+	return types.Node{
+		Name:           record.Name,
+		Role:           nodeRole,
+		MachineID:      record.MachineID,
+		SystemID:       record.SystemID,
+		Member:         record.Member,
+		Metadata:       nodeMetadata,
+		Cordoned:       record.Cordoned,
+		LastManifestID: record.LastManifestID,
+		CreatedBy:      record.CreatedBy,
+		UpdatedAt:      record.UpdatedAt,
+		NodeCapacity:   types.NodeCapacity{CPUs: record.Cpus, Memory: record.Memory, Disk: record.Disk},
+	}, nil
+}
+
+// DeleteAllNodes deletes all the nodes in a single transaction and returns the number of nodes deleted.
+// Unlike DeleteNode, the critical roles are not checked.
+func DeleteAllNodes(ctx context.Context, s *state.State) (int, error) {
+	var count int64
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		count, err = database.DeleteAllNodes(ctx, tx)
 		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// DeleteNodesByRole deletes the nodes holding all the given roles in a single transaction
+// and returns their names. Like DeleteNode, deleting the last node holding a critical role
+// is refused unless force is set, in which case no node is deleted.
+func DeleteNodesByRole(ctx context.Context, s *state.State, roles []string, force bool) ([]string, error) {
+	if len(roles) == 0 || slices.Contains(roles, "") {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Deleting nodes by role requires non empty roles")
+	}
+
+	criticalRoles, err := getListSetting(ctx, s, criticalRolesSetting, defaultCriticalRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		deleted = []string{}
+
+		records, err := database.GetNodesFromRoles(ctx, tx, roles)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch nodes: %w", err)
+		}
+
+		for _, record := range records {
+			deleted = append(deleted, record.Name)
+		}
+
+		sort.Strings(deleted)
+
+		// The nodes are checked as they are deleted, so the last holder of a critical role is caught.
+		for _, name := range deleted {
+			if !force {
+				err := checkCriticalRoles(ctx, tx, name, criticalRoles)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := database.DeleteNodeWithRoles(ctx, tx, name)
+			if err != nil {
+				return fmt.Errorf("Failed to delete node %q: %w", name, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// checkNodeCapacity checks the capacity values of a node are not negative
+func checkNodeCapacity(capacity types.NodeCapacity) error {
+	if capacity.CPUs < 0 || capacity.Memory < 0 || capacity.Disk < 0 {
+		return api.StatusErrorf(http.StatusBadRequest, "Node capacity must not be negative")
+	}
+
+	return nil
+}
+
+// checkMetadataSize refuses metadata whose keys and values add up to more than
+// maxBytes bytes. A maxBytes of zero or less disables the check.
+func checkMetadataSize(metadata map[string]string, maxBytes int) error {
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	size := 0
+	for key, value := range metadata {
+		size += len(key) + len(value)
+	}
+
+	if size > maxBytes {
+		return api.StatusErrorf(http.StatusRequestEntityTooLarge, "Node metadata is %d bytes, the limit is %d bytes", size, maxBytes)
 	}
 
 	return nil
 }
 
+// metadataToStr converts node metadata to a JSON string
+func metadataToStr(metadata map[string]string) (string, error) {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal metadata: %w", err)
+	}
+	return string(metadataJSON), nil
+}
+
+// metadataFromStr converts a metadata JSON string to a map
+func metadataFromStr(metadataStr string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if metadataStr == "" {
+		return metadata, nil
+	}
+	err := json.Unmarshal([]byte(metadataStr), &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal metadata: %w", err)
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return metadata, nil
+}
+
 // roleToStr converts a role slice to a string sorted
 func roleToStr(role []string) (string, error) {
 	sort.Strings(role)
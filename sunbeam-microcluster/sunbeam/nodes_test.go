@@ -0,0 +1,518 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+func TestPlanNodeRoleDelta(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		desired []string
+		delta   types.NodeRolesDelta
+		wantErr bool
+	}{
+		{
+			name:    "addition",
+			stored:  `["control"]`,
+			desired: []string{"control", "compute"},
+			delta:   types.NodeRolesDelta{Name: "node1", Add: []string{"compute"}, Remove: []string{}},
+		},
+		{
+			name:    "removal",
+			stored:  `["compute","control","storage"]`,
+			desired: []string{"control"},
+			delta:   types.NodeRolesDelta{Name: "node1", Add: []string{}, Remove: []string{"compute", "storage"}},
+		},
+		{
+			name:    "addition and removal",
+			stored:  `["compute"]`,
+			desired: []string{"storage", "control"},
+			delta:   types.NodeRolesDelta{Name: "node1", Add: []string{"control", "storage"}, Remove: []string{"compute"}},
+		},
+		{
+			name:    "no-op",
+			stored:  `["compute","control"]`,
+			desired: []string{"control", "compute", "control"},
+			delta:   types.NodeRolesDelta{Name: "node1", Add: []string{}, Remove: []string{}},
+		},
+		{
+			name:    "all removed",
+			stored:  `["compute"]`,
+			desired: nil,
+			delta:   types.NodeRolesDelta{Name: "node1", Add: []string{}, Remove: []string{"compute"}},
+		},
+		{name: "broken roles", stored: "compute", desired: []string{"compute"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, err := planNodeRoleDelta("node1", tt.stored, tt.desired)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planNodeRoleDelta error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(delta, tt.delta) {
+				t.Errorf("planNodeRoleDelta = %+v, expected %+v", delta, tt.delta)
+			}
+		})
+	}
+}
+
+// addTestNodes records nodes with the given roles, keyed by node name.
+func addTestNodes(t *testing.T, s *state.State, nodes map[string][]string) {
+	t.Helper()
+
+	for name, roles := range nodes {
+		err := AddNode(context.Background(), s, name, roles, 0, "", nil, types.NodeCapacity{}, "test")
+		if err != nil {
+			t.Fatalf("Failed to add node %q: %v", name, err)
+		}
+	}
+}
+
+// nodeNames returns the names of the nodes.
+func nodeNames(nodes types.Nodes) []string {
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+
+	return names
+}
+
+func TestListNodesByNames(t *testing.T) {
+	s := newTestState(t)
+	addTestNodes(t, s, map[string][]string{"node1": {"control"}, "node2": {"compute"}})
+
+	tests := []struct {
+		name    string
+		names   []string
+		roles   []string
+		nodes   []string
+		missing []string
+	}{
+		{name: "all found", names: []string{"node1", "node2"}, nodes: []string{"node1", "node2"}, missing: []string{}},
+		{name: "some missing", names: []string{"node1", "node3", "node4", "node3"}, nodes: []string{"node1"}, missing: []string{"node3", "node4"}},
+		{name: "filtered by role", names: []string{"node1", "node2", "node3"}, roles: []string{"compute"}, nodes: []string{"node2"}, missing: []string{"node3"}},
+		{name: "no names", names: []string{}, nodes: []string{}, missing: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ListNodesByNames(context.Background(), s, tt.names, NodeFilter{Roles: tt.roles})
+			if err != nil {
+				t.Fatalf("ListNodesByNames failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(nodeNames(result.Nodes), tt.nodes) {
+				t.Errorf("Found nodes %v, expected %v", nodeNames(result.Nodes), tt.nodes)
+			}
+
+			if !reflect.DeepEqual(result.Missing, tt.missing) {
+				t.Errorf("Missing nodes %v, expected %v", result.Missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestListNodesByNamesRetried(t *testing.T) {
+	s := newTestState(t)
+	addTestNodes(t, s, map[string][]string{"node1": {"control"}})
+
+	// The transaction is retried, as dqlite does on a busy database.
+	previous := dbTransaction
+	t.Cleanup(func() { dbTransaction = previous })
+	dbTransaction = func(ctx context.Context, s *state.State, f func(context.Context, *sql.Tx) error) error {
+		err := previous(ctx, s, f)
+		if err != nil {
+			return err
+		}
+
+		return previous(ctx, s, f)
+	}
+
+	result, err := ListNodesByNames(context.Background(), s, []string{"node1", "node2"}, NodeFilter{})
+	if err != nil {
+		t.Fatalf("ListNodesByNames failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(nodeNames(result.Nodes), []string{"node1"}) || !reflect.DeepEqual(result.Missing, []string{"node2"}) {
+		t.Errorf("Retried listing found %v and missed %v, expected [node1] and [node2]", nodeNames(result.Nodes), result.Missing)
+	}
+}
+
+func TestListNodesByNamesTooMany(t *testing.T) {
+	s := newTestState(t)
+
+	names := make([]string, maxNodeNames+1)
+	for i := range names {
+		names[i] = fmt.Sprintf("node%d", i)
+	}
+
+	_, err := ListNodesByNames(context.Background(), s, names, NodeFilter{})
+	if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+		t.Errorf("Listing %d names returned %v, expected 400", len(names), err)
+	}
+
+	_, err = ListNodesByNames(context.Background(), s, names[:maxNodeNames], NodeFilter{})
+	if err != nil {
+		t.Errorf("Listing %d names failed: %v", maxNodeNames, err)
+	}
+}
+
+func TestDeleteNodeCriticalRoles(t *testing.T) {
+	s := newTestState(t)
+	addTestNodes(t, s, map[string][]string{"node1": {"control"}, "node2": {"control", "compute"}, "node3": {"compute"}})
+
+	// The steps run in order against the same nodes.
+	steps := []struct {
+		name    string
+		node    string
+		force   bool
+		blocked bool
+	}{
+		{name: "no critical role", node: "node3"},
+		{name: "critical role held elsewhere", node: "node2"},
+		{name: "last critical node", node: "node1", blocked: true},
+		{name: "forced last critical node", node: "node1", force: true},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			_, err := DeleteNode(context.Background(), s, step.node, step.force, false)
+			if step.blocked {
+				if !api.StatusErrorCheck(err, http.StatusConflict) {
+					t.Fatalf("DeleteNode(%q) error = %v, expected a conflict", step.node, err)
+				}
+			} else if err != nil {
+				t.Fatalf("DeleteNode(%q) failed: %v", step.node, err)
+			}
+
+			nodes, err := ListNodesByNames(context.Background(), s, []string{step.node}, NodeFilter{})
+			if err != nil {
+				t.Fatalf("ListNodesByNames failed: %v", err)
+			}
+
+			if (len(nodes.Nodes) == 1) != step.blocked {
+				t.Errorf("Node %q exists is %v, expected %v", step.node, len(nodes.Nodes) == 1, step.blocked)
+			}
+		})
+	}
+}
+
+func TestDeleteNodesByRole(t *testing.T) {
+	s := newTestState(t)
+	addTestNodes(t, s, map[string][]string{"node1": {"control", "compute"}, "node2": {"compute"}, "node3": {"compute-gpu"}, "node4": {"storage"}})
+
+	// The steps run in order against the same nodes.
+	steps := []struct {
+		name    string
+		roles   []string
+		force   bool
+		deleted []string
+		status  int
+		left    []string
+	}{
+		{name: "no roles", roles: []string{}, status: http.StatusBadRequest, left: []string{"node1", "node2", "node3", "node4"}},
+		{name: "empty role", roles: []string{""}, status: http.StatusBadRequest, left: []string{"node1", "node2", "node3", "node4"}},
+		{name: "unheld roles", roles: []string{"compute", "storage"}, deleted: []string{}, left: []string{"node1", "node2", "node3", "node4"}},
+		{name: "last critical node", roles: []string{"compute"}, status: http.StatusConflict, left: []string{"node1", "node2", "node3", "node4"}},
+		{name: "forced last critical node", roles: []string{"compute"}, force: true, deleted: []string{"node1", "node2"}, left: []string{"node3", "node4"}},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			deleted, err := DeleteNodesByRole(context.Background(), s, step.roles, step.force)
+			if step.status != 0 {
+				if !api.StatusErrorCheck(err, step.status) {
+					t.Fatalf("DeleteNodesByRole(%v) error = %v, expected status %d", step.roles, err, step.status)
+				}
+			} else if err != nil {
+				t.Fatalf("DeleteNodesByRole(%v) failed: %v", step.roles, err)
+			} else if !reflect.DeepEqual(deleted, step.deleted) {
+				t.Errorf("DeleteNodesByRole(%v) = %v, expected %v", step.roles, deleted, step.deleted)
+			}
+
+			// A refused delete leaves all the nodes in place.
+			nodes, err := ListNodes(context.Background(), s, NodeFilter{})
+			if err != nil {
+				t.Fatalf("ListNodes failed: %v", err)
+			}
+
+			left := nodeNames(nodes)
+			sort.Strings(left)
+			if !reflect.DeepEqual(left, step.left) {
+				t.Errorf("Nodes left are %v, expected %v", left, step.left)
+			}
+		})
+	}
+}
+
+func TestCheckMetadataSize(t *testing.T) {
+	metadata := map[string]string{"rack": "r1", "zone": "z1"}
+
+	tests := []struct {
+		name     string
+		maxBytes int
+		wantErr  bool
+	}{
+		{name: "disabled", maxBytes: 0},
+		{name: "at the limit", maxBytes: 12},
+		{name: "over the limit", maxBytes: 11, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMetadataSize(metadata, tt.maxBytes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkMetadataSize(%d) error = %v, wantErr %v", tt.maxBytes, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataFromStr(t *testing.T) {
+	tests := []struct {
+		name     string
+		str      string
+		metadata map[string]string
+		wantErr  bool
+	}{
+		{name: "empty", str: "", metadata: map[string]string{}},
+		{name: "null", str: "null", metadata: map[string]string{}},
+		{name: "values", str: `{"rack":"r1"}`, metadata: map[string]string{"rack": "r1"}},
+		{name: "not an object", str: `["r1"]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := metadataFromStr(tt.str)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("metadataFromStr(%q) error = %v, wantErr %v", tt.str, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(metadata, tt.metadata) {
+				t.Errorf("metadataFromStr(%q) = %v, expected %v", tt.str, metadata, tt.metadata)
+			}
+		})
+	}
+}
+
+func TestReconcileNodes(t *testing.T) {
+	machineID := 2
+	systemID := ""
+
+	tests := []struct {
+		name      string
+		inventory map[string]types.NodeInventory
+		changes   types.NodesReconcile
+		status    int
+	}{
+		{
+			name: "changes",
+			inventory: map[string]types.NodeInventory{
+				"node1": {MachineID: &machineID, SystemID: &systemID},
+				"node2": {Role: []string{"storage", "compute"}},
+			},
+			changes: types.NodesReconcile{"node1": {"machineid"}, "node2": {"role"}},
+		},
+		{
+			name:      "up to date",
+			inventory: map[string]types.NodeInventory{"node1": {SystemID: &systemID, Role: []string{"control"}}},
+			changes:   types.NodesReconcile{"node1": {}},
+		},
+		{
+			name: "missing node",
+			inventory: map[string]types.NodeInventory{
+				"node1": {MachineID: &machineID},
+				"node3": {MachineID: &machineID},
+			},
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			addTestNodes(t, s, map[string][]string{"node1": {"control"}, "node2": {"compute"}})
+
+			changes, err := ReconcileNodes(context.Background(), s, tt.inventory)
+			if tt.status != 0 {
+				if !api.StatusErrorCheck(err, tt.status) {
+					t.Fatalf("ReconcileNodes error = %v, expected status %d", err, tt.status)
+				}
+			} else if err != nil {
+				t.Fatalf("ReconcileNodes failed: %v", err)
+			}
+
+			if tt.status == 0 && !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("ReconcileNodes = %v, expected %v", changes, tt.changes)
+			}
+
+			node, err := GetNode(context.Background(), s, "node1")
+			if err != nil {
+				t.Fatalf("GetNode failed: %v", err)
+			}
+
+			// A failed reconcile leaves all the nodes unchanged.
+			expected := 0
+			if tt.status == 0 && tt.inventory["node1"].MachineID != nil {
+				expected = machineID
+			}
+
+			if node.MachineID != expected {
+				t.Errorf("Node machine id is %d, expected %d", node.MachineID, expected)
+			}
+
+			node, err = GetNode(context.Background(), s, "node2")
+			if err != nil {
+				t.Fatalf("GetNode failed: %v", err)
+			}
+
+			if tt.changes["node2"] != nil && !reflect.DeepEqual(node.Role, []string{"compute", "storage"}) {
+				t.Errorf("Node roles are %v after the reconcile", node.Role)
+			}
+		})
+	}
+}
+
+func TestAddNodeDuplicateSystemID(t *testing.T) {
+	tests := []struct {
+		name       string
+		uniqueness string
+		wantStatus int
+	}{
+		{name: "enforced by default", wantStatus: http.StatusConflict},
+		{name: "enforce", uniqueness: SystemIDUniquenessEnforce, wantStatus: http.StatusConflict},
+		{name: "warn", uniqueness: SystemIDUniquenessWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			if tt.uniqueness != "" {
+				createTestConfig(t, s, map[string]string{systemIDUniquenessSetting: tt.uniqueness})
+			}
+
+			err := AddNode(context.Background(), s, "node1", []string{"compute"}, 0, "system1", nil, types.NodeCapacity{}, "test")
+			if err != nil {
+				t.Fatalf("Failed to add node1: %v", err)
+			}
+
+			err = AddNode(context.Background(), s, "node2", []string{"compute"}, 0, "system1", nil, types.NodeCapacity{}, "test")
+			if tt.wantStatus != 0 {
+				if !api.StatusErrorCheck(err, tt.wantStatus) {
+					t.Fatalf("Adding node2 error = %v, expected status %d", err, tt.wantStatus)
+				}
+			} else if err != nil {
+				t.Fatalf("Failed to add node2: %v", err)
+			}
+
+			nodes, err := ListNodes(context.Background(), s, NodeFilter{})
+			if err != nil {
+				t.Fatalf("Failed to list nodes: %v", err)
+			}
+
+			expected := 2
+			if tt.wantStatus != 0 {
+				expected = 1
+			}
+
+			if len(nodes) != expected {
+				t.Errorf("Found %d nodes, expected %d", len(nodes), expected)
+			}
+		})
+	}
+}
+
+func TestListNodesChangedSince(t *testing.T) {
+	s := newTestState(t)
+
+	// since returns the current time, making sure the nodes written before it
+	// and after it do not share a time at the millisecond precision of the database.
+	since := func() time.Time {
+		time.Sleep(5 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		return now
+	}
+
+	addTestNodes(t, s, map[string][]string{"node1": {"compute"}})
+	beforeNode2 := since()
+	addTestNodes(t, s, map[string][]string{"node2": {"compute"}})
+	beforeCordon := since()
+
+	err := SetNodeCordoned(context.Background(), s, "node1", true)
+	if err != nil {
+		t.Fatalf("Failed to cordon node1: %v", err)
+	}
+
+	afterCordon := since()
+
+	tests := []struct {
+		name  string
+		since time.Time
+		nodes []string
+	}{
+		{name: "added", since: beforeNode2, nodes: []string{"node1", "node2"}},
+		{name: "updated", since: beforeCordon, nodes: []string{"node1"}},
+		{name: "unchanged", since: afterCordon, nodes: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := ListNodes(context.Background(), s, NodeFilter{ChangedSince: &tt.since})
+			if err != nil {
+				t.Fatalf("ListNodes failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(nodeNames(nodes), tt.nodes) {
+				t.Errorf("ListNodes changed since %s = %v, expected %v", tt.since, nodeNames(nodes), tt.nodes)
+			}
+		})
+	}
+}
+
+func TestCheckFilterRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		filter   NodeFilter
+		wantErr  bool
+	}{
+		{name: "not strict", settings: map[string]string{knownNodeRolesSetting: "control"}, filter: NodeFilter{Roles: []string{"storage"}}},
+		{name: "no known roles", settings: map[string]string{strictNodeRoleFilterSetting: "true"}, filter: NodeFilter{Roles: []string{"storage"}}},
+		{name: "known role", settings: map[string]string{strictNodeRoleFilterSetting: "true", knownNodeRolesSetting: "control,compute"}, filter: NodeFilter{Roles: []string{"compute"}}},
+		{name: "unknown role", settings: map[string]string{strictNodeRoleFilterSetting: "true", knownNodeRolesSetting: "control,compute"}, filter: NodeFilter{Roles: []string{"storage"}}, wantErr: true},
+		{name: "unknown exact role", settings: map[string]string{strictNodeRoleFilterSetting: "true", knownNodeRolesSetting: "control,compute"}, filter: NodeFilter{ExactRoles: []string{"control", "storage"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			createTestConfig(t, s, tt.settings)
+
+			_, err := ListNodes(context.Background(), s, tt.filter)
+			if tt.wantErr {
+				if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+					t.Errorf("ListNodes(%+v) error = %v, expected a bad request", tt.filter, err)
+				}
+			} else if err != nil {
+				t.Errorf("ListNodes(%+v) failed: %v", tt.filter, err)
+			}
+		})
+	}
+}
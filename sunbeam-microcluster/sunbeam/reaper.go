@@ -0,0 +1,114 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// reaperMu is held while a stale lock reaping runs
+var reaperMu sync.Mutex
+
+// lastReaping is the time the last stale lock reaping started
+var lastReaping time.Time
+
+// ReapLocksOnHeartbeat starts clearing the stale terraform locks in the background
+// if the reaping interval elapsed since the last reaping. Like the compaction, it
+// is run after each heartbeat so that only the dqlite leader reaps the locks.
+func ReapLocksOnHeartbeat(s *state.State) error {
+	ttl, err := getDurationSetting(s.Context, s, tflockTTLSetting, 0)
+	if err != nil {
+		return err
+	}
+
+	interval, err := getDurationSetting(s.Context, s, tflockReapIntervalSetting, defaultTflockReapInterval)
+	if err != nil {
+		return err
+	}
+
+	if ttl <= 0 || interval <= 0 || !reaperMu.TryLock() {
+		return nil
+	}
+
+	if time.Since(lastReaping) < interval {
+		reaperMu.Unlock()
+		return nil
+	}
+
+	lastReaping = time.Now()
+	go func() {
+		defer reaperMu.Unlock()
+
+		_, err := reapStaleLocks(s, ttl)
+		if err != nil {
+			logger.Warn("Failed to reap stale terraform locks", logger.Ctx{"err": err})
+		}
+	}()
+
+	return nil
+}
+
+// reapStaleLocks clears the terraform locks older than ttl and returns the plans
+// whose lock was cleared. Each lock is cleared in its own transaction, and the
+// reaping stops when the daemon shuts down.
+func reapStaleLocks(s *state.State, ttl time.Duration) ([]string, error) {
+	var keys []string
+
+	err := transaction(s.Context, s, func(ctx context.Context, tx *sql.Tx) error {
+		prefix := tflockPrefix
+		var err error
+		keys, err = database.GetConfigItemKeys(ctx, tx, &prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reaped := []string{}
+	for _, key := range keys {
+		if s.Context.Err() != nil {
+			return reaped, nil
+		}
+
+		var lock types.Lock
+		cleared := false
+
+		// The lock is checked again as it may have been released or taken meanwhile.
+		err := transaction(s.Context, s, func(ctx context.Context, tx *sql.Tx) error {
+			cleared = false
+
+			record, err := database.GetConfigItem(ctx, tx, key)
+			if err != nil {
+				return nil
+			}
+
+			err = json.Unmarshal([]byte(record.Value), &lock)
+			if err != nil || time.Since(lock.Created) <= ttl {
+				return nil
+			}
+
+			cleared = true
+			return database.DeleteConfigItem(ctx, tx, key)
+		})
+		if err != nil {
+			return reaped, err
+		}
+
+		if cleared {
+			plan := strings.TrimPrefix(key, tflockPrefix)
+			reaped = append(reaped, plan)
+			logger.Info("Cleared stale terraform lock", logger.Ctx{"plan": plan, "id": lock.ID, "who": lock.Who, "created": lock.Created})
+		}
+	}
+
+	return reaped, nil
+}
@@ -0,0 +1,54 @@
+package sunbeam
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+func TestReapStaleLocks(t *testing.T) {
+	s := newTestState(t)
+
+	lock := func(id string, age time.Duration) string {
+		value, err := json.Marshal(types.Lock{ID: id, Created: time.Now().Add(-age)})
+		if err != nil {
+			t.Fatalf("Failed to encode lock: %v", err)
+		}
+
+		return string(value)
+	}
+
+	createTestConfig(t, s, map[string]string{
+		tflockPrefix + "stale":  lock("lock1", 2*time.Hour),
+		tflockPrefix + "fresh":  lock("lock2", time.Minute),
+		tflockPrefix + "broken": "not a lock",
+	})
+
+	reaped, err := reapStaleLocks(s, time.Hour)
+	if err != nil {
+		t.Fatalf("reapStaleLocks failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(reaped, []string{"stale"}) {
+		t.Errorf("reapStaleLocks cleared %v, expected [stale]", reaped)
+	}
+
+	_, err = GetConfig(context.Background(), s, tflockPrefix+"stale")
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("Stale lock lookup error = %v, expected not found", err)
+	}
+
+	for _, plan := range []string{"fresh", "broken"} {
+		_, err = GetConfig(context.Background(), s, tflockPrefix+plan)
+		if err != nil {
+			t.Errorf("Lock of %q was cleared: %v", plan, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// GetSchema returns the applied schema version and the known schema extensions
+func GetSchema(ctx context.Context, s *state.State) (types.Schema, error) {
+	schema := types.Schema{Extensions: database.SchemaExtensionNames()}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		version, err := database.GetSchemaVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		schema.Version = version
+
+		return nil
+	})
+
+	return schema, err
+}
+
+// VerifySchema checks that all the schema extensions are applied and that the
+// tables they create hold the expected columns, reporting each discrepancy.
+func VerifySchema(ctx context.Context, s *state.State) (types.SchemaVerify, error) {
+	verify := types.SchemaVerify{Expected: len(database.SchemaExtensions), Discrepancies: []string{}}
+
+	tables := make([]string, 0, len(database.SchemaTables))
+	for table := range database.SchemaTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		version, err := database.GetSchemaVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		verify.Version = version
+
+		for _, table := range tables {
+			columns, err := database.GetTableColumns(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+
+			if len(columns) == 0 {
+				verify.Discrepancies = append(verify.Discrepancies, fmt.Sprintf("Table %q is missing", table))
+				continue
+			}
+
+			for _, column := range database.SchemaTables[table] {
+				if !slices.Contains(columns, column) {
+					verify.Discrepancies = append(verify.Discrepancies, fmt.Sprintf("Table %q is missing column %q", table, column))
+				}
+			}
+
+			for _, column := range columns {
+				if !slices.Contains(database.SchemaTables[table], column) {
+					verify.Discrepancies = append(verify.Discrepancies, fmt.Sprintf("Table %q has unexpected column %q", table, column))
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return verify, err
+	}
+
+	// A schema behind is reported first as it explains the missing columns.
+	if verify.Version != verify.Expected {
+		verify.Discrepancies = append([]string{fmt.Sprintf("Schema version is %d, expected %d", verify.Version, verify.Expected)}, verify.Discrepancies...)
+	}
+
+	verify.Valid = len(verify.Discrepancies) == 0
+
+	return verify, nil
+}
+
+// GetDBStats returns the number of rows of the tables created by the schema extensions
+// and the total size of the config values, to tell when the database needs pruning.
+func GetDBStats(ctx context.Context, s *state.State) (types.DBStats, error) {
+	stats := types.DBStats{Rows: map[string]int64{}}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		version, err := database.GetSchemaVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		stats.Version = version
+
+		for table := range database.SchemaTables {
+			count, err := database.CountTableRows(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+
+			stats.Rows[table] = count
+		}
+
+		stats.ConfigValueBytes, err = database.GetConfigItemsSize(ctx, tx)
+
+		return err
+	})
+	if err != nil {
+		return types.DBStats{}, err
+	}
+
+	return stats, nil
+}
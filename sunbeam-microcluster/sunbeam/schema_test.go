@@ -0,0 +1,61 @@
+package sunbeam
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestGetSchema(t *testing.T) {
+	s := newTestState(t)
+
+	schema, err := GetSchema(context.Background(), s)
+	if err != nil {
+		t.Fatalf("GetSchema failed: %v", err)
+	}
+
+	if schema.Version != len(database.SchemaExtensions) {
+		t.Errorf("Schema version is %d, expected %d", schema.Version, len(database.SchemaExtensions))
+	}
+
+	if len(schema.Extensions) != len(database.SchemaExtensions) {
+		t.Fatalf("Schema lists %d extensions, expected %d", len(schema.Extensions), len(database.SchemaExtensions))
+	}
+
+	if !reflect.DeepEqual(schema.Extensions[:2], []string{"NodesSchemaUpdate", "ConfigSchemaUpdate"}) {
+		t.Errorf("Schema extensions start with %v", schema.Extensions[:2])
+	}
+}
+
+func TestGetDBStats(t *testing.T) {
+	s := newTestState(t)
+	addTestNodes(t, s, map[string][]string{"node1": {"control", "compute"}, "node2": {"compute"}})
+	createTestConfig(t, s, map[string]string{"key1": "abc", "key2": "défg"})
+
+	stats, err := GetDBStats(context.Background(), s)
+	if err != nil {
+		t.Fatalf("GetDBStats failed: %v", err)
+	}
+
+	if stats.Version != len(database.SchemaExtensions) {
+		t.Errorf("Schema version is %d, expected %d", stats.Version, len(database.SchemaExtensions))
+	}
+
+	if len(stats.Rows) != len(database.SchemaTables) {
+		t.Errorf("Stats count the rows of %d tables, expected %d", len(stats.Rows), len(database.SchemaTables))
+	}
+
+	expected := map[string]int64{"nodes": 2, "node_roles": 3, "config": 2, "manifest": 0}
+	for table, rows := range expected {
+		if stats.Rows[table] != rows {
+			t.Errorf("Table %q has %d rows, expected %d", table, stats.Rows[table], rows)
+		}
+	}
+
+	// The values are measured in bytes, not characters.
+	if stats.ConfigValueBytes != 8 {
+		t.Errorf("Config values take %d bytes, expected 8", stats.ConfigValueBytes)
+	}
+}
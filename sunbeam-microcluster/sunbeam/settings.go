@@ -0,0 +1,374 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/microcluster/state"
+)
+
+// Daemon settings are stored as config items under settingsPrefix so they
+// can be managed with the existing /1.0/config endpoints, by trusted clients only.
+const settingsPrefix = "daemon-"
+
+// tflockTTLSetting is the age after which a terraform lock is considered stale.
+// Locks never go stale when unset.
+const tflockTTLSetting = settingsPrefix + "tflock-ttl"
+
+// tflockReapIntervalSetting is the interval between two clearings of the terraform
+// locks older than the tflockTTLSetting. Zero disables the clearing.
+const tflockReapIntervalSetting = settingsPrefix + "tflock-reap-interval"
+
+// defaultTflockReapInterval is the reaping interval used when tflockReapIntervalSetting is unset.
+const defaultTflockReapInterval = 5 * time.Minute
+
+// tfstateStoreSetting is the http or https URL of the object store new terraform
+// states are put in, the database only recording where each state is. The states
+// are put in the database when unset.
+const tfstateStoreSetting = settingsPrefix + "tfstate-store"
+
+// tfstateStoreTokenSetting is the bearer token sent to the terraform state object store, if any.
+// Like the other settings only trusted clients may set it, and the config endpoints
+// never return its value.
+const tfstateStoreTokenSetting = settingsPrefix + "tfstate-store-token"
+
+// criticalRolesSetting is the comma separated list of roles that must always
+// be held by at least one node.
+const criticalRolesSetting = settingsPrefix + "critical-roles"
+
+// defaultCriticalRoles are the critical roles used when criticalRolesSetting is unset.
+var defaultCriticalRoles = []string{"control"}
+
+// rateLimitRateSetting is the number of requests per second each untrusted client
+// may make to the untrusted endpoints. Zero disables rate limiting.
+const rateLimitRateSetting = settingsPrefix + "ratelimit-rate"
+
+// rateLimitBurstSetting is the number of requests a client may burst above the rate.
+const rateLimitBurstSetting = settingsPrefix + "ratelimit-burst"
+
+// Default rate limiting of the untrusted endpoints.
+const (
+	defaultRateLimitRate  = 20
+	defaultRateLimitBurst = 100
+)
+
+// tflockIdentitySetting requires the Who of terraform locks taken over
+// authenticated connections to be the common name of the client certificate.
+const tflockIdentitySetting = settingsPrefix + "tflock-identity"
+
+// tflockForceUnlockSetting is the comma separated list of identity=plan entries
+// allowing the client with the given certificate common name to force-unlock
+// the plans matching the plan pattern, e.g. ci-runner=ci-*.
+const tflockForceUnlockSetting = settingsPrefix + "tflock-force-unlock"
+
+// tflockStrictPathSetting refuses the terraform locks whose Path references
+// another plan than the one being locked. Locks without a Path are accepted.
+const tflockStrictPathSetting = settingsPrefix + "tflock-strict-path"
+
+// compactIntervalSetting is the interval between two background compactions
+// of the database. Zero disables the background compaction.
+const compactIntervalSetting = settingsPrefix + "compact-interval"
+
+// defaultCompactInterval is the compaction interval used when compactIntervalSetting is unset.
+const defaultCompactInterval = time.Hour
+
+// configHistorySetting is the number of prior values kept for each config key.
+// Zero disables the config history.
+const configHistorySetting = settingsPrefix + "config-history"
+
+// defaultConfigHistory is the config history length used when configHistorySetting is unset.
+const defaultConfigHistory = 10
+
+// tfstateAccessLogSetting is the number of accesses to the terraform state kept
+// for each plan. Zero disables the access log.
+const tfstateAccessLogSetting = settingsPrefix + "tfstate-access-log"
+
+// defaultTfstateAccessLog is the access log length used when tfstateAccessLogSetting is unset.
+const defaultTfstateAccessLog = 100
+
+// systemIDUniquenessSetting selects how adding a node with the system id of
+// another node is handled, either enforce to refuse it or warn to log it.
+const systemIDUniquenessSetting = settingsPrefix + "node-systemid-uniqueness"
+
+// Modes of systemIDUniquenessSetting.
+const (
+	SystemIDUniquenessEnforce = "enforce"
+	SystemIDUniquenessWarn    = "warn"
+)
+
+// GetSystemIDUniqueness returns how nodes sharing a system id are handled, enforce by default
+func GetSystemIDUniqueness(ctx context.Context, s *state.State) (string, error) {
+	mode, err := getSetting(ctx, s, systemIDUniquenessSetting, SystemIDUniquenessEnforce)
+	if err != nil {
+		return "", err
+	}
+
+	mode = strings.TrimSpace(mode)
+	if mode != SystemIDUniquenessEnforce && mode != SystemIDUniquenessWarn {
+		return "", fmt.Errorf("Invalid value %q for setting %q, expected %s or %s", mode, systemIDUniquenessSetting, SystemIDUniquenessEnforce, SystemIDUniquenessWarn)
+	}
+
+	return mode, nil
+}
+
+// defaultNodeRolesSetting is the comma separated list of roles given to the nodes
+// added without any role. Nodes are added without roles when unset.
+const defaultNodeRolesSetting = settingsPrefix + "node-default-roles"
+
+// strictNodeRolesSetting refuses adding nodes without any role instead of giving
+// them the defaultNodeRolesSetting roles.
+const strictNodeRolesSetting = settingsPrefix + "node-strict-roles"
+
+// nodeEnrollRequiredSetting requires the untrusted clients adding a node to redeem
+// an enrollment token, so that only the nodes given a token can add themselves.
+const nodeEnrollRequiredSetting = settingsPrefix + "node-enroll-required"
+
+// knownNodeRolesSetting is the comma separated list of the roles known to the
+// deployment, which the node filters are checked against when the
+// strictNodeRoleFilterSetting is enabled.
+const knownNodeRolesSetting = settingsPrefix + "node-known-roles"
+
+// strictNodeRoleFilterSetting refuses the node filters on roles missing from the
+// knownNodeRolesSetting, instead of listing no nodes. The filters are not checked
+// while no known roles are set.
+const strictNodeRoleFilterSetting = settingsPrefix + "node-strict-role-filter"
+
+// nodeMetadataMaxBytesSetting is the maximum total size in bytes of the keys and
+// values of the metadata of a node. Zero disables the limit.
+const nodeMetadataMaxBytesSetting = settingsPrefix + "node-metadata-max-bytes"
+
+// defaultNodeMetadataMaxBytes is the metadata size limit used when nodeMetadataMaxBytesSetting is unset.
+const defaultNodeMetadataMaxBytes = 64 * 1024
+
+// configACLSetting is the comma separated list of identity=key entries restricting
+// the client with the given certificate common name to the config keys matching
+// the key patterns, e.g. automation-a=tfstate-a-*. Clients without any entry are
+// not restricted.
+const configACLSetting = settingsPrefix + "config-acl"
+
+// configACLUntrustedSetting is the policy of the untrusted clients on the config
+// endpoints, either allow or deny. Even when allowed, they cannot access the
+// settings and the terraform records.
+const configACLUntrustedSetting = settingsPrefix + "config-acl-untrusted"
+
+// manifestMaxSetting is the maximum number of manifests kept. Adding a manifest
+// beyond it drops the oldest ones. Zero keeps all the manifests.
+const manifestMaxSetting = settingsPrefix + "manifest-max"
+
+// manifestMaxStrictSetting refuses adding manifests beyond the manifestMaxSetting
+// instead of dropping the oldest ones.
+const manifestMaxStrictSetting = settingsPrefix + "manifest-max-strict"
+
+// manifestBlobThresholdSetting is the size in bytes above which the manifest data
+// is stored as a blob in the state directory of the member adding the manifest,
+// instead of in the replicated database. Zero keeps all the manifest data in the
+// database. The other members read the blobs from the member that stored them,
+// so the manifests stored on a member are unreadable while it is unreachable.
+const manifestBlobThresholdSetting = settingsPrefix + "manifest-blob-threshold"
+
+// manifestCanonicalizeSetting stores the manifest data re-serialized as YAML with
+// sorted keys, instead of the exact bytes sent, dropping comments and formatting.
+// Scalars are re-serialized as their YAML 1.1 values, e.g. a plain yes or y
+// becomes true, so it is only meant for manifests using unambiguous scalars.
+const manifestCanonicalizeSetting = settingsPrefix + "manifest-canonicalize"
+
+// requestTimeoutsSetting is the comma separated list of path=duration request
+// timeouts overriding the defaults, where path is the endpoint path relative to
+// /1.0 and "*" sets the timeout of all the other endpoints.
+const requestTimeoutsSetting = settingsPrefix + "request-timeouts"
+
+// defaultRequestTimeouts are the request timeouts used when requestTimeoutsSetting is unset.
+// Terraform states can be large, so their endpoints get more time.
+var defaultRequestTimeouts = map[string]time.Duration{
+	"*":                     time.Minute,
+	"terraformstate/{name}": 5 * time.Minute,
+}
+
+// GetRequestTimeouts returns the request timeouts keyed by endpoint path, "*" holding the default timeout
+func GetRequestTimeouts(ctx context.Context, s *state.State) (map[string]time.Duration, error) {
+	entries, err := getListSetting(ctx, s, requestTimeoutsSetting, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts := maps.Clone(defaultRequestTimeouts)
+	for _, entry := range entries {
+		path, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("Invalid entry %q for setting %q, expected path=duration", entry, requestTimeoutsSetting)
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("Invalid timeout %q for setting %q, expected a positive duration", value, requestTimeoutsSetting)
+		}
+
+		timeouts[strings.TrimSpace(path)] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// leaderWritesSetting is the comma separated list of path=mode entries naming the
+// endpoints whose writes are only processed by the dqlite leader, where path is the
+// endpoint path relative to /1.0. When another member gets a write, the redirect
+// mode answers it with a redirect to the leader and the hint mode rejects it with
+// the leader address so that the client retries there.
+const leaderWritesSetting = settingsPrefix + "leader-writes"
+
+// Modes of leaderWritesSetting.
+const (
+	LeaderWritesRedirect = "redirect"
+	LeaderWritesHint     = "hint"
+)
+
+// GetLeaderWrites returns the mode of the leader-only writes keyed by endpoint path
+func GetLeaderWrites(ctx context.Context, s *state.State) (map[string]string, error) {
+	entries, err := getListSetting(ctx, s, leaderWritesSetting, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	modes := map[string]string{}
+	for _, entry := range entries {
+		path, mode, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("Invalid entry %q for setting %q, expected path=mode", entry, leaderWritesSetting)
+		}
+
+		mode = strings.TrimSpace(mode)
+		if mode != LeaderWritesRedirect && mode != LeaderWritesHint {
+			return nil, fmt.Errorf("Invalid mode %q for setting %q, expected %s or %s", mode, leaderWritesSetting, LeaderWritesRedirect, LeaderWritesHint)
+		}
+
+		modes[strings.TrimSpace(path)] = mode
+	}
+
+	return modes, nil
+}
+
+// GetRateLimit returns the rate in requests per second and the burst allowed to each client of the untrusted endpoints
+func GetRateLimit(ctx context.Context, s *state.State) (float64, int, error) {
+	rate, err := getFloatSetting(ctx, s, rateLimitRateSetting, defaultRateLimitRate)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	burst, err := getIntSetting(ctx, s, rateLimitBurstSetting, defaultRateLimitBurst)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return rate, burst, nil
+}
+
+// getSetting returns the value of a daemon setting, or def if it is not set
+func getSetting(ctx context.Context, s *state.State, key string, def string) (string, error) {
+	value, err := GetConfig(ctx, s, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return def, nil
+		}
+		return "", err
+	}
+
+	return value, nil
+}
+
+// getDurationSetting returns the value of a daemon setting parsed as a duration, or def if it is not set
+func getDurationSetting(ctx context.Context, s *state.State, key string, def time.Duration) (time.Duration, error) {
+	value, err := getSetting(ctx, s, key, "")
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return def, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration for setting %q: %w", key, err)
+	}
+
+	return duration, nil
+}
+
+// getListSetting returns the value of a daemon setting split on commas, or def if it is not set
+func getListSetting(ctx context.Context, s *state.State, key string, def []string) ([]string, error) {
+	value, err := getSetting(ctx, s, key, "")
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return def, nil
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list, nil
+}
+
+// getIntSetting returns the value of a daemon setting parsed as an integer, or def if it is not set
+func getIntSetting(ctx context.Context, s *state.State, key string, def int) (int, error) {
+	value, err := getSetting(ctx, s, key, "")
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid integer for setting %q: %w", key, err)
+	}
+
+	return n, nil
+}
+
+// getFloatSetting returns the value of a daemon setting parsed as a float, or def if it is not set
+func getFloatSetting(ctx context.Context, s *state.State, key string, def float64) (float64, error) {
+	value, err := getSetting(ctx, s, key, "")
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number for setting %q: %w", key, err)
+	}
+
+	return f, nil
+}
+
+// getBoolSetting returns the value of a daemon setting parsed as a boolean, or def if it is not set
+func getBoolSetting(ctx context.Context, s *state.State, key string, def bool) (bool, error) {
+	value, err := getSetting(ctx, s, key, "")
+	if err != nil {
+		return false, err
+	}
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("Invalid boolean for setting %q: %w", key, err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,280 @@
+package sunbeam
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+)
+
+// StateStore stores the terraform states. The database always holds a record
+// for each state, which is either the state itself or where the store put it,
+// so that the plans are listed and the states resolved the same way whichever
+// store holds them.
+type StateStore interface {
+	// Put stores the state of the plan and returns the value to record in the database
+	Put(ctx context.Context, name string, state string) (string, error)
+	// Get returns the state of the plan from the value recorded in the database
+	Get(ctx context.Context, name string, record string) (string, error)
+	// Delete removes the state of the plan recorded with the given value
+	Delete(ctx context.Context, name string, record string) error
+}
+
+// databaseStateStore keeps the states in the database, the default
+type databaseStateStore struct{}
+
+// Put returns the state to record it as is
+func (databaseStateStore) Put(_ context.Context, _ string, state string) (string, error) {
+	return state, nil
+}
+
+// Get returns the recorded state
+func (databaseStateStore) Get(_ context.Context, _ string, record string) (string, error) {
+	return record, nil
+}
+
+// Delete does nothing, the state goes with its record
+func (databaseStateStore) Delete(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+// objectStateRef is recorded in the database for the states held by an object store.
+// Object is the name of the object within the store, never a URL, so that the states
+// are only ever fetched from the store configured by the tfstateStoreSetting.
+type objectStateRef struct {
+	Object string `json:"sunbeam-object"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// objectStoreTimeout bounds each request to the object store
+const objectStoreTimeout = time.Minute
+
+// objectStateStore keeps the states in an HTTP object store accepting PUT, GET and
+// DELETE on the objects, such as an S3 bucket or a MinIO server allowing the daemon
+// through their bucket policy. Each write of a state is put in an object of its own,
+// named after the plan and a random suffix, so that a write refused once the object
+// is put never replaces the object of the recorded state.
+type objectStateStore struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// objectStateName returns a new object name for the state of the plan
+func objectStateName(name string) (string, error) {
+	suffix := make([]byte, 16)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+
+	return url.PathEscape(name) + "/" + hex.EncodeToString(suffix), nil
+}
+
+// objectURL returns the URL of the named object in the store. Names are checked to
+// be made of the two escaped segments objectStateName returns, and nothing else.
+func (o objectStateStore) objectURL(object string) (string, error) {
+	segments := strings.Split(object, "/")
+	if len(segments) != 2 {
+		return "", fmt.Errorf("Invalid terraform state object name %q", object)
+	}
+
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil || unescaped == "" || unescaped == "." || unescaped == ".." {
+			return "", fmt.Errorf("Invalid terraform state object name %q", object)
+		}
+
+		segments[i] = url.PathEscape(unescaped)
+	}
+
+	return strings.TrimSuffix(o.endpoint, "/") + "/" + strings.Join(segments, "/"), nil
+}
+
+// Put uploads the state to a new object and returns its reference
+func (o objectStateStore) Put(ctx context.Context, name string, state string) (string, error) {
+	object, err := objectStateName(name)
+	if err != nil {
+		return "", err
+	}
+
+	objectURL, err := o.objectURL(object)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = o.do(ctx, http.MethodPut, objectURL, []byte(state))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(state))
+	ref, err := json.Marshal(objectStateRef{Object: object, Size: len(state), SHA256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		return "", err
+	}
+
+	return string(ref), nil
+}
+
+// Get downloads the state referenced by the record and checks its checksum
+func (o objectStateStore) Get(ctx context.Context, name string, record string) (string, error) {
+	ref, ok := parseObjectStateRef(record)
+	if !ok {
+		return record, nil
+	}
+
+	objectURL, err := o.objectURL(ref.Object)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := o.do(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != ref.SHA256 {
+		return "", fmt.Errorf("Terraform state of plan %q does not match its checksum in the object store", name)
+	}
+
+	return string(data), nil
+}
+
+// Delete removes the object referenced by the record, succeeding if it is already gone
+func (o objectStateStore) Delete(ctx context.Context, _ string, record string) error {
+	ref, ok := parseObjectStateRef(record)
+	if !ok {
+		return nil
+	}
+
+	objectURL, err := o.objectURL(ref.Object)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.do(ctx, http.MethodDelete, objectURL, nil)
+	return err
+}
+
+// do sends a request to the object store and returns the response body
+func (o objectStateStore) do(ctx context.Context, method string, object string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, objectStoreTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, object, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to reach the terraform state object store: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from the terraform state object store: %w", err)
+	}
+
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("Terraform state object store returned %q on %s %s", resp.Status, method, object)
+	}
+
+	return data, nil
+}
+
+// parseObjectStateRef returns the object store reference held by the record, if any
+func parseObjectStateRef(record string) (objectStateRef, bool) {
+	var ref objectStateRef
+	err := json.Unmarshal([]byte(record), &ref)
+	if err != nil || ref.Object == "" {
+		return ref, false
+	}
+
+	return ref, true
+}
+
+// terraformStateStore returns the store new terraform states are put in, per the tfstateStoreSetting
+func terraformStateStore(ctx context.Context, s *state.State) (StateStore, error) {
+	endpoint, err := getSetting(ctx, s, tfstateStoreSetting, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint == "" {
+		return databaseStateStore{}, nil
+	}
+
+	return newObjectStateStore(ctx, s, endpoint)
+}
+
+// recordStateStore returns the store holding the state recorded with the given value.
+// States held by an object store are fetched from the one of the tfstateStoreSetting.
+func recordStateStore(ctx context.Context, s *state.State, record string) (StateStore, error) {
+	_, ok := parseObjectStateRef(record)
+	if !ok {
+		return databaseStateStore{}, nil
+	}
+
+	endpoint, err := getSetting(ctx, s, tfstateStoreSetting, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint == "" {
+		return nil, fmt.Errorf("Terraform state is held by an object store but setting %q is unset", tfstateStoreSetting)
+	}
+
+	return newObjectStateStore(ctx, s, endpoint)
+}
+
+// newObjectStateStore returns the object store at the endpoint, authenticated with the tfstateStoreTokenSetting
+func newObjectStateStore(ctx context.Context, s *state.State, endpoint string) (StateStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid value %q for setting %q, expected an http or https URL", endpoint, tfstateStoreSetting)
+	}
+
+	token, err := getSetting(ctx, s, tfstateStoreTokenSetting, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return objectStateStore{endpoint: endpoint, token: token, client: &http.Client{}}, nil
+}
+
+// discardStateRecord deletes the stored state of a write that was not recorded, or
+// of a state that was replaced, logging the failures as the write is already settled.
+func discardStateRecord(ctx context.Context, s *state.State, name string, record string) {
+	store, err := recordStateStore(ctx, s, record)
+	if err == nil {
+		err = store.Delete(ctx, name, record)
+	}
+
+	if err != nil {
+		logger.Warn("Failed to delete unrecorded terraform state", logger.Ctx{"plan": name, "err": err})
+	}
+}
@@ -0,0 +1,223 @@
+package sunbeam
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeObjectStore is an in-memory object store serving PUT, GET and DELETE on the objects
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	objects map[string]string
+	tokens  []string
+}
+
+func (f *fakeObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokens = append(f.tokens, r.Header.Get("Authorization"))
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.EscapedPath()] = string(data)
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write([]byte(data))
+	case http.MethodDelete:
+		_, ok := f.objects[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		delete(f.objects, r.URL.EscapedPath())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newFakeObjectStateStore returns an objectStateStore backed by a fakeObjectStore
+func newFakeObjectStateStore(t *testing.T) (objectStateStore, *fakeObjectStore) {
+	t.Helper()
+
+	fake := &fakeObjectStore{objects: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return objectStateStore{endpoint: server.URL + "/bucket/", token: "secret", client: server.Client()}, fake
+}
+
+func TestStateStoreParity(t *testing.T) {
+	objectStore, _ := newFakeObjectStateStore(t)
+
+	stores := []struct {
+		name  string
+		store StateStore
+	}{
+		{name: "database", store: databaseStateStore{}},
+		{name: "object", store: objectStore},
+	}
+
+	states := []struct {
+		plan  string
+		state string
+	}{
+		{plan: "openstack", state: `{"version": 4, "serial": 1}`},
+		{plan: "with/slash", state: `{"version": 4, "serial": 2}`},
+		{plan: "tfws-staging-openstack", state: `{}`},
+	}
+
+	for _, store := range stores {
+		for _, tt := range states {
+			t.Run(store.name+"/"+tt.plan, func(t *testing.T) {
+				ctx := context.Background()
+
+				record, err := store.store.Put(ctx, tt.plan, tt.state)
+				if err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+
+				state, err := store.store.Get(ctx, tt.plan, record)
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+
+				if state != tt.state {
+					t.Errorf("Get returned %q, expected %q", state, tt.state)
+				}
+
+				err = store.store.Delete(ctx, tt.plan, record)
+				if err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+
+				// Deleting a state already gone succeeds.
+				err = store.store.Delete(ctx, tt.plan, record)
+				if err != nil {
+					t.Fatalf("Second delete failed: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestObjectStateStoreWrites(t *testing.T) {
+	store, fake := newFakeObjectStateStore(t)
+	ctx := context.Background()
+
+	first, err := store.Put(ctx, "openstack", `{"serial": 1}`)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	second, err := store.Put(ctx, "openstack", `{"serial": 2}`)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// A second write of the plan never replaces the object of the first one.
+	state, err := store.Get(ctx, "openstack", first)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if state != `{"serial": 1}` {
+		t.Errorf("First state is %q after the second write", state)
+	}
+
+	err = store.Delete(ctx, "openstack", second)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if len(fake.objects) != 1 {
+		t.Errorf("Store holds %d objects, expected 1", len(fake.objects))
+	}
+
+	for _, token := range fake.tokens {
+		if token != "Bearer secret" {
+			t.Errorf("Store got authorization %q, expected the bearer token", token)
+		}
+	}
+
+	ref, ok := parseObjectStateRef(first)
+	if !ok {
+		t.Fatalf("Record %q is not an object reference", first)
+	}
+
+	if strings.Contains(ref.Object, "://") {
+		t.Errorf("Record holds the URL %q instead of an object name", ref.Object)
+	}
+}
+
+func TestObjectStateStoreChecksum(t *testing.T) {
+	store, fake := newFakeObjectStateStore(t)
+	ctx := context.Background()
+
+	record, err := store.Put(ctx, "openstack", `{"serial": 1}`)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for object := range fake.objects {
+		fake.objects[object] = `{"serial": 2}`
+	}
+
+	_, err = store.Get(ctx, "openstack", record)
+	if err == nil {
+		t.Errorf("Get of a modified object succeeded")
+	}
+}
+
+func TestObjectStateStoreObjectURL(t *testing.T) {
+	store := objectStateStore{endpoint: "https://store.example.com/bucket/"}
+
+	tests := []struct {
+		object string
+		url    string
+	}{
+		{object: "openstack/0123", url: "https://store.example.com/bucket/openstack/0123"},
+		{object: "with%2Fslash/0123", url: "https://store.example.com/bucket/with%2Fslash/0123"},
+		{object: "http://attacker.example.com/x"},
+		{object: "https:/%2F/attacker.example.com"},
+		{object: "../0123"},
+		{object: "openstack/.."},
+		{object: "openstack"},
+		{object: "a/b/c"},
+		{object: "/0123"},
+		{object: "open%zzstack/0123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.object, func(t *testing.T) {
+			url, err := store.objectURL(tt.object)
+			if tt.url == "" {
+				if err == nil {
+					t.Errorf("objectURL(%q) = %q, expected an error", tt.object, url)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("objectURL(%q) failed: %v", tt.object, err)
+			}
+
+			if url != tt.url {
+				t.Errorf("objectURL(%q) = %q, expected %q", tt.object, url, tt.url)
+			}
+		})
+	}
+}
@@ -1,23 +1,152 @@
 package sunbeam
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/state"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
 const tfstatePrefix = "tfstate-"
 const tflockPrefix = "tflock-"
+const tfserialPrefix = "tfserial-"
+
+// tfPrefixes are the prefixes of the config keys holding the terraform plans.
+var tfPrefixes = []string{tfstatePrefix, tflockPrefix, tfserialPrefix}
+
+// ValidateTerraformPlanName checks the plan name can be stored under the terraform prefixes.
+// Names starting with one of the prefixes are rejected so that the keys of a plan are never
+// mistaken for the keys of another, e.g. tfstate-tflock-x for the lock of plan x.
+func ValidateTerraformPlanName(name string) error {
+	if name == "" {
+		return api.StatusErrorf(http.StatusBadRequest, "Plan name must not be empty")
+	}
+
+	for _, prefix := range append([]string{tfworkspacePrefix}, tfPrefixes...) {
+		if strings.HasPrefix(name, prefix) {
+			return api.StatusErrorf(http.StatusBadRequest, "Plan name %q must not start with %q", name, prefix)
+		}
+	}
+
+	return nil
+}
+
+// ErrTerraformRecordReadOnly is returned when writing a terraform record through the config endpoints.
+var ErrTerraformRecordReadOnly = errors.New("Terraform records are only written by the terraform endpoints")
+
+// CheckConfigWritable fails with a forbidden error if one of the keys holds a terraform
+// record, as records written outside of the terraform endpoints skip their checks.
+func CheckConfigWritable(keys ...string) error {
+	for _, key := range keys {
+		for _, prefix := range tfPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return api.StatusErrorf(http.StatusForbidden, "%w: %q", ErrTerraformRecordReadOnly, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ErrTerraformLockIdentity is returned when the Who of a lock does not match the
+// identity of the authenticated client.
+var ErrTerraformLockIdentity = errors.New("Lock owner does not match the client certificate")
+
+// ErrTerraformLockPath is returned when the Path of a lock does not reference its plan.
+var ErrTerraformLockPath = errors.New("Lock path does not match the plan")
+
+// ErrInvalidTerraformState is returned when writing a terraform state that is not a JSON object.
+var ErrInvalidTerraformState = errors.New("Invalid terraform state")
+
+// ErrTerraformForceUnlockDenied is returned when the client is not allowed to force-unlock the plan.
+var ErrTerraformForceUnlockDenied = errors.New("Client is not allowed to force-unlock the plan")
+
+// ErrTerraformStateExists is returned when importing the state of a plan that already has one.
+var ErrTerraformStateExists = errors.New("Terraform state already exists")
+
+// ErrStaleTerraformSerial is returned when writing a terraform state with a serial
+// lower than the one of the stored state, which indicates a stale client.
+var ErrStaleTerraformSerial = errors.New("Terraform state serial is older than the stored one")
+
+// tfLockConflicts counts the lock conflicts served since the daemon started
+var tfLockConflicts atomic.Uint64
+
+// tfLockConflictsSince is the time the daemon started counting the lock conflicts
+var tfLockConflictsSince = time.Now()
+
+// maxLockConflictIdentities bounds the identities whose lock conflicts are counted apart
+const maxLockConflictIdentities = 256
+
+// tfLockConflictsMu guards tfLockConflictsByWho and tfLockConflictsUntracked
+var tfLockConflictsMu sync.Mutex
+
+// tfLockConflictsByWho counts the lock conflicts served since the daemon started by the Who of the request
+var tfLockConflictsByWho = map[string]uint64{}
+
+// tfLockConflictsUntracked counts the lock conflicts of the identities beyond maxLockConflictIdentities
+var tfLockConflictsUntracked uint64
+
+// lockConflictErrorf returns a lock conflict error and counts it for who, the
+// Who of the conflicting request.
+func lockConflictErrorf(who string, status int, format string, a ...any) error {
+	countLockConflict(who)
+
+	return api.StatusErrorf(status, format, a...)
+}
+
+// countLockConflict counts a lock conflict for who. Requests without a Who, such
+// as state writes, are counted for UnknownCreator. Conflicts found within a
+// transaction are counted once it returns, as it may be retried.
+func countLockConflict(who string) {
+	tfLockConflicts.Add(1)
+
+	if who == "" {
+		who = UnknownCreator
+	}
+
+	tfLockConflictsMu.Lock()
+	_, tracked := tfLockConflictsByWho[who]
+	if tracked || len(tfLockConflictsByWho) < maxLockConflictIdentities {
+		tfLockConflictsByWho[who]++
+	} else {
+		tfLockConflictsUntracked++
+	}
+	tfLockConflictsMu.Unlock()
+}
+
+// GetTerraformLockConflicts returns the lock conflicts served since the daemon started, by the Who of the requests
+func GetTerraformLockConflicts() types.TerraformLockConflicts {
+	tfLockConflictsMu.Lock()
+	defer tfLockConflictsMu.Unlock()
+
+	return types.TerraformLockConflicts{
+		Total:     tfLockConflicts.Load(),
+		ByWho:     maps.Clone(tfLockConflictsByWho),
+		Untracked: tfLockConflictsUntracked,
+	}
+}
 
 // GetTerraformStates returns the list of terraform states from the database
-func GetTerraformStates(s *state.State) ([]string, error) {
+func GetTerraformStates(ctx context.Context, s *state.State) ([]string, error) {
 	prefix := tfstatePrefix
-	states, err := GetConfigItemKeys(s, &prefix)
+	states, err := GetConfigItemKeys(ctx, s, &prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -30,19 +159,63 @@ func GetTerraformStates(s *state.State) ([]string, error) {
 	return plans, nil
 }
 
-// GetTerraformState returns the terraform state from the database
-func GetTerraformState(s *state.State, name string) (string, error) {
+// GetTerraformState returns the terraform state from the store holding it
+func GetTerraformState(ctx context.Context, s *state.State, name string) (string, error) {
 	tfstateKey := tfstatePrefix + name
-	state, err := GetConfig(s, tfstateKey)
-	return state, err
+	record, err := GetConfig(ctx, s, tfstateKey)
+	if err != nil {
+		return "", err
+	}
+
+	store, err := recordStateStore(ctx, s, record)
+	if err != nil {
+		return "", err
+	}
+
+	return store.Get(ctx, name, record)
 }
 
-// UpdateTerraformState updates the terraform state record in the database
-func UpdateTerraformState(s *state.State, name string, lockID string, state string) (types.Lock, error) {
+// checkTerraformSerial returns an error if serial is older than the stored serial.
+// An empty stored serial means no serial is tracked yet.
+func checkTerraformSerial(serial int64, stored string) error {
+	if stored == "" {
+		return nil
+	}
+
+	storedSerial, err := strconv.ParseInt(stored, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if serial < storedSerial {
+		return api.StatusErrorf(http.StatusConflict, "%w: got serial %d, stored serial is %d", ErrStaleTerraformSerial, serial, storedSerial)
+	}
+
+	return nil
+}
+
+// UpdateTerraformState updates the terraform state record in the database.
+// States that are not JSON objects are refused, as are states with a serial
+// lower than the stored one unless force is set. The state, its serial and,
+// if unlock is set, the release of the lock are committed in one transaction,
+// so that a client finishing an apply never leaves the lock behind the state.
+func UpdateTerraformState(ctx context.Context, s *state.State, name string, lockID string, state string, force bool, unlock bool) (types.Lock, error) {
 	var dbLock types.Lock
 
+	// Reject broken states before anything is stored, the state is served back as is.
+	serial, err := terraformStateSerial(state)
+	if err != nil {
+		return dbLock, api.StatusErrorf(http.StatusBadRequest, "%w: %v", ErrInvalidTerraformState, err)
+	}
+
+	done, err := beginTerraformWrite()
+	if err != nil {
+		return dbLock, err
+	}
+	defer done()
+
 	tflockKey := tflockPrefix + name
-	lockInDb, err := GetConfig(s, tflockKey)
+	lockInDb, err := GetConfig(ctx, s, tflockKey)
 	if err != nil {
 		return dbLock, err
 	}
@@ -53,29 +226,297 @@ func UpdateTerraformState(s *state.State, name string, lockID string, state stri
 	}
 
 	if lockID != dbLock.ID {
-		return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+		return dbLock, lockConflictErrorf("", http.StatusConflict, "Conflict in Lock ID")
+	}
+
+	tfserialKey := tfserialPrefix + name
+	store, err := terraformStateStore(ctx, s)
+	if err != nil {
+		return dbLock, err
+	}
+
+	record, err := store.Put(ctx, name, state)
+	if err != nil {
+		return dbLock, err
 	}
 
 	tfstateKey := tfstatePrefix + name
-	err = UpdateConfig(s, tfstateKey, state)
+	unlockKeys := configKeyLock.lock(tfstateKey, tfserialKey, tflockKey)
+	defer unlockKeys()
+
+	// The lock is checked again as it may have been released or taken meanwhile.
+	// The record of the replaced state is kept to delete its object once committed.
+	var previous string
+	conflict := false
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		previous = ""
+		conflict = false
+
+		lockRecord, err := database.GetConfigItem(ctx, tx, tflockKey)
+		if err != nil {
+			return err
+		}
+
+		var heldLock types.Lock
+		err = json.Unmarshal([]byte(lockRecord.Value), &heldLock)
+		if err != nil {
+			return err
+		}
+
+		if heldLock.ID != lockID {
+			dbLock = heldLock
+			conflict = true
+			return api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+		}
+
+		// States without a serial are stored without serial tracking. The serial is checked
+		// under the lock so that concurrent writes cannot both pass it.
+		if serial != nil && !force {
+			storedSerial := ""
+			serialRecord, err := database.GetConfigItem(ctx, tx, tfserialKey)
+			if err == nil {
+				storedSerial = serialRecord.Value
+			} else if !api.StatusErrorCheck(err, http.StatusNotFound) {
+				return err
+			}
+
+			err = checkTerraformSerial(*serial, storedSerial)
+			if err != nil {
+				return err
+			}
+		}
+
+		stateRecord, err := database.GetConfigItem(ctx, tx, tfstateKey)
+		if err == nil {
+			previous = stateRecord.Value
+		} else if !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return err
+		}
+
+		err = putTerraformRecord(ctx, tx, tfstateKey, record)
+		if err != nil {
+			return err
+		}
+
+		if serial != nil {
+			err = putTerraformRecord(ctx, tx, tfserialKey, strconv.FormatInt(*serial, 10))
+			if err != nil {
+				return err
+			}
+		}
+
+		if unlock {
+			err = database.DeleteConfigItem(ctx, tx, tflockKey)
+			if err != nil {
+				return fmt.Errorf("Failed to release terraform lock: %w", err)
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
+		if conflict {
+			countLockConflict("")
+		}
+
+		discardStateRecord(ctx, s, name, record)
 		return dbLock, err
 	}
 
+	if previous != "" {
+		discardStateRecord(ctx, s, name, previous)
+	}
+
 	return dbLock, nil
 }
 
-// DeleteTerraformState deletes the terraform state from the database
-func DeleteTerraformState(s *state.State, name string) error {
+// ImportTerraformState creates the terraform state of a plan that has none, such as
+// a plan adopted from another backend. The state is validated as on updates and no
+// lock is needed, but an existing state is never overwritten. createdBy is recorded
+// as the creator of the state.
+func ImportTerraformState(ctx context.Context, s *state.State, name string, state string, createdBy string) error {
+	serial, err := terraformStateSerial(state)
+	if err != nil {
+		return api.StatusErrorf(http.StatusBadRequest, "%w: %v", ErrInvalidTerraformState, err)
+	}
+
+	done, err := beginTerraformWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	tfstateKey := tfstatePrefix + name
+	tfserialKey := tfserialPrefix + name
+	unlockKeys := configKeyLock.lock(tfstateKey, tfserialKey)
+	defer unlockKeys()
+
+	// The existing state is checked before the store is written to, to spare
+	// uploading states that are refused anyway.
+	exists := false
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		exists, err = database.ConfigItemExists(ctx, tx, tfstateKey)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return api.StatusErrorf(http.StatusConflict, "%w: %q", ErrTerraformStateExists, name)
+	}
+
+	store, err := terraformStateStore(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	record, err := store.Put(ctx, name, state)
+	if err != nil {
+		return err
+	}
+
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: tfstateKey, Value: record, CreatedBy: createdBy})
+		if err != nil {
+			if api.StatusErrorCheck(err, http.StatusConflict) {
+				return api.StatusErrorf(http.StatusConflict, "%w: %q", ErrTerraformStateExists, name)
+			}
+			return fmt.Errorf("Failed to record config item: %w", err)
+		}
+
+		if serial != nil {
+			return putTerraformRecord(ctx, tx, tfserialKey, strconv.FormatInt(*serial, 10))
+		}
+
+		return nil
+	})
+	if err != nil {
+		discardStateRecord(ctx, s, name, record)
+		return err
+	}
+
+	return nil
+}
+
+// putTerraformRecord creates or updates the ConfigItem holding a terraform record
+// within the transaction. The terraform records have no history to keep.
+func putTerraformRecord(ctx context.Context, tx *sql.Tx, key string, value string) error {
+	configItem := database.ConfigItem{Key: key, Value: value, CreatedBy: UnknownCreator}
+
+	exists, err := database.ConfigItemExists(ctx, tx, key)
+	if err != nil {
+		return fmt.Errorf("Failed to record config item: %w", err)
+	}
+
+	if exists {
+		err = database.UpdateConfigItem(ctx, tx, key, configItem)
+	} else {
+		_, err = database.CreateConfigItem(ctx, tx, configItem)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to record config item: %w", err)
+	}
+
+	return nil
+}
+
+// GetTerraformStateLock returns the lock held on the terraform plan, or nil if the plan is not locked
+func GetTerraformStateLock(ctx context.Context, s *state.State, name string) (*types.Lock, error) {
+	lockInDb, err := GetTerraformLock(ctx, s, name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var lock types.Lock
+	err = json.Unmarshal([]byte(lockInDb), &lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// GetTerraformLockConflict returns the lock along with its age and staleness
+func GetTerraformLockConflict(ctx context.Context, s *state.State, lock types.Lock) (types.LockConflict, error) {
+	conflict := types.LockConflict{Lock: lock}
+
+	ttl, err := getDurationSetting(ctx, s, tflockTTLSetting, 0)
+	if err != nil {
+		return conflict, err
+	}
+
+	age := time.Since(lock.Created)
+	conflict.Age = int64(age.Seconds())
+	conflict.Stale = ttl > 0 && age > ttl
+	conflict.RetryAfter = int64(lockRetryAfter(age, ttl).Seconds())
+
+	return conflict, nil
+}
+
+// Bounds of the delay clients are asked to wait before retrying on a held lock.
+const (
+	minLockRetryAfter = time.Second
+	maxLockRetryAfter = time.Minute
+)
+
+// lockRetryAfter returns how long to wait before retrying on a lock of the given age.
+// With a TTL, it is the time left until the lock goes stale. Without, locks held for
+// long are assumed to belong to long applies and a tenth of the age is waited.
+func lockRetryAfter(age time.Duration, ttl time.Duration) time.Duration {
+	delay := age / 10
+	if ttl > 0 {
+		delay = ttl - age
+	}
+
+	return min(max(delay, minLockRetryAfter), maxLockRetryAfter)
+}
+
+// DeleteTerraformState deletes the terraform state from the database and the store holding it
+func DeleteTerraformState(ctx context.Context, s *state.State, name string) error {
+	done, err := beginTerraformWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	tfstateKey := tfstatePrefix + name
-	err := DeleteConfig(s, tfstateKey)
-	return err
+	record, err := GetConfig(ctx, s, tfstateKey)
+	if err != nil {
+		return err
+	}
+
+	store, err := recordStateStore(ctx, s, record)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteConfig(ctx, s, tfstateKey)
+	if err != nil {
+		return err
+	}
+
+	err = store.Delete(ctx, name, record)
+	if err != nil {
+		return err
+	}
+
+	// States stored before serial tracking have no serial.
+	err = DeleteConfig(ctx, s, tfserialPrefix+name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return nil
 }
 
 // GetTerraformLocks returns the list of terraform locks from the database
-func GetTerraformLocks(s *state.State) ([]string, error) {
+func GetTerraformLocks(ctx context.Context, s *state.State) ([]string, error) {
 	prefix := tflockPrefix
-	locks, err := GetConfigItemKeys(s, &prefix)
+	locks, err := GetConfigItemKeys(ctx, s, &prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -89,14 +530,99 @@ func GetTerraformLocks(s *state.State) ([]string, error) {
 }
 
 // GetTerraformLock returns the terraform lock from the database
-func GetTerraformLock(s *state.State, name string) (string, error) {
+func GetTerraformLock(ctx context.Context, s *state.State, name string) (string, error) {
 	tflockKey := tflockPrefix + name
-	lock, err := GetConfig(s, tflockKey)
+	lock, err := GetConfig(ctx, s, tflockKey)
 	return lock, err
 }
 
-// UpdateTerraformLock updates the terraform lock record in the database
-func UpdateTerraformLock(s *state.State, name string, lock string) (types.Lock, error) {
+// checkTerraformLockIdentity ties the owner of the lock to the identity of the
+// authenticated client when tflockIdentitySetting is enabled. An empty Who is
+// set to the identity, any other Who must match it. Requests without an
+// identity, such as untrusted ones, are not checked.
+func checkTerraformLockIdentity(ctx context.Context, s *state.State, lock *types.Lock, identity string) error {
+	if identity == "" {
+		return nil
+	}
+
+	enabled, err := getBoolSetting(ctx, s, tflockIdentitySetting, false)
+	if err != nil || !enabled {
+		return err
+	}
+
+	if lock.Who == "" {
+		lock.Who = identity
+	} else if lock.Who != identity {
+		return api.StatusErrorf(http.StatusForbidden, "%w: %q is not %q", ErrTerraformLockIdentity, lock.Who, identity)
+	}
+
+	return nil
+}
+
+// checkTerraformLockPath checks the Path of the lock references the plan when
+// tflockStrictPathSetting is enabled. The Path may be the plan name or a path or
+// URL ending with it, such as the lock address of the plan.
+func checkTerraformLockPath(ctx context.Context, s *state.State, lock types.Lock, plan string) error {
+	if lock.Path == "" {
+		return nil
+	}
+
+	strict, err := getBoolSetting(ctx, s, tflockStrictPathSetting, false)
+	if err != nil || !strict {
+		return err
+	}
+
+	_, name := SplitTerraformWorkspacePlan(plan)
+
+	lockPath := lock.Path
+	u, err := url.Parse(lock.Path)
+	if err == nil && u.Path != "" {
+		lockPath = u.Path
+	}
+
+	if lockPath == name || lockPath == plan || strings.HasSuffix(lockPath, "/"+name) {
+		return nil
+	}
+
+	return api.StatusErrorf(http.StatusBadRequest, "%w: %q is not %q", ErrTerraformLockPath, lock.Path, name)
+}
+
+// checkTerraformForceUnlock checks the identity is allowed to force-unlock the plan.
+// Requests without an identity, such as untrusted ones, are never allowed.
+func checkTerraformForceUnlock(ctx context.Context, s *state.State, name string, identity string) error {
+	if identity != "" {
+		entries, err := getListSetting(ctx, s, tflockForceUnlockSetting, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			allowed, pattern, found := strings.Cut(entry, "=")
+			if !found {
+				return fmt.Errorf("Invalid entry %q for setting %q, expected identity=plan", entry, tflockForceUnlockSetting)
+			}
+
+			if strings.TrimSpace(allowed) != identity {
+				continue
+			}
+
+			matched, err := path.Match(strings.TrimSpace(pattern), name)
+			if err != nil {
+				return fmt.Errorf("Invalid plan pattern %q for setting %q: %w", pattern, tflockForceUnlockSetting, err)
+			}
+
+			if matched {
+				return nil
+			}
+		}
+	}
+
+	return api.StatusErrorf(http.StatusForbidden, "%w: %q may not force-unlock %q", ErrTerraformForceUnlockDenied, identity, name)
+}
+
+// UpdateTerraformLock updates the terraform lock record in the database.
+// identity is the identity of the authenticated client, if any.
+func UpdateTerraformLock(ctx context.Context, s *state.State, name string, lock string, identity string) (types.Lock, error) {
 	var reqLock types.Lock
 	var dbLock types.Lock
 
@@ -105,20 +631,34 @@ func UpdateTerraformLock(s *state.State, name string, lock string) (types.Lock,
 		return dbLock, err
 	}
 
-	tflockKey := tflockPrefix + name
-	lockInDb, err := GetConfig(s, tflockKey)
+	err = checkTerraformLockIdentity(ctx, s, &reqLock, identity)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			// No Lock exists, add lock details in DB
-			if err.Status() == http.StatusNotFound {
-				j, err := json.Marshal(reqLock)
-				if err != nil {
-					return dbLock, err
-				}
+		return dbLock, err
+	}
+
+	err = checkTerraformLockPath(ctx, s, reqLock, name)
+	if err != nil {
+		return dbLock, err
+	}
 
-				err = UpdateConfig(s, tflockKey, string(j))
+	done, err := beginTerraformWrite()
+	if err != nil {
+		return dbLock, err
+	}
+	defer done()
+
+	tflockKey := tflockPrefix + name
+	lockInDb, err := GetConfig(ctx, s, tflockKey)
+	if err != nil {
+		// No Lock exists, add lock details in DB
+		if errors.Is(err, ErrNotFound) {
+			j, err := json.Marshal(reqLock)
+			if err != nil {
 				return dbLock, err
 			}
+
+			err = UpdateConfig(ctx, s, tflockKey, string(j))
+			return dbLock, err
 		}
 		return dbLock, err
 	}
@@ -128,33 +668,154 @@ func UpdateTerraformLock(s *state.State, name string, lock string) (types.Lock,
 		return dbLock, err
 	}
 
-	// If the lock from DB and request are same, send http 423
+	// If the lock from DB and request are same, send http 423.
+	// The client already holds the lock, so this is not counted as a conflict.
 	if dbLock.ID == reqLock.ID && dbLock.Operation == reqLock.Operation && dbLock.Who == reqLock.Who {
 		return dbLock, api.StatusErrorf(http.StatusLocked, "Already locked with same ID")
 	}
 
 	// Already locked and request has different lockid, send http 409
-	return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+	return dbLock, lockConflictErrorf(reqLock.Who, http.StatusConflict, "Conflict in Lock ID")
+}
+
+// MaxTerraformStealGrace bounds the grace given to the holder of a lock being stolen
+const MaxTerraformStealGrace = 30 * time.Second
+
+// StealTerraformLock replaces the terraform lock held on the plan with the given lock,
+// such as when the holder died without unlocking. The holder is first given the
+// grace to release the lock; the lock is taken if it was released meanwhile, and
+// the steal fails with a conflict if another lock replaced it. identity is the
+// identity of the authenticated client, which must be allowed to force-unlock
+// the plan by tflockForceUnlockSetting.
+func StealTerraformLock(ctx context.Context, s *state.State, name string, lock string, identity string, grace time.Duration) (types.LockSteal, error) {
+	var steal types.LockSteal
+
+	err := json.Unmarshal([]byte(lock), &steal.Current)
+	if err != nil {
+		return steal, api.StatusErrorf(http.StatusBadRequest, "Invalid lock: %v", err)
+	}
+
+	err = checkTerraformForceUnlock(ctx, s, name, identity)
+	if err != nil {
+		return steal, err
+	}
+
+	err = checkTerraformLockIdentity(ctx, s, &steal.Current, identity)
+	if err != nil {
+		return steal, err
+	}
+
+	err = checkTerraformLockPath(ctx, s, steal.Current, name)
+	if err != nil {
+		return steal, err
+	}
+
+	previous, err := GetTerraformStateLock(ctx, s, name)
+	if err != nil {
+		return steal, err
+	}
+
+	if previous != nil && grace > 0 {
+		err = waitTerraformLock(ctx, grace)
+		if err != nil {
+			return steal, err
+		}
+	}
+
+	done, err := beginTerraformWrite()
+	if err != nil {
+		return steal, err
+	}
+	defer done()
+
+	tflockKey := tflockPrefix + name
+	unlockKeys := configKeyLock.lock(tflockKey)
+	defer unlockKeys()
+
+	current, err := json.Marshal(steal.Current)
+	if err != nil {
+		return steal, err
+	}
+
+	conflict := false
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		steal.Previous = nil
+		conflict = false
+
+		record, err := database.GetConfigItem(ctx, tx, tflockKey)
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return err
+		}
+
+		if record != nil {
+			var held types.Lock
+			err = json.Unmarshal([]byte(record.Value), &held)
+			if err != nil {
+				return err
+			}
+
+			if previous == nil || held.ID != previous.ID {
+				conflict = true
+				return api.StatusErrorf(http.StatusConflict, "Lock was replaced during the grace")
+			}
+
+			steal.Previous = &held
+		}
+
+		return putTerraformRecord(ctx, tx, tflockKey, string(current))
+	})
+	if err != nil {
+		if conflict {
+			countLockConflict(steal.Current.Who)
+		}
+
+		return steal, err
+	}
+
+	if steal.Previous != nil {
+		logger.Info("Stole terraform lock", logger.Ctx{"plan": name, "by": identity, "id": steal.Current.ID, "who": steal.Current.Who, "previousID": steal.Previous.ID, "previousWho": steal.Previous.Who})
+	}
+
+	return steal, nil
 }
 
-// DeleteTerraformLock deletes the terraform lock from the database
-func DeleteTerraformLock(s *state.State, name string, lock string) (types.Lock, error) {
+// DeleteTerraformLock deletes the terraform lock from the database.
+// identity is the identity of the authenticated client, if any.
+// A forced unlock deletes the lock whoever holds it, provided the identity is
+// allowed to force-unlock the plan by tflockForceUnlockSetting.
+func DeleteTerraformLock(ctx context.Context, s *state.State, name string, lock string, identity string, force bool) (types.Lock, error) {
 	var reqLock types.Lock
 	var dbLock types.Lock
 
-	err := json.Unmarshal([]byte(lock), &reqLock)
+	// The lock held does not need to be given to force the unlock.
+	if lock != "" || !force {
+		err := json.Unmarshal([]byte(lock), &reqLock)
+		if err != nil {
+			return dbLock, err
+		}
+	}
+
+	done, err := beginTerraformWrite()
+	if err != nil {
+		return dbLock, err
+	}
+	defer done()
+
+	if force {
+		err = checkTerraformForceUnlock(ctx, s, name, identity)
+	} else {
+		err = checkTerraformLockIdentity(ctx, s, &reqLock, identity)
+	}
 	if err != nil {
 		return dbLock, err
 	}
 
 	tflockKey := tflockPrefix + name
-	lockInDb, err := GetConfig(s, tflockKey)
+	lockInDb, err := GetConfig(ctx, s, tflockKey)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			// No Lock exists to unlock, send 200: OK
-			if err.Status() == http.StatusNotFound {
-				return dbLock, nil
-			}
+		// No Lock exists to unlock, send 200: OK
+		if errors.Is(err, ErrNotFound) {
+			return dbLock, nil
 		}
 		return dbLock, err
 	}
@@ -165,11 +826,283 @@ func DeleteTerraformLock(s *state.State, name string, lock string) (types.Lock,
 	}
 
 	// If the lock from DB and request are same, clear the lock from DB
-	if dbLock.ID == reqLock.ID && dbLock.Operation == reqLock.Operation && dbLock.Who == reqLock.Who {
-		err = DeleteConfig(s, tflockKey)
+	if force || (dbLock.ID == reqLock.ID && dbLock.Operation == reqLock.Operation && dbLock.Who == reqLock.Who) {
+		err = DeleteConfig(ctx, s, tflockKey)
 		return dbLock, err
 	}
 
 	// Request has different lock id than in database, send http 409
-	return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+	return dbLock, lockConflictErrorf(reqLock.Who, http.StatusConflict, "Conflict in Lock ID")
+}
+
+// Operations recorded in the terraform state access log.
+const (
+	TerraformStateAccessGet    = "get"
+	TerraformStateAccessPut    = "put"
+	TerraformStateAccessDelete = "delete"
+)
+
+// RecordTerraformStateAccess appends an access to the terraform state of the plan to
+// the access log, keeping the tfstateAccessLogSetting most recent accesses of the plan.
+func RecordTerraformStateAccess(ctx context.Context, s *state.State, name string, operation string, source string, identity string) error {
+	length, err := getIntSetting(ctx, s, tfstateAccessLogSetting, defaultTfstateAccessLog)
+	if err != nil || length <= 0 {
+		return err
+	}
+
+	return transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		err := database.CreateTerraformStateAccess(ctx, tx, name, operation, source, identity)
+		if err != nil {
+			return err
+		}
+
+		_, err = database.TrimTerraformStateAccesses(ctx, tx, name, length)
+		return err
+	})
+}
+
+// GetTerraformStateAccessLog returns the recorded accesses to the terraform state of the plan, most recent first
+func GetTerraformStateAccessLog(ctx context.Context, s *state.State, name string) ([]types.TerraformStateAccess, error) {
+	var accesses []types.TerraformStateAccess
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetTerraformStateAccesses(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		accesses = make([]types.TerraformStateAccess, 0, len(records))
+		for _, record := range records {
+			accesses = append(accesses, types.TerraformStateAccess{Operation: record.Operation, Source: record.Source, Identity: record.Identity, AccessedAt: record.AccessedAt})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accesses, nil
+}
+
+// GetTerraformLockStatus returns the lock status of each plan with a state, keyed by plan.
+// The states and locks are read in a single transaction so that they are consistent.
+func GetTerraformLockStatus(ctx context.Context, s *state.State) (map[string]types.TerraformLockStatus, error) {
+	statuses := map[string]types.TerraformLockStatus{}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		prefix := tfstatePrefix
+		keys, err := database.GetConfigItemKeys(ctx, tx, &prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			statuses[strings.TrimPrefix(key, tfstatePrefix)] = types.TerraformLockStatus{}
+		}
+
+		prefix = tflockPrefix
+		records, err := database.GetConfigItemsByPrefix(ctx, tx, &prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			plan := strings.TrimPrefix(record.Key, tflockPrefix)
+			if _, ok := statuses[plan]; !ok {
+				continue
+			}
+
+			var lock types.Lock
+			err = json.Unmarshal([]byte(record.Value), &lock)
+			if err != nil {
+				return fmt.Errorf("Failed to parse lock %q: %w", record.Key, err)
+			}
+
+			statuses[plan] = types.TerraformLockStatus{Locked: true, Who: lock.Who, Age: int64(time.Since(lock.Created).Seconds())}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// GetTerraformLockMetrics returns the age of the held terraform locks and the number of lock conflicts
+func GetTerraformLockMetrics(ctx context.Context, s *state.State) (types.TerraformLockMetrics, error) {
+	metrics := types.TerraformLockMetrics{LockAges: map[string]float64{}}
+
+	err := transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		prefix := tflockPrefix
+		keys, err := database.GetConfigItemKeys(ctx, tx, &prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			record, err := database.GetConfigItem(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+
+			var lock types.Lock
+			err = json.Unmarshal([]byte(record.Value), &lock)
+			if err != nil {
+				return fmt.Errorf("Failed to parse lock %q: %w", key, err)
+			}
+
+			metrics.LockAges[strings.TrimPrefix(key, tflockPrefix)] = time.Since(lock.Created).Seconds()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return metrics, err
+	}
+
+	metrics.LockConflicts = tfLockConflicts.Load()
+	metrics.LockConflictsSince = tfLockConflictsSince
+
+	return metrics, nil
+}
+
+// CheckTerraform reports the terraform locks and states without their counterpart.
+// If repair is set the orphaned locks older than the tflockTTLSetting are deleted,
+// as a plan has no state yet while its first apply holds the lock. Without a TTL
+// no lock is deleted.
+func CheckTerraform(ctx context.Context, s *state.State, repair bool) (types.TerraformFsck, error) {
+	ttl, err := getDurationSetting(ctx, s, tflockTTLSetting, 0)
+	if err != nil {
+		return types.TerraformFsck{}, err
+	}
+
+	var result types.TerraformFsck
+	err = transaction(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+		result = types.TerraformFsck{OrphanedLocks: []string{}, UnlockedStates: []string{}, RemovedLocks: []string{}}
+
+		statePrefix := tfstatePrefix
+		stateKeys, err := database.GetConfigItemKeys(ctx, tx, &statePrefix)
+		if err != nil {
+			return err
+		}
+
+		lockPrefix := tflockPrefix
+		lockKeys, err := database.GetConfigItemKeys(ctx, tx, &lockPrefix)
+		if err != nil {
+			return err
+		}
+
+		plans := make(map[string]bool, len(stateKeys))
+		for _, key := range stateKeys {
+			plans[strings.TrimPrefix(key, tfstatePrefix)] = true
+		}
+
+		locked := make(map[string]bool, len(lockKeys))
+		for _, key := range lockKeys {
+			plan := strings.TrimPrefix(key, tflockPrefix)
+			locked[plan] = true
+			if plans[plan] {
+				continue
+			}
+
+			result.OrphanedLocks = append(result.OrphanedLocks, plan)
+			if repair && ttl > 0 {
+				record, err := database.GetConfigItem(ctx, tx, key)
+				if err != nil {
+					return err
+				}
+
+				var lock types.Lock
+				err = json.Unmarshal([]byte(record.Value), &lock)
+				if err == nil && time.Since(lock.Created) <= ttl {
+					continue
+				}
+
+				err = database.DeleteConfigItem(ctx, tx, key)
+				if err != nil {
+					return fmt.Errorf("Failed to delete orphaned lock %q: %w", plan, err)
+				}
+				result.RemovedLocks = append(result.RemovedLocks, plan)
+			}
+		}
+
+		for _, key := range stateKeys {
+			plan := strings.TrimPrefix(key, tfstatePrefix)
+			if !locked[plan] {
+				result.UnlockedStates = append(result.UnlockedStates, plan)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return types.TerraformFsck{}, err
+	}
+
+	return result, nil
+}
+
+// terraformStateSerial returns the serial of the terraform state, nil if it has none.
+// It fails if the state is not a JSON object.
+func terraformStateSerial(state string) (*int64, error) {
+	var tfState struct {
+		Serial *int64 `json:"serial"`
+	}
+	err := json.Unmarshal([]byte(state), &tfState)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(state), "{") {
+		return nil, errors.New("expected a JSON object")
+	}
+
+	return tfState.Serial, nil
+}
+
+// Statuses of the checks of VerifyTerraformState.
+const (
+	TerraformVerifyOK          = "ok"
+	TerraformVerifyMismatch    = "mismatch"
+	TerraformVerifyUntracked   = "untracked"
+	TerraformVerifyUnavailable = "unavailable"
+)
+
+// VerifyTerraformState checks that the stored terraform state is a JSON object
+// and that its serial is the tracked one.
+func VerifyTerraformState(ctx context.Context, s *state.State, name string) (types.TerraformStateVerify, error) {
+	report := types.TerraformStateVerify{SerialStatus: TerraformVerifyUntracked, ChecksumStatus: TerraformVerifyUnavailable}
+
+	state, err := GetTerraformState(ctx, s, name)
+	if err != nil {
+		return report, err
+	}
+	report.Size = len(state)
+
+	serial, err := terraformStateSerial(state)
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.Valid = true
+
+	serialInDb, err := GetConfig(ctx, s, tfserialPrefix+name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return report, nil
+		}
+		return report, err
+	}
+
+	if serial != nil {
+		report.SerialStatus = TerraformVerifyMismatch
+		if strconv.FormatInt(*serial, 10) == serialInDb {
+			report.SerialStatus = TerraformVerifyOK
+		}
+	}
+
+	return report, nil
 }
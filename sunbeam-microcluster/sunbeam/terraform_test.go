@@ -0,0 +1,561 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+func TestGetTerraformLockConflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     string
+		created time.Duration
+		stale   bool
+	}{
+		{name: "no ttl", created: 2 * time.Hour},
+		{name: "fresh lock", ttl: "1h", created: time.Minute},
+		{name: "stale lock", ttl: "1h", created: 2 * time.Hour, stale: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			if tt.ttl != "" {
+				err := CreateConfig(context.Background(), s, tflockTTLSetting, tt.ttl, "", "test")
+				if err != nil {
+					t.Fatalf("Failed to set the lock TTL: %v", err)
+				}
+			}
+
+			lock := types.Lock{ID: "lock1", Who: "user@host", Created: time.Now().Add(-tt.created)}
+			conflict, err := GetTerraformLockConflict(context.Background(), s, lock)
+			if err != nil {
+				t.Fatalf("GetTerraformLockConflict failed: %v", err)
+			}
+
+			if conflict.Lock != lock {
+				t.Errorf("Conflict holds lock %+v, expected %+v", conflict.Lock, lock)
+			}
+
+			if conflict.Age < int64(tt.created.Seconds())-1 || conflict.Age > int64(tt.created.Seconds())+1 {
+				t.Errorf("Conflict age is %ds, expected %ds", conflict.Age, int64(tt.created.Seconds()))
+			}
+
+			if conflict.Stale != tt.stale {
+				t.Errorf("Conflict stale is %v, expected %v", conflict.Stale, tt.stale)
+			}
+		})
+	}
+}
+
+func TestCheckTerraform(t *testing.T) {
+	// The lock of plan4 is held by the first apply of the plan, before it has a state.
+	fresh := fmt.Sprintf(`{"ID":"lock4","Created":%q}`, time.Now().Format(time.RFC3339Nano))
+
+	tests := []struct {
+		name    string
+		ttl     string
+		repair  bool
+		removed []string
+	}{
+		{name: "check", ttl: "1h", removed: []string{}},
+		{name: "repair", ttl: "1h", repair: true, removed: []string{"plan3"}},
+		{name: "repair without ttl", repair: true, removed: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			createTestConfig(t, s, map[string]string{
+				tfstatePrefix + "plan1": "{}",
+				tfstatePrefix + "plan2": "{}",
+				tflockPrefix + "plan1":  `{"ID":"lock1"}`,
+				tflockPrefix + "plan3":  `{"ID":"lock3"}`,
+				tflockPrefix + "plan4":  fresh,
+			})
+
+			if tt.ttl != "" {
+				err := CreateConfig(context.Background(), s, tflockTTLSetting, tt.ttl, "", "test")
+				if err != nil {
+					t.Fatalf("Failed to set the lock TTL: %v", err)
+				}
+			}
+
+			result, err := CheckTerraform(context.Background(), s, tt.repair)
+			if err != nil {
+				t.Fatalf("CheckTerraform failed: %v", err)
+			}
+
+			expected := types.TerraformFsck{OrphanedLocks: []string{"plan3", "plan4"}, UnlockedStates: []string{"plan2"}, RemovedLocks: tt.removed}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("CheckTerraform = %+v, expected %+v", result, expected)
+			}
+
+			_, err = GetConfig(context.Background(), s, tflockPrefix+"plan3")
+			if api.StatusErrorCheck(err, http.StatusNotFound) != (len(tt.removed) > 0) {
+				t.Errorf("Orphaned lock lookup error = %v, expected removed %v", err, tt.removed)
+			}
+
+			for _, plan := range []string{"plan1", "plan4"} {
+				_, err = GetConfig(context.Background(), s, tflockPrefix+plan)
+				if err != nil {
+					t.Errorf("Held lock of %q lookup failed: %v", plan, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTerraformPlanName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "openstack"},
+		{name: "openstack-tfstate-x"},
+		{name: "", wantErr: true},
+		{name: tfstatePrefix + "x", wantErr: true},
+		{name: tflockPrefix + "x", wantErr: true},
+		{name: tfserialPrefix + "x", wantErr: true},
+		{name: tfworkspacePrefix + "x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTerraformPlanName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTerraformPlanName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLockRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		age   time.Duration
+		ttl   time.Duration
+		delay time.Duration
+	}{
+		{name: "new lock", age: 0, delay: minLockRetryAfter},
+		{name: "tenth of the age", age: 5 * time.Minute, delay: 30 * time.Second},
+		{name: "old lock", age: time.Hour, delay: maxLockRetryAfter},
+		{name: "time left of the TTL", age: time.Minute, ttl: time.Minute + 20*time.Second, delay: 20 * time.Second},
+		{name: "stale lock", age: 2 * time.Minute, ttl: time.Minute, delay: minLockRetryAfter},
+		{name: "long TTL", age: 0, ttl: time.Hour, delay: maxLockRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay := lockRetryAfter(tt.age, tt.ttl)
+			if delay != tt.delay {
+				t.Errorf("lockRetryAfter(%s, %s) = %s, expected %s", tt.age, tt.ttl, delay, tt.delay)
+			}
+		})
+	}
+}
+
+func TestUpdateTerraformStateUnlock(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{tflockPrefix + "plan1": `{"ID":"lock1"}`})
+
+	// Steps run in order against the same database.
+	steps := []struct {
+		name       string
+		lockID     string
+		state      string
+		unlock     bool
+		wantStatus int
+		stored     string
+		locked     bool
+	}{
+		{name: "other lock", lockID: "lock2", state: `{"serial":1}`, unlock: true, wantStatus: http.StatusConflict, locked: true},
+		{name: "kept lock", lockID: "lock1", state: `{"serial":1}`, stored: `{"serial":1}`, locked: true},
+		{name: "released lock", lockID: "lock1", state: `{"serial":2}`, unlock: true, stored: `{"serial":2}`},
+	}
+
+	for _, step := range steps {
+		_, err := UpdateTerraformState(context.Background(), s, "plan1", step.lockID, step.state, false, step.unlock)
+		if step.wantStatus != 0 {
+			if !api.StatusErrorCheck(err, step.wantStatus) {
+				t.Fatalf("%s: UpdateTerraformState error = %v, expected status %d", step.name, err, step.wantStatus)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: UpdateTerraformState failed: %v", step.name, err)
+		}
+
+		stored, err := GetConfig(context.Background(), s, tfstatePrefix+"plan1")
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			t.Fatalf("%s: Failed to get the state: %v", step.name, err)
+		}
+
+		if stored != step.stored {
+			t.Errorf("%s: Stored state is %q, expected %q", step.name, stored, step.stored)
+		}
+
+		_, err = GetConfig(context.Background(), s, tflockPrefix+"plan1")
+		if (err == nil) != step.locked {
+			t.Errorf("%s: Lock lookup error = %v, expected locked=%v", step.name, err, step.locked)
+		}
+	}
+}
+
+func TestStealTerraformLock(t *testing.T) {
+	held := `{"ID":"lock1","Who":"dead@host"}`
+	steal := `{"ID":"lock2","Who":"ci-runner@host"}`
+
+	tests := []struct {
+		name        string
+		identity    string
+		duringGrace func(s *state.State) error
+		wantStatus  int
+		previous    string
+		stored      string
+	}{
+		{name: "held lock", identity: "ci-runner", previous: "lock1", stored: "lock2"},
+		{name: "released during grace", identity: "ci-runner", duringGrace: func(s *state.State) error {
+			return DeleteConfig(context.Background(), s, tflockPrefix+"plan1")
+		}, stored: "lock2"},
+		{name: "replaced during grace", identity: "ci-runner", duringGrace: func(s *state.State) error {
+			return UpdateConfig(context.Background(), s, tflockPrefix+"plan1", `{"ID":"lock3"}`)
+		}, wantStatus: http.StatusConflict, stored: "lock3"},
+		{name: "not allowed", identity: "other", wantStatus: http.StatusForbidden, stored: "lock1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			createTestConfig(t, s, map[string]string{
+				tflockForceUnlockSetting: "ci-runner=plan*",
+				tflockPrefix + "plan1":   held,
+			})
+
+			grace := time.Duration(0)
+			if tt.duringGrace != nil {
+				grace = 200 * time.Millisecond
+				timer := time.AfterFunc(20*time.Millisecond, func() {
+					err := tt.duringGrace(s)
+					if err != nil {
+						t.Errorf("Failed to change the lock during the grace: %v", err)
+					}
+				})
+				defer timer.Stop()
+			}
+
+			result, err := StealTerraformLock(context.Background(), s, "plan1", steal, tt.identity, grace)
+			if tt.wantStatus != 0 {
+				if !api.StatusErrorCheck(err, tt.wantStatus) {
+					t.Fatalf("StealTerraformLock error = %v, expected status %d", err, tt.wantStatus)
+				}
+			} else if err != nil {
+				t.Fatalf("StealTerraformLock failed: %v", err)
+			}
+
+			if tt.wantStatus == 0 {
+				previous := ""
+				if result.Previous != nil {
+					previous = result.Previous.ID
+				}
+
+				if previous != tt.previous {
+					t.Errorf("Stolen lock is %q, expected %q", previous, tt.previous)
+				}
+			}
+
+			lock, err := GetTerraformStateLock(context.Background(), s, "plan1")
+			if err != nil {
+				t.Fatalf("Failed to get the lock: %v", err)
+			}
+
+			if lock == nil || lock.ID != tt.stored {
+				t.Errorf("Held lock is %+v, expected %q", lock, tt.stored)
+			}
+		})
+	}
+}
+
+func TestGetTerraformLockStatus(t *testing.T) {
+	s := newTestState(t)
+	created := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	createTestConfig(t, s, map[string]string{
+		tfstatePrefix + "plan1":  `{}`,
+		tfstatePrefix + "plan2":  `{}`,
+		tflockPrefix + "plan1":   fmt.Sprintf(`{"ID":"lock1","Who":"alice@host","Created":%q}`, created),
+		tflockPrefix + "orphan":  `{"ID":"lock2","Who":"bob@host"}`,
+		tflockPrefix + "corrupt": `not json`,
+	})
+
+	statuses, err := GetTerraformLockStatus(context.Background(), s)
+	if err != nil {
+		t.Fatalf("GetTerraformLockStatus failed: %v", err)
+	}
+
+	// The age is checked separately as it depends on the time of the call.
+	age := statuses["plan1"].Age
+	if age < 60 || age > 120 {
+		t.Errorf("Age of the plan1 lock is %d, expected about 60", age)
+	}
+
+	statuses["plan1"] = types.TerraformLockStatus{Locked: statuses["plan1"].Locked, Who: statuses["plan1"].Who}
+
+	expected := map[string]types.TerraformLockStatus{
+		"plan1": {Locked: true, Who: "alice@host"},
+		"plan2": {},
+	}
+
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("GetTerraformLockStatus = %+v, expected %+v", statuses, expected)
+	}
+}
+
+func TestCheckTerraformLockPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		path    string
+		plan    string
+		wantErr bool
+	}{
+		{name: "not strict", path: "other", plan: "plan1"},
+		{name: "no path", strict: true, path: "", plan: "plan1"},
+		{name: "plan name", strict: true, path: "plan1", plan: "plan1"},
+		{name: "lock address", strict: true, path: "https://10.0.0.1:7000/1.0/terraformlock/plan1", plan: "plan1"},
+		{name: "workspace plan", strict: true, path: "plan1", plan: tfworkspacePrefix + "staging/plan1"},
+		{name: "other plan", strict: true, path: "plan2", plan: "plan1", wantErr: true},
+		{name: "plan suffix", strict: true, path: "https://10.0.0.1:7000/1.0/terraformlock/myplan1", plan: "plan1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestState(t)
+			if tt.strict {
+				createTestConfig(t, s, map[string]string{tflockStrictPathSetting: "true"})
+			}
+
+			err := checkTerraformLockPath(context.Background(), s, types.Lock{ID: "lock1", Path: tt.path}, tt.plan)
+			if tt.wantErr {
+				if !api.StatusErrorCheck(err, http.StatusBadRequest) || !errors.Is(err, ErrTerraformLockPath) {
+					t.Errorf("checkTerraformLockPath(%q, %q) error = %v, expected a bad request", tt.path, tt.plan, err)
+				}
+			} else if err != nil {
+				t.Errorf("checkTerraformLockPath(%q, %q) failed: %v", tt.path, tt.plan, err)
+			}
+		})
+	}
+}
+
+func TestTerraformStateAccessLog(t *testing.T) {
+	s := newTestState(t)
+	createTestConfig(t, s, map[string]string{tfstateAccessLogSetting: "2"})
+
+	accesses := []struct {
+		plan      string
+		operation string
+		identity  string
+	}{
+		{plan: "plan1", operation: TerraformStateAccessGet},
+		{plan: "plan1", operation: TerraformStateAccessPut, identity: "admin"},
+		{plan: "plan2", operation: TerraformStateAccessGet},
+		{plan: "plan1", operation: TerraformStateAccessDelete, identity: "admin"},
+	}
+
+	for _, access := range accesses {
+		err := RecordTerraformStateAccess(context.Background(), s, access.plan, access.operation, "10.0.0.2", access.identity)
+		if err != nil {
+			t.Fatalf("RecordTerraformStateAccess failed: %v", err)
+		}
+	}
+
+	// Only the most recent accesses of each plan are kept.
+	tests := []struct {
+		plan       string
+		operations []string
+	}{
+		{plan: "plan1", operations: []string{TerraformStateAccessDelete, TerraformStateAccessPut}},
+		{plan: "plan2", operations: []string{TerraformStateAccessGet}},
+		{plan: "plan3", operations: []string{}},
+	}
+
+	for _, tt := range tests {
+		log, err := GetTerraformStateAccessLog(context.Background(), s, tt.plan)
+		if err != nil {
+			t.Fatalf("GetTerraformStateAccessLog(%q) failed: %v", tt.plan, err)
+		}
+
+		operations := []string{}
+		for _, access := range log {
+			operations = append(operations, access.Operation)
+			if access.Source != "10.0.0.2" || access.AccessedAt == "" {
+				t.Errorf("Access to %q is %+v, expected the source and time", tt.plan, access)
+			}
+		}
+
+		if !reflect.DeepEqual(operations, tt.operations) {
+			t.Errorf("Accesses to %q are %v, expected %v", tt.plan, operations, tt.operations)
+		}
+	}
+
+	// Nothing is recorded once the access log is disabled.
+	err := UpdateConfig(context.Background(), s, tfstateAccessLogSetting, "0")
+	if err != nil {
+		t.Fatalf("Failed to disable the access log: %v", err)
+	}
+
+	err = RecordTerraformStateAccess(context.Background(), s, "plan3", TerraformStateAccessGet, "10.0.0.2", "")
+	if err != nil {
+		t.Fatalf("RecordTerraformStateAccess failed: %v", err)
+	}
+
+	log, err := GetTerraformStateAccessLog(context.Background(), s, "plan3")
+	if err != nil {
+		t.Fatalf("GetTerraformStateAccessLog failed: %v", err)
+	}
+
+	if len(log) != 0 {
+		t.Errorf("Accesses recorded while disabled: %+v", log)
+	}
+}
+
+func TestTerraformStateSerial(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   string
+		serial  int64
+		tracked bool
+		wantErr bool
+	}{
+		{name: "serial", state: `{"version": 4, "serial": 3}`, serial: 3, tracked: true},
+		{name: "no serial", state: `{"version": 4}`},
+		{name: "array", state: `[]`, wantErr: true},
+		{name: "not JSON", state: `serial`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serial, err := terraformStateSerial(tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("terraformStateSerial(%q) error = %v, wantErr %v", tt.state, err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if (serial != nil) != tt.tracked || (serial != nil && *serial != tt.serial) {
+				t.Errorf("terraformStateSerial(%q) = %v, expected %d", tt.state, serial, tt.serial)
+			}
+		})
+	}
+}
+
+func TestCheckTerraformSerial(t *testing.T) {
+	tests := []struct {
+		name    string
+		serial  int64
+		stored  string
+		stale   bool
+		wantErr bool
+	}{
+		{name: "untracked", serial: 0, stored: ""},
+		{name: "newer", serial: 4, stored: "3"},
+		{name: "same", serial: 3, stored: "3"},
+		{name: "older", serial: 2, stored: "3", stale: true, wantErr: true},
+		{name: "broken", serial: 2, stored: "three", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTerraformSerial(tt.serial, tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkTerraformSerial(%d, %q) error = %v, wantErr %v", tt.serial, tt.stored, err, tt.wantErr)
+			}
+
+			if errors.Is(err, ErrStaleTerraformSerial) != tt.stale {
+				t.Errorf("checkTerraformSerial(%d, %q) = %v, stale %v", tt.serial, tt.stored, err, tt.stale)
+			}
+		})
+	}
+}
+
+func TestTerraformLockConflictCounts(t *testing.T) {
+	s := newTestState(t)
+
+	// The counters are shared by the tests, so only their increase is checked.
+	expectConflicts := func(t *testing.T, before types.TerraformLockConflicts, total uint64, who string, byWho uint64) {
+		t.Helper()
+
+		after := GetTerraformLockConflicts()
+		if after.Total-before.Total != total || after.ByWho[who]-before.ByWho[who] != byWho {
+			t.Errorf("Conflicts increased by %d, %d for %q, expected %d and %d", after.Total-before.Total, after.ByWho[who]-before.ByWho[who], who, total, byWho)
+		}
+	}
+
+	held := `{"ID":"lock1","Operation":"OperationTypeApply","Who":"user@host"}`
+	_, err := UpdateTerraformLock(context.Background(), s, "plan1", held, "")
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+
+	t.Run("same lock", func(t *testing.T) {
+		before := GetTerraformLockConflicts()
+		_, err := UpdateTerraformLock(context.Background(), s, "plan1", held, "")
+		if !api.StatusErrorCheck(err, http.StatusLocked) {
+			t.Fatalf("Locking again returned %v, expected 423", err)
+		}
+
+		expectConflicts(t, before, 0, "user@host", 0)
+	})
+
+	t.Run("other lock", func(t *testing.T) {
+		before := GetTerraformLockConflicts()
+		_, err := UpdateTerraformLock(context.Background(), s, "plan1", `{"ID":"lock2","Who":"other@host"}`, "")
+		if !api.StatusErrorCheck(err, http.StatusConflict) {
+			t.Fatalf("Locking with another ID returned %v, expected 409", err)
+		}
+
+		expectConflicts(t, before, 1, "other@host", 1)
+	})
+
+	t.Run("state write retried", func(t *testing.T) {
+		// The lock is taken by another client after the check of the lock, and
+		// the transaction finding the conflict is retried.
+		previous := dbTransaction
+		t.Cleanup(func() { dbTransaction = previous })
+
+		calls := 0
+		dbTransaction = func(ctx context.Context, s *state.State, f func(context.Context, *sql.Tx) error) error {
+			calls++
+			err := previous(ctx, s, f)
+			if calls == 1 {
+				_ = previous(ctx, s, func(ctx context.Context, tx *sql.Tx) error {
+					return putTerraformRecord(ctx, tx, tflockPrefix+"plan1", `{"ID":"lock3","Who":"third@host"}`)
+				})
+			}
+
+			if api.StatusErrorCheck(err, http.StatusConflict) {
+				err = previous(ctx, s, f)
+			}
+
+			return err
+		}
+
+		before := GetTerraformLockConflicts()
+		_, err := UpdateTerraformState(context.Background(), s, "plan1", "lock1", `{"serial":1}`, false, false)
+		if !api.StatusErrorCheck(err, http.StatusConflict) {
+			t.Fatalf("State write returned %v, expected 409", err)
+		}
+
+		expectConflicts(t, before, 1, UnknownCreator, 1)
+	})
+}
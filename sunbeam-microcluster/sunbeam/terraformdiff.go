@@ -0,0 +1,145 @@
+package sunbeam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// tfStateResources is the part of a terraform state holding the resource instances
+type tfStateResources struct {
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   any            `json:"index_key"`
+			Attributes map[string]any `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// DiffTerraformStates compares the resource instances of the states of the
+// plans name and other, other being the newer state. Only the current state of
+// a plan is kept, so the earlier serials of a plan cannot be compared.
+func DiffTerraformStates(ctx context.Context, s *state.State, name string, other string) (types.TerraformStateDiff, error) {
+	fromState, err := GetTerraformState(ctx, s, name)
+	if err != nil {
+		return types.TerraformStateDiff{}, err
+	}
+
+	toState, err := GetTerraformState(ctx, s, other)
+	if err != nil {
+		return types.TerraformStateDiff{}, err
+	}
+
+	return diffTerraformStates(name, fromState, other, toState)
+}
+
+// diffTerraformStates compares the resource instances of the state of the plan
+// name with the newer state of the plan other.
+func diffTerraformStates(name string, fromState string, other string, toState string) (types.TerraformStateDiff, error) {
+	diff := types.TerraformStateDiff{From: name, To: other, Added: []string{}, Removed: []string{}, Changed: []types.TerraformResourceChange{}}
+
+	from, err := terraformStateInstances(name, fromState)
+	if err != nil {
+		return diff, err
+	}
+
+	to, err := terraformStateInstances(other, toState)
+	if err != nil {
+		return diff, err
+	}
+
+	for address, attributes := range to {
+		fromAttributes, ok := from[address]
+		if !ok {
+			diff.Added = append(diff.Added, address)
+			continue
+		}
+
+		changes := diffAttributes(fromAttributes, attributes)
+		if len(changes) > 0 {
+			diff.Changed = append(diff.Changed, types.TerraformResourceChange{Address: address, Attributes: changes})
+		}
+	}
+
+	for address := range from {
+		_, ok := to[address]
+		if !ok {
+			diff.Removed = append(diff.Removed, address)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Address < diff.Changed[j].Address })
+
+	return diff, nil
+}
+
+// terraformStateInstances returns the attributes of the resource instances in the state of the plan, keyed by address
+func terraformStateInstances(name string, state string) (map[string]map[string]any, error) {
+	var tfState tfStateResources
+	err := json.Unmarshal([]byte(state), &tfState)
+	if err != nil {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "%w: plan %q: %v", ErrInvalidTerraformState, name, err)
+	}
+
+	instances := map[string]map[string]any{}
+	for _, resource := range tfState.Resources {
+		address := resource.Type + "." + resource.Name
+		if resource.Mode == "data" {
+			address = "data." + address
+		}
+		if resource.Module != "" {
+			address = resource.Module + "." + address
+		}
+
+		for _, instance := range resource.Instances {
+			instanceAddress := address
+			switch key := instance.IndexKey.(type) {
+			case string:
+				instanceAddress += fmt.Sprintf("[%q]", key)
+			case float64:
+				instanceAddress += fmt.Sprintf("[%d]", int64(key))
+			}
+
+			instances[instanceAddress] = instance.Attributes
+		}
+	}
+
+	return instances, nil
+}
+
+// diffAttributes returns the attributes added, removed or changed from a to b, sorted by name
+func diffAttributes(a map[string]any, b map[string]any) []types.TerraformAttributeChange {
+	changes := []types.TerraformAttributeChange{}
+
+	for name, value := range b {
+		old, ok := a[name]
+		if !ok || !reflect.DeepEqual(old, value) {
+			changes = append(changes, types.TerraformAttributeChange{Name: name, From: old, To: value})
+		}
+	}
+
+	for name, old := range a {
+		_, ok := b[name]
+		if !ok {
+			changes = append(changes, types.TerraformAttributeChange{Name: name, From: old})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+
+	return changes
+}
@@ -0,0 +1,95 @@
+package sunbeam
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+func TestDiffTerraformStates(t *testing.T) {
+	base := `{"version": 4, "serial": 1, "resources": [
+		{"mode": "managed", "type": "juju_model", "name": "openstack", "instances": [{"attributes": {"name": "openstack", "cloud": "k8s"}}]},
+		{"mode": "managed", "type": "juju_application", "name": "app", "instances": [
+			{"index_key": 0, "attributes": {"units": 1}},
+			{"index_key": 1, "attributes": {"units": 1}}
+		]},
+		{"mode": "data", "type": "juju_offer", "name": "ceph", "instances": [{"attributes": {"url": "admin/ceph"}}]}
+	]}`
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		added   []string
+		removed []string
+		changed []types.TerraformResourceChange
+		wantErr bool
+	}{
+		{name: "same", from: base, to: base},
+		{
+			name: "resources added and removed",
+			from: base,
+			to: `{"version": 4, "serial": 2, "resources": [
+				{"mode": "managed", "type": "juju_model", "name": "openstack", "instances": [{"attributes": {"name": "openstack", "cloud": "k8s"}}]},
+				{"mode": "managed", "type": "juju_application", "name": "app", "instances": [{"index_key": 0, "attributes": {"units": 1}}]},
+				{"module": "module.mysql", "mode": "managed", "type": "juju_application", "name": "mysql", "instances": [{"index_key": "a", "attributes": {}}]}
+			]}`,
+			added:   []string{`module.mysql.juju_application.mysql["a"]`},
+			removed: []string{"data.juju_offer.ceph", "juju_application.app[1]"},
+		},
+		{
+			name: "attributes changed",
+			from: base,
+			to: `{"version": 4, "serial": 2, "resources": [
+				{"mode": "managed", "type": "juju_model", "name": "openstack", "instances": [{"attributes": {"name": "openstack", "region": "default"}}]},
+				{"mode": "managed", "type": "juju_application", "name": "app", "instances": [
+					{"index_key": 0, "attributes": {"units": 3}},
+					{"index_key": 1, "attributes": {"units": 1}}
+				]},
+				{"mode": "data", "type": "juju_offer", "name": "ceph", "instances": [{"attributes": {"url": "admin/ceph"}}]}
+			]}`,
+			changed: []types.TerraformResourceChange{
+				{Address: "juju_application.app[0]", Attributes: []types.TerraformAttributeChange{{Name: "units", From: float64(1), To: float64(3)}}},
+				{Address: "juju_model.openstack", Attributes: []types.TerraformAttributeChange{{Name: "cloud", From: "k8s"}, {Name: "region", To: "default"}}},
+			},
+		},
+		{name: "from empty", from: `{}`, to: `{"resources": [{"mode": "managed", "type": "null_resource", "name": "a", "instances": [{"attributes": {}}]}]}`, added: []string{"null_resource.a"}},
+		{name: "broken", from: base, to: `{"resources": {}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := diffTerraformStates("from", tt.from, "to", tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("diffTerraformStates error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTerraformState) {
+					t.Errorf("diffTerraformStates = %v, expected %v", err, ErrInvalidTerraformState)
+				}
+
+				return
+			}
+
+			expected := types.TerraformStateDiff{From: "from", To: "to", Added: tt.added, Removed: tt.removed, Changed: tt.changed}
+			if expected.Added == nil {
+				expected.Added = []string{}
+			}
+
+			if expected.Removed == nil {
+				expected.Removed = []string{}
+			}
+
+			if expected.Changed == nil {
+				expected.Changed = []types.TerraformResourceChange{}
+			}
+
+			if !reflect.DeepEqual(diff, expected) {
+				t.Errorf("diffTerraformStates = %+v, expected %+v", diff, expected)
+			}
+		})
+	}
+}
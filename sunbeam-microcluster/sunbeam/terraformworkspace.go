@@ -0,0 +1,79 @@
+package sunbeam
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// DefaultTerraformWorkspace is the workspace of the plans given without a workspace
+const DefaultTerraformWorkspace = "default"
+
+// tfworkspacePrefix prefixes the names the plans of the other workspaces are stored
+// under, followed by the workspace and a slash, e.g. tfws-staging/openstack. The plans
+// of the default workspace keep their bare names so that their keys are unchanged.
+const tfworkspacePrefix = "tfws-"
+
+// TerraformWorkspacePlan returns the name the plan of the workspace is stored under.
+// An empty workspace is the default one.
+func TerraformWorkspacePlan(workspace string, name string) (string, error) {
+	if workspace == "" || workspace == DefaultTerraformWorkspace {
+		return name, nil
+	}
+
+	for _, r := range workspace {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return "", api.StatusErrorf(http.StatusBadRequest, "Invalid workspace %q, expected letters, digits, '-', '_' or '.'", workspace)
+		}
+	}
+
+	return tfworkspacePrefix + workspace + "/" + name, nil
+}
+
+// SplitTerraformWorkspacePlan returns the workspace and the name of a plan from the name it is stored under
+func SplitTerraformWorkspacePlan(plan string) (string, string) {
+	if !strings.HasPrefix(plan, tfworkspacePrefix) {
+		return DefaultTerraformWorkspace, plan
+	}
+
+	workspace, name, found := strings.Cut(strings.TrimPrefix(plan, tfworkspacePrefix), "/")
+	if !found {
+		return DefaultTerraformWorkspace, plan
+	}
+
+	return workspace, name
+}
+
+// TerraformWorkspacePlans returns the names of the plans of the workspace among the stored plans
+func TerraformWorkspacePlans(plans []string, workspace string) []string {
+	if workspace == "" {
+		workspace = DefaultTerraformWorkspace
+	}
+
+	names := []string{}
+	for _, plan := range plans {
+		planWorkspace, name := SplitTerraformWorkspacePlan(plan)
+		if planWorkspace == workspace {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// GroupTerraformPlans groups the names of the stored plans by workspace
+func GroupTerraformPlans(plans []string) map[string][]string {
+	grouped := map[string][]string{}
+	for _, plan := range plans {
+		workspace, name := SplitTerraformWorkspacePlan(plan)
+		grouped[workspace] = append(grouped[workspace], name)
+	}
+
+	for _, names := range grouped {
+		sort.Strings(names)
+	}
+
+	return grouped
+}
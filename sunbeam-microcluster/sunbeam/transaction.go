@@ -0,0 +1,54 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// ErrNotFound is matched with errors.Is by the errors of records that do not exist.
+var ErrNotFound = database.ErrNotFound
+
+// ErrConflict is matched with errors.Is by the errors of records conflicting with existing ones.
+var ErrConflict = database.ErrConflict
+
+// dbTransaction runs f in a transaction of the database of the daemon.
+var dbTransaction = func(ctx context.Context, s *state.State, f func(context.Context, *sql.Tx) error) error {
+	return s.Database.Transaction(ctx, f)
+}
+
+// transaction runs f in a transaction bounded by ctx. A transaction that fails
+// because the deadline of ctx expired is reported with 504, so that the request
+// being served is answered as timed out. The result of a transaction that
+// committed is returned as is, even if the deadline expired since.
+//
+// The status errors of the transaction are made to match ErrNotFound and
+// ErrConflict. Within f, the errors of the database mappers are plain status
+// errors and are checked with api.StatusErrorCheck.
+func transaction(ctx context.Context, s *state.State, f func(context.Context, *sql.Tx) error) error {
+	err := database.WrapStatusError(dbTransaction(ctx, s, f))
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return api.StatusErrorf(http.StatusGatewayTimeout, "Request timed out: %w", err)
+	}
+
+	return err
+}
+
+// UseTestDatabase runs the transactions of this package against db instead of
+// the database of the daemon, until the returned function is called. It is
+// meant for tests, which have no daemon to get a database from.
+func UseTestDatabase(db *sql.DB) func() {
+	previous := dbTransaction
+	dbTransaction = func(ctx context.Context, _ *state.State, f func(context.Context, *sql.Tx) error) error {
+		return query.Transaction(ctx, db, f)
+	}
+
+	return func() { dbTransaction = previous }
+}
@@ -0,0 +1,72 @@
+package sunbeam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database/dbtest"
+)
+
+// newTestState returns a daemon state for the cluster member member1 whose
+// transactions run against a fresh in-memory database.
+func newTestState(t *testing.T) *state.State {
+	t.Helper()
+
+	restore := UseTestDatabase(dbtest.NewDB(t, database.SchemaExtensions))
+	t.Cleanup(restore)
+
+	return &state.State{
+		Context: context.Background(),
+		Name:    func() string { return "member1" },
+	}
+}
+
+func TestTransactionSentinelErrors(t *testing.T) {
+	s := newTestState(t)
+
+	err := CreateConfig(context.Background(), s, "key1", "value1", "", "test")
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	_, notFound := GetConfig(context.Background(), s, "missing")
+	conflict := CreateConfig(context.Background(), s, "key1", "value2", "", "test")
+
+	tests := []struct {
+		name     string
+		err      error
+		status   int
+		sentinel error
+	}{
+		{name: "missing config", err: notFound, status: http.StatusNotFound, sentinel: ErrNotFound},
+		{name: "existing config", err: conflict, status: http.StatusConflict, sentinel: ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The sentinels still match once the error is wrapped by the caller.
+			err := fmt.Errorf("Request failed: %w", tt.err)
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("Error %v does not match %v", err, tt.sentinel)
+			}
+
+			if !api.StatusErrorCheck(err, tt.status) {
+				t.Errorf("Error %v does not have status %d", err, tt.status)
+			}
+		})
+	}
+
+	// Unlocking a missing lock matches the sentinel and succeeds.
+	_, err = DeleteTerraformLock(context.Background(), s, "plan1", `{"ID":"lock1"}`, "", false)
+	if err != nil {
+		t.Errorf("Unlocking a missing lock failed: %v", err)
+	}
+}